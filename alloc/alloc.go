@@ -0,0 +1,129 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package alloc
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"unsafe"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+	"golang.org/x/sys/unix"
+)
+
+// ErrInvalidFree is returned when freeing memory not returned by Alloc or
+// already freed.
+var ErrInvalidFree = errors.New("free of unallocated memory")
+
+// allocation is one mapping holding an allocation between two guard pages.
+type allocation struct {
+	mapping []byte
+	data    []byte // page-aligned pages holding the allocation
+	freed   bool
+}
+
+// Guarded is an electric-fence style allocator: every allocation gets its
+// own pages, placed right before a guard page and after another one.
+// Accessing a guard page, or an allocation after it was freed, raises
+// SIGBUS at the faulting instruction instead of silently corrupting memory.
+//
+// Guard pages are registered in missing mode on a userfaultfd created with
+// UFFD_FEATURE_SIGBUS, so no handler thread is needed. The Go runtime
+// crashes on SIGBUS unless debug.SetPanicOnFault is enabled, in which case
+// the fault turns into a recoverable runtime.Error with an Addr method.
+//
+// Freed memory is released but its address range is never reused.
+type Guarded struct {
+	uffd     *userfaultfd.Uffd
+	pageSize int
+	mu       sync.Mutex
+	allocs   map[uintptr]*allocation // by address of the allocation
+}
+
+// NewGuarded returns a new guarded allocator.
+func NewGuarded() (*Guarded, error) {
+	flags := unix.O_CLOEXEC
+	if userfaultfd.HaveUserModeOnly {
+		flags |= userfaultfd.UFFD_USER_MODE_ONLY
+	}
+	u, err := userfaultfd.New(flags, userfaultfd.UFFD_FEATURE_SIGBUS)
+	if err != nil {
+		return nil, err
+	}
+	return &Guarded{
+		uffd:     u,
+		pageSize: os.Getpagesize(),
+		allocs:   make(map[uintptr]*allocation),
+	}, nil
+}
+
+// Alloc returns n zeroed bytes ending right before a guard page.
+func (g *Guarded) Alloc(n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, errors.New("invalid allocation size")
+	}
+	dataLen := int(userfaultfd.RoundUp(uintptr(n), uintptr(g.pageSize)))
+
+	mapping, err := unix.Mmap(-1, 0, dataLen+2*g.pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := g.uffd.Register(addr(mapping), len(mapping), userfaultfd.UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		unix.Munmap(mapping)
+		return nil, err
+	}
+
+	a := &allocation{mapping: mapping, data: mapping[g.pageSize : g.pageSize+dataLen]}
+	if _, err := g.uffd.Zeropage(addr(a.data), dataLen, 0); err != nil {
+		g.release(a)
+		return nil, err
+	}
+
+	b := a.data[dataLen-n:]
+	g.mu.Lock()
+	g.allocs[addr(b)] = a
+	g.mu.Unlock()
+	return b, nil
+}
+
+// Free releases b, which must have been returned by Alloc. Further
+// accesses to b raise SIGBUS.
+func (g *Guarded) Free(b []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, ok := g.allocs[addr(b)]
+	if !ok || a.freed {
+		return ErrInvalidFree
+	}
+	a.freed = true
+	return unix.Madvise(a.data, unix.MADV_DONTNEED)
+}
+
+// Close unmaps all allocations, freed or not, and closes the userfaultfd.
+func (g *Guarded) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var errs []error
+	for p, a := range g.allocs {
+		errs = append(errs, g.release(a))
+		delete(g.allocs, p)
+	}
+	errs = append(errs, g.uffd.Close())
+	return errors.Join(errs...)
+}
+
+func (g *Guarded) release(a *allocation) error {
+	return errors.Join(
+		g.uffd.Unregister(addr(a.mapping), len(a.mapping)),
+		unix.Munmap(a.mapping),
+	)
+}
+
+func addr(b []byte) uintptr {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+}
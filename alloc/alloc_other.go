@@ -0,0 +1,36 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package alloc
+
+import (
+	"errors"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+)
+
+var (
+	ErrInvalidFree = errors.New("free of unallocated memory")
+	ErrArenaFull   = errors.New("arena full")
+)
+
+// Guarded is an electric-fence style allocator.
+type Guarded struct{}
+
+func NewGuarded() (*Guarded, error) { return nil, userfaultfd.ErrNotSupported }
+
+func (g *Guarded) Alloc(n int) ([]byte, error) { return nil, userfaultfd.ErrNotSupported }
+func (g *Guarded) Free(b []byte) error         { return userfaultfd.ErrNotSupported }
+func (g *Guarded) Close() error                { return userfaultfd.ErrNotSupported }
+
+// Arena is a bump allocator over lazily zeroed memory.
+type Arena struct{}
+
+func NewArena(size int) (*Arena, error) { return nil, userfaultfd.ErrNotSupported }
+
+func (a *Arena) Alloc(n int) ([]byte, error) { return nil, userfaultfd.ErrNotSupported }
+func (a *Arena) Reserved() int64             { return 0 }
+func (a *Arena) Allocated() int64            { return 0 }
+func (a *Arena) Touched() (int64, error)     { return 0, userfaultfd.ErrNotSupported }
+func (a *Arena) Close() error                { return userfaultfd.ErrNotSupported }
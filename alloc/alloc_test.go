@@ -0,0 +1,106 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package alloc
+
+import (
+	"runtime/debug"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// faultAddr runs fn and returns the address of the memory fault it caused.
+func faultAddr(t *testing.T, fn func()) (addr uintptr) {
+	t.Helper()
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatalf("expected memory fault")
+		}
+		err, ok := r.(interface{ Addr() uintptr })
+		if !ok {
+			panic(r)
+		}
+		addr = err.Addr()
+	}()
+	fn()
+	return 0
+}
+
+func newGuarded(t *testing.T) *Guarded {
+	t.Helper()
+	g, err := NewGuarded()
+	if err != nil {
+		t.Skipf("NewGuarded failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := g.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	})
+	return g
+}
+
+func TestGuardedAlloc(t *testing.T) {
+	g := newGuarded(t)
+
+	b, err := g.Alloc(100)
+	if err != nil {
+		t.Fatalf("Alloc failed: %v", err)
+	}
+	if len(b) != 100 {
+		t.Fatalf("len = %d, want 100", len(b))
+	}
+	for i := range b {
+		if b[i] != 0 {
+			t.Fatalf("allocation not zeroed at %d", i)
+		}
+		b[i] = byte(i)
+	}
+	if (uintptr(unsafe.Pointer(&b[99]))+1)%uintptr(unix.Getpagesize()) != 0 {
+		t.Fatalf("allocation does not end at a page boundary")
+	}
+}
+
+func TestGuardedOverflow(t *testing.T) {
+	g := newGuarded(t)
+
+	b, err := g.Alloc(100)
+	if err != nil {
+		t.Fatalf("Alloc failed: %v", err)
+	}
+
+	past := unsafe.Add(unsafe.Pointer(&b[0]), len(b))
+	if got := faultAddr(t, func() { *(*byte)(past) = 1 }); got != uintptr(past) {
+		t.Fatalf("fault at %#x, want %#x", got, uintptr(past))
+	}
+
+	before := unsafe.Add(unsafe.Pointer(&b[0]), -unix.Getpagesize())
+	faultAddr(t, func() { _ = *(*byte)(before) })
+}
+
+func TestGuardedUseAfterFree(t *testing.T) {
+	g := newGuarded(t)
+
+	b, err := g.Alloc(10)
+	if err != nil {
+		t.Fatalf("Alloc failed: %v", err)
+	}
+	if err := g.Free(b); err != nil {
+		t.Fatalf("Free failed: %v", err)
+	}
+	if got := faultAddr(t, func() { b[5] = 1 }); got != uintptr(unsafe.Pointer(&b[5])) {
+		t.Fatalf("fault at %#x, want %#x", got, uintptr(unsafe.Pointer(&b[5])))
+	}
+
+	if err := g.Free(b); err != ErrInvalidFree {
+		t.Fatalf("double Free returned %v, want ErrInvalidFree", err)
+	}
+	if err := g.Free(make([]byte, 10)); err != ErrInvalidFree {
+		t.Fatalf("Free of foreign memory returned %v, want ErrInvalidFree", err)
+	}
+}
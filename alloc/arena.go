@@ -0,0 +1,122 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package alloc
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+	"golang.org/x/sys/unix"
+)
+
+// arenaAlign is the alignment of arena allocations.
+const arenaAlign = 16
+
+// ErrArenaFull is returned when an allocation does not fit in the arena.
+var ErrArenaFull = errors.New("arena full")
+
+// Arena is a bump allocator over a large reserved region whose pages are
+// only backed by memory once touched: first-touch faults are resolved with
+// UFFDIO_ZEROPAGE by a background goroutine.
+//
+// The arena memory is not managed by the Go garbage collector, so it must
+// not hold Go pointers. As with userfaultfd.Serve, GOMAXPROCS must be
+// larger than the number of goroutines that may fault at the same time.
+//
+// Unprivileged processes can only handle faults in user mode unless
+// vm.unprivileged_userfaultfd is set: their arena memory must then be
+// touched before being passed to system calls such as read(2), which
+// otherwise fail with EFAULT.
+type Arena struct {
+	uffd   *userfaultfd.Uffd
+	region *userfaultfd.Region
+	mem    []byte
+	done   chan error
+
+	mu  sync.Mutex
+	off int
+}
+
+// NewArena reserves size bytes of address space.
+func NewArena(size int) (*Arena, error) {
+	flags := unix.O_CLOEXEC | unix.O_NONBLOCK
+	// Faults in kernel mode are handled too where allowed.
+	if os.Geteuid() != 0 && !userfaultfd.UnprivilegedUserfaultfd {
+		flags |= userfaultfd.UFFD_USER_MODE_ONLY
+	}
+	u, err := userfaultfd.New(flags, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_NORESERVE)
+	if err != nil {
+		u.Close()
+		return nil, err
+	}
+
+	r, err := u.RegisterRegion(mem, userfaultfd.UFFDIO_REGISTER_MODE_MISSING)
+	if err != nil {
+		u.Close()
+		unix.Munmap(mem)
+		return nil, err
+	}
+
+	a := &Arena{
+		uffd:   u,
+		region: r,
+		mem:    mem,
+		done:   make(chan error, 1),
+	}
+	go func() {
+		a.done <- r.Serve()
+	}()
+	return a, nil
+}
+
+// Alloc returns n bytes of zeroed memory from the arena.
+func (a *Arena) Alloc(n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, errors.New("invalid allocation size")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n > len(a.mem)-a.off {
+		return nil, ErrArenaFull
+	}
+	b := a.mem[a.off : a.off+n : a.off+n]
+	a.off = min((a.off+n+arenaAlign-1)&^(arenaAlign-1), len(a.mem))
+	return b, nil
+}
+
+// Reserved returns the size of the arena in bytes.
+func (a *Arena) Reserved() int64 {
+	return int64(len(a.mem))
+}
+
+// Allocated returns the number of bytes handed out by Alloc.
+func (a *Arena) Allocated() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int64(a.off)
+}
+
+// Touched returns the number of bytes in pages that were accessed.
+func (a *Arena) Touched() (int64, error) {
+	resident, _, err := a.region.ResidentBytes()
+	return resident, err
+}
+
+// Close stops serving faults and unmaps the arena. Memory returned by
+// Alloc must not be accessed afterwards.
+func (a *Arena) Close() error {
+	errs := []error{a.region.Close(), a.uffd.Close(), <-a.done}
+	errs = append(errs, unix.Munmap(a.mem))
+	return errors.Join(errs...)
+}
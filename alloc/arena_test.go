@@ -0,0 +1,122 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package alloc
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+	"golang.org/x/sys/unix"
+)
+
+func newArena(t *testing.T, size int) *Arena {
+	t.Helper()
+
+	// The faulting goroutine keeps its P while blocked, see userfaultfd.Serve.
+	if prev := runtime.GOMAXPROCS(0); prev < 2 {
+		runtime.GOMAXPROCS(2)
+		t.Cleanup(func() { runtime.GOMAXPROCS(prev) })
+	}
+
+	a, err := NewArena(size)
+	if err != nil {
+		t.Skipf("NewArena failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := a.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	})
+	return a
+}
+
+func TestArenaLazyZero(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	a := newArena(t, 1<<30)
+
+	if got := a.Reserved(); got != 1<<30 {
+		t.Fatalf("Reserved() = %d, want %d", got, 1<<30)
+	}
+
+	var bufs [][]byte
+	for i := 0; i < 3; i++ {
+		b, err := a.Alloc(4 * pageSize)
+		if err != nil {
+			t.Fatalf("Alloc failed: %v", err)
+		}
+		bufs = append(bufs, b)
+	}
+	for i, b := range bufs {
+		if b[0] != 0 {
+			t.Fatalf("allocation %d not zeroed", i)
+		}
+		b[0] = byte(i + 1)
+	}
+	for i, b := range bufs {
+		if b[0] != byte(i+1) {
+			t.Fatalf("allocation %d lost its contents", i)
+		}
+	}
+
+	if got := a.Allocated(); got != int64(12*pageSize) {
+		t.Fatalf("Allocated() = %d, want %d", got, 12*pageSize)
+	}
+	touched, err := a.Touched()
+	if err != nil {
+		t.Fatalf("Touched failed: %v", err)
+	}
+	if touched != int64(3*pageSize) {
+		t.Fatalf("Touched() = %d, want %d", touched, 3*pageSize)
+	}
+}
+
+// System calls can fill arena memory not touched yet, where allowed.
+func TestArenaKernelFault(t *testing.T) {
+	if os.Geteuid() != 0 && !userfaultfd.UnprivilegedUserfaultfd {
+		t.Skip("faults in kernel mode not handled")
+	}
+	a := newArena(t, 1<<20)
+	b, err := a.Alloc(16)
+	if err != nil {
+		t.Fatalf("Alloc failed: %v", err)
+	}
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(p[0])
+	defer unix.Close(p[1])
+	if _, err := unix.Write(p[1], []byte("arena")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.Read(p[0], b)
+	if err != nil {
+		t.Fatalf("read into the arena failed: %v", err)
+	}
+	if string(b[:n]) != "arena" {
+		t.Errorf("read %q into the arena", b[:n])
+	}
+}
+
+func TestArenaFull(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	a := newArena(t, pageSize)
+
+	b, err := a.Alloc(10)
+	if err != nil {
+		t.Fatalf("Alloc failed: %v", err)
+	}
+	if cap(b) != 10 {
+		t.Fatalf("cap = %d, want 10", cap(b))
+	}
+	if _, err := a.Alloc(pageSize); err != ErrArenaFull {
+		t.Fatalf("Alloc returned %v, want ErrArenaFull", err)
+	}
+	if _, err := a.Alloc(pageSize - arenaAlign); err != nil {
+		t.Fatalf("Alloc of the remaining space failed: %v", err)
+	}
+}
@@ -0,0 +1,4 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+// Package alloc provides debugging allocators built on userfaultfd.
+package alloc
@@ -0,0 +1,203 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"unsafe"
+)
+
+// ArchiveMember is a PageProvider serving a single member of a tar or zip
+// archive, so that artifacts in images can be mapped without being
+// extracted. Stored members are read straight from the archive, and when
+// the archive is a MappedPageProvider, such as a MappedSource, their pages
+// that are page aligned in it, as laid out by zipalign -p, are copied from
+// its mapping. Compressed members are inflated as far as read, keeping
+// what was inflated in memory. It is safe for concurrent use.
+type ArchiveMember struct {
+	size   int64
+	stored *io.SectionReader  // nil if compressed
+	mapped MappedPageProvider // of a stored member, or nil
+	off    int64              // of a stored member in the archive
+
+	mu   sync.Mutex
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser // inflating the member, nil before the first read
+	data []byte        // inflated so far
+}
+
+// OpenZipMember returns the member called name of the zip archive of size
+// bytes in r.
+func OpenZipMember(r io.ReaderAt, size int64, name string) (*ArchiveMember, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		if f.UncompressedSize64 > math.MaxInt64 {
+			return nil, fmt.Errorf("zip member %s too large", name)
+		}
+		m := &ArchiveMember{size: int64(f.UncompressedSize64)}
+		if f.Method != zip.Store {
+			m.open = f.Open
+			return m, nil
+		}
+		off, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		m.setStored(r, off)
+		return m, nil
+	}
+	return nil, fmt.Errorf("zip member %s: %w", name, os.ErrNotExist)
+}
+
+// OpenTarMember returns the regular file called name of the uncompressed
+// tar archive in r. Sparse files are not supported.
+func OpenTarMember(r io.ReaderAt, name string) (*ArchiveMember, error) {
+	cr := &countingReader{r: io.NewSectionReader(r, 0, math.MaxInt64)}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("tar member %s: %w", name, os.ErrNotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name != name {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.PAXRecords["GNU.sparse.map"] != "" || hdr.PAXRecords["GNU.sparse.major"] != "" {
+			return nil, fmt.Errorf("tar member %s is not a regular file", name)
+		}
+		// The data follows the header just read.
+		m := &ArchiveMember{size: hdr.Size}
+		m.setStored(r, cr.pos)
+		return m, nil
+	}
+}
+
+func (m *ArchiveMember) setStored(r io.ReaderAt, off int64) {
+	m.stored = io.NewSectionReader(r, off, m.size)
+	m.off = off
+	if p, ok := r.(MappedPageProvider); ok {
+		m.mapped = p
+	}
+}
+
+// Size returns the uncompressed size of the member.
+func (m *ArchiveMember) Size() int64 {
+	return m.size
+}
+
+// Compressed returns whether the member is compressed.
+func (m *ArchiveMember) Compressed() bool {
+	return m.stored == nil
+}
+
+// ReadAt implements PageProvider.
+func (m *ArchiveMember) ReadAt(p []byte, off int64) (int, error) {
+	if m.stored != nil {
+		return m.stored.ReadAt(p, off)
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), m.size)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.inflate(end); err != nil {
+		return 0, err
+	}
+	n := copy(p, m.data[off:end])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// inflate inflates the member up to end, with mu held.
+func (m *ArchiveMember) inflate(end int64) error {
+	if int64(len(m.data)) >= end {
+		return nil
+	}
+	if m.rc == nil {
+		rc, err := m.open()
+		if err != nil {
+			return err
+		}
+		m.rc = rc
+	}
+	// Grow in steps of at least 1MB, not to inflate a page at a time.
+	want := int(min(max(end, int64(len(m.data))+1<<20), m.size))
+	m.data = slices.Grow(m.data, want-len(m.data))
+	n, err := io.ReadFull(m.rc, m.data[len(m.data):want])
+	m.data = m.data[:len(m.data)+n]
+	if err != nil {
+		return fmt.Errorf("inflating archive member: %w", err)
+	}
+	return nil
+}
+
+// MappedPage implements MappedPageProvider, for the page aligned pages of
+// a stored member of a mapped archive.
+func (m *ArchiveMember) MappedPage(off int64, n int) []byte {
+	if m.mapped == nil || off < 0 || off+int64(n) > m.size {
+		return nil
+	}
+	b := m.mapped.MappedPage(m.off+off, n)
+	if len(b) == 0 || uintptr(unsafe.Pointer(&b[0]))%uintptr(os.Getpagesize()) != 0 {
+		return nil
+	}
+	return b
+}
+
+// Close releases the inflated data of a compressed member.
+func (m *ArchiveMember) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = nil
+	if m.rc == nil {
+		return nil
+	}
+	err := m.rc.Close()
+	m.rc = nil
+	return err
+}
+
+// countingReader keeps the position of a reader, as read or skipped by
+// tar.Reader.
+type countingReader struct {
+	r   *io.SectionReader
+	pos int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.r.Seek(offset, whence)
+	if err == nil {
+		c.pos = pos
+	}
+	return pos, err
+}
@@ -0,0 +1,131 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+// mappedBytes is a MappedPageProvider over a page aligned buffer.
+type mappedBytes struct {
+	*bytes.Reader
+	b []byte
+}
+
+func newMappedBytes(data []byte) mappedBytes {
+	pageSize := os.Getpagesize()
+	buf := make([]byte, len(data)+pageSize)
+	skip := -int(uintptr(unsafe.Pointer(&buf[0]))) & (pageSize - 1)
+	b := buf[skip : skip+len(data)]
+	copy(b, data)
+	return mappedBytes{bytes.NewReader(b), b}
+}
+
+func (m mappedBytes) MappedPage(off int64, n int) []byte {
+	return m.b[off : off+int64(n)]
+}
+
+func archiveData() []byte {
+	data := make([]byte, 3*os.Getpagesize()+100)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+	return data
+}
+
+func readMember(t *testing.T, m *ArchiveMember) []byte {
+	t.Helper()
+	got := make([]byte, m.Size())
+	// Out of order, to go back in inflated data.
+	half := m.Size() / 2
+	if _, err := m.ReadAt(got[half:], half); err != nil {
+		t.Fatalf("ReadAt(%d) failed: %v", half, err)
+	}
+	if _, err := m.ReadAt(got[:half], 0); err != nil {
+		t.Fatalf("ReadAt(0) failed: %v", err)
+	}
+	if _, err := m.ReadAt(make([]byte, 1), m.Size()); err != io.EOF {
+		t.Errorf("ReadAt past the end = %v", err)
+	}
+	return got
+}
+
+func TestZipMember(t *testing.T) {
+	data := archiveData()
+	pageSize := os.Getpagesize()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		name   string
+		method uint16
+	}{{"stored", zip.Store}, {"deflated", zip.Deflate}} {
+		// Align the data of the stored member, first, as zipalign -p does.
+		hdr := &zip.FileHeader{Name: f.name, Method: f.method}
+		if f.method == zip.Store {
+			hdr.Extra = make([]byte, 4+(-(30+len(f.name)+4))&(pageSize-1))
+			hdr.Extra[0] = 0xff // unknown extra field id
+			hdr.Extra[2] = byte(len(hdr.Extra) - 4)
+			hdr.Extra[3] = byte((len(hdr.Extra) - 4) >> 8)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	zw.Close()
+	archive := newMappedBytes(buf.Bytes())
+
+	for _, name := range []string{"deflated", "stored"} {
+		m, err := OpenZipMember(archive, int64(len(archive.b)), name)
+		if err != nil {
+			t.Fatalf("OpenZipMember(%s) failed: %v", name, err)
+		}
+		if m.Compressed() != (name == "deflated") {
+			t.Errorf("%s: Compressed = %v", name, m.Compressed())
+		}
+		if got := readMember(t, m); !bytes.Equal(got, data) {
+			t.Errorf("%s: member differs", name)
+		}
+		if mapped := m.MappedPage(int64(pageSize), pageSize); (mapped != nil) != (name == "stored") || mapped != nil && !bytes.Equal(mapped, data[pageSize:2*pageSize]) {
+			t.Errorf("%s: MappedPage = %d bytes", name, len(mapped))
+		}
+		m.Close()
+	}
+	if _, err := OpenZipMember(archive, int64(len(archive.b)), "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenZipMember of a missing member = %v", err)
+	}
+}
+
+func TestTarMember(t *testing.T) {
+	data := archiveData()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"first", "second"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write(data)
+	}
+	tw.WriteHeader(&tar.Header{Name: "dir/", Mode: 0o700, Typeflag: tar.TypeDir})
+	tw.Close()
+
+	m, err := OpenTarMember(bytes.NewReader(buf.Bytes()), "second")
+	if err != nil {
+		t.Fatalf("OpenTarMember failed: %v", err)
+	}
+	if got := readMember(t, m); !bytes.Equal(got, data) {
+		t.Error("member differs")
+	}
+	if _, err := OpenTarMember(bytes.NewReader(buf.Bytes()), "dir/"); err == nil {
+		t.Error("directory opened")
+	}
+	if _, err := OpenTarMember(bytes.NewReader(buf.Bytes()), "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenTarMember of a missing member = %v", err)
+	}
+}
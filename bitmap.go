@@ -0,0 +1,136 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Bitmap is a fixed-size set of page indexes.
+type Bitmap struct {
+	words []uint64
+	n     int
+}
+
+// NewBitmap returns an empty bitmap holding n bits.
+func NewBitmap(n int) Bitmap {
+	return Bitmap{words: make([]uint64, (n+63)/64), n: n}
+}
+
+// Len returns the number of bits in the bitmap.
+func (b Bitmap) Len() int {
+	return b.n
+}
+
+// Test returns true if bit i is set.
+func (b Bitmap) Test(i int) bool {
+	return b.words[i/64]&(1<<(i%64)) != 0
+}
+
+// Set sets bit i.
+func (b Bitmap) Set(i int) {
+	b.words[i/64] |= 1 << (i % 64)
+}
+
+// Clear clears bit i.
+func (b Bitmap) Clear(i int) {
+	b.words[i/64] &^= 1 << (i % 64)
+}
+
+// Count returns the number of bits set.
+func (b Bitmap) Count() int {
+	n := 0
+	for _, w := range b.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Clone returns a copy of the bitmap.
+func (b Bitmap) Clone() Bitmap {
+	return Bitmap{words: append([]uint64(nil), b.words...), n: b.n}
+}
+
+// Union sets the bits set in o, which must have the same length.
+func (b Bitmap) Union(o Bitmap) {
+	b.mustMatch(o)
+	for i, w := range o.words {
+		b.words[i] |= w
+	}
+}
+
+// Intersect clears the bits not set in o, which must have the same length.
+func (b Bitmap) Intersect(o Bitmap) {
+	b.mustMatch(o)
+	for i, w := range o.words {
+		b.words[i] &= w
+	}
+}
+
+func (b Bitmap) mustMatch(o Bitmap) {
+	if b.n != o.n {
+		panic("userfaultfd: bitmaps of different lengths")
+	}
+}
+
+// MarshalBinary encodes the bitmap as its length followed by its 64-bit
+// words, little endian.
+func (b Bitmap) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 8*(1+len(b.words)))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(b.n))
+	for _, w := range b.words {
+		buf = binary.LittleEndian.AppendUint64(buf, w)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a bitmap encoded by MarshalBinary.
+func (b *Bitmap) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("bitmap too short")
+	}
+	n := binary.LittleEndian.Uint64(data)
+	data = data[8:]
+	if n > uint64(len(data))*8 || uint64(len(data)) != (n+63)/64*8 {
+		return errors.New("bitmap length does not match its data")
+	}
+	nb := NewBitmap(int(n))
+	for i := range nb.words {
+		nb.words[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+	// Bits beyond the length must not be counted.
+	if r := n % 64; r != 0 && nb.words[len(nb.words)-1]>>r != 0 {
+		return errors.New("bitmap has bits set beyond its length")
+	}
+	*b = nb
+	return nil
+}
+
+// Atomic variants, for bitmaps updated concurrently.
+
+func (b Bitmap) testAtomic(i int) bool {
+	return atomic.LoadUint64(&b.words[i/64])&(1<<(i%64)) != 0
+}
+
+func (b Bitmap) setAtomic(i int) {
+	atomic.OrUint64(&b.words[i/64], 1<<(i%64))
+}
+
+// clearRangeAtomic clears bits i to j excluded.
+func (b Bitmap) clearRangeAtomic(i, j int) {
+	for ; i < j; i++ {
+		atomic.AndUint64(&b.words[i/64], ^uint64(1<<(i%64)))
+	}
+}
+
+// cloneAtomic returns a copy of the bitmap.
+func (b Bitmap) cloneAtomic() Bitmap {
+	c := NewBitmap(b.n)
+	for i := range b.words {
+		c.words[i] = atomic.LoadUint64(&b.words[i])
+	}
+	return c
+}
@@ -0,0 +1,74 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	b := NewBitmap(130)
+	if b.Len() != 130 {
+		t.Fatalf("Len() = %d, want 130", b.Len())
+	}
+
+	for _, i := range []int{0, 63, 64, 129} {
+		b.Set(i)
+	}
+	b.Clear(63)
+
+	for i := 0; i < b.Len(); i++ {
+		want := i == 0 || i == 64 || i == 129
+		if b.Test(i) != want {
+			t.Fatalf("Test(%d) = %v, want %v", i, b.Test(i), want)
+		}
+	}
+	if b.Count() != 3 {
+		t.Fatalf("Count() = %d, want 3", b.Count())
+	}
+}
+
+func TestBitmapSetOps(t *testing.T) {
+	a, b := NewBitmap(70), NewBitmap(70)
+	a.Set(1)
+	a.Set(65)
+	b.Set(65)
+	b.Set(69)
+
+	u := a.Clone()
+	u.Union(b)
+	if u.Count() != 3 || !u.Test(1) || !u.Test(69) {
+		t.Errorf("union has %d bits", u.Count())
+	}
+	a.Intersect(b)
+	if a.Count() != 1 || !a.Test(65) {
+		t.Errorf("intersection has %d bits", a.Count())
+	}
+
+	data, err := u.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d Bitmap
+	if err := d.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if d.Len() != 70 || d.Count() != 3 || !d.Test(1) || !d.Test(65) || !d.Test(69) {
+		t.Errorf("decoded %d of %d bits", d.Count(), d.Len())
+	}
+
+	data[len(data)-1] = 0x80 // bit 127, beyond the length
+	if err := d.UnmarshalBinary(data); err == nil {
+		t.Error("UnmarshalBinary accepted a bit beyond the length")
+	}
+	if err := d.UnmarshalBinary(data[:12]); err == nil {
+		t.Error("UnmarshalBinary accepted truncated data")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Union of bitmaps of different lengths did not panic")
+		}
+	}()
+	a.Union(NewBitmap(64))
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// BlockDevice is a PageProvider reading straight from a raw block device,
+// such as a disk image or the memory of a virtual machine on an NVMe
+// namespace, with O_DIRECT: pages are read into the region without going
+// through, or polluting, the page cache. Regular files on filesystems
+// supporting O_DIRECT work too.
+//
+// Reads that are not aligned to the logical block size of the device,
+// in offset, length or memory, go through a bounce buffer. The page
+// buffers of a Region are aligned, so only the last page of a device not
+// ending on a page boundary does.
+type BlockDevice struct {
+	f     *os.File
+	size  int64
+	align int64
+}
+
+// OpenBlockDevice opens the block device, or file, at path for reading
+// with O_DIRECT.
+func OpenBlockDevice(path string) (*BlockDevice, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_DIRECT|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := &BlockDevice{f: f, align: int64(os.Getpagesize())}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Mode()&os.ModeDevice != 0 {
+		n, err := unix.IoctlGetInt(int(f.Fd()), unix.BLKSSZGET)
+		if err != nil {
+			f.Close()
+			return nil, os.NewSyscallError("ioctl BLKSSZGET", err)
+		}
+		d.align = int64(n)
+	}
+	// Block devices have no size in their inode.
+	if d.size, err = f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if d.align <= 0 || d.align&(d.align-1) != 0 {
+		f.Close()
+		return nil, fmt.Errorf("invalid logical block size %d of %s", d.align, path)
+	}
+	return d, nil
+}
+
+// Size returns the size of the device in bytes.
+func (d *BlockDevice) Size() int64 {
+	return d.size
+}
+
+// BlockSize returns the alignment of direct reads.
+func (d *BlockDevice) BlockSize() int {
+	return int(d.align)
+}
+
+// ReadAt implements PageProvider.
+func (d *BlockDevice) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), d.size-off)
+	start := off &^ (d.align - 1)
+	end := (off + want + d.align - 1) &^ (d.align - 1)
+
+	var n int
+	var err error
+	if start == off && end == off+want && d.aligned(p) {
+		n, err = d.pread(p[:want], off)
+	} else {
+		buf := d.alignedBuf(int(end - start))
+		n, err = d.pread(buf, start)
+		n = copy(p[:want], buf[min(int64(n), off-start):n])
+	}
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// pread reads p at off, until the end of the device.
+func (d *BlockDevice) pread(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		var m int
+		err := retryOnEINTR(func() (err error) {
+			m, err = unix.Pread(int(d.f.Fd()), p[n:], off+int64(n))
+			return err
+		})
+		if err != nil {
+			return n, os.NewSyscallError("pread", err)
+		}
+		if m == 0 {
+			break
+		}
+		n += m
+	}
+	return n, nil
+}
+
+func (d *BlockDevice) aligned(p []byte) bool {
+	return len(p) > 0 && int64(uintptr(unsafe.Pointer(&p[0])))&(d.align-1) == 0
+}
+
+// alignedBuf returns a buffer of n bytes aligned for direct reads.
+func (d *BlockDevice) alignedBuf(n int) []byte {
+	buf := make([]byte, n+int(d.align))
+	skip := int(-int64(uintptr(unsafe.Pointer(&buf[0]))) & (d.align - 1))
+	return buf[skip : skip+n : skip+n]
+}
+
+// Close closes the device.
+func (d *BlockDevice) Close() error {
+	return d.f.Close()
+}
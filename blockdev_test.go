@@ -0,0 +1,57 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestBlockDevice(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	data := make([]byte, 2*pageSize+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	name := filepath.Join(t.TempDir(), "disk")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenBlockDevice(name)
+	if err != nil {
+		t.Skipf("O_DIRECT not available: %v", err)
+	}
+	defer d.Close()
+	if d.Size() != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", d.Size(), len(data))
+	}
+
+	// Unaligned reads go through a bounce buffer.
+	buf := make([]byte, 10)
+	if n, err := d.ReadAt(buf, 5); n != len(buf) || err != nil || !bytes.Equal(buf, data[5:15]) {
+		t.Errorf("ReadAt(5) = %d, %v", n, err)
+	}
+	buf = make([]byte, 200)
+	if n, err := d.ReadAt(buf, int64(2*pageSize)); n != 100 || err != io.EOF || !bytes.Equal(buf[:n], data[2*pageSize:]) {
+		t.Errorf("ReadAt at end = %d, %v", n, err)
+	}
+	if _, err := d.ReadAt(buf, int64(len(data))); err != io.EOF {
+		t.Errorf("ReadAt past end = %v", err)
+	}
+
+	mem, r := serveRegion(t, 3, WithProvider(d))
+	if !bytes.Equal(mem[:len(data)], data) {
+		t.Error("region differs from the device")
+	}
+	if !bytes.Equal(mem[len(data):], make([]byte, len(mem)-len(data))) {
+		t.Error("region not zero-filled past the device")
+	}
+	waitFor(t, func() bool { return r.Stats().BytesFilled == uint64(3*pageSize) })
+}
@@ -0,0 +1,88 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// BufferPool pins ranges of a served Region in memory, as the buffer pool
+// of a database does: Pin faults the pages in and excludes them from
+// eviction until Unpin. Pins of the same page nest. It is safe for
+// concurrent use.
+type BufferPool struct {
+	r      *Region
+	e      *Evictor
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewBufferPool returns a BufferPool over r, using its evictor, attached
+// with the policy set by WithEvictionPolicy if r has none.
+func NewBufferPool(r *Region) *BufferPool {
+	e := r.evictor.Load()
+	if e == nil {
+		e = NewEvictor(r, r.policy)
+	}
+	return &BufferPool{r: r, e: e}
+}
+
+// Pin makes the pages covering the length bytes at off resident and
+// excludes them from eviction, and returns these bytes. Missing pages are
+// faulted in with MADV_POPULATE_READ, so the region must be served by
+// another goroutine.
+func (p *BufferPool) Pin(off, length int) ([]byte, error) {
+	mem, err := p.r.pages(off, length)
+	if err != nil || len(mem) == 0 {
+		return nil, err
+	}
+	start := uintptr(unsafe.Pointer(unsafe.SliceData(mem)))
+	p.e.pin(start, len(mem))
+
+	vec := make([]byte, len(mem)/p.r.pageSize)
+	if err := mincore(mem, vec); err != nil {
+		p.e.unpin(start, len(mem))
+		return nil, err
+	}
+	hits := 0
+	for _, v := range vec {
+		hits += int(v & 1)
+	}
+	if hits < len(vec) {
+		if err := p.r.madvise(off, length, unix.MADV_POPULATE_READ); err != nil {
+			p.e.unpin(start, len(mem))
+			return nil, err
+		}
+	}
+	p.hits.Add(uint64(hits))
+	p.misses.Add(uint64(len(vec) - hits))
+	return p.r.mem[off : off+length], nil
+}
+
+// Unpin makes the pages covering the length bytes at off evictable again,
+// once unpinned as many times as pinned.
+func (p *BufferPool) Unpin(off, length int) error {
+	mem, err := p.r.pages(off, length)
+	if err != nil || len(mem) == 0 {
+		return err
+	}
+	return p.e.unpin(uintptr(unsafe.Pointer(unsafe.SliceData(mem))), len(mem))
+}
+
+// Stats returns the hit and miss counts of Pin and the number of pinned
+// pages.
+func (p *BufferPool) Stats() BufferPoolStats {
+	p.e.mu.Lock()
+	pinned := len(p.e.pins)
+	p.e.mu.Unlock()
+	return BufferPoolStats{
+		Hits:   p.hits.Load(),
+		Misses: p.misses.Load(),
+		Pinned: pinned,
+	}
+}
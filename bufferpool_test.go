@@ -0,0 +1,60 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestBufferPool(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 8, WithProvider(patternProvider(8)), WithMaxResidentBytes(int64(4*pageSize)))
+	p := NewBufferPool(r)
+
+	buf, err := p.Pin(pageSize/2, pageSize)
+	if err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+	if len(buf) != pageSize || buf[0] != 1 || buf[pageSize-1] != 2 {
+		t.Fatalf("unexpected pinned bytes")
+	}
+	if _, err := p.Pin(0, 2*pageSize); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+	if s := p.Stats(); s.Hits != 2 || s.Misses != 2 || s.Pinned != 2 {
+		t.Errorf("Stats = %+v, want 2 hits, 2 misses and 2 pinned pages", s)
+	}
+
+	// Faults past the resident limit evict the other pages only.
+	for i := 2; i < 8; i++ {
+		if got := mem[i*pageSize]; got != byte(i+1) {
+			t.Fatalf("page %d: got %#x, want %#x", i, got, i+1)
+		}
+	}
+	resident, err := r.Residency()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resident.Test(0) || !resident.Test(1) {
+		t.Error("pinned page evicted")
+	}
+	if r.Stats().Evictions == 0 {
+		t.Error("no page evicted")
+	}
+
+	for range 2 {
+		if err := p.Unpin(0, 2*pageSize); err != nil {
+			t.Fatalf("Unpin failed: %v", err)
+		}
+	}
+	if p.Stats().Pinned != 0 {
+		t.Errorf("pages still pinned")
+	}
+	if err := p.Unpin(0, pageSize); err == nil {
+		t.Error("Unpin of an unpinned page succeeded")
+	}
+}
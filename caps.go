@@ -0,0 +1,26 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+// KernelCaps reports what userfaultfd effectively supports on the running
+// kernel, as found by ProbeKernel, ioctl numbers of the build included.
+type KernelCaps struct {
+	Features      FeatureSet // Supported by the API handshake
+	MaxCopyBytes  int        // Largest UFFDIO_COPY observed to complete in one call
+	WPUnpopulated bool       // Unpopulated pages can be write-protected
+	WPAsync       bool       // Write-protect faults can be resolved by the kernel
+	Anon          MemoryCaps // Private anonymous memory
+	Shmem         MemoryCaps // Shared memory, as of memfd_create(2)
+	Hugetlb       MemoryCaps // Huge pages of the default size
+}
+
+// MemoryCaps reports what works on a kind of memory. Each ioctl was tried
+// on a test mapping registered in all the modes the kernel supports for it.
+type MemoryCaps struct {
+	Registered   bool // Could be registered at all
+	Minor        bool // Could be registered for minor faults
+	WriteProtect bool // UFFDIO_WRITEPROTECT
+	Continue     bool // UFFDIO_CONTINUE
+	Move         bool // UFFDIO_MOVE
+	Poison       bool // UFFDIO_POISON
+}
@@ -0,0 +1,169 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Features enabled on the userfaultfd of the probes.
+const probeFeatures = UFFD_FEATURE_PAGEFAULT_FLAG_WP | UFFD_FEATURE_MISSING_SHMEM |
+	UFFD_FEATURE_MISSING_HUGETLBFS | UFFD_FEATURE_MINOR_SHMEM | UFFD_FEATURE_MINOR_HUGETLBFS |
+	UFFD_FEATURE_WP_HUGETLBFS_SHMEM | UFFD_FEATURE_POISON | UFFD_FEATURE_MOVE
+
+// Size of the UFFDIO_COPY tried by ProbeKernel.
+const probeCopySize = 1 << 20
+
+var probeOnce = sync.OnceValues(probeKernel)
+
+// ProbeKernel reports the effective capabilities of userfaultfd on the
+// running kernel, found by trying the ioctls on small test mappings of
+// each kind of memory, so that they are known before they fail in
+// production. The probes run once, and their result is returned by later
+// calls. Hugetlb is only probed if huge pages are available.
+func ProbeKernel() (KernelCaps, error) {
+	return probeOnce()
+}
+
+func probeKernel() (KernelCaps, error) {
+	flags := unix.O_CLOEXEC | unix.O_NONBLOCK
+	if HaveUserModeOnly {
+		flags |= UFFD_USER_MODE_ONLY
+	}
+	u, granted, err := NewBestEffort(flags, probeFeatures)
+	if err != nil {
+		return KernelCaps{}, err
+	}
+	defer u.Close()
+
+	features := u.Features()
+	caps := KernelCaps{
+		Features:      FeatureSet(features),
+		WPUnpopulated: features&UFFD_FEATURE_WP_UNPOPULATED != 0,
+		WPAsync:       features&UFFD_FEATURE_WP_ASYNC != 0,
+		MaxCopyBytes:  probeCopy(u),
+	}
+	var mode int
+	if granted&UFFD_FEATURE_PAGEFAULT_FLAG_WP != 0 {
+		mode |= UFFDIO_REGISTER_MODE_WP
+	}
+	caps.Anon = probeMemory(u, -1, mode)
+
+	mode = 0
+	if granted&UFFD_FEATURE_WP_HUGETLBFS_SHMEM != 0 {
+		mode |= UFFDIO_REGISTER_MODE_WP
+	}
+	if granted&UFFD_FEATURE_MISSING_SHMEM != 0 {
+		if fd, err := unix.MemfdCreate("uffd-probe", unix.MFD_CLOEXEC); err == nil {
+			m := mode
+			if granted&UFFD_FEATURE_MINOR_SHMEM != 0 {
+				m |= UFFDIO_REGISTER_MODE_MINOR
+			}
+			caps.Shmem = probeMemory(u, fd, m)
+			unix.Close(fd)
+		}
+	}
+	if granted&UFFD_FEATURE_MISSING_HUGETLBFS != 0 {
+		if fd, err := unix.MemfdCreate("uffd-probe", unix.MFD_CLOEXEC|unix.MFD_HUGETLB); err == nil {
+			m := mode
+			if granted&UFFD_FEATURE_MINOR_HUGETLBFS != 0 {
+				m |= UFFDIO_REGISTER_MODE_MINOR
+			}
+			caps.Hugetlb = probeMemory(u, fd, m)
+			unix.Close(fd)
+		}
+	}
+	return caps, nil
+}
+
+// probeCopy returns how much of a large UFFDIO_COPY completes in one call.
+func probeCopy(u *Uffd) int {
+	mem, err := unix.Mmap(-1, 0, probeCopySize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_NORESERVE)
+	if err != nil {
+		return 0
+	}
+	defer unix.Munmap(mem)
+	dst := uintptr(unsafe.Pointer(&mem[0]))
+	if _, err := u.Register(dst, len(mem), UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		return 0
+	}
+	defer u.Unregister(dst, len(mem))
+	src := make([]byte, probeCopySize)
+	n, _ := u.Copy(dst, uintptr(unsafe.Pointer(&src[0])), len(src), UFFDIO_COPY_MODE_DONTWAKE)
+	return int(max(n, 0))
+}
+
+// probeMemory tries the ioctls on four pages of the file fd, or of
+// anonymous memory if fd is negative, registered in missing mode and mode:
+// page 0 is copied then write-protected, page 1 poisoned, page 2 moved into
+// and page 3 continued.
+func probeMemory(u *Uffd, fd int, mode int) (caps MemoryCaps) {
+	pageSize := os.Getpagesize()
+	flags := unix.MAP_PRIVATE | unix.MAP_ANONYMOUS
+	if fd >= 0 {
+		var st unix.Stat_t
+		if unix.Fstat(fd, &st) != nil {
+			return caps
+		}
+		pageSize = int(st.Blksize)
+		if unix.Ftruncate(fd, int64(4*pageSize)) != nil {
+			return caps
+		}
+		flags = unix.MAP_SHARED
+	}
+	length := 4 * pageSize
+	mem, err := unix.Mmap(fd, 0, length, unix.PROT_READ|unix.PROT_WRITE, flags)
+	if err != nil {
+		return caps
+	}
+	defer unix.Munmap(mem)
+	base := uintptr(unsafe.Pointer(&mem[0]))
+	page := func(i int) uintptr {
+		return base + uintptr(i*pageSize)
+	}
+
+	mode |= UFFDIO_REGISTER_MODE_MISSING
+	if _, err := u.Register(base, length, mode); err != nil {
+		// Without the optional modes.
+		mode = UFFDIO_REGISTER_MODE_MISSING
+		if _, err := u.Register(base, length, mode); err != nil {
+			return caps
+		}
+	}
+	defer u.Unregister(base, length)
+	caps.Registered = true
+	caps.Minor = mode&UFFDIO_REGISTER_MODE_MINOR != 0
+
+	// Pages of the source, anonymous for moves.
+	src, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return caps
+	}
+	defer unix.Munmap(src)
+	src[0] = 1
+	srcAddr := uintptr(unsafe.Pointer(&src[0]))
+
+	if _, err := u.Copy(page(0), srcAddr, pageSize, UFFDIO_COPY_MODE_DONTWAKE); err == nil && mode&UFFDIO_REGISTER_MODE_WP != 0 {
+		caps.WriteProtect = u.WriteProtect(page(0), pageSize, UFFDIO_WRITEPROTECT_MODE_WP) == nil
+	}
+	_, err = u.Poison(page(1), pageSize, UFFDIO_POISON_MODE_DONTWAKE)
+	caps.Poison = err == nil
+	_, err = u.Move(page(2), srcAddr, pageSize, UFFDIO_MOVE_MODE_DONTWAKE)
+	caps.Move = err == nil
+	if caps.Minor {
+		// Populate the page cache through another view.
+		alias, err := unix.Mmap(fd, 0, length, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+		if err == nil {
+			alias[3*pageSize] = 1
+			caps.Continue = u.Continue(page(3), pageSize, UFFDIO_CONTINUE_MODE_DONTWAKE) == nil
+			unix.Munmap(alias)
+		}
+	}
+	return caps
+}
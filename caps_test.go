@@ -0,0 +1,42 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import "testing"
+
+func TestProbeKernel(t *testing.T) {
+	caps, err := ProbeKernel()
+	if err != nil {
+		t.Fatalf("ProbeKernel failed: %v", err)
+	}
+	t.Logf("%+v", caps)
+	if !caps.Anon.Registered || caps.Anon.Minor || caps.Anon.Continue {
+		t.Errorf("anonymous memory capabilities %+v", caps.Anon)
+	}
+	if caps.MaxCopyBytes <= 0 {
+		t.Errorf("MaxCopyBytes = %d", caps.MaxCopyBytes)
+	}
+	// The ioctls work if known to the build and supported.
+	has := caps.Features.Has
+	if want := HaveIoctlWriteProtect && has(UFFD_FEATURE_PAGEFAULT_FLAG_WP); caps.Anon.WriteProtect != want {
+		t.Errorf("Anon.WriteProtect = %v, want %v", caps.Anon.WriteProtect, want)
+	}
+	if want := HaveIoctlMove && has(UFFD_FEATURE_MOVE); caps.Anon.Move != want {
+		t.Errorf("Anon.Move = %v, want %v", caps.Anon.Move, want)
+	}
+	if want := HaveIoctlPoison && has(UFFD_FEATURE_POISON); caps.Anon.Poison != want {
+		t.Errorf("Anon.Poison = %v, want %v", caps.Anon.Poison, want)
+	}
+	if caps.Shmem.Move {
+		t.Error("moves reported to work on shared memory")
+	}
+	if caps.Shmem.Minor && caps.Shmem.Continue != HaveIoctlContinue {
+		t.Errorf("Shmem.Continue = %v", caps.Shmem.Continue)
+	}
+
+	if again, _ := ProbeKernel(); again != caps {
+		t.Error("second probe differs")
+	}
+}
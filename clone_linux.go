@@ -0,0 +1,109 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"io"
+	"slices"
+)
+
+// Clone returns a copy-on-write clone of the region, such as to snapshot
+// large in-memory state before a test. The clone is a private anonymous
+// mapping whose pages are filled on first access with the current contents
+// of the region. Writes to the clone stay in the clone, and the first write
+// to each page of the region copies its contents to its clones beforehand.
+//
+// The region must be registered in missing and write-protect modes, as
+// with write-back, and be served meanwhile. Its resident pages are
+// write-protected, as are the pages it fills from then on. The clone has
+// its own userfaultfd, served by its Serve method and closed by Close.
+// Pages evicted from the clone are filled again from the region.
+func (r *Region) Clone(opts ...RegionOption) (*Region, error) {
+	want := UFFDIO_REGISTER_MODE_MISSING | UFFDIO_REGISTER_MODE_WP
+	if r.mode&want != want || r.mode&UFFDIO_REGISTER_MODE_MINOR != 0 {
+		return nil, errors.New("clones need a region registered in missing and write-protect modes")
+	}
+	if r.staging != nil {
+		return nil, errors.New("move staging is not compatible with clones")
+	}
+	u, err := New(r.uffd.flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]RegionOption{WithProvider(cloneSource{r})}, opts...)
+	c, err := u.MapAndRegister(int64(len(r.mem)), UFFDIO_REGISTER_MODE_MISSING, opts...)
+	if err != nil {
+		u.Close()
+		return nil, err
+	}
+	c.origin = r
+
+	// Fills are write-protected once there is a clone, so protect the
+	// pages filled before.
+	r.cloneMu.Lock()
+	r.clones = append(r.clones, c)
+	r.cloneMu.Unlock()
+	if err := r.uffd.WriteProtect(r.Base(), len(r.mem), UFFDIO_WRITEPROTECT_MODE_WP); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// cloned reports whether the region has clones.
+func (r *Region) cloned() bool {
+	r.cloneMu.RLock()
+	defer r.cloneMu.RUnlock()
+	return len(r.clones) > 0
+}
+
+// diverge copies page, about to be written, to the clones that did not
+// fill it yet.
+func (r *Region) diverge(page uintptr) error {
+	r.cloneMu.RLock()
+	defer r.cloneMu.RUnlock()
+
+	off := page - r.Base()
+	for _, c := range r.clones {
+		if c.filled.testAtomic(c.pageIndex(c.Base() + off)) {
+			continue
+		}
+		_, err := c.uffd.Copy(c.Base()+off, page, r.pageSize, 0)
+		if err == nil {
+			c.hashPage(c.Base()+off, r.mem[off:off+uintptr(r.pageSize)])
+		}
+		if err == nil || errors.Is(err, ErrAlreadyMapped) {
+			c.filled.setAtomic(c.pageIndex(c.Base() + off))
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+// detach stops the region of a clone from copying pages to it.
+func (r *Region) detach() {
+	o := r.origin
+	o.cloneMu.Lock()
+	defer o.cloneMu.Unlock()
+	o.clones = slices.DeleteFunc(o.clones, func(c *Region) bool { return c == r })
+}
+
+// cloneSource reads the current contents of the region of a clone.
+type cloneSource struct {
+	r *Region
+}
+
+func (s cloneSource) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(s.r.mem)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.r.mem[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
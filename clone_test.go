@@ -0,0 +1,65 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRegionClone(t *testing.T) {
+	// The clone faults on the region while its own faults are resolved.
+	needProcs(t, 4)
+	pageSize := unix.Getpagesize()
+	r := serveWriteProtected(t, 4)
+	mem := r.Bytes()
+	mem[0] = 0xAA
+
+	c, err := r.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Serve()
+	}()
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+	}()
+	clone := c.Bytes()
+
+	// Writes to the region, to pages filled before cloning or not, are
+	// not seen by the clone.
+	mem[0] = 0xBB
+	mem[pageSize] = 0xBB
+	if clone[0] != 0xAA || clone[pageSize] != 2 {
+		t.Errorf("clone sees writes to the region: %#x %#x", clone[0], clone[pageSize])
+	}
+	// Writes to the clone are not seen by the region.
+	if clone[2*pageSize] != 3 {
+		t.Errorf("clone page 2 = %#x, want 3", clone[2*pageSize])
+	}
+	clone[2*pageSize] = 0xCC
+	clone[3*pageSize] = 0xCC
+	if mem[2*pageSize] != 3 || mem[3*pageSize] != 4 {
+		t.Errorf("region sees writes to the clone: %#x %#x", mem[2*pageSize], mem[3*pageSize])
+	}
+	if mem[0] != 0xBB || mem[pageSize] != 0xBB {
+		t.Errorf("region lost its writes: %#x %#x", mem[0], mem[pageSize])
+	}
+}
+
+func TestRegionCloneMode(t *testing.T) {
+	_, r := serveRegion(t, 1)
+	if _, err := r.Clone(); err == nil {
+		t.Error("region without write-protect mode cloned")
+	}
+}
@@ -0,0 +1,163 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+// Command uffd-cat writes a file to the standard output through a memory
+// mapping whose pages are filled on demand by a userfaultfd, and prints the
+// fault statistics to the standard error. The file may be a local path or
+// an http(s) URL of a server that supports range requests.
+//
+// Usage:
+//
+//	uffd-cat [-q] file|url
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+	"golang.org/x/sys/unix"
+)
+
+func main() {
+	quiet := flag.Bool("q", false, "do not print fault statistics")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-q] file|url\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	stats, err := cat(os.Stdout, flag.Arg(0))
+	if !*quiet {
+		printStats(os.Stderr, stats)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "uffd-cat: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// cat copies name to w through a userfaultfd-backed mapping.
+func cat(w io.Writer, name string) (userfaultfd.Stats, error) {
+	var stats userfaultfd.Stats
+	src, size, err := open(name)
+	if err != nil {
+		return stats, err
+	}
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	if size == 0 {
+		return stats, nil
+	}
+
+	flags := unix.O_CLOEXEC | unix.O_NONBLOCK
+	if userfaultfd.HaveUserModeOnly {
+		flags |= userfaultfd.UFFD_USER_MODE_ONLY
+	}
+	uffd, err := userfaultfd.New(flags, 0)
+	if err != nil {
+		return stats, err
+	}
+	defer uffd.Close()
+
+	r, err := uffd.MapAndRegister(size, userfaultfd.UFFDIO_REGISTER_MODE_MISSING, userfaultfd.WithProvider(src))
+	if err != nil {
+		return stats, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+
+	// Copy through a buffer rather than passing the mapping to write(2):
+	// faults taken by the kernel are not served with UFFD_USER_MODE_ONLY.
+	// The reads of the region fill the pages before copying them, so they
+	// are counted as filled rather than as faults.
+	_, err = io.Copy(w, io.NewSectionReader(r, 0, size))
+
+	uffd.Close()
+	if serr := <-done; err == nil {
+		err = serr
+	}
+	stats = r.Stats()
+	r.Close()
+	return stats, err
+}
+
+// open returns a reader of a local file or URL and its size.
+func open(name string) (io.ReaderAt, int64, error) {
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		resp, err := http.Head(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, 0, fmt.Errorf("%s: %s", name, resp.Status)
+		}
+		if resp.ContentLength < 0 {
+			return nil, 0, fmt.Errorf("%s: unknown size", name)
+		}
+		return &httpFile{url: name}, resp.ContentLength, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// httpFile reads a remote file with HTTP range requests.
+type httpFile struct {
+	url string
+}
+
+func (f *httpFile) ReadAt(p []byte, off int64) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		return 0, fmt.Errorf("%s: %s", f.url, resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func printStats(w io.Writer, s userfaultfd.Stats) {
+	fmt.Fprintf(w, "faults: %d missing, %d errors\n", s.Missing, s.Errors)
+	fmt.Fprintf(w, "filled: %d bytes\n", s.BytesFilled)
+	if s.Latency.Count() > 0 {
+		fmt.Fprintf(w, "latency: min %v, mean %v, p99 %v, max %v\n",
+			s.Latency.Min(), s.Latency.Mean(), s.Latency.Quantile(0.99), s.Latency.Max())
+	}
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCat(t *testing.T) {
+	data := make([]byte, 3*os.Getpagesize()+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	name := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	stats, err := cat(&out, name)
+	if err != nil {
+		t.Fatalf("cat failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("output does not match")
+	}
+	if want := uint64(4 * os.Getpagesize()); stats.BytesFilled != want {
+		t.Errorf("%d bytes filled, want %d", stats.BytesFilled, want)
+	}
+}
+
+func TestCatHTTP(t *testing.T) {
+	data := bytes.Repeat([]byte("uffd"), os.Getpagesize())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	if _, err := cat(&out, srv.URL); err != nil {
+		t.Fatalf("cat failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("output does not match")
+	}
+}
@@ -0,0 +1,236 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+// Command uffd-migrate demonstrates post-copy migration of memory between
+// two processes, possibly on different hosts. The sender serves its memory
+// with the page-transfer protocol of userfaultfd.PageServer. The receiver
+// maps the same amount of memory at once and starts using it: pages are
+// fetched when first touched, while the rest are pulled in the background.
+// Both sides print a digest of the memory to check the result.
+//
+// Usage:
+//
+//	uffd-migrate send [-listen network:address] [-size bytes] [file]
+//	uffd-migrate recv [-connect network:address] [-touches n] [-rate bytes]
+//
+// The sender migrates the contents of file, or size random bytes. Addresses
+// are either unix:path or tcp:host:port. The receiver pulls at most rate
+// bytes per second in the background, leaving the rest of the bandwidth to
+// demand faults.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand/v2"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+	"golang.org/x/sys/unix"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s send [options] [file]\n       %s recv [options]\n", os.Args[0], os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	log.SetPrefix("uffd-migrate: ")
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "send":
+		sendMain(os.Args[2:])
+	case "recv":
+		recvMain(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func sendMain(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	listenAddr := fs.String("listen", "tcp:localhost:7071", "`network:address` to listen on")
+	size := fs.Int64("size", 64<<20, "`bytes` of random memory to migrate without a file")
+	fs.Parse(args)
+
+	var mem []byte
+	var err error
+	if fs.NArg() > 0 {
+		mem, err = os.ReadFile(fs.Arg(0))
+	} else {
+		mem = make([]byte, *size)
+		_, err = rand.Read(mem)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	network, address, err := splitAddr(*listenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving %d bytes, sha256 %x", len(mem), sha256.Sum256(mem))
+
+	s := send(mem)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		s.Close()
+	}()
+	if err := s.Serve(l); err != nil {
+		log.Fatal(err)
+	}
+	st := s.Stats()
+	log.Printf("sent %d bytes in %d requests", st.Bytes, st.Requests)
+}
+
+// send returns a server of the memory to migrate.
+func send(mem []byte) *userfaultfd.PageServer {
+	return userfaultfd.NewPageServer(&memReader{mem: mem}, int64(len(mem)), 0, 0)
+}
+
+// memReader serves memory without the seek offset of a bytes.Reader.
+type memReader struct {
+	mem []byte
+}
+
+func (m *memReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.mem)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.mem[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func recvMain(args []string) {
+	fs := flag.NewFlagSet("recv", flag.ExitOnError)
+	connectAddr := fs.String("connect", "tcp:localhost:7071", "`network:address` of the sender")
+	touches := fs.Int("touches", 1000, "random pages touched while the rest is pulled")
+	rate := fs.Float64("rate", 0, "`bytes` per second pulled in the background, 0 for no limit")
+	fs.Parse(args)
+
+	network, address, err := splitAddr(*connectAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now()
+	res, err := receive(network, address, *touches, *rate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("received %d bytes in %v, sha256 %x", res.size, time.Since(start), res.digest)
+	log.Printf("%d demand faults, p50 %v, p99 %v", res.stats.Missing,
+		res.stats.Latency.Quantile(0.5), res.stats.Latency.Quantile(0.99))
+}
+
+// sink keeps reads from being optimized away.
+var sink byte
+
+type result struct {
+	size   int64
+	digest [sha256.Size]byte
+	stats  userfaultfd.Stats
+}
+
+// receive maps the memory of the sender, touches random pages of it while
+// the others are pulled in order at rate bytes per second, and returns the
+// digest of the memory.
+func receive(network, address string, touches int, rate float64) (*result, error) {
+	// The workload, the puller and the handler all need a P.
+	runtime.GOMAXPROCS(max(runtime.GOMAXPROCS(0), 3))
+
+	client, err := userfaultfd.DialPages(network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	size := client.Size()
+
+	flags := unix.O_CLOEXEC | unix.O_NONBLOCK
+	if userfaultfd.HaveUserModeOnly {
+		flags |= userfaultfd.UFFD_USER_MODE_ONLY
+	}
+	uffd, err := userfaultfd.New(flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer uffd.Close()
+
+	limiter := userfaultfd.NewRateLimiter(rate, 0)
+	r, err := uffd.MapAndRegister(size, userfaultfd.UFFDIO_REGISTER_MODE_MISSING,
+		userfaultfd.WithProvider(client), userfaultfd.WithRateLimiter(limiter, false))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+
+	mem := r.Bytes()[:size]
+	pageSize := os.Getpagesize()
+	pages := (len(mem) + pageSize - 1) / pageSize
+
+	// Pull the pages in order in the background, while the workload
+	// touches random pages.
+	var wg sync.WaitGroup
+	var pullErr error
+	wg.Go(func() {
+		pullErr = r.Prefetch(context.Background(), 0, size)
+	})
+	var touched byte
+	for range touches {
+		touched ^= mem[mrand.IntN(pages)*pageSize]
+	}
+	wg.Wait()
+	sink = touched
+	if pullErr != nil {
+		return nil, pullErr
+	}
+
+	res := &result{size: size, digest: sha256.Sum256(mem)}
+	uffd.Close()
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	res.stats = r.Stats()
+	return res, nil
+}
+
+// splitAddr parses unix:path or tcp:host:port.
+func splitAddr(spec string) (network, address string, err error) {
+	network, address, ok := strings.Cut(spec, ":")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("invalid address %q", spec)
+	}
+	switch network {
+	case "unix", "tcp", "tcp4", "tcp6":
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("unsupported network %q", network)
+}
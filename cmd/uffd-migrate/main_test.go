@@ -0,0 +1,51 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package main
+
+import (
+	"crypto/sha256"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	mem := make([]byte, 64*os.Getpagesize()+10)
+	for i := range mem {
+		mem[i] = byte(i * 31)
+	}
+	addr := filepath.Join(t.TempDir(), "migrate.sock")
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := send(mem)
+	go s.Serve(l)
+	defer s.Close()
+
+	// Pull slowly enough for the touches to fault.
+	res, err := receive("unix", addr, 100, float64(len(mem)*4))
+	if err != nil {
+		t.Fatalf("receive failed: %v", err)
+	}
+	if res.size != int64(len(mem)) || res.digest != sha256.Sum256(mem) {
+		t.Errorf("received %d bytes with a different digest", res.size)
+	}
+	if res.stats.Missing == 0 {
+		t.Errorf("%d missing faults", res.stats.Missing)
+	}
+}
+
+func TestSplitAddr(t *testing.T) {
+	if n, a, err := splitAddr("tcp:localhost:1"); n != "tcp" || a != "localhost:1" || err != nil {
+		t.Errorf("splitAddr = %q, %q, %v", n, a, err)
+	}
+	for _, spec := range []string{"", "tcp:", "udp:x:1"} {
+		if _, _, err := splitAddr(spec); err == nil {
+			t.Errorf("splitAddr(%q) succeeded", spec)
+		}
+	}
+}
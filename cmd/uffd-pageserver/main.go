@@ -0,0 +1,107 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+// Command uffd-pageserver serves the pages of a snapshot or any other file
+// with the page-transfer protocol of userfaultfd.PageServer, to be mapped
+// lazily by clients with userfaultfd.PageClient as their provider.
+//
+// Usage:
+//
+//	uffd-pageserver [-listen network:address] [-metrics address] file
+//
+// The listen address is either unix:path or tcp:host:port. Counters are
+// published with expvar at /debug/vars of the metrics address, if any.
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+)
+
+func main() {
+	listenAddr := flag.String("listen", "tcp:localhost:7070", "`network:address` to listen on")
+	metricsAddr := flag.String("metrics", "", "`address` to serve metrics on")
+	maxConns := flag.Int("max-conns", 64, "maximum number of connections, 0 for no limit")
+	maxInflight := flag.Int("max-inflight", 16, "maximum number of concurrent reads, 0 for no limit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetPrefix("uffd-pageserver: ")
+	log.SetFlags(0)
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	l, err := listen(*listenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := userfaultfd.NewPageServer(f, fi.Size(), *maxConns, *maxInflight)
+	publish(s)
+
+	if *metricsAddr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		s.Close()
+	}()
+
+	if err := s.Serve(l); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// listen parses unix:path or tcp:host:port and listens on it.
+func listen(spec string) (net.Listener, error) {
+	network, address, ok := strings.Cut(spec, ":")
+	if !ok || address == "" {
+		return nil, fmt.Errorf("invalid listen address %q", spec)
+	}
+	switch network {
+	case "unix", "tcp", "tcp4", "tcp6":
+		return net.Listen(network, address)
+	}
+	return nil, fmt.Errorf("unsupported network %q", network)
+}
+
+// publish exposes the server counters with expvar.
+func publish(s *userfaultfd.PageServer) {
+	expvar.Publish("pageserver", expvar.Func(func() any {
+		st := s.Stats()
+		return map[string]any{
+			"conns":    st.Conns,
+			"active":   st.Active,
+			"requests": st.Requests,
+			"bytes":    st.Bytes,
+			"errors":   st.Errors,
+		}
+	}))
+}
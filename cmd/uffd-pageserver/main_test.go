@@ -0,0 +1,39 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+)
+
+func TestListen(t *testing.T) {
+	for _, spec := range []string{"", "unix:", "udp:localhost:0", "localhost"} {
+		if l, err := listen(spec); err == nil {
+			l.Close()
+			t.Errorf("listen(%q) succeeded", spec)
+		}
+	}
+
+	l, err := listen("unix:" + filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("hello, pages")
+	s := userfaultfd.NewPageServer(bytes.NewReader(data), int64(len(data)), 1, 1)
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := userfaultfd.DialPages("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	buf := make([]byte, 5)
+	if _, err := c.ReadAt(buf, 7); err != nil || string(buf) != "pages" {
+		t.Errorf("ReadAt = %q, %v", buf, err)
+	}
+}
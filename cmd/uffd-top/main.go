@@ -0,0 +1,178 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+// Command uffd-top shows the fault rates, latencies, residency and heatmaps
+// of a running handler, as published by userfaultfd.ExpvarPublisher and
+// served by the expvar handler.
+//
+// Usage:
+//
+//	uffd-top [-url url] [-prefix name] [-interval duration] [-n count]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:6060/debug/vars", "expvar `url` of the handler")
+	prefix := flag.String("prefix", "userfaultfd", "`name` given to NewExpvarPublisher")
+	interval := flag.Duration("interval", time.Second, "refresh `interval`")
+	count := flag.Int("n", 0, "number of refreshes, 0 for no limit")
+	flag.Parse()
+	log.SetPrefix("uffd-top: ")
+	log.SetFlags(0)
+
+	prev, err := fetch(*url, *prefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	last := time.Now()
+	for i := 0; *count == 0 || i < *count; i++ {
+		time.Sleep(*interval)
+		cur, err := fetch(*url, *prefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		now := time.Now()
+		// Clear the screen and move to the top left corner.
+		fmt.Print("\x1b[H\x1b[2J")
+		render(os.Stdout, prev, cur, now.Sub(last))
+		prev, last = cur, now
+	}
+}
+
+// snapshot holds the published entries by name.
+type snapshot map[string]entry
+
+// entry is a set of counters or a heatmap.
+type entry struct {
+	Pagefault, Fork, Remap, Remove, Unmap float64 // of a userfaultfd
+
+	Missing       float64 `json:"missing"`
+	WP            float64 `json:"wp"`
+	Minor         float64 `json:"minor"`
+	Errors        float64 `json:"errors"`
+	LatencyP50    float64 `json:"latency_p50_ns"`
+	LatencyP99    float64 `json:"latency_p99_ns"`
+	LatencyMax    float64 `json:"latency_max_ns"`
+	SizeBytes     float64 `json:"size_bytes"`
+	ResidentBytes float64 `json:"resident_bytes"`
+
+	BucketSize float64   `json:"bucket_size"`
+	Faults     []float64 `json:"faults"`
+}
+
+func (e entry) isUffd() bool    { return e.SizeBytes == 0 && e.Faults == nil }
+func (e entry) isHeatmap() bool { return e.Faults != nil }
+
+// fetch reads the entries published under prefix.
+func fetch(url, prefix string) (snapshot, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	raw, ok := vars[prefix]
+	if !ok {
+		return nil, fmt.Errorf("%s: no %q variable", url, prefix)
+	}
+	var s snapshot
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	return s, nil
+}
+
+// render writes the rates between prev and cur, taken dt apart.
+func render(w io.Writer, prev, cur snapshot, dt time.Duration) {
+	rate := func(cur, prev float64) float64 {
+		return max(cur-prev, 0) / dt.Seconds()
+	}
+	names := slices.Sorted(maps.Keys(cur))
+
+	fmt.Fprintf(w, "%-20s %10s %10s %10s %10s\n", "USERFAULTFD", "FAULTS/s", "FORK/s", "REMOVE/s", "UNMAP/s")
+	for _, name := range names {
+		c, p := cur[name], prev[name]
+		if !c.isUffd() {
+			continue
+		}
+		fmt.Fprintf(w, "%-20s %10.0f %10.0f %10.0f %10.0f\n", name,
+			rate(c.Pagefault, p.Pagefault), rate(c.Fork, p.Fork), rate(c.Remove, p.Remove), rate(c.Unmap, p.Unmap))
+	}
+
+	fmt.Fprintf(w, "\n%-20s %10s %10s %10s %8s %10s %10s %10s %16s\n",
+		"REGION", "MISSING/s", "WP/s", "MINOR/s", "ERRORS", "P50", "P99", "MAX", "RESIDENT")
+	for _, name := range names {
+		c, p := cur[name], prev[name]
+		if c.isUffd() || c.isHeatmap() {
+			continue
+		}
+		fmt.Fprintf(w, "%-20s %10.0f %10.0f %10.0f %8.0f %10v %10v %10v %16s\n", name,
+			rate(c.Missing, p.Missing), rate(c.WP, p.WP), rate(c.Minor, p.Minor), c.Errors,
+			time.Duration(c.LatencyP50), time.Duration(c.LatencyP99), time.Duration(c.LatencyMax),
+			fmt.Sprintf("%s/%s", size(c.ResidentBytes), size(c.SizeBytes)))
+	}
+
+	for _, name := range names {
+		c, p := cur[name], prev[name]
+		if !c.isHeatmap() {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s (%s per cell, faults since last refresh)\n%s\n", name, size(c.BucketSize), heat(c.Faults, p.Faults))
+	}
+}
+
+// heat draws the faults of each bucket since prev as a line of shades.
+func heat(cur, prev []float64) string {
+	const shades = " ░▒▓█"
+	runes := []rune(shades)
+	var top float64
+	deltas := make([]float64, len(cur))
+	for i, n := range cur {
+		if i < len(prev) {
+			n -= prev[i]
+		}
+		deltas[i] = max(n, 0)
+		top = max(top, deltas[i])
+	}
+	var b strings.Builder
+	for _, d := range deltas {
+		i := 0
+		if top > 0 {
+			i = int(d / top * float64(len(runes)-1))
+		}
+		if d > 0 {
+			i = max(i, 1)
+		}
+		b.WriteRune(runes[i])
+	}
+	return b.String()
+}
+
+// size formats a number of bytes with a binary unit.
+func size(n float64) string {
+	for _, unit := range []string{"B", "KiB", "MiB", "GiB"} {
+		if n < 1024 {
+			return fmt.Sprintf("%.4g%s", n, unit)
+		}
+		n /= 1024
+	}
+	return fmt.Sprintf("%.4gTiB", n)
+}
@@ -0,0 +1,56 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const vars = `{
+"cmdline": ["handler"],
+"userfaultfd": {
+	"uffd": {"pagefault": 30, "fork": 0, "remap": 0, "remove": 2, "unmap": 0},
+	"region": {"missing": 20, "wp": 0, "minor": 0, "errors": 1, "latency_p50_ns": 1500,
+		"latency_p99_ns": 20000, "latency_max_ns": 50000, "size_bytes": 1048576, "resident_bytes": 81920},
+	"region.heatmap": {"bucket_size": 4096, "faults": [4, 0, 1]}
+}}`
+
+func TestTop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(vars))
+	}))
+	defer srv.Close()
+
+	cur, err := fetch(srv.URL, "userfaultfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetch(srv.URL, "missing"); err == nil {
+		t.Error("fetch found a missing variable")
+	}
+
+	prev := snapshot{
+		"uffd":           {Pagefault: 10},
+		"region":         {Missing: 10},
+		"region.heatmap": {Faults: []float64{0, 0, 1}},
+	}
+	var b strings.Builder
+	render(&b, prev, cur, 2*time.Second)
+	out := b.String()
+	for _, want := range []string{
+		"uffd                         10",
+		"region                        5",
+		"1.5µs",
+		"80KiB/1MiB",
+		"4KiB per cell",
+		"\n█  \n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
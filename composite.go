@@ -0,0 +1,120 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Segment is a range of a file laid out in a Composite.
+type Segment struct {
+	File   io.ReaderAt
+	Offset int64 // in File
+	Length int64
+}
+
+// Composite is a contiguous mapping made of segments of several files, as
+// when restoring a virtual machine or assembling a dataset. Each segment
+// starts on a page boundary and is served by its own Region, dispatched to
+// by a ServeMux.
+type Composite struct {
+	uffd    *Uffd
+	mem     []byte
+	mux     *ServeMux
+	regions []*Region
+	slices  [][]byte
+}
+
+// MapComposite maps the segments one after the other, with each one padded
+// with zeros up to the next page, and registers them for missing faults.
+// The options apply to the Region of each segment.
+func (u *Uffd) MapComposite(segs []Segment, opts ...RegionOption) (*Composite, error) {
+	pageSize := uintptr(os.Getpagesize())
+	var size uintptr
+	for i, s := range segs {
+		if s.Length <= 0 || s.Offset < 0 || int64(uintptr(s.Length)) != s.Length {
+			return nil, fmt.Errorf("invalid segment %d: offset %d, length %d", i, s.Offset, s.Length)
+		}
+		size += RoundUp(uintptr(s.Length), pageSize)
+		if int(size) < 0 {
+			return nil, fmt.Errorf("composite mapping too large at segment %d", i)
+		}
+	}
+	if size == 0 {
+		return nil, errors.New("no segments")
+	}
+
+	mem, err := unix.Mmap(-1, 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_NORESERVE)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	c := &Composite{
+		uffd: u,
+		mem:  mem,
+		mux:  NewServeMux(),
+	}
+
+	var off int
+	for _, s := range segs {
+		end := off + int(RoundUp(uintptr(s.Length), pageSize))
+		provider := io.NewSectionReader(s.File, s.Offset, s.Length)
+		ropts := append(opts[:len(opts):len(opts)], WithProvider(provider))
+		r, err := u.RegisterRegion(mem[off:end:end], UFFDIO_REGISTER_MODE_MISSING, ropts...)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.regions = append(c.regions, r)
+		c.slices = append(c.slices, mem[off:off+int(s.Length):off+int(s.Length)])
+		if err := c.mux.HandleRegion(r); err != nil {
+			c.Close()
+			return nil, err
+		}
+		off = end
+	}
+	return c, nil
+}
+
+// Bytes returns the whole mapping, including padding.
+func (c *Composite) Bytes() []byte {
+	return c.mem
+}
+
+// Slices returns the memory of each segment, without padding.
+func (c *Composite) Slices() [][]byte {
+	return c.slices
+}
+
+// Regions returns the Region of each segment.
+func (c *Composite) Regions() []*Region {
+	return c.regions
+}
+
+// Mux returns the ServeMux dispatching to the regions, to be served with
+// Serve or a Server.
+func (c *Composite) Mux() *ServeMux {
+	return c.mux
+}
+
+// Serve resolves faults on the mapping until its userfaultfd is closed.
+func (c *Composite) Serve() error {
+	return Serve(c.uffd, c.mux)
+}
+
+// Close unregisters and unmaps the mapping, whose memory must not be
+// accessed anymore.
+func (c *Composite) Close() error {
+	var errs []error
+	for _, r := range c.regions {
+		errs = append(errs, r.Close())
+	}
+	errs = append(errs, unix.Munmap(c.mem))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMapComposite(t *testing.T) {
+	needProcs(t, 2)
+	pageSize := unix.Getpagesize()
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	a := bytes.Repeat([]byte{'a'}, 3*pageSize)
+	b := bytes.Repeat([]byte{'b'}, pageSize)
+	segs := []Segment{
+		{File: bytes.NewReader(a), Offset: int64(pageSize), Length: int64(pageSize + 10)},
+		{File: bytes.NewReader(b), Offset: 0, Length: int64(pageSize)},
+	}
+	c, err := uffd.MapComposite(segs)
+	if err != nil {
+		t.Fatalf("MapComposite failed: %v", err)
+	}
+	if len(c.Bytes()) != 3*pageSize || len(c.Regions()) != 2 {
+		t.Fatalf("%d bytes in %d regions", len(c.Bytes()), len(c.Regions()))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Serve()
+	}()
+
+	slices := c.Slices()
+	if !bytes.Equal(slices[0], a[pageSize:2*pageSize+10]) {
+		t.Error("segment 0 does not match")
+	}
+	if !bytes.Equal(slices[1], b) {
+		t.Error("segment 1 does not match")
+	}
+	if &slices[1][0] != &c.Bytes()[2*pageSize] {
+		t.Error("segment 1 not page aligned")
+	}
+	// Padding after the first segment reads as zeros.
+	if got := c.Bytes()[2*pageSize-1]; got != 0 {
+		t.Errorf("padding = %d, want 0", got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+
+	if _, err := uffd.MapComposite([]Segment{{File: bytes.NewReader(a), Length: -1}}); err == nil {
+		t.Error("MapComposite accepted a negative length")
+	}
+}
@@ -0,0 +1,51 @@
+//go:build linux && cgo && !userfaultfd_nocgo
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+/*
+#include <linux/ioctl.h>
+#include <linux/userfaultfd.h>
+#include <asm/unistd.h>
+
+#ifndef UFFD_USER_MODE_ONLY
+#define UFFD_USER_MODE_ONLY	0
+#endif
+#ifndef UFFDIO_CONTINUE
+#define UFFDIO_CONTINUE		0
+#endif
+#ifndef UFFDIO_MOVE
+#define UFFDIO_MOVE		0
+#endif
+#ifndef UFFDIO_POISON
+#define UFFDIO_POISON		0
+#endif
+#ifndef UFFDIO_WRITEPROTECT
+#define UFFDIO_WRITEPROTECT	0
+#endif
+#ifndef USERFAULTFD_IOC_NEW
+#define USERFAULTFD_IOC_NEW	0
+#endif
+*/
+import "C"
+
+const (
+	// Create a userfaultfd that can handle page faults only in user mode.
+	UFFD_USER_MODE_ONLY = C.UFFD_USER_MODE_ONLY
+)
+
+const (
+	UFFD_API            = C.UFFD_API
+	UFFDIO_API          = C.UFFDIO_API
+	UFFDIO_REGISTER     = C.UFFDIO_REGISTER
+	UFFDIO_UNREGISTER   = C.UFFDIO_UNREGISTER
+	UFFDIO_WAKE         = C.UFFDIO_WAKE
+	UFFDIO_COPY         = C.UFFDIO_COPY
+	UFFDIO_ZEROPAGE     = C.UFFDIO_ZEROPAGE
+	UFFDIO_MOVE         = C.UFFDIO_MOVE
+	UFFDIO_WRITEPROTECT = C.UFFDIO_WRITEPROTECT
+	UFFDIO_CONTINUE     = C.UFFDIO_CONTINUE
+	UFFDIO_POISON       = C.UFFDIO_POISON
+	USERFAULTFD_IOC_NEW = C.USERFAULTFD_IOC_NEW
+)
@@ -0,0 +1,30 @@
+//go:build amd64 || arm64
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import "testing"
+
+// TestIoctlNumbers checks the ioctl numbers against the values from
+// hack/sizeof.c, so the cgo and cgo-free builds must agree.
+func TestIoctlNumbers(t *testing.T) {
+	tests := []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{"UFFDIO_API", UFFDIO_API, 0xc018aa3f},
+		{"UFFDIO_REGISTER", UFFDIO_REGISTER, 0xc020aa00},
+		{"UFFDIO_UNREGISTER", UFFDIO_UNREGISTER, 0x8010aa01},
+		{"UFFDIO_WAKE", UFFDIO_WAKE, 0x8010aa02},
+		{"UFFDIO_COPY", UFFDIO_COPY, 0xc028aa03},
+		{"UFFDIO_ZEROPAGE", UFFDIO_ZEROPAGE, 0xc020aa04},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %#x, want %#x", tt.name, tt.got, tt.want)
+		}
+	}
+}
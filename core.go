@@ -0,0 +1,170 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"cmp"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// CoreSegment is a PT_LOAD segment of an ELF core file.
+type CoreSegment struct {
+	Vaddr  uint64       // Address in the dumped process
+	Memsz  uint64       // Size in memory
+	Filesz uint64       // Size in the file, the rest being zero
+	Off    int64        // Offset in the file
+	Flags  elf.ProgFlag // Permissions
+}
+
+// CoreFile serves the memory of a process from its ELF core dump, so that
+// post-mortem analysis can map huge cores and only read the pages it
+// touches. As an io.ReaderAt, offsets are virtual addresses of the dumped
+// process, zero-filled outside the PT_LOAD segments and past the part of
+// each in the file. See also MapCore.
+type CoreFile struct {
+	r      io.ReaderAt
+	segs   []CoreSegment
+	closer io.Closer
+}
+
+// OpenCore opens the ELF core file at name.
+func OpenCore(name string) (*CoreFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCore(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	c.closer = f
+	return c, nil
+}
+
+// NewCore reads the program headers of the ELF core file in r.
+func NewCore(r io.ReaderAt) (*CoreFile, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("ELF file of type %v, not a core file", f.Type)
+	}
+	c := &CoreFile{r: r}
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Memsz == 0 {
+			continue
+		}
+		if p.Filesz > p.Memsz || p.Vaddr+p.Memsz < p.Vaddr || int64(p.Off) < 0 {
+			return nil, fmt.Errorf("invalid PT_LOAD segment at %#x", p.Vaddr)
+		}
+		c.segs = append(c.segs, CoreSegment{
+			Vaddr:  p.Vaddr,
+			Memsz:  p.Memsz,
+			Filesz: p.Filesz,
+			Off:    int64(p.Off),
+			Flags:  p.Flags,
+		})
+	}
+	if len(c.segs) == 0 {
+		return nil, errors.New("core file without PT_LOAD segments")
+	}
+	slices.SortFunc(c.segs, func(a, b CoreSegment) int {
+		return cmp.Compare(a.Vaddr, b.Vaddr)
+	})
+	for i := 1; i < len(c.segs); i++ {
+		if prev := c.segs[i-1]; prev.Vaddr+prev.Memsz > c.segs[i].Vaddr {
+			return nil, fmt.Errorf("overlapping PT_LOAD segments at %#x", c.segs[i].Vaddr)
+		}
+	}
+	return c, nil
+}
+
+// Segments returns the PT_LOAD segments, sorted by address.
+func (c *CoreFile) Segments() []CoreSegment {
+	return c.segs
+}
+
+// ReadAt reads the memory of the process at virtual address off. Use
+// SegmentReader for segments in the upper half of the address space, such
+// as the vsyscall page of x86-64.
+func (c *CoreFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	return c.read(p, uint64(off))
+}
+
+// SegmentReader returns a reader of the memory of the segment, by offset
+// from its start, such as the provider of the region restoring it.
+func (c *CoreFile) SegmentReader(s CoreSegment) *io.SectionReader {
+	return io.NewSectionReader(coreSegment{c, s.Vaddr}, 0, int64(s.Memsz))
+}
+
+// coreSegment reads a CoreFile from an address.
+type coreSegment struct {
+	c    *CoreFile
+	addr uint64
+}
+
+func (s coreSegment) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	return s.c.read(p, s.addr+uint64(off))
+}
+
+func (c *CoreFile) read(p []byte, start uint64) (int, error) {
+	var n int
+	for n < len(p) {
+		addr := start + uint64(n)
+		i, found := slices.BinarySearchFunc(c.segs, addr, func(s CoreSegment, addr uint64) int {
+			switch {
+			case addr < s.Vaddr:
+				return 1
+			case addr >= s.Vaddr+s.Memsz:
+				return -1
+			}
+			return 0
+		})
+		// Zero-fill up to the next segment, or in the segment past its
+		// part in the file.
+		end := uint64(len(p) - n)
+		if !found {
+			if i < len(c.segs) {
+				end = min(end, c.segs[i].Vaddr-addr)
+			}
+			clear(p[n : n+int(end)])
+			n += int(end)
+			continue
+		}
+		s := c.segs[i]
+		within := addr - s.Vaddr
+		if within >= s.Filesz {
+			end = min(end, s.Memsz-within)
+			clear(p[n : n+int(end)])
+			n += int(end)
+			continue
+		}
+		chunk := p[n : n+int(min(end, s.Filesz-within))]
+		if _, err := c.r.ReadAt(chunk, s.Off+int64(within)); err != nil {
+			return n, fmt.Errorf("reading segment at %#x: %w", s.Vaddr, err)
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// Close closes the file opened by OpenCore.
+func (c *CoreFile) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
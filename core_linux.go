@@ -0,0 +1,80 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"os"
+)
+
+// CoreMapping is the memory of an ELF core file mapped by MapCore, each
+// PT_LOAD segment in a Region of its own, dispatched to by a ServeMux.
+type CoreMapping struct {
+	uffd    *Uffd
+	segs    []CoreSegment
+	regions []*Region
+	mux     *ServeMux
+}
+
+// MapCore maps the PT_LOAD segments of c, each registered for missing
+// faults and filled from the core as accessed. Segments are mapped at
+// addresses chosen by the kernel, not those of the dumped process: At
+// translates them. The options apply to the Region of each segment.
+func (u *Uffd) MapCore(c *CoreFile, opts ...RegionOption) (*CoreMapping, error) {
+	m := &CoreMapping{uffd: u, segs: c.Segments(), mux: NewServeMux()}
+	for _, s := range m.segs {
+		size := int64(RoundUp(uintptr(s.Memsz), uintptr(os.Getpagesize())))
+		ropts := append(opts[:len(opts):len(opts)], WithProvider(c.SegmentReader(s)))
+		r, err := u.MapAndRegister(size, UFFDIO_REGISTER_MODE_MISSING, ropts...)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.regions = append(m.regions, r)
+		if err := m.mux.HandleRegion(r); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// At returns the n bytes of memory at address vaddr of the dumped process,
+// or nil if they are not all in one segment.
+func (m *CoreMapping) At(vaddr uint64, n int) []byte {
+	for i, s := range m.segs {
+		if vaddr >= s.Vaddr && vaddr-s.Vaddr <= s.Memsz && uint64(n) <= s.Memsz-(vaddr-s.Vaddr) {
+			off := vaddr - s.Vaddr
+			return m.regions[i].Bytes()[off : off+uint64(n)]
+		}
+	}
+	return nil
+}
+
+// Regions returns the Region of each segment.
+func (m *CoreMapping) Regions() []*Region {
+	return m.regions
+}
+
+// Mux returns the ServeMux dispatching to the regions, to be served with
+// Serve or a Server.
+func (m *CoreMapping) Mux() *ServeMux {
+	return m.mux
+}
+
+// Serve resolves faults on the mapping until its userfaultfd is closed.
+func (m *CoreMapping) Serve() error {
+	return Serve(m.uffd, m.mux)
+}
+
+// Close unregisters and unmaps the segments, whose memory must not be
+// accessed anymore.
+func (m *CoreMapping) Close() error {
+	var errs []error
+	for _, r := range m.regions {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// buildCore returns an ELF core file with the PT_LOAD segments, of the
+// given sizes, filled with the segment index plus one, and a PT_NOTE.
+func buildCore(segs []CoreSegment) []byte {
+	pageSize := uint64(unix.Getpagesize())
+	le := binary.LittleEndian
+	hdr := []byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)}
+	hdr = append(hdr, make([]byte, 16-len(hdr))...)
+	hdr = le.AppendUint16(hdr, uint16(elf.ET_CORE))
+	hdr = le.AppendUint16(hdr, uint16(elf.EM_X86_64))
+	hdr = le.AppendUint32(hdr, uint32(elf.EV_CURRENT))
+	hdr = le.AppendUint64(hdr, 0)  // entry
+	hdr = le.AppendUint64(hdr, 64) // program headers
+	hdr = le.AppendUint64(hdr, 0)  // section headers
+	hdr = le.AppendUint32(hdr, 0)
+	hdr = le.AppendUint16(hdr, 64)
+	hdr = le.AppendUint16(hdr, 56)
+	hdr = le.AppendUint16(hdr, uint16(len(segs)+1))
+	hdr = le.AppendUint16(hdr, 64)
+	hdr = le.AppendUint16(hdr, 0)
+	hdr = le.AppendUint16(hdr, 0)
+
+	phdr := func(typ elf.ProgType, off, vaddr, filesz, memsz uint64) {
+		hdr = le.AppendUint32(hdr, uint32(typ))
+		hdr = le.AppendUint32(hdr, uint32(elf.PF_R|elf.PF_W))
+		for _, v := range []uint64{off, vaddr, 0, filesz, memsz, pageSize} {
+			hdr = le.AppendUint64(hdr, v)
+		}
+	}
+	phdr(elf.PT_NOTE, 0, 0, 0, 0)
+	off := pageSize
+	for _, s := range segs {
+		phdr(elf.PT_LOAD, off, s.Vaddr, s.Filesz, s.Memsz)
+		off += s.Filesz
+	}
+	core := append(hdr, make([]byte, pageSize-uint64(len(hdr)))...)
+	for i, s := range segs {
+		core = append(core, bytes.Repeat([]byte{byte(i + 1)}, int(s.Filesz))...)
+	}
+	return core
+}
+
+func TestCoreFile(t *testing.T) {
+	pageSize := uint64(unix.Getpagesize())
+	segs := []CoreSegment{
+		{Vaddr: 0x400000, Memsz: 2 * pageSize, Filesz: pageSize + 100},
+		{Vaddr: 0xffffffffff600000, Memsz: pageSize, Filesz: pageSize},
+	}
+	c, err := NewCore(bytes.NewReader(buildCore(segs)))
+	if err != nil {
+		t.Fatalf("NewCore failed: %v", err)
+	}
+	if got := c.Segments(); len(got) != 2 || got[1].Vaddr != segs[1].Vaddr || got[0].Filesz != segs[0].Filesz {
+		t.Fatalf("Segments = %+v", got)
+	}
+
+	// The part of a segment not in the file and the holes read as zeros.
+	got := make([]byte, 3*pageSize)
+	if _, err := c.ReadAt(got, int64(0x400000-pageSize)); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	want := make([]byte, 3*pageSize)
+	copy(want[pageSize:], bytes.Repeat([]byte{1}, int(pageSize+100)))
+	if !bytes.Equal(got, want) {
+		t.Error("segment contents differ")
+	}
+	got = make([]byte, pageSize)
+	if _, err := io.ReadFull(c.SegmentReader(c.Segments()[1]), got); err != nil || got[0] != 2 || got[pageSize-1] != 2 {
+		t.Errorf("upper half segment = %d, %v", got[0], err)
+	}
+
+	if _, err := NewCore(bytes.NewReader(make([]byte, 64))); err == nil {
+		t.Error("NewCore accepted garbage")
+	}
+}
+
+func TestMapCore(t *testing.T) {
+	needProcs(t, 2)
+	pageSize := uint64(unix.Getpagesize())
+	c, err := NewCore(bytes.NewReader(buildCore([]CoreSegment{
+		{Vaddr: 0x400000, Memsz: 2 * pageSize, Filesz: pageSize},
+		{Vaddr: 0x600000, Memsz: pageSize, Filesz: pageSize},
+	})))
+	if err != nil {
+		t.Fatalf("NewCore failed: %v", err)
+	}
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	m, err := uffd.MapCore(c)
+	if err != nil {
+		t.Fatalf("MapCore failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Serve()
+	}()
+
+	if b := m.At(0x400000+pageSize-1, 2); len(b) != 2 || b[0] != 1 || b[1] != 0 {
+		t.Errorf("At(end of file part) = %v", b)
+	}
+	if b := m.At(0x600000, int(pageSize)); len(b) != int(pageSize) || b[pageSize-1] != 2 {
+		t.Errorf("At(segment 1) = %d bytes", len(b))
+	}
+	if m.At(0x600000, int(pageSize)+1) != nil || m.At(0x500000, 1) != nil {
+		t.Error("At outside the segments")
+	}
+
+	if err := m.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// elfMachines maps GOARCH to the machine of the cores written by
+// StreamCore.
+var elfMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"loong64": elf.EM_LOONGARCH,
+	"ppc64":   elf.EM_PPC64,
+	"ppc64le": elf.EM_PPC64,
+	"riscv64": elf.EM_RISCV,
+	"s390x":   elf.EM_S390,
+}
+
+// StreamCore returns the memory of process pid as a CoreFile streamed on
+// demand into f, so that an analysis tool only copies the pages it reads
+// instead of dumping the whole address space up front. f is laid out as a
+// sparse ELF core file with a PT_LOAD segment for each readable mapping
+// for which filter, if not nil, returns true, and each page is read from
+// the process with process_vm_readv(2) and written to f the first time it
+// is read. The CoreFile can be mapped lazily with MapCore.
+//
+// Pages are copied as of when first read: the process is best stopped
+// meanwhile. Its pid is that of the sender of ReceiveUffd for a process
+// cooperating by sending its userfaultfd. Closing the CoreFile leaves f
+// open, as a core file of the pages read.
+func StreamCore(pid int, f *os.File, filter func(Mapping) bool) (*CoreFile, error) {
+	maps, err := readableMaps(pid, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(maps) == 0 {
+		return nil, errors.New("no readable mappings")
+	}
+	ranges := make([]RemoteRange, len(maps))
+	for i, mp := range maps {
+		ranges[i] = RemoteRange{Addr: mp.Start, Len: int(mp.End - mp.Start)}
+	}
+	pm, err := NewProcessMemory(pid, ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	// The segments follow the headers, in the order of the mappings.
+	var order binary.ByteOrder = binary.LittleEndian
+	data := elf.ELFDATA2LSB
+	if binary.NativeEndian.Uint16([]byte{0, 1}) == 1 {
+		order, data = binary.BigEndian, elf.ELFDATA2MSB
+	}
+	ehsize, phentsize := unsafe.Sizeof(elf.Header64{}), unsafe.Sizeof(elf.Prog64{})
+	start := int64(RoundUp(ehsize+uintptr(len(maps))*phentsize, uintptr(os.Getpagesize())))
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elfMachines[runtime.GOARCH]),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     uint64(ehsize),
+		Ehsize:    uint16(ehsize),
+		Phentsize: uint16(phentsize),
+		Phnum:     uint16(len(maps)),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(data)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var buf bytes.Buffer
+	binary.Write(&buf, order, hdr)
+	off := start
+	for _, mp := range maps {
+		var flags elf.ProgFlag
+		for i, flag := range []elf.ProgFlag{elf.PF_R, elf.PF_W, elf.PF_X} {
+			if len(mp.Perms) > i && mp.Perms[i] != '-' {
+				flags |= flag
+			}
+		}
+		size := uint64(mp.End - mp.Start)
+		binary.Write(&buf, order, elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(flags),
+			Off:    uint64(off),
+			Vaddr:  uint64(mp.Start),
+			Filesz: size,
+			Memsz:  size,
+			Align:  uint64(os.Getpagesize()),
+		})
+		off += int64(size)
+	}
+	if err := f.Truncate(0); err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteAt(buf.Bytes(), 0); err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(off); err != nil {
+		return nil, err
+	}
+	return NewCore(&coreStreamer{f: f, pm: pm, start: start})
+}
+
+// coreStreamer reads a core file written by StreamCore, streaming the
+// pages of its segments from the process first.
+type coreStreamer struct {
+	f     *os.File
+	pm    *ProcessMemory // of the segments
+	start int64          // of the segments in f
+
+	mu       sync.Mutex
+	streamed PageSet
+}
+
+func (s *coreStreamer) ReadAt(p []byte, off int64) (int, error) {
+	if err := s.stream(off, len(p)); err != nil {
+		return 0, err
+	}
+	return s.f.ReadAt(p, off)
+}
+
+// stream copies the pages of the segments covering the length bytes at off
+// of the file that were not yet.
+func (s *coreStreamer) stream(off int64, length int) error {
+	pageSize := int64(os.Getpagesize())
+	first := max(off-s.start, 0) / pageSize
+	end := min((off+int64(length)-s.start+pageSize-1)/pageSize, (s.pm.Size()+pageSize-1)/pageSize)
+	if first >= end {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page := make([]byte, pageSize)
+	for i := first; i < end; i++ {
+		if s.streamed.Contains(uint64(i)) {
+			continue
+		}
+		if _, err := s.pm.ReadAt(page, i*pageSize); err != nil {
+			return err
+		}
+		if _, err := s.f.WriteAt(page, s.start+i*pageSize); err != nil {
+			return err
+		}
+		s.streamed.Add(uint64(i))
+	}
+	return nil
+}
+
+// SendUffd sends the userfaultfd u over c, to a process such as one
+// calling ReceiveUffd.
+func SendUffd(c *net.UnixConn, u *Uffd) error {
+	_, _, err := c.WriteMsgUnix([]byte{0}, unix.UnixRights(u.Fd()), nil)
+	return err
+}
+
+// ReceiveUffd receives a userfaultfd sent over c, as by SendUffd, and
+// returns it with the pid of the sender, from the credentials of the
+// socket. Other file descriptors are refused.
+func ReceiveUffd(c *net.UnixConn) (*os.File, int, error) {
+	f, _, pid, err := receiveUffd(c, make([]byte, 1))
+	return f, pid, err
+}
+
+// receiveUffd receives a userfaultfd sent over c with a message read into
+// buf, and returns it with the length of the message and the pid of the
+// sender.
+func receiveUffd(c *net.UnixConn, buf []byte) (*os.File, int, int, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, 0, 0, err
+	}
+	if credErr != nil {
+		return nil, 0, 0, os.NewSyscallError("getsockopt", credErr)
+	}
+
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := c.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, 0, 0, os.NewSyscallError("recvmsg", err)
+	}
+	for _, msg := range msgs {
+		fds, err := unix.ParseUnixRights(&msg)
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+		for _, fd := range fds[1:] {
+			unix.Close(fd)
+		}
+		if _, err := ReadFdInfo(0, fds[0]); err != nil {
+			unix.Close(fds[0])
+			return nil, 0, 0, err
+		}
+		return os.NewFile(uintptr(fds[0]), "userfaultfd"), n, int(cred.Pid), nil
+	}
+	return nil, 0, 0, errors.New("no userfaultfd received")
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStreamCore(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot run sleep: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	exe, err := filepath.EvalSymlinks(cmd.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "core"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	c, err := StreamCore(cmd.Process.Pid, f, nil)
+	if err != nil {
+		t.Fatalf("StreamCore failed: %v", err)
+	}
+	var size uint64
+	for _, s := range c.Segments() {
+		size += s.Memsz
+	}
+
+	// Only the pages read are written to the file.
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		t.Fatal(err)
+	}
+	allocated := st.Blocks * 512
+	maps, err := readableMaps(cmd.Process.Pid, func(mp Mapping) bool { return mp.Path == exe && mp.Offset == 0 })
+	if err != nil || len(maps) != 1 {
+		t.Fatalf("mapping of %s not found: %v", exe, err)
+	}
+	buf := make([]byte, 4)
+	if _, err := c.ReadAt(buf, int64(maps[0].Start)); err != nil || string(buf) != "\x7fELF" {
+		t.Errorf("ReadAt = %q, %v", buf, err)
+	}
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if grown := st.Blocks*512 - allocated; grown <= 0 || grown > 64<<10 || uint64(st.Size) < size {
+		t.Errorf("core of %d bytes grew by %d bytes for %d bytes of segments", st.Size, grown, size)
+	}
+
+	// The file is a core of the pages read.
+	c2, err := NewCore(f)
+	if err != nil {
+		t.Fatalf("NewCore failed: %v", err)
+	}
+	if _, err := c2.ReadAt(buf, int64(maps[0].Start)); err != nil || string(buf) != "\x7fELF" {
+		t.Errorf("core ReadAt = %q, %v", buf, err)
+	}
+	if len(c2.Segments()) != len(c.Segments()) {
+		t.Errorf("core has %d segments, want %d", len(c2.Segments()), len(c.Segments()))
+	}
+}
+
+func TestSendUffd(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socket")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns[i] = c.(*net.UnixConn)
+	}
+
+	uffd, err := New(flags|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	if err := SendUffd(conns[0], uffd); err != nil {
+		t.Fatalf("SendUffd failed: %v", err)
+	}
+	f, pid, err := ReceiveUffd(conns[1])
+	if err != nil {
+		t.Fatalf("ReceiveUffd failed: %v", err)
+	}
+	defer f.Close()
+	if pid != os.Getpid() {
+		t.Errorf("sender pid %d, want %d", pid, os.Getpid())
+	}
+
+	// Other files are refused.
+	if _, _, err := conns[0].WriteMsgUnix([]byte{0}, unix.UnixRights(int(os.Stdin.Fd())), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReceiveUffd(conns[1]); err == nil {
+		t.Error("expected error on a file that is not a userfaultfd")
+	}
+}
@@ -0,0 +1,276 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Magics of CRIU images. Images since CRIU 1.1 start with the common or
+// service magic, followed by the magic of the image.
+const (
+	criuCommonMagic  = 0x54564319
+	criuServiceMagic = 0x55105940
+	criuPagemapMagic = 0x56084025
+)
+
+// Flags of CRIU pagemap entries.
+const (
+	CRIUPageParent  = 1 << 0 // In the pages of the parent dump
+	CRIUPageLazy    = 1 << 1 // May be restored lazily
+	CRIUPagePresent = 1 << 2 // In the pages image
+)
+
+// CRIUPagemapEntry is a run of pages of a pagemap image.
+type CRIUPagemapEntry struct {
+	Vaddr   uint64 // Address of the first page
+	NrPages uint32
+	Flags   uint32 // CRIUPage*
+	off     int64  // in the pages image, if present
+}
+
+// CRIUImage serves the memory of a process dumped by CRIU, from its
+// pagemap and pages images, so that it can be restored lazily without the
+// CRIU page server. As an io.ReaderAt, offsets are virtual addresses of the
+// process: the provider of the region restoring a mapping is a section of
+// it, such as io.NewSectionReader(img, start, length).
+//
+// Addresses not in the pagemap are zero-filled. Reading pages left in the
+// parent dump of an incremental dump fails.
+type CRIUImage struct {
+	entries  []CRIUPagemapEntry
+	pages    io.ReaderAt
+	pageSize int64
+	closer   io.Closer
+}
+
+// OpenCRIUImage opens the pagemap-<pid>.img image in the CRIU dump
+// directory dir and the pages image it refers to.
+func OpenCRIUImage(dir string, pid int) (*CRIUImage, error) {
+	f, err := os.Open(filepath.Join(dir, fmt.Sprintf("pagemap-%d.img", pid)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	id, entries, err := ReadCRIUPagemap(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	pages, err := os.Open(filepath.Join(dir, fmt.Sprintf("pages-%d.img", id)))
+	if err != nil {
+		return nil, err
+	}
+	img := NewCRIUImage(entries, pages)
+	img.closer = pages
+	return img, nil
+}
+
+// NewCRIUImage returns a CRIUImage of the pagemap entries, as read by
+// ReadCRIUPagemap, with the present pages read from pages.
+func NewCRIUImage(entries []CRIUPagemapEntry, pages io.ReaderAt) *CRIUImage {
+	return &CRIUImage{entries: entries, pages: pages, pageSize: int64(os.Getpagesize())}
+}
+
+// ReadCRIUPagemap reads a pagemap image, returning the id of its pages
+// image and its entries, sorted by address.
+func ReadCRIUPagemap(r io.Reader) (pagesID uint32, entries []CRIUPagemapEntry, err error) {
+	br := bufio.NewReader(r)
+	var magic uint32
+	if err := binary.Read(br, binary.LittleEndian, &magic); err != nil {
+		return 0, nil, err
+	}
+	if magic == criuCommonMagic || magic == criuServiceMagic {
+		if err := binary.Read(br, binary.LittleEndian, &magic); err != nil {
+			return 0, nil, err
+		}
+	}
+	if magic != criuPagemapMagic {
+		return 0, nil, errors.New("not a CRIU pagemap image")
+	}
+
+	head, err := readCRIUEntry(br)
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading pagemap head: %w", err)
+	}
+	if err := head.fields(func(field int, v uint64) {
+		if field == 1 {
+			pagesID = uint32(v)
+		}
+	}); err != nil {
+		return 0, nil, err
+	}
+
+	var off int64
+	pageSize := uint64(os.Getpagesize())
+	for {
+		msg, err := readCRIUEntry(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("reading pagemap entry %d: %w", len(entries), err)
+		}
+		var e CRIUPagemapEntry
+		var inParent, hasFlags bool
+		if err := msg.fields(func(field int, v uint64) {
+			switch field {
+			case 1:
+				e.Vaddr = v
+			case 2:
+				e.NrPages = uint32(v)
+			case 3:
+				inParent = v != 0
+			case 4:
+				e.Flags, hasFlags = uint32(v), true
+			}
+		}); err != nil {
+			return 0, nil, fmt.Errorf("pagemap entry %d: %w", len(entries), err)
+		}
+		// Older images only tell pages in the parent apart.
+		if !hasFlags {
+			e.Flags = CRIUPagePresent
+			if inParent {
+				e.Flags = CRIUPageParent
+			}
+		}
+		if e.Vaddr%pageSize != 0 || e.Vaddr+uint64(e.NrPages)*pageSize < e.Vaddr {
+			return 0, nil, fmt.Errorf("invalid pagemap entry %d: %d pages at %#x", len(entries), e.NrPages, e.Vaddr)
+		}
+		if e.Flags&CRIUPagePresent != 0 {
+			e.off = off
+			off += int64(e.NrPages) * int64(pageSize)
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b CRIUPagemapEntry) int {
+		return cmp.Compare(a.Vaddr, b.Vaddr)
+	})
+	return pagesID, entries, nil
+}
+
+// Entries returns the pagemap entries, sorted by address.
+func (img *CRIUImage) Entries() []CRIUPagemapEntry {
+	return img.entries
+}
+
+// ReadAt reads the memory of the process at virtual address off.
+func (img *CRIUImage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	var n int
+	for n < len(p) {
+		addr := uint64(off) + uint64(n)
+		i, found := slices.BinarySearchFunc(img.entries, addr, func(e CRIUPagemapEntry, addr uint64) int {
+			if addr < e.Vaddr {
+				return 1
+			}
+			if addr >= e.Vaddr+uint64(e.NrPages)*uint64(img.pageSize) {
+				return -1
+			}
+			return 0
+		})
+		if !found {
+			// Zero-fill up to the next entry.
+			end := uint64(len(p) - n)
+			if i < len(img.entries) {
+				end = min(end, img.entries[i].Vaddr-addr)
+			}
+			clear(p[n : n+int(end)])
+			n += int(end)
+			continue
+		}
+		e := img.entries[i]
+		if e.Flags&CRIUPagePresent == 0 {
+			if e.Flags&CRIUPageParent != 0 {
+				return n, fmt.Errorf("page at %#x is in the parent dump", addr)
+			}
+			return n, fmt.Errorf("page at %#x is not in the dump", addr)
+		}
+		chunk := p[n:min(len(p), n+int(e.Vaddr+uint64(e.NrPages)*uint64(img.pageSize)-addr))]
+		if _, err := img.pages.ReadAt(chunk, e.off+int64(addr-e.Vaddr)); err != nil {
+			return n, fmt.Errorf("reading pages at %#x: %w", addr, err)
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// Close closes the pages image opened by OpenCRIUImage.
+func (img *CRIUImage) Close() error {
+	if img.closer == nil {
+		return nil
+	}
+	return img.closer.Close()
+}
+
+// criuEntry is the protobuf message of an entry of a CRIU image.
+type criuEntry []byte
+
+// readCRIUEntry reads an entry, prefixed by its size.
+func readCRIUEntry(r io.Reader) (criuEntry, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > 1<<20 {
+		return nil, fmt.Errorf("entry of %d bytes too large", size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// fields calls fn with the varint fields of the message, skipping the
+// others.
+func (m criuEntry) fields(fn func(field int, v uint64)) error {
+	for b := []byte(m); len(b) > 0; {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid protobuf key")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			fn(field, v)
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return io.ErrUnexpectedEOF
+			}
+			b = b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errors.New("invalid protobuf length")
+			}
+			b = b[n+int(l):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("invalid protobuf wire type %d", key&7)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// criuMessage encodes the varint fields of a protobuf message, prefixed by
+// its size as in CRIU images.
+func criuMessage(fields ...uint64) []byte {
+	var msg []byte
+	for i := 0; i < len(fields); i += 2 {
+		msg = binary.AppendUvarint(msg, fields[i]<<3)
+		msg = binary.AppendUvarint(msg, fields[i+1])
+	}
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(msg))), msg...)
+}
+
+func TestCRIUImage(t *testing.T) {
+	pageSize := uint64(os.Getpagesize())
+	base := 0x10000 * pageSize
+
+	var pagemap []byte
+	pagemap = binary.LittleEndian.AppendUint32(pagemap, criuCommonMagic)
+	pagemap = binary.LittleEndian.AppendUint32(pagemap, criuPagemapMagic)
+	pagemap = append(pagemap, criuMessage(1, 7)...)
+	// Two present pages, a hole, a page in the parent, then an entry of the
+	// older format, present.
+	pagemap = append(pagemap, criuMessage(1, base, 2, 2, 4, CRIUPagePresent)...)
+	pagemap = append(pagemap, criuMessage(1, base+4*pageSize, 2, 1, 4, CRIUPageParent)...)
+	pagemap = append(pagemap, criuMessage(1, base+5*pageSize, 2, 1)...)
+
+	pages := make([]byte, 3*pageSize)
+	for i := range pages {
+		pages[i] = byte(i/int(pageSize) + 1)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pagemap-42.img"), pagemap, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pages-7.img"), pages, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	img, err := OpenCRIUImage(dir, 42)
+	if err != nil {
+		t.Fatalf("OpenCRIUImage failed: %v", err)
+	}
+	defer img.Close()
+	if n := len(img.Entries()); n != 3 {
+		t.Fatalf("%d entries, want 3", n)
+	}
+
+	got := make([]byte, 4*pageSize)
+	if _, err := img.ReadAt(got, int64(base)); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	want := bytes.Join([][]byte{pages[:2*pageSize], make([]byte, 2*pageSize)}, nil)
+	if !bytes.Equal(got, want) {
+		t.Error("present pages or hole differ")
+	}
+	if _, err := img.ReadAt(got[:pageSize], int64(base+5*pageSize)); err != nil || got[0] != 3 {
+		t.Errorf("ReadAt of older entry = %d, %v", got[0], err)
+	}
+	if _, err := img.ReadAt(got[:pageSize], int64(base+4*pageSize)); err == nil {
+		t.Error("page in the parent dump read")
+	}
+}
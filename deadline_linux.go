@@ -0,0 +1,107 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// deadlineKick is an eventfd signalled to wake up the reads waiting when
+// the read deadline changes. It is replaced once signalled, and closed once
+// the reads waiting on it are done.
+type deadlineKick struct {
+	fd      int
+	waiters int
+	stale   bool
+}
+
+// SetDeadline sets the read deadline, as events are only read from a
+// userfaultfd. See SetReadDeadline.
+func (u *Uffd) SetDeadline(t time.Time) error {
+	return u.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of the reads of events, as for a
+// net.Conn: once it is past, the reads waiting and the next ones fail with
+// os.ErrDeadlineExceeded, whatever their timeout, until the deadline is
+// extended. It applies to the reads waiting when it is set. A zero t means
+// no deadline.
+func (u *Uffd) SetReadDeadline(t time.Time) error {
+	if u.closed.Load() {
+		return ErrClosed
+	}
+	var ns int64
+	if !t.IsZero() {
+		ns = t.UnixNano()
+	}
+	u.deadline.Store(ns)
+
+	u.readMu.Lock()
+	defer u.readMu.Unlock()
+	if k := u.kick; k != nil && k.waiters > 0 {
+		unix.Write(k.fd, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+		k.stale = true
+		u.kick = nil
+	}
+	return nil
+}
+
+// watchDeadline returns the kick signalled when the read deadline changes.
+// It must be called with u.mu held for reading.
+func (u *Uffd) watchDeadline() (*deadlineKick, error) {
+	u.readMu.Lock()
+	defer u.readMu.Unlock()
+	if u.kick == nil {
+		fd, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+		if err != nil {
+			return nil, os.NewSyscallError("eventfd", err)
+		}
+		u.kick = &deadlineKick{fd: fd}
+	}
+	u.kick.waiters++
+	return u.kick, nil
+}
+
+func (u *Uffd) unwatchDeadline(k *deadlineKick) {
+	u.readMu.Lock()
+	defer u.readMu.Unlock()
+	if k.waiters--; k.waiters == 0 && k.stale {
+		unix.Close(k.fd)
+	}
+}
+
+// readTimeout returns the poll(2) timeout of a read waiting timeout
+// milliseconds, until end if positive, cut short by the read deadline.
+func (u *Uffd) readTimeout(timeout int, end time.Time) (int, error) {
+	if timeout > 0 {
+		timeout = ceilMillis(time.Until(end))
+	}
+	d := u.deadline.Load()
+	if d == 0 {
+		return timeout, nil
+	}
+	left := time.Until(time.Unix(0, d))
+	if left <= 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	if ms := ceilMillis(left); timeout < 0 || ms < timeout {
+		return ms, nil
+	}
+	return timeout, nil
+}
+
+// deadlinePassed reports whether the read deadline is past.
+func (u *Uffd) deadlinePassed() bool {
+	d := u.deadline.Load()
+	return d != 0 && time.Now().UnixNano() >= d
+}
+
+// ceilMillis returns d in milliseconds, rounded up, or 0 if negative.
+func ceilMillis(d time.Duration) int {
+	return int(max(d+time.Millisecond-1, 0) / time.Millisecond)
+}
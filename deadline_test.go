@@ -0,0 +1,82 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSetReadDeadline(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	// A past deadline fails reads right away.
+	if err := uffd.SetReadDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	if _, err := uffd.ReadMsg(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadMsg: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+
+	// A deadline cuts the timeout short.
+	start := time.Now()
+	if err := uffd.SetDeadline(start.Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("SetDeadline failed: %v", err)
+	}
+	if _, err := uffd.ReadMsgTimeout(int(time.Hour.Milliseconds())); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadMsgTimeout: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond || elapsed > time.Second {
+		t.Errorf("deadline of 50ms exceeded after %v", elapsed)
+	}
+
+	// Without a deadline, reads time out as before.
+	if err := uffd.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	if _, err := uffd.ReadMsgTimeout(0); !errors.Is(err, unix.EAGAIN) {
+		t.Fatalf("ReadMsgTimeout(0): %v, want EAGAIN", err)
+	}
+
+	// A deadline applies to the reads waiting.
+	done := make(chan error)
+	go func() {
+		_, err := uffd.ReadMsg()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := uffd.SetReadDeadline(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("ReadMsg returned after extending the deadline: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := uffd.SetReadDeadline(time.Now()); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("ReadMsg: %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadMsg still blocked after the deadline")
+	}
+
+	uffd.Close()
+	if err := uffd.SetReadDeadline(time.Time{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("SetReadDeadline after Close: %v, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,284 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"iter"
+	"os"
+)
+
+// The delta-sync protocol brings a remote copy of a source up to date in
+// rounds, in the manner of rsync but page by page. The sender starts a
+// round with syncProtoMagic, the size of the source, the page size and the
+// length of an encoded PageSet of the pages that may have changed,
+// followed by the set and by the weak and strong hashes of these pages, in
+// order: an Adler-32 and a SHA-256 of each page, zero-filled past the end
+// of the source. The receiver answers with the length of an encoded PageSet
+// of the pages whose hashes differ from its own, followed by the set. The
+// sender then sends these pages, in order, and the receiver ends the round
+// with the length of an error message, followed by the message, empty if
+// the pages were written. Integers are little endian.
+const syncProtoMagic = "UFFDSYN1"
+
+const syncHashSize = 4 + sha256.Size
+
+// maxSyncSet bounds the encoded page sets of the delta-sync protocol, that
+// of every page of 1TiB of 4KiB pages taking 32MiB.
+const maxSyncSet = 1 << 26
+
+// SyncStats counts the pages of a round of delta synchronization.
+type SyncStats struct {
+	Hashed int   // Pages whose hashes were compared
+	Sent   int   // Pages that differed and were sent
+	Bytes  int64 // Bytes of hashes and pages transferred
+}
+
+// Syncer sends a source, such as the memory of a region, to a remote copy
+// kept by ReceiveSync, sending only the pages that differ from it. The
+// first round compares every page. With a DirtyTracker, the write-back of
+// the region, later rounds only compare the pages written back since the
+// previous round, or every page if the tracker history was trimmed past
+// it. The region should then be flushed before each round, and the remote
+// copy must not change meanwhile.
+type Syncer struct {
+	src      io.ReaderAt
+	size     int64
+	tracker  *DirtyTracker
+	pageSize int
+
+	synced bool   // a round completed
+	epoch  uint64 // of the tracker at the start of the last round
+}
+
+// NewSyncer returns a Syncer of size bytes read from src, limiting the
+// later rounds to the pages written back to tracker if not nil.
+func NewSyncer(src io.ReaderAt, size int64, tracker *DirtyTracker) *Syncer {
+	return &Syncer{src: src, size: size, tracker: tracker, pageSize: os.Getpagesize()}
+}
+
+// Sync runs a round over c, returning its counts. Errors of the receiver
+// are returned as *SyncError.
+func (s *Syncer) Sync(c io.ReadWriter) (SyncStats, error) {
+	var stats SyncStats
+	candidates := new(PageSet)
+	var epoch uint64
+	if s.tracker != nil {
+		epoch = s.tracker.Epoch()
+	}
+	if diffs, err := s.diffs(epoch); err == nil {
+		for d := range diffs {
+			first := uint64(d.Off) / uint64(s.pageSize)
+			candidates.AddRange(first, first+uint64(d.Len/s.pageSize))
+		}
+	} else if pages := (s.size + int64(s.pageSize) - 1) / int64(s.pageSize); pages > 0 {
+		candidates.AddRange(0, uint64(pages))
+	}
+
+	w := bufio.NewWriter(c)
+	set, err := candidates.MarshalBinary()
+	if err != nil {
+		return stats, err
+	}
+	w.WriteString(syncProtoMagic)
+	binary.Write(w, binary.LittleEndian, uint64(s.size))
+	binary.Write(w, binary.LittleEndian, uint32(s.pageSize))
+	binary.Write(w, binary.LittleEndian, uint32(len(set)))
+	w.Write(set)
+	page := make([]byte, s.pageSize)
+	for i := range candidates.All() {
+		if err := s.read(page, i); err != nil {
+			return stats, err
+		}
+		w.Write(syncHash(page))
+		stats.Hashed++
+	}
+	if err := w.Flush(); err != nil {
+		return stats, err
+	}
+
+	r := bufio.NewReader(c)
+	wanted, err := readSyncSet(r)
+	if err != nil {
+		return stats, err
+	}
+	for i := range wanted.All() {
+		if !candidates.Contains(i) {
+			return stats, fmt.Errorf("page %d requested but not offered", i)
+		}
+		if err := s.read(page, i); err != nil {
+			return stats, err
+		}
+		w.Write(page)
+		stats.Sent++
+	}
+	if err := w.Flush(); err != nil {
+		return stats, err
+	}
+	stats.Bytes = int64(stats.Hashed)*syncHashSize + int64(stats.Sent)*int64(s.pageSize)
+
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return stats, err
+	}
+	if n > MaxPageRequest {
+		return stats, errors.New("invalid delta-sync response")
+	}
+	if n > 0 {
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return stats, err
+		}
+		return stats, &SyncError{Msg: string(msg)}
+	}
+	s.synced, s.epoch = true, epoch
+	return stats, nil
+}
+
+// diffs returns the pages written back since the last round, up to epoch.
+func (s *Syncer) diffs(epoch uint64) (iter.Seq[PageDiff], error) {
+	if s.tracker == nil || !s.synced {
+		return nil, errors.New("no previous round")
+	}
+	return s.tracker.Diff(s.epoch, epoch)
+}
+
+// read reads page i of the source, zero-filled past its end.
+func (s *Syncer) read(page []byte, i uint64) error {
+	return readSyncPage(s.src, page, int64(i)*int64(s.pageSize))
+}
+
+// ReceiveSync receives a round of a Syncer over c into dst, such as an
+// *os.File, returning its counts. The pages that differ are written to dst,
+// but not past the size of the source.
+func ReceiveSync(c io.ReadWriter, dst interface {
+	io.ReaderAt
+	io.WriterAt
+}) (SyncStats, error) {
+	var stats SyncStats
+	r := bufio.NewReader(c)
+	var magic [len(syncProtoMagic)]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return stats, err
+	}
+	if string(magic[:]) != syncProtoMagic {
+		return stats, errors.New("not a delta-sync protocol peer")
+	}
+	var hdr struct {
+		Size     uint64
+		PageSize uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return stats, err
+	}
+	if hdr.PageSize == 0 || hdr.PageSize > MaxPageRequest || int64(hdr.Size) < 0 {
+		return stats, fmt.Errorf("invalid delta-sync round of %d bytes in pages of %d", hdr.Size, hdr.PageSize)
+	}
+	size, pageSize := int64(hdr.Size), int64(hdr.PageSize)
+	candidates, err := readSyncSet(r)
+	if err != nil {
+		return stats, err
+	}
+
+	wanted := new(PageSet)
+	page := make([]byte, pageSize)
+	hash := make([]byte, syncHashSize)
+	for i := range candidates.All() {
+		if int64(i) >= (size+pageSize-1)/pageSize {
+			return stats, fmt.Errorf("page %d past the end of the source", i)
+		}
+		if _, err := io.ReadFull(r, hash); err != nil {
+			return stats, err
+		}
+		stats.Hashed++
+		if err := readSyncPage(dst, page, int64(i)*pageSize); err != nil {
+			return stats, err
+		}
+		// Only hash with SHA-256 the pages whose Adler-32 matches.
+		if binary.LittleEndian.Uint32(hash) != adler32.Checksum(page) || sha256.Sum256(page) != [sha256.Size]byte(hash[4:]) {
+			wanted.Add(i)
+		}
+	}
+	set, err := wanted.MarshalBinary()
+	if err != nil {
+		return stats, err
+	}
+	w := bufio.NewWriter(c)
+	binary.Write(w, binary.LittleEndian, uint32(len(set)))
+	w.Write(set)
+	if err := w.Flush(); err != nil {
+		return stats, err
+	}
+
+	var werr error
+	for i := range wanted.All() {
+		if _, err := io.ReadFull(r, page); err != nil {
+			return stats, err
+		}
+		off := int64(i) * pageSize
+		if werr == nil {
+			_, werr = dst.WriteAt(page[:min(pageSize, size-off)], off)
+		}
+		stats.Sent++
+	}
+	stats.Bytes = int64(stats.Hashed)*syncHashSize + int64(stats.Sent)*pageSize
+	var msg string
+	if werr != nil {
+		msg = werr.Error()
+	}
+	binary.Write(w, binary.LittleEndian, uint32(len(msg)))
+	w.WriteString(msg)
+	if err := w.Flush(); err != nil {
+		return stats, err
+	}
+	return stats, werr
+}
+
+// SyncError is an error reported by the receiver of a delta-sync round.
+type SyncError struct {
+	Msg string
+}
+
+func (e *SyncError) Error() string {
+	return "delta-sync receiver: " + e.Msg
+}
+
+func syncHash(page []byte) []byte {
+	sum := sha256.Sum256(page)
+	return append(binary.LittleEndian.AppendUint32(nil, adler32.Checksum(page)), sum[:]...)
+}
+
+// readSyncPage reads the page at off of r, zero-filled past its end.
+func readSyncPage(r io.ReaderAt, page []byte, off int64) error {
+	n, err := r.ReadAt(page, off)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	clear(page[n:])
+	return nil
+}
+
+func readSyncSet(r io.Reader) (*PageSet, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n > maxSyncSet {
+		return nil, fmt.Errorf("page set of %d bytes too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	s := new(PageSet)
+	if err := s.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,72 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// syncRound runs a round from s to dst, returning the counts of the sender.
+func syncRound(t *testing.T, s *Syncer, dst *os.File) SyncStats {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		_, err := ReceiveSync(server, dst)
+		done <- err
+	}()
+	stats, err := s.Sync(client)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ReceiveSync failed: %v", err)
+	}
+	return stats
+}
+
+func TestSyncer(t *testing.T) {
+	pageSize := os.Getpagesize()
+	src := make([]byte, 8*pageSize+100)
+	for i := range src {
+		src[i] = byte(i % 251)
+	}
+	dst, err := os.Create(filepath.Join(t.TempDir(), "copy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	// The copy already has the first half.
+	dst.Write(src[:4*pageSize])
+
+	tracker := NewDirtyTracker(nil, false)
+	s := NewSyncer(bytes.NewReader(src), int64(len(src)), tracker)
+	if st := syncRound(t, s, dst); st.Hashed != 9 || st.Sent != 5 {
+		t.Errorf("first round: %+v", st)
+	}
+	if got := readFile(t, dst); !bytes.Equal(got, src) {
+		t.Fatalf("copy differs after the first round: %d bytes", len(got))
+	}
+
+	// Later rounds only compare the pages written back.
+	src[2*pageSize] ^= 0xff
+	src[5*pageSize] ^= 0xff
+	tracker.WritePages(src[2*pageSize:3*pageSize], int64(2*pageSize))
+	tracker.WritePages(src[5*pageSize:7*pageSize], int64(5*pageSize))
+	tracker.Sync()
+	if st := syncRound(t, s, dst); st.Hashed != 3 || st.Sent != 2 {
+		t.Errorf("second round: %+v", st)
+	}
+	if got := readFile(t, dst); !bytes.Equal(got, src) {
+		t.Error("copy differs after the second round")
+	}
+	if st := syncRound(t, s, dst); st.Hashed != 0 {
+		t.Errorf("round without changes: %+v", st)
+	}
+}
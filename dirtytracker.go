@@ -0,0 +1,184 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"iter"
+	"os"
+	"slices"
+	"sync"
+)
+
+// DirtyTracker is a PageSyncer keeping the history of the write-back of a
+// region: each flush that writes pages back ends an epoch, and Diff tells
+// the pages changed between epochs, for audit tools or replication. Pages
+// are passed on to another PageWriter, if any, which is synced as well.
+// Epoch 0 is the state before the first flush.
+//
+// With a shadow store, the tracker also keeps the contents of the pages as
+// of every epoch, until trimmed. Pages are tracked by system page. It is
+// safe for concurrent use.
+type DirtyTracker struct {
+	w        PageWriter
+	shadow   bool
+	pageSize int64
+
+	mu       sync.Mutex
+	base     uint64     // first epoch Diff can start from
+	epochs   []*PageSet // pages written by epochs base+1 on
+	pending  PageSet    // pages written by the epoch not ended yet
+	versions map[uint64][]pageVersion
+}
+
+// pageVersion is the contents of a page as of an epoch.
+type pageVersion struct {
+	epoch uint64
+	data  []byte
+}
+
+// PageDiff is a run of contiguous pages changed between two epochs, with
+// their contents as of each when kept by the shadow store. Old is nil when
+// the pages were not written back by then, as they still had the contents
+// the region started with.
+type PageDiff struct {
+	Off      int64 // in the region
+	Len      int
+	Old, New []byte
+}
+
+// NewDirtyTracker returns a DirtyTracker passing pages on to w, if not nil,
+// and keeping their contents if shadow is true.
+func NewDirtyTracker(w PageWriter, shadow bool) *DirtyTracker {
+	t := &DirtyTracker{w: w, shadow: shadow, pageSize: int64(os.Getpagesize())}
+	if shadow {
+		t.versions = make(map[uint64][]pageVersion)
+	}
+	return t
+}
+
+// WritePages implements PageWriter.
+func (t *DirtyTracker) WritePages(p []byte, off int64) error {
+	if off%t.pageSize != 0 || int64(len(p))%t.pageSize != 0 {
+		return fmt.Errorf("pages at %d of %d bytes not page aligned", off, len(p))
+	}
+	if t.w != nil {
+		if err := t.w.WritePages(p, off); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	epoch := t.base + uint64(len(t.epochs)) + 1
+	for k := int64(0); k < int64(len(p)); k += t.pageSize {
+		i := uint64((off + k) / t.pageSize)
+		t.pending.Add(i)
+		if !t.shadow {
+			continue
+		}
+		data := bytes.Clone(p[k : k+t.pageSize])
+		vs := t.versions[i]
+		if n := len(vs); n > 0 && vs[n-1].epoch == epoch {
+			vs[n-1].data = data
+		} else {
+			t.versions[i] = append(vs, pageVersion{epoch, data})
+		}
+	}
+	return nil
+}
+
+// Sync implements PageSyncer: it syncs the PageWriter, if a PageSyncer, and
+// ends the epoch.
+func (t *DirtyTracker) Sync() error {
+	if s, ok := t.w.(PageSyncer); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.epochs = append(t.epochs, t.pending.Clone())
+	t.pending = PageSet{}
+	return nil
+}
+
+// Epoch returns the last epoch ended.
+func (t *DirtyTracker) Epoch() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.base + uint64(len(t.epochs))
+}
+
+// Diff returns an iterator over the runs of pages written back after epoch
+// a up to epoch b, by offset. The history must not have been trimmed past
+// a. The iterator works on a snapshot taken by Diff.
+func (t *DirtyTracker) Diff(a, b uint64) (iter.Seq[PageDiff], error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last := t.base + uint64(len(t.epochs)); a > b || a < t.base || b > last {
+		return nil, fmt.Errorf("invalid epochs %d to %d of history from %d to %d", a, b, t.base, last)
+	}
+
+	changed := new(PageSet)
+	for _, s := range t.epochs[a-t.base : b-t.base] {
+		changed.Union(s)
+	}
+	var diffs []PageDiff
+	for i := range changed.All() {
+		off := int64(i) * t.pageSize
+		var old, cur []byte
+		if t.shadow {
+			old, cur = t.version(i, a), t.version(i, b)
+		}
+		// Runs are split where the old contents are missing or not.
+		if n := len(diffs); n > 0 && diffs[n-1].Off+int64(diffs[n-1].Len) == off && (diffs[n-1].Old == nil) == (old == nil) {
+			d := &diffs[n-1]
+			d.Len += int(t.pageSize)
+			d.Old = append(d.Old, old...)
+			d.New = append(d.New, cur...)
+			continue
+		}
+		diffs = append(diffs, PageDiff{Off: off, Len: int(t.pageSize), Old: bytes.Clone(old), New: bytes.Clone(cur)})
+	}
+	return slices.Values(diffs), nil
+}
+
+// version returns the contents of page i as of epoch e, or nil, with mu
+// held.
+func (t *DirtyTracker) version(i, e uint64) []byte {
+	vs := t.versions[i]
+	k, found := slices.BinarySearchFunc(vs, e, func(v pageVersion, e uint64) int {
+		return cmp.Compare(v.epoch, e)
+	})
+	if found {
+		return vs[k].data
+	}
+	if k == 0 {
+		return nil
+	}
+	return vs[k-1].data
+}
+
+// Trim discards the history up to epoch e, which Diff can no longer start
+// from, keeping the contents of the pages as of e.
+func (t *DirtyTracker) Trim(e uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e <= t.base {
+		return
+	}
+	e = min(e, t.base+uint64(len(t.epochs)))
+	t.epochs = slices.Delete(t.epochs, 0, int(e-t.base))
+	t.base = e
+	for i, vs := range t.versions {
+		// Keep the last version up to e and the later ones.
+		k := 0
+		for k+1 < len(vs) && vs[k+1].epoch <= e {
+			k++
+		}
+		t.versions[i] = slices.Delete(vs, 0, k)
+	}
+}
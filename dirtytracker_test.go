@@ -0,0 +1,69 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestDirtyTracker(t *testing.T) {
+	pageSize := os.Getpagesize()
+	page := func(b byte) []byte { return bytes.Repeat([]byte{b}, pageSize) }
+	tr := NewDirtyTracker(nil, true)
+
+	// Epoch 1 writes pages 0 and 1, epoch 2 pages 1 and 3.
+	tr.WritePages(slices.Concat(page(1), page(1)), 0)
+	tr.Sync()
+	tr.WritePages(page(2), int64(pageSize))
+	tr.WritePages(page(2), int64(3*pageSize))
+	tr.Sync()
+	tr.WritePages(page(3), 0) // not ended
+	if tr.Epoch() != 2 {
+		t.Fatalf("Epoch = %d, want 2", tr.Epoch())
+	}
+
+	diff := func(a, b uint64) []PageDiff {
+		t.Helper()
+		seq, err := tr.Diff(a, b)
+		if err != nil {
+			t.Fatalf("Diff(%d, %d) failed: %v", a, b, err)
+		}
+		return slices.Collect(seq)
+	}
+	want := []PageDiff{
+		{Off: 0, Len: 2 * pageSize, New: slices.Concat(page(1), page(2))},
+		{Off: int64(3 * pageSize), Len: pageSize, New: page(2)},
+	}
+	if got := diff(0, 2); !slices.EqualFunc(got, want, equalPageDiff) {
+		t.Errorf("Diff(0, 2) = %v", got)
+	}
+	// Old contents are split from missing ones.
+	want = []PageDiff{
+		{Off: int64(pageSize), Len: pageSize, Old: page(1), New: page(2)},
+		{Off: int64(3 * pageSize), Len: pageSize, New: page(2)},
+	}
+	if got := diff(1, 2); !slices.EqualFunc(got, want, equalPageDiff) {
+		t.Errorf("Diff(1, 2) = %v", got)
+	}
+	if got := diff(2, 2); len(got) != 0 {
+		t.Errorf("Diff(2, 2) = %v", got)
+	}
+
+	tr.Trim(1)
+	if _, err := tr.Diff(0, 2); err == nil {
+		t.Error("Diff from a trimmed epoch succeeded")
+	}
+	if got := diff(1, 2); !slices.EqualFunc(got, want, equalPageDiff) {
+		t.Errorf("Diff(1, 2) after Trim = %v", got)
+	}
+	if _, err := tr.Diff(1, 3); err == nil {
+		t.Error("Diff to an epoch not ended succeeded")
+	}
+}
+
+func equalPageDiff(a, b PageDiff) bool {
+	return a.Off == b.Off && a.Len == b.Len && bytes.Equal(a.Old, b.Old) && bytes.Equal(a.New, b.New)
+}
@@ -6,14 +6,64 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-
-	"golang.org/x/sys/unix"
 )
 
 var (
 	ErrInvalidApi         = errors.New("kernel returned unexpected UFFD_API version")
 	ErrMissingIoctl       = errors.New("missing ioctl")
 	ErrUnsupportedFeature = errors.New("requested userfaultfd features not supported by kernel")
+	ErrNotSupported       = errors.New("userfaultfd not supported on this platform")
+	ErrClosed             = errors.New("userfaultfd closed")
+	ErrSelfFault          = errors.New("fault caused by the fault handler")
+	ErrInvalidMsg         = errors.New("invalid userfaultfd message")
+	ErrStoreFull          = errors.New("eviction store full")
+)
+
+// Failure modes of the ioctls, matched with errors.Is along with the errno
+// they come from.
+var (
+	ErrBadAlignment  = errors.New("range not page aligned")            // EINVAL
+	ErrNotRegistered = errors.New("range not registered")              // ENOENT
+	ErrRangeGone     = errors.New("address space of the range exited") // ESRCH
+	ErrAlreadyMapped = errors.New("page already mapped")               // EEXIST
+)
+
+// ioctlError is an error of an ioctl that also matches the sentinel error
+// of its failure mode.
+type ioctlError struct {
+	err      error
+	sentinel error
+}
+
+func (e *ioctlError) Error() string {
+	return e.err.Error() + " (" + e.sentinel.Error() + ")"
+}
+
+func (e *ioctlError) Unwrap() []error {
+	return []error{e.err, e.sentinel}
+}
+
+// ErrFeatureUnavailable is returned by New when the kernel does not support
+// some of the requested features. It matches ErrUnsupportedFeature.
+type ErrFeatureUnavailable struct {
+	Feature uint64 // UFFD_FEATURE_* flags not supported
+}
+
+func (e *ErrFeatureUnavailable) Error() string {
+	return fmt.Sprintf("userfaultfd features not supported by kernel: %s", FeatureString(e.Feature))
+}
+
+func (e *ErrFeatureUnavailable) Is(target error) bool {
+	return target == ErrUnsupportedFeature
+}
+
+// poll(2) events, the same on all Linux architectures.
+const (
+	pollIn   = 0x1
+	pollOut  = 0x4
+	pollErr  = 0x8
+	pollHup  = 0x10
+	pollNval = 0x20
 )
 
 // PollError indicates a poll(2) error condition such as POLLERR, POLLHUP, or POLLNVAL.
@@ -25,19 +75,19 @@ type PollError struct {
 func ReventString(revents int16) string {
 	var parts []string
 
-	if revents&unix.POLLIN != 0 {
+	if revents&pollIn != 0 {
 		parts = append(parts, "POLLIN")
 	}
-	if revents&unix.POLLOUT != 0 {
+	if revents&pollOut != 0 {
 		parts = append(parts, "POLLOUT")
 	}
-	if revents&unix.POLLERR != 0 {
+	if revents&pollErr != 0 {
 		parts = append(parts, "POLLERR")
 	}
-	if revents&unix.POLLHUP != 0 {
+	if revents&pollHup != 0 {
 		parts = append(parts, "POLLHUP")
 	}
-	if revents&unix.POLLNVAL != 0 {
+	if revents&pollNval != 0 {
 		parts = append(parts, "POLLNVAL")
 	}
 
@@ -56,6 +106,23 @@ func (e *PollError) Is(target error) bool {
 	return ok
 }
 
-func (e *PollError) IsHangup() bool  { return e.Revents&unix.POLLHUP != 0 }
-func (e *PollError) IsError() bool   { return e.Revents&unix.POLLERR != 0 }
-func (e *PollError) IsInvalid() bool { return e.Revents&unix.POLLNVAL != 0 }
+func (e *PollError) IsHangup() bool  { return e.Revents&pollHup != 0 }
+func (e *PollError) IsError() bool   { return e.Revents&pollErr != 0 }
+func (e *PollError) IsInvalid() bool { return e.Revents&pollNval != 0 }
+
+// FaultError is returned by SigbusDispatcher.Do for a fault it cannot resolve.
+type FaultError struct {
+	Addr uintptr
+	Err  error // nil if the address is outside all regions
+}
+
+func (e *FaultError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("unhandled fault at %#x", e.Addr)
+	}
+	return fmt.Sprintf("fault at %#x: %v", e.Addr, e.Err)
+}
+
+func (e *FaultError) Unwrap() error {
+	return e.Err
+}
@@ -1,3 +1,5 @@
+//go:build linux
+
 /* SPDX-License-Identifier: BSD-2-Clause */
 
 package userfaultfd
@@ -5,6 +7,7 @@ package userfaultfd
 import (
 	"errors"
 	"testing"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
@@ -90,3 +93,52 @@ func TestPollErrorErrorFormat(t *testing.T) {
 		t.Fatalf("Error() = %q, want %q", got, want)
 	}
 }
+
+func TestIoctlErrorSentinels(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	mem, err := unix.Mmap(-1, 0, 2*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+	addr := uintptr(unsafe.Pointer(&mem[0]))
+
+	_, err = uffd.Register(addr+1, pageSize, UFFDIO_REGISTER_MODE_MISSING)
+	if !errors.Is(err, ErrBadAlignment) || !errors.Is(err, unix.EINVAL) {
+		t.Fatalf("misaligned Register returned %v, want ErrBadAlignment", err)
+	}
+
+	if _, err := uffd.Zeropage(addr, pageSize, UFFDIO_ZEROPAGE_MODE_DONTWAKE); !errors.Is(err, ErrNotRegistered) || !errors.Is(err, unix.ENOENT) {
+		t.Fatalf("Zeropage of unregistered range returned %v, want ErrNotRegistered", err)
+	}
+
+	if _, err := uffd.Register(addr, pageSize, UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if _, err := uffd.Zeropage(addr, pageSize, UFFDIO_ZEROPAGE_MODE_DONTWAKE); err != nil {
+		t.Fatalf("Zeropage failed: %v", err)
+	}
+	if _, err := uffd.Zeropage(addr, pageSize, UFFDIO_ZEROPAGE_MODE_DONTWAKE); !errors.Is(err, ErrAlreadyMapped) || !errors.Is(err, unix.EEXIST) {
+		t.Fatalf("second Zeropage returned %v, want ErrAlreadyMapped", err)
+	}
+}
+
+func TestErrFeatureUnavailable(t *testing.T) {
+	var err error = &ErrFeatureUnavailable{Feature: UFFD_FEATURE_SIGBUS}
+	if !errors.Is(err, ErrUnsupportedFeature) {
+		t.Fatalf("%v does not match ErrUnsupportedFeature", err)
+	}
+	var fe *ErrFeatureUnavailable
+	if !errors.As(err, &fe) || fe.Feature != UFFD_FEATURE_SIGBUS {
+		t.Fatalf("errors.As returned %v", fe)
+	}
+	if want := "userfaultfd features not supported by kernel: SIGBUS"; err.Error() != want {
+		t.Fatalf("Error() = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,225 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// EventLoop dispatches the events of many userfaultfds from a single
+// goroutine waiting on an epoll(7) descriptor, instead of one goroutine
+// per userfaultfd calling Serve.
+type EventLoop struct {
+	// OnError, if set, is called from Run when a handler returns an error.
+	// The userfaultfd is then removed from the loop.
+	OnError func(u *Uffd, err error)
+
+	epfd      int
+	wake      int          // eventfd signalled by Close to stop Run
+	life      sync.RWMutex // held for reading by Run
+	closed    atomic.Bool
+	closeOnce sync.Once
+
+	msgs []UffdMsg // used by Run only
+
+	mu       sync.Mutex
+	handlers map[int32]loopEntry // by id, which is the epoll data
+	ids      map[*Uffd]int32
+	nextID   int32
+}
+
+type loopEntry struct {
+	uffd    *Uffd
+	handler Handler
+}
+
+// NewEventLoop creates an empty EventLoop.
+func NewEventLoop() (*EventLoop, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		unix.Close(epfd)
+		return nil, os.NewSyscallError("eventfd", err)
+	}
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: wakeID}
+	if err := unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, wake, &ev); err != nil {
+		unix.Close(wake)
+		unix.Close(epfd)
+		return nil, os.NewSyscallError("epoll_ctl", err)
+	}
+	return &EventLoop{
+		epfd:     epfd,
+		wake:     wake,
+		handlers: make(map[int32]loopEntry),
+		ids:      make(map[*Uffd]int32),
+		msgs:     make([]UffdMsg, msgBatchSize),
+	}, nil
+}
+
+// Epoll data of the eventfd. Userfaultfds are identified by a counter
+// rather than by descriptor, which may be reused once closed.
+const wakeID = -1
+
+// Add dispatches the events of u to h. It may be called while Run is
+// running. The userfaultfd must be non-blocking, as epoll(7) always reports
+// blocking userfaultfds in error.
+func (l *EventLoop) Add(u *Uffd, h Handler) error {
+	if !u.Nonblock() {
+		return errors.New("userfaultfd must be non-blocking")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed.Load() {
+		return ErrClosed
+	}
+
+	if _, ok := l.ids[u]; ok {
+		return errors.New("userfaultfd already in event loop")
+	}
+	id := l.nextID
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: id}
+	if err := u.control(func(fd uintptr) error {
+		return unix.EpollCtl(l.epfd, unix.EPOLL_CTL_ADD, int(fd), &ev)
+	}); err == ErrClosed {
+		return err
+	} else if err != nil {
+		return os.NewSyscallError("epoll_ctl", err)
+	}
+	l.nextID++
+	l.handlers[id] = loopEntry{uffd: u, handler: h}
+	l.ids[u] = id
+	return nil
+}
+
+// Remove stops dispatching the events of u. Its handler is not called
+// anymore once Remove returns, unless it is running already. Closing u
+// stops its events too, but it must still be removed.
+func (l *EventLoop) Remove(u *Uffd) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.ids[u]; !ok {
+		return errors.New("userfaultfd not in event loop")
+	}
+	l.remove(u)
+	return nil
+}
+
+// remove must be called with l.mu held. If the userfaultfd was closed, the
+// kernel removed it from the epoll set already.
+func (l *EventLoop) remove(u *Uffd) {
+	delete(l.handlers, l.ids[u])
+	delete(l.ids, u)
+	u.control(func(fd uintptr) error {
+		return unix.EpollCtl(l.epfd, unix.EPOLL_CTL_DEL, int(fd), nil)
+	})
+}
+
+// Len returns the number of userfaultfds in the loop.
+func (l *EventLoop) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.handlers)
+}
+
+// Run waits for events and dispatches them until the loop is closed, in
+// which case it returns nil.
+//
+// As with Serve, a goroutine blocked on a fault keeps its P, so GOMAXPROCS
+// must be large enough for Run to get to run.
+func (l *EventLoop) Run() error {
+	l.life.RLock()
+	defer l.life.RUnlock()
+
+	events := make([]unix.EpollEvent, 64)
+	for !l.closed.Load() {
+		n, err := unix.EpollWait(l.epfd, events, -1)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return os.NewSyscallError("epoll_wait", err)
+		}
+		for _, ev := range events[:n] {
+			if ev.Fd == wakeID {
+				return nil
+			}
+			l.dispatch(ev.Fd)
+		}
+	}
+	return nil
+}
+
+// dispatch reads all pending events of the userfaultfd with the given id.
+func (l *EventLoop) dispatch(id int32) {
+	l.mu.Lock()
+	e, ok := l.handlers[id]
+	l.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	var perr *PollError
+	for {
+		n, err := e.uffd.ReadMsgsTimeout(l.msgs, 0)
+		switch {
+		case err == nil:
+			for i := range l.msgs[:n] {
+				if err = e.handler.HandleEvent(e.uffd, &l.msgs[i]); err != nil {
+					break
+				}
+			}
+			if bh, ok := e.handler.(BatchHandler); ok && err == nil {
+				err = bh.EndBatch(e.uffd)
+			}
+		case errors.Is(err, unix.EAGAIN):
+			return
+		case errors.Is(err, ErrClosed), errors.As(err, &perr):
+			// The userfaultfd was closed or released.
+			l.drop(e.uffd)
+			return
+		}
+		if err != nil {
+			l.drop(e.uffd)
+			if l.OnError != nil {
+				l.OnError(e.uffd, err)
+			}
+			return
+		}
+	}
+}
+
+// drop removes u unless it was removed already.
+func (l *EventLoop) drop(u *Uffd) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.ids[u]; ok {
+		l.remove(u)
+	}
+}
+
+// Close stops Run and releases the epoll descriptor. The userfaultfds in
+// the loop are left open.
+func (l *EventLoop) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		l.closed.Store(true)
+		l.mu.Unlock()
+		unix.Write(l.wake, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+
+		l.life.Lock()
+		defer l.life.Unlock()
+		err = unix.Close(l.epfd)
+		unix.Close(l.wake)
+	})
+	return err
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestEventLoop(t *testing.T) {
+	needProcs(t, 2)
+
+	l, err := NewEventLoop()
+	if err != nil {
+		t.Fatalf("NewEventLoop failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Run()
+	}()
+
+	const n = 8
+	pageSize := unix.Getpagesize()
+	mems := make([][]byte, n)
+	uffds := make([]*Uffd, n)
+	for i := range n {
+		uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer uffd.Close()
+		mem, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+		if err != nil {
+			t.Fatalf("mmap failed: %v", err)
+		}
+		defer unix.Munmap(mem)
+		r, err := uffd.RegisterRegion(mem, UFFDIO_REGISTER_MODE_MISSING, WithProvider(patternProvider(1)))
+		if err != nil {
+			t.Fatalf("RegisterRegion failed: %v", err)
+		}
+		if err := l.Add(uffd, r); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		mems[i], uffds[i] = mem, uffd
+	}
+	if err := l.Add(uffds[0], nil); err == nil {
+		t.Errorf("Add of the same userfaultfd succeeded")
+	}
+	if l.Len() != n {
+		t.Errorf("Len = %d, want %d", l.Len(), n)
+	}
+
+	for i, mem := range mems {
+		if mem[0] != 1 {
+			t.Errorf("mem[%d][0] = %d, want 1", i, mem[0])
+		}
+	}
+
+	if err := l.Remove(uffds[0]); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+	if err := l.Remove(uffds[0]); err == nil {
+		t.Errorf("second Remove succeeded")
+	}
+	uffds[1].Close()
+	if err := l.Remove(uffds[1]); err != nil {
+		t.Errorf("Remove of closed userfaultfd failed: %v", err)
+	}
+	if l.Len() != n-2 {
+		t.Errorf("Len = %d, want %d", l.Len(), n-2)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if err := l.Add(uffds[0], nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Add after Close: %v, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,244 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"container/list"
+	"fmt"
+	"slices"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Evictor reclaims cold pages of a Region with MADV_DONTNEED, relying on
+// the region's handler to refault them on the next access. Pages are
+// chosen by an EvictionPolicy fed with the region's fault activity. Pages
+// pinned by a BufferPool are left alone, but count as resident.
+//
+// Eviction only releases memory for private anonymous mappings. For shared
+// mappings the page cache keeps the contents and no fault is raised.
+//
+// With WithEvictionStore, evicted pages are copied to the store, modified
+// or not, and refaulted from it. Writes racing with their eviction are lost.
+//
+// If UFFD_FEATURE_EVENT_REMOVE is enabled, Evict blocks until the resulting
+// event is read, so it must not be called from the goroutine serving the region.
+type Evictor struct {
+	r      *Region
+	mu     sync.Mutex
+	policy EvictionPolicy
+	pins   map[uintptr]int // pages excluded from eviction, by pin count
+	// Pages not admitted by an AdmissionPolicy, evicted first.
+	rejected lruPolicy
+}
+
+// NewEvictor attaches an Evictor to r. Pages filled by r from then on are
+// tracked for eviction. If policy is nil, least recently used pages are
+// evicted first. If policy is an AdmissionPolicy, pages faulted at the
+// resident limit of r that it does not admit are evicted first.
+func NewEvictor(r *Region, policy EvictionPolicy) *Evictor {
+	if policy == nil {
+		policy = NewLRUPolicy()
+	}
+	e := &Evictor{
+		r:      r,
+		policy: policy,
+		pins:   make(map[uintptr]int),
+	}
+	e.rejected = lruPolicy{lru: list.New(), pages: make(map[uintptr]*list.Element)}
+	r.evictor.Store(e)
+	return e
+}
+
+// Touch records activity on the page containing addr.
+func (e *Evictor) Touch(addr uintptr) {
+	page := addr &^ uintptr(e.r.pageSize-1)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, rejected := e.rejected.pages[page]; rejected || e.pins[page] > 0 {
+		return
+	}
+	if p, ok := e.policy.(AdmissionPolicy); ok && e.atLimit() {
+		if !p.Admit(page) {
+			e.rejected.Touch(page)
+		}
+		return
+	}
+	e.policy.Touch(page)
+}
+
+// atLimit reports whether one more page uses up the resident limit.
+func (e *Evictor) atLimit() bool {
+	max := e.r.maxResident
+	return max > 0 && int64(e.resident()+1)*int64(e.r.pageSize) >= max
+}
+
+// resident returns the number of tracked pages, with mu held.
+func (e *Evictor) resident() int {
+	return e.policy.Len() + len(e.pins) + e.rejected.Len()
+}
+
+// Forget stops tracking pages in the given range, e.g. after they were
+// removed by some other means.
+func (e *Evictor) Forget(start uintptr, length int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ps := uintptr(e.r.pageSize)
+	end := RoundUp(start+uintptr(length), ps)
+	for page := start &^ (ps - 1); page < end; page += ps {
+		e.policy.Remove(page)
+		e.rejected.Remove(page)
+	}
+}
+
+// Resident returns the number of tracked resident pages, pinned included.
+func (e *Evictor) Resident() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.resident()
+}
+
+// pin excludes the pages of a range from eviction until unpinned as many
+// times.
+func (e *Evictor) pin(start uintptr, length int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ps := uintptr(e.r.pageSize)
+	end := RoundUp(start+uintptr(length), ps)
+	for page := start &^ (ps - 1); page < end; page += ps {
+		if e.pins[page] == 0 {
+			e.policy.Remove(page)
+			e.rejected.Remove(page)
+		}
+		e.pins[page]++
+	}
+}
+
+// unpin makes the pages of a range evictable again once unpinned as many
+// times as pinned.
+func (e *Evictor) unpin(start uintptr, length int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ps := uintptr(e.r.pageSize)
+	end := RoundUp(start+uintptr(length), ps)
+	start &^= ps - 1
+	for page := start; page < end; page += ps {
+		if e.pins[page] == 0 {
+			return fmt.Errorf("page %#x not pinned", page)
+		}
+	}
+	for page := start; page < end; page += ps {
+		if e.pins[page]--; e.pins[page] == 0 {
+			delete(e.pins, page)
+			e.policy.Touch(page)
+		}
+	}
+	return nil
+}
+
+// Evict reclaims up to n pages chosen by the policy.
+// Returns the number of pages evicted.
+func (e *Evictor) Evict(n int) (int, error) {
+	// The pages are dropped with mu held, so that a pin meanwhile cannot
+	// see them resident and have them dropped afterwards.
+	e.mu.Lock()
+	var victims []uintptr
+	for len(victims) < n {
+		page, ok := e.rejected.Victim()
+		if !ok {
+			page, ok = e.policy.Victim()
+		}
+		if !ok {
+			break
+		}
+		victims = append(victims, page)
+	}
+	runs, err := e.dontneed(victims)
+	e.mu.Unlock()
+
+	for _, run := range runs {
+		e.r.onEvict(run.start, run.length)
+	}
+	if err != nil {
+		return 0, err
+	}
+	e.r.stats.add(&e.r.stats.Evictions, uint64(len(victims)))
+	return len(victims), nil
+}
+
+// reserve evicts pages until one more page fits in max resident bytes.
+func (e *Evictor) reserve(max int64) error {
+	e.mu.Lock()
+	excess := (int64(e.resident())+1)*int64(e.r.pageSize) - max
+	e.mu.Unlock()
+
+	if excess <= 0 {
+		return nil
+	}
+	n := int((excess + int64(e.r.pageSize) - 1) / int64(e.r.pageSize))
+	_, err := e.Evict(n)
+	return err
+}
+
+// dontneed issues one madvise(2) per run of contiguous pages, with mu held,
+// and returns the runs dropped.
+func (e *Evictor) dontneed(pages []uintptr) (runs []evictedRun, err error) {
+	slices.Sort(pages)
+
+	ps := uintptr(e.r.pageSize)
+	for i := 0; i < len(pages); {
+		j := i + 1
+		for j < len(pages) && pages[j] == pages[j-1]+ps {
+			j++
+		}
+		off := pages[i] - e.r.Base()
+		mem := e.r.mem[off : off+uintptr(j-i)*ps]
+		if e.r.store != nil {
+			if err := e.store(mem, int64(off)); err != nil {
+				return runs, err
+			}
+			if e.r.fillsTracked() {
+				e.r.evicting(pages[i], len(mem))
+			}
+		}
+		if err := unix.Madvise(mem, unix.MADV_DONTNEED); err != nil {
+			e.r.evicted(uint64(pages[i]), uint64(pages[i])+uint64(len(mem)))
+			return runs, err
+		}
+		e.r.unfill(pages[i], (j-i)*int(ps))
+		runs = append(runs, evictedRun{pages[i], (j - i) * int(ps)})
+		i = j
+	}
+	return runs, nil
+}
+
+// evictedRun is a run of pages dropped by dontneed.
+type evictedRun struct {
+	start  uintptr
+	length int
+}
+
+// store keeps the resident pages of mem, at off in the region, in its
+// EvictionStore. Pages no longer resident are skipped, as reading them
+// would fault, possibly on the goroutine serving the region.
+func (e *Evictor) store(mem []byte, off int64) error {
+	ps := e.r.pageSize
+	vec := make([]byte, len(mem)/ps)
+	if err := mincore(mem, vec); err != nil {
+		return err
+	}
+	for i, v := range vec {
+		if v&1 != 0 {
+			// Pages not kept are dropped.
+			e.r.store.Put(off+int64(i*ps), mem[i*ps:(i+1)*ps])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestEvictorEvictAndRefault(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 4, WithProvider(patternProvider(4)))
+	e := NewEvictor(r, nil)
+
+	for i := 0; i < 4; i++ {
+		if mem[i*pageSize] != byte(i+1) {
+			t.Fatalf("page %d not filled from provider", i)
+		}
+	}
+	if got := e.Resident(); got != 4 {
+		t.Fatalf("Resident() = %d, want 4", got)
+	}
+
+	// Modify page 0 so we can tell whether it was evicted.
+	mem[0] = 0xFF
+
+	n, err := e.Evict(2)
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Evict evicted %d pages, want 2", n)
+	}
+	if got := e.Resident(); got != 2 {
+		t.Fatalf("Resident() after Evict = %d, want 2", got)
+	}
+	if got := r.Stats().Evictions; got != 2 {
+		t.Errorf("Stats().Evictions = %d, want 2", got)
+	}
+
+	// Page 0 was the least recently filled, so it must come back from the provider.
+	if mem[0] != 1 {
+		t.Fatalf("evicted page not refaulted from provider: got %#x", mem[0])
+	}
+	if got := e.Resident(); got != 3 {
+		t.Fatalf("Resident() after refault = %d, want 3", got)
+	}
+}
+
+func TestEvictorLRUOrder(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 3, WithProvider(patternProvider(3)))
+	e := NewEvictor(r, nil)
+
+	for i := 0; i < 3; i++ {
+		mem[i*pageSize] = 0xFF
+	}
+	// Make page 0 the most recently used.
+	e.Touch(r.Base())
+
+	if _, err := e.Evict(1); err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if mem[0] != 0xFF || mem[2*pageSize] != 0xFF {
+		t.Fatalf("wrong page evicted")
+	}
+	if mem[pageSize] != 2 {
+		t.Fatalf("page 1 not evicted: got %#x", mem[pageSize])
+	}
+}
+
+func TestEvictorForget(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 2)
+	e := NewEvictor(r, nil)
+
+	mem[0] = 1
+	mem[pageSize] = 1
+
+	e.Forget(r.Base(), pageSize)
+	if got := e.Resident(); got != 1 {
+		t.Fatalf("Resident() after Forget = %d, want 1", got)
+	}
+
+	// An unaligned range covers the pages it overlaps.
+	e.Forget(r.Base()+uintptr(pageSize)-1, 2)
+	if got := e.Resident(); got != 0 {
+		t.Fatalf("Resident() after Forget of an unaligned range = %d, want 0", got)
+	}
+}
+
+func TestEvictorPin(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 2)
+	e := NewEvictor(r, nil)
+
+	mem[0] = 1
+	mem[pageSize] = 1
+
+	// An unaligned range pins and unpins the pages it overlaps.
+	start := r.Base() + uintptr(pageSize) - 1
+	e.pin(start, 2)
+	if n, err := e.Evict(2); err != nil || n != 0 {
+		t.Fatalf("Evict of pinned pages = %d, %v", n, err)
+	}
+	if err := e.unpin(start, 2); err != nil {
+		t.Fatalf("unpin failed: %v", err)
+	}
+	if n, err := e.Evict(2); err != nil || n != 2 {
+		t.Fatalf("Evict after unpin = %d, %v, want 2", n, err)
+	}
+}
+
+func TestEvictorAdmission(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 4, WithProvider(patternProvider(4)),
+		WithMaxResidentBytes(int64(2*pageSize)), WithEvictionPolicy(NewTinyLFUPolicy(16)))
+
+	if mem[0] != 1 {
+		t.Fatalf("page 0: got %#x, want 1", mem[0])
+	}
+	// A scan of the other pages does not push out page 0.
+	for i := 1; i < 4; i++ {
+		if got := mem[i*pageSize]; got != byte(i+1) {
+			t.Fatalf("page %d: got %#x, want %#x", i, got, i+1)
+		}
+	}
+	resident, err := r.Residency()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resident.Test(0) || resident.Count() != 2 {
+		t.Errorf("resident pages = %d, page 0 resident: %v", resident.Count(), resident.Test(0))
+	}
+}
+
+func TestEvictorStore(t *testing.T) {
+	spill, err := NewSpillStore(t.TempDir(), 0, make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spill.Close()
+	for _, store := range []interface {
+		EvictionStore
+		Len() int
+	}{NewCompressedStore(0), spill} {
+		testEvictorStore(t, store)
+	}
+}
+
+func testEvictorStore(t *testing.T, store interface {
+	EvictionStore
+	Len() int
+}) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 3, WithProvider(patternProvider(3)), WithEvictionStore(store))
+	e := NewEvictor(r, nil)
+
+	for i := 0; i < 3; i++ {
+		mem[i*pageSize+1] = 0xFF
+	}
+	if n, err := e.Evict(3); n != 3 || err != nil {
+		t.Fatalf("Evict = %d, %v", n, err)
+	}
+	if store.Len() != 3 {
+		t.Fatalf("store has %d pages, want 3", store.Len())
+	}
+	// Modified pages come back from the store, not the provider.
+	for i := 0; i < 3; i++ {
+		if mem[i*pageSize] != byte(i+1) || mem[i*pageSize+1] != 0xFF {
+			t.Fatalf("page %d not restored: got %#x %#x", i, mem[i*pageSize], mem[i*pageSize+1])
+		}
+	}
+	waitFor(t, func() bool { return r.Stats().Restored == 3 })
+	if store.Len() != 0 {
+		t.Errorf("store keeps %d restored pages", store.Len())
+	}
+}
@@ -0,0 +1,160 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"maps"
+	"sync"
+)
+
+// EvictionStore keeps the contents of the pages evicted from a region, by
+// offset in the region, so that their faults are resolved from it instead
+// of the provider. Implementations must be safe for concurrent use.
+type EvictionStore interface {
+	// Put keeps a copy of the page at off, replacing any previous one.
+	Put(off int64, page []byte) error
+	// Take copies the page kept at off into page and forgets it. It
+	// reports false if the page is not kept.
+	Take(off int64, page []byte) (bool, error)
+	// Drop forgets the pages kept in a range, as their contents are gone.
+	Drop(off int64, length int)
+}
+
+// Kinds of the pages of a CompressedStore.
+const (
+	storedZero = iota
+	storedRaw
+	storedDeflate
+)
+
+type storedPage struct {
+	kind uint8
+	data []byte
+}
+
+// CompressedStore is an EvictionStore keeping pages compressed in memory,
+// as zram does, which makes refaults cheap when the provider is slow, or
+// the region has no provider and evicted pages would otherwise be lost.
+// Zero pages take no space and pages that do not compress are kept as is.
+type CompressedStore struct {
+	mu       sync.Mutex
+	pages    map[int64]storedPage
+	bytes    int64
+	maxBytes int64
+
+	writers sync.Pool // of *flate.Writer
+	readers sync.Pool // of io.ReadCloser, flate.Resetter
+}
+
+// NewCompressedStore returns a CompressedStore holding up to maxBytes of
+// compressed pages, with no limit if 0. Pages that do not fit are refused
+// with ErrStoreFull.
+func NewCompressedStore(maxBytes int64) *CompressedStore {
+	return &CompressedStore{pages: make(map[int64]storedPage), maxBytes: maxBytes}
+}
+
+// Put implements EvictionStore.
+func (s *CompressedStore) Put(off int64, page []byte) error {
+	p, err := s.compress(page)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.pages[off]
+	size := s.bytes - int64(len(old.data)) + int64(len(p.data))
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return ErrStoreFull
+	}
+	s.pages[off] = p
+	s.bytes = size
+	return nil
+}
+
+func (s *CompressedStore) compress(page []byte) (storedPage, error) {
+	if zero(page) {
+		return storedPage{kind: storedZero}, nil
+	}
+	var buf bytes.Buffer
+	w, _ := s.writers.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(&buf, flate.BestSpeed)
+	} else {
+		w.Reset(&buf)
+	}
+	defer s.writers.Put(w)
+	if _, err := w.Write(page); err != nil {
+		return storedPage{}, err
+	}
+	if err := w.Close(); err != nil {
+		return storedPage{}, err
+	}
+	if buf.Len() >= len(page) {
+		return storedPage{kind: storedRaw, data: bytes.Clone(page)}, nil
+	}
+	return storedPage{kind: storedDeflate, data: bytes.Clone(buf.Bytes())}, nil
+}
+
+// Take implements EvictionStore.
+func (s *CompressedStore) Take(off int64, page []byte) (bool, error) {
+	s.mu.Lock()
+	p, ok := s.pages[off]
+	if ok {
+		delete(s.pages, off)
+		s.bytes -= int64(len(p.data))
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	switch p.kind {
+	case storedZero:
+		clear(page)
+	case storedRaw:
+		copy(page, p.data)
+	case storedDeflate:
+		r, _ := s.readers.Get().(io.ReadCloser)
+		if r == nil {
+			r = flate.NewReader(bytes.NewReader(p.data))
+		} else {
+			r.(flate.Resetter).Reset(bytes.NewReader(p.data), nil)
+		}
+		defer s.readers.Put(r)
+		if _, err := io.ReadFull(r, page); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Drop implements EvictionStore.
+func (s *CompressedStore) Drop(off int64, length int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maps.DeleteFunc(s.pages, func(o int64, p storedPage) bool {
+		if o >= off && o < off+int64(length) {
+			s.bytes -= int64(len(p.data))
+			return true
+		}
+		return false
+	})
+}
+
+// Len returns the number of pages kept.
+func (s *CompressedStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pages)
+}
+
+// Bytes returns the memory taken by the pages kept, once compressed.
+func (s *CompressedStore) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
@@ -0,0 +1,62 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestCompressedStore(t *testing.T) {
+	const pageSize = 4096
+	text := bytes.Repeat([]byte("compressible "), pageSize/13+1)[:pageSize]
+	random := make([]byte, pageSize)
+	for i := range random {
+		random[i] = byte(rand.Uint32())
+	}
+	pages := [][]byte{text, make([]byte, pageSize), random}
+
+	s := NewCompressedStore(0)
+	for i, p := range pages {
+		if err := s.Put(int64(i*pageSize), p); err != nil {
+			t.Fatalf("Put(%d) failed: %v", i, err)
+		}
+	}
+	if s.Len() != 3 {
+		t.Errorf("Len = %d, want 3", s.Len())
+	}
+	// Zero pages take nothing and random pages are kept as is.
+	if b := s.Bytes(); b <= pageSize || b >= 2*pageSize {
+		t.Errorf("Bytes = %d", b)
+	}
+	got := make([]byte, pageSize)
+	for i, p := range pages {
+		got[0] = 0xff
+		if ok, err := s.Take(int64(i*pageSize), got); !ok || err != nil {
+			t.Fatalf("Take(%d) = %v, %v", i, ok, err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Errorf("page %d differs", i)
+		}
+	}
+	if ok, _ := s.Take(0, got); ok || s.Len() != 0 || s.Bytes() != 0 {
+		t.Errorf("page taken twice, Len = %d, Bytes = %d", s.Len(), s.Bytes())
+	}
+
+	s = NewCompressedStore(pageSize + pageSize/2)
+	s.Put(0, random)
+	if err := s.Put(pageSize, random); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Put past the limit = %v", err)
+	}
+	// Replacing a page frees its space first.
+	if err := s.Put(0, random); err != nil {
+		t.Errorf("Put replacing a page failed: %v", err)
+	}
+	s.Put(pageSize, text)
+	s.Drop(0, pageSize)
+	if ok, _ := s.Take(0, got); ok || s.Len() != 1 {
+		t.Errorf("dropped page kept, Len = %d", s.Len())
+	}
+}
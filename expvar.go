@@ -0,0 +1,89 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"expvar"
+)
+
+// ExpvarPublisher publishes the counters of userfaultfds, servers and
+// regions as an expvar.Map, served as JSON by the expvar handler at
+// /debug/vars.
+type ExpvarPublisher struct {
+	m *expvar.Map
+}
+
+// NewExpvarPublisher publishes an empty map under prefix.
+// Like expvar.Publish, it panics if prefix is already in use.
+func NewExpvarPublisher(prefix string) *ExpvarPublisher {
+	return &ExpvarPublisher{m: expvar.NewMap(prefix)}
+}
+
+// AddUffd publishes the event counters of u under name.
+func (p *ExpvarPublisher) AddUffd(name string, u *Uffd) {
+	p.m.Set(name, expvar.Func(func() any {
+		st := u.EventStats()
+		return map[string]uint64{
+			"pagefault": st.Pagefault,
+			"fork":      st.Fork,
+			"remap":     st.Remap,
+			"remove":    st.Remove,
+			"unmap":     st.Unmap,
+		}
+	}))
+}
+
+// AddRegion publishes the fault counters, latency percentiles and resident
+// size of r under name.
+func (p *ExpvarPublisher) AddRegion(name string, r *Region) {
+	p.m.Set(name, expvar.Func(func() any {
+		st := r.Stats()
+		resident, _, _ := r.ResidentBytes()
+		return map[string]any{
+			"missing":         st.Missing,
+			"wp":              st.WP,
+			"minor":           st.Minor,
+			"errors":          st.Errors,
+			"bytes_filled":    st.BytesFilled,
+			"bytes_moved":     st.BytesMoved,
+			"zeropages":       st.Zeropages,
+			"evictions":       st.Evictions,
+			"restored":        st.Restored,
+			"provider_errors": st.ProviderErrors,
+			"latency_mean_ns": st.Latency.Mean().Nanoseconds(),
+			"latency_p50_ns":  st.Latency.Quantile(0.50).Nanoseconds(),
+			"latency_p99_ns":  st.Latency.Quantile(0.99).Nanoseconds(),
+			"latency_max_ns":  st.Latency.Max().Nanoseconds(),
+			"size_bytes":      int64(r.Len()),
+			"resident_bytes":  resident,
+		}
+	}))
+}
+
+// AddServer publishes the number of faults deduplicated by s, and whether
+// it is paused, under name.
+func (p *ExpvarPublisher) AddServer(name string, s *Server) {
+	p.m.Set(name, expvar.Func(func() any {
+		return map[string]any{
+			"deduplicated": s.Deduplicated(),
+			"paused":       s.Paused(),
+		}
+	}))
+}
+
+// AddHeatmap publishes the heatmap of r, created with WithHeatmap, under
+// name, aggregated into at most buckets buckets.
+func (p *ExpvarPublisher) AddHeatmap(name string, r *Region, buckets int) {
+	p.m.Set(name, expvar.Func(func() any {
+		h := r.Heatmap(buckets)
+		return map[string]any{
+			"bucket_size": h.BucketSize,
+			"faults":      h.Faults,
+		}
+	}))
+}
+
+// Remove stops publishing the counters under name.
+func (p *ExpvarPublisher) Remove(name string) {
+	p.m.Delete(name)
+}
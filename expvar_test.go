@@ -0,0 +1,87 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestExpvarPublisher(t *testing.T) {
+	mem, r := serveRegion(t, 1)
+	mem[0] = 1
+	waitFor(t, func() bool { return r.Stats().Missing == 1 })
+
+	p := NewExpvarPublisher("uffd_test")
+	p.AddUffd("uffd", r.uffd)
+	p.AddRegion("region", r)
+
+	var vars struct {
+		Uffd   map[string]uint64 `json:"uffd"`
+		Region map[string]int64  `json:"region"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("uffd_test").String()), &vars); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if vars.Uffd["pagefault"] != 1 {
+		t.Errorf("pagefault = %d, want 1", vars.Uffd["pagefault"])
+	}
+	if vars.Region["missing"] != 1 || vars.Region["latency_max_ns"] <= 0 {
+		t.Errorf("unexpected region counters: %v", vars.Region)
+	}
+	if vars.Region["resident_bytes"] != int64(len(mem)) || vars.Region["size_bytes"] != int64(len(mem)) {
+		t.Errorf("unexpected region sizes: %v", vars.Region)
+	}
+
+	p.Remove("region")
+	if p.m.Get("region") != nil {
+		t.Errorf("region still published after Remove")
+	}
+}
+
+func TestExpvarPublisherHeatmap(t *testing.T) {
+	mem, r := serveRegion(t, 2, WithHeatmap())
+	mem[len(mem)-1] = 1
+	waitFor(t, func() bool { return r.Stats().Missing == 1 })
+
+	p := NewExpvarPublisher("uffd_test_heatmap")
+	p.AddHeatmap("heatmap", r, 2)
+
+	var vars struct {
+		Heatmap struct {
+			BucketSize int      `json:"bucket_size"`
+			Faults     []uint64 `json:"faults"`
+		} `json:"heatmap"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("uffd_test_heatmap").String()), &vars); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if vars.Heatmap.BucketSize != len(mem)/2 || len(vars.Heatmap.Faults) != 2 || vars.Heatmap.Faults[1] != 1 {
+		t.Errorf("unexpected heatmap: %+v", vars.Heatmap)
+	}
+}
+
+func TestExpvarPublisherServer(t *testing.T) {
+	_, r := serveRegion(t, 1)
+	s := NewServer(r.uffd, r)
+	s.Pause()
+	defer s.Resume()
+
+	p := NewExpvarPublisher("uffd_test_server")
+	p.AddServer("server", s)
+	var vars struct {
+		Server struct {
+			Deduplicated uint64 `json:"deduplicated"`
+			Paused       bool   `json:"paused"`
+		} `json:"server"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("uffd_test_server").String()), &vars); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if vars.Server.Deduplicated != 0 || !vars.Server.Paused {
+		t.Errorf("unexpected server vars: %+v", vars.Server)
+	}
+}
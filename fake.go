@@ -0,0 +1,371 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"cmp"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FakeUffd simulates a userfaultfd in user space, for testing code written
+// against the Userfaultfd interface on systems without one. Faults are
+// injected with InjectFault instead of being raised by memory accesses, and
+// resolving ioctls only track which pages were populated: no memory is
+// read or written.
+//
+// Handlers, Region, ServeMux and Server take a *Uffd and cannot be driven
+// by a FakeUffd. Handler logic to be tested with one must be written
+// against Userfaultfd, or the narrower interfaces it is made of.
+type FakeUffd struct {
+	pageSize uintptr
+
+	mu        sync.Mutex
+	cond      *sync.Cond // signalled when events are queued or on Close
+	closed    bool
+	ranges    []fakeRange // sorted by start, not overlapping
+	populated map[uintptr]bool
+	queue     []UffdMsg
+	waiting   map[uintptr][]chan struct{} // faulting "threads" by page
+	resolved  []FakeResolution
+}
+
+type fakeRange struct {
+	start, end uintptr
+	mode       int
+}
+
+// FakeResolution records an ioctl applied by a FakeUffd.
+type FakeResolution struct {
+	Ioctl  string // such as "UFFDIO_COPY"
+	Start  uintptr
+	Length int
+	Mode   int
+}
+
+var _ Userfaultfd = (*FakeUffd)(nil)
+
+// NewFakeUffd returns a FakeUffd with no registered ranges.
+func NewFakeUffd() *FakeUffd {
+	f := &FakeUffd{
+		pageSize:  uintptr(os.Getpagesize()),
+		populated: make(map[uintptr]bool),
+		waiting:   make(map[uintptr][]chan struct{}),
+	}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// fakeError returns the error of a syscall failing with err, matching the
+// sentinel error of its failure mode as the errors of Uffd do.
+func fakeError(name string, err syscall.Errno) error {
+	serr := os.NewSyscallError(name, err)
+	switch err {
+	case syscall.EEXIST:
+		return &ioctlError{err: serr, sentinel: ErrAlreadyMapped}
+	case syscall.ENOENT:
+		return &ioctlError{err: serr, sentinel: ErrNotRegistered}
+	}
+	return serr
+}
+
+// checkRange validates a range as the kernel does, and must be called with
+// f.mu held.
+func (f *FakeUffd) checkRange(start uintptr, length int) error {
+	if f.closed {
+		return ErrClosed
+	}
+	if start%f.pageSize != 0 || uintptr(length)%f.pageSize != 0 {
+		return &ioctlError{err: fakeError("ioctl", syscall.EINVAL), sentinel: ErrBadAlignment}
+	}
+	if length <= 0 || start+uintptr(length) < start {
+		return fakeError("ioctl", syscall.EINVAL)
+	}
+	return nil
+}
+
+// lookup returns the index of the range containing addr, or -1. It must be
+// called with f.mu held.
+func (f *FakeUffd) lookup(addr uintptr) int {
+	i, ok := slices.BinarySearchFunc(f.ranges, addr, func(r fakeRange, addr uintptr) int {
+		return cmp.Compare(r.start, addr)
+	})
+	if !ok {
+		i--
+	}
+	if i >= 0 && addr < f.ranges[i].end {
+		return i
+	}
+	return -1
+}
+
+// covered returns true if the range lies within registered ranges. It must
+// be called with f.mu held.
+func (f *FakeUffd) covered(start uintptr, length int) bool {
+	for addr := start; addr < start+uintptr(length); addr += f.pageSize {
+		if f.lookup(addr) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Register registers a range, which must not overlap a registered one.
+func (f *FakeUffd) Register(start uintptr, length int, mode int) (*UffdioRegister, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.checkRange(start, length); err != nil {
+		return nil, err
+	}
+	if mode == 0 || mode&^(UFFDIO_REGISTER_MODE_MISSING|UFFDIO_REGISTER_MODE_WP|UFFDIO_REGISTER_MODE_MINOR) != 0 {
+		return nil, fakeError("ioctl", syscall.EINVAL)
+	}
+	r := fakeRange{start: start, end: start + uintptr(length), mode: mode}
+	i, _ := slices.BinarySearchFunc(f.ranges, start, func(r fakeRange, start uintptr) int {
+		return cmp.Compare(r.start, start)
+	})
+	if i > 0 && f.ranges[i-1].end > r.start || i < len(f.ranges) && f.ranges[i].start < r.end {
+		return nil, fakeError("ioctl", syscall.EBUSY)
+	}
+	f.ranges = slices.Insert(f.ranges, i, r)
+
+	ioctls := uint64(1<<_UFFDIO_WAKE | 1<<_UFFDIO_COPY | 1<<_UFFDIO_ZEROPAGE)
+	if mode&UFFDIO_REGISTER_MODE_MINOR != 0 {
+		ioctls |= 1 << _UFFDIO_CONTINUE
+	}
+	if mode&UFFDIO_REGISTER_MODE_WP != 0 {
+		ioctls |= 1 << _UFFDIO_WRITEPROTECT
+	}
+	return &UffdioRegister{Range: UffdioRange{Start: uint64(start), Len: uint64(length)}, Mode: uint64(mode), Ioctls: ioctls}, nil
+}
+
+// Unregister removes the registered ranges within the given range, which
+// must contain them entirely, and wakes up their faulting threads.
+func (f *FakeUffd) Unregister(start uintptr, length int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.checkRange(start, length); err != nil {
+		return err
+	}
+	end := start + uintptr(length)
+	f.ranges = slices.DeleteFunc(f.ranges, func(r fakeRange) bool {
+		return r.start >= start && r.end <= end
+	})
+	f.wake(start, length)
+	return nil
+}
+
+// InjectFault queues a page fault at addr, as if a thread accessed it, and
+// returns a channel closed once the thread is woken up. The fault is a
+// minor fault if the range is only registered for minor faults, and a
+// missing fault otherwise. Faults outside registered ranges, which the
+// kernel would not report, fail with EFAULT.
+func (f *FakeUffd) InjectFault(addr uintptr) (<-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ErrClosed
+	}
+	i := f.lookup(addr)
+	if i < 0 {
+		return nil, fakeError("fault", syscall.EFAULT)
+	}
+	msg := UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	pf := msg.GetPagefault()
+	pf.Address = uint64(addr)
+	if f.ranges[i].mode&UFFDIO_REGISTER_MODE_MISSING == 0 {
+		pf.Flags = UFFD_PAGEFAULT_FLAG_MINOR
+	}
+	page := addr &^ (f.pageSize - 1)
+	done := make(chan struct{})
+	f.waiting[page] = append(f.waiting[page], done)
+	f.queue = append(f.queue, msg)
+	f.cond.Broadcast()
+	return done, nil
+}
+
+// InjectEvent queues any event.
+func (f *FakeUffd) InjectEvent(msg UffdMsg) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	f.queue = append(f.queue, msg)
+	f.cond.Broadcast()
+	return nil
+}
+
+// ReadMsgsTimeout reads queued events into msgs, waiting up to timeout
+// milliseconds for one, or forever if negative. It returns EAGAIN on
+// timeout and ErrClosed once closed.
+func (f *FakeUffd) ReadMsgsTimeout(msgs []UffdMsg, timeout int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if timeout > 0 {
+		timer := time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+			f.mu.Lock()
+			f.cond.Broadcast()
+			f.mu.Unlock()
+		})
+		defer timer.Stop()
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	for len(f.queue) == 0 && !f.closed {
+		if timeout == 0 || timeout > 0 && !time.Now().Before(deadline) {
+			return 0, fakeError("read", syscall.EAGAIN)
+		}
+		f.cond.Wait()
+	}
+	if f.closed {
+		return 0, ErrClosed
+	}
+	n := copy(msgs, f.queue)
+	f.queue = f.queue[n:]
+	return n, nil
+}
+
+// resolve marks the pages of a range populated and wakes up their threads
+// unless dontwake is set in mode. It must be called with f.mu held.
+func (f *FakeUffd) resolve(ioctl string, start uintptr, length int, mode, dontwake int, populate bool) (int64, error) {
+	if err := f.checkRange(start, length); err != nil {
+		return 0, err
+	}
+	if !f.covered(start, length) {
+		return 0, fakeError("ioctl", syscall.ENOENT)
+	}
+	if populate {
+		for addr := start; addr < start+uintptr(length); addr += f.pageSize {
+			if f.populated[addr] {
+				return int64(addr - start), fakeError("ioctl", syscall.EEXIST)
+			}
+		}
+		for addr := start; addr < start+uintptr(length); addr += f.pageSize {
+			f.populated[addr] = true
+		}
+	}
+	f.resolved = append(f.resolved, FakeResolution{Ioctl: ioctl, Start: start, Length: length, Mode: mode})
+	if mode&dontwake == 0 {
+		f.wake(start, length)
+	}
+	return int64(length), nil
+}
+
+// wake wakes up the threads faulting in a range. It must be called with
+// f.mu held.
+func (f *FakeUffd) wake(start uintptr, length int) {
+	for addr := start; addr < start+uintptr(length); addr += f.pageSize {
+		for _, done := range f.waiting[addr] {
+			close(done)
+		}
+		delete(f.waiting, addr)
+	}
+}
+
+// Copy marks the pages populated, failing with EEXIST if one already is.
+func (f *FakeUffd) Copy(dst, src uintptr, length int, mode int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.resolve("UFFDIO_COPY", dst, length, mode, UFFDIO_COPY_MODE_DONTWAKE, true)
+}
+
+// Zeropage marks the pages populated, failing with EEXIST if one already is.
+func (f *FakeUffd) Zeropage(start uintptr, length int, mode int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.resolve("UFFDIO_ZEROPAGE", start, length, mode, UFFDIO_ZEROPAGE_MODE_DONTWAKE, true)
+}
+
+// Continue wakes up the threads faulting in the range.
+func (f *FakeUffd) Continue(start uintptr, length int, mode int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.resolve("UFFDIO_CONTINUE", start, length, mode, UFFDIO_CONTINUE_MODE_DONTWAKE, false)
+	return err
+}
+
+// Poison marks the pages populated, failing with EEXIST if one already is.
+func (f *FakeUffd) Poison(start uintptr, length int, mode int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.resolve("UFFDIO_POISON", start, length, mode, UFFDIO_POISON_MODE_DONTWAKE, true)
+}
+
+// Move marks the destination pages populated.
+func (f *FakeUffd) Move(dst, src uintptr, length int, mode int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.resolve("UFFDIO_MOVE", dst, length, mode, UFFDIO_MOVE_MODE_DONTWAKE, true)
+}
+
+// WriteProtect records the change of protection.
+func (f *FakeUffd) WriteProtect(start uintptr, length int, mode int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.resolve("UFFDIO_WRITEPROTECT", start, length, mode, UFFDIO_WRITEPROTECT_MODE_DONTWAKE, false)
+	return err
+}
+
+// Wake wakes up the threads faulting in the range.
+func (f *FakeUffd) Wake(start uintptr, length int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.checkRange(start, length); err != nil {
+		return err
+	}
+	f.wake(start, length)
+	return nil
+}
+
+// WakeRanges wakes up the threads faulting in several ranges.
+func (f *FakeUffd) WakeRanges(ranges []UffdioRange) error {
+	for _, r := range ranges {
+		if err := f.Wake(uintptr(r.Start), int(r.Len)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Populated returns true if the page containing addr was populated.
+func (f *FakeUffd) Populated(addr uintptr) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.populated[addr&^(f.pageSize-1)]
+}
+
+// Waiting returns the number of injected faults not woken up yet.
+func (f *FakeUffd) Waiting() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	for _, w := range f.waiting {
+		n += len(w)
+	}
+	return n
+}
+
+// Resolutions returns the ioctls applied so far, in order.
+func (f *FakeUffd) Resolutions() []FakeResolution {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.resolved)
+}
+
+// Close makes reads and ioctls fail with ErrClosed and wakes up all the
+// faulting threads, as the kernel does.
+func (f *FakeUffd) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		for page := range f.waiting {
+			f.wake(page, int(f.pageSize))
+		}
+		f.cond.Broadcast()
+	}
+	return nil
+}
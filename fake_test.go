@@ -0,0 +1,193 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestFakeUffd(t *testing.T) {
+	pageSize := uintptr(os.Getpagesize())
+	const base = 0x10000000
+	f := NewFakeUffd()
+
+	if _, err := f.Register(base+1, int(pageSize), UFFDIO_REGISTER_MODE_MISSING); !errors.Is(err, syscall.EINVAL) || !errors.Is(err, ErrBadAlignment) {
+		t.Errorf("unaligned Register = %v", err)
+	}
+	if _, err := f.Register(base, 4*int(pageSize), UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Register(base+pageSize, int(pageSize), UFFDIO_REGISTER_MODE_MISSING); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("overlapping Register = %v", err)
+	}
+	if _, err := f.InjectFault(base - 1); !errors.Is(err, syscall.EFAULT) {
+		t.Errorf("fault outside registered ranges = %v", err)
+	}
+
+	done, err := f.InjectFault(base + pageSize + 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]UffdMsg, 4)
+	n, err := f.ReadMsgsTimeout(msgs, -1)
+	if n != 1 || err != nil {
+		t.Fatalf("ReadMsgsTimeout = %d, %v", n, err)
+	}
+	if exact, page := msgs[0].GetPagefault().Addr(); exact != base+pageSize+5 || page != base+pageSize {
+		t.Errorf("fault at %#x in page %#x", exact, page)
+	}
+	if n, err := f.ReadMsgsTimeout(msgs, 1); n != 0 || !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("ReadMsgsTimeout without events = %d, %v", n, err)
+	}
+
+	// Resolving without waking up leaves the thread blocked.
+	if _, err := f.Copy(base+pageSize, 0, int(pageSize), UFFDIO_COPY_MODE_DONTWAKE); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+		t.Fatal("thread woken up with UFFDIO_COPY_MODE_DONTWAKE")
+	default:
+	}
+	if f.Waiting() != 1 || !f.Populated(base+pageSize+5) {
+		t.Errorf("Waiting() = %d, Populated() = %v", f.Waiting(), f.Populated(base+pageSize))
+	}
+	if err := f.Wake(base, 2*int(pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if _, err := f.Zeropage(base+pageSize, int(pageSize), 0); !errors.Is(err, syscall.EEXIST) || !errors.Is(err, ErrAlreadyMapped) {
+		t.Errorf("Zeropage on a populated page = %v", err)
+	}
+	if _, err := f.Copy(base+4*pageSize, 0, int(pageSize), 0); !errors.Is(err, syscall.ENOENT) || !errors.Is(err, ErrNotRegistered) {
+		t.Errorf("Copy outside registered ranges = %v", err)
+	}
+	if res := f.Resolutions(); len(res) != 1 || res[0].Ioctl != "UFFDIO_COPY" || res[0].Start != base+pageSize {
+		t.Errorf("Resolutions() = %+v", res)
+	}
+
+	// Close wakes up the threads and reads.
+	done, _ = f.InjectFault(base)
+	f.ReadMsgsTimeout(msgs, 0)
+	read := make(chan error)
+	go func() {
+		_, err := f.ReadMsgsTimeout(msgs, -1)
+		read <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	f.Close()
+	<-done
+	if err := <-read; err != ErrClosed {
+		t.Errorf("ReadMsgsTimeout after Close = %v", err)
+	}
+	if _, err := f.Register(base, int(pageSize), UFFDIO_REGISTER_MODE_MISSING); err != ErrClosed {
+		t.Errorf("Register after Close = %v", err)
+	}
+}
+
+func TestFakeUffdMinor(t *testing.T) {
+	pageSize := os.Getpagesize()
+	f := NewFakeUffd()
+	defer f.Close()
+	reg, err := f.Register(0x20000000, pageSize, UFFDIO_REGISTER_MODE_MINOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Require(_UFFDIO_CONTINUE); err != nil {
+		t.Error(err)
+	}
+	done, _ := f.InjectFault(0x20000000)
+	msgs := make([]UffdMsg, 1)
+	if _, err := f.ReadMsgsTimeout(msgs, 0); err != nil {
+		t.Fatal(err)
+	}
+	if msgs[0].GetPagefault().Flags&UFFD_PAGEFAULT_FLAG_MINOR == 0 {
+		t.Error("fault is not minor")
+	}
+	if err := f.Continue(0x20000000, pageSize, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+// fillFaults resolves the missing faults in the range at base with the
+// pages of p until u is closed, as a handler written against Userfaultfd
+// rather than *Uffd would.
+func fillFaults(u Userfaultfd, base uintptr, p PageProvider) error {
+	pageSize := os.Getpagesize()
+	msgs := make([]UffdMsg, 8)
+	buf := make([]byte, pageSize)
+	for {
+		n, err := u.ReadMsgsTimeout(msgs, -1)
+		if err == ErrClosed {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for i := range msgs[:n] {
+			if msgs[i].Event != UFFD_EVENT_PAGEFAULT {
+				continue
+			}
+			_, page := msgs[i].GetPagefault().Addr()
+			if _, err := p.ReadAt(buf, int64(page-base)); err != nil {
+				return err
+			}
+			_, err := u.Copy(page, uintptr(unsafe.Pointer(&buf[0])), pageSize, 0)
+			if errors.Is(err, ErrAlreadyMapped) {
+				err = u.Wake(page, pageSize)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func TestFakeUffdHandler(t *testing.T) {
+	pageSize := uintptr(os.Getpagesize())
+	const base = 0x30000000
+	f := NewFakeUffd()
+	if _, err := f.Register(base, 4*int(pageSize), UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error)
+	go func() {
+		served <- fillFaults(f, base, bytes.NewReader(make([]byte, 4*pageSize)))
+	}()
+
+	// Two threads fault on the same page: it is copied once and both are
+	// woken up.
+	var dones []<-chan struct{}
+	for _, addr := range []uintptr{base + pageSize, base + pageSize + 8, base + 3*pageSize} {
+		done, err := f.InjectFault(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dones = append(dones, done)
+	}
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("faulting thread not woken up")
+		}
+	}
+	if !f.Populated(base+pageSize) || f.Populated(base+2*pageSize) || !f.Populated(base+3*pageSize) {
+		t.Error("unexpected pages populated")
+	}
+	if res := f.Resolutions(); len(res) != 2 || res[0].Start != base+pageSize || res[1].Start != base+3*pageSize {
+		t.Errorf("Resolutions() = %+v", res)
+	}
+
+	f.Close()
+	if err := <-served; err != nil {
+		t.Errorf("handler failed: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import "runtime/debug"
+
+// CatchFault runs fn and reports the address of the memory fault it
+// caused, if any. It relies on debug.SetPanicOnFault, so only faults
+// raised by fn's own goroutine are caught.
+func CatchFault(fn func()) (addr uintptr, faulted bool) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(interface{ Addr() uintptr })
+			if !ok {
+				panic(r)
+			}
+			addr, faulted = err.Addr(), true
+		}
+	}()
+	fn()
+	return 0, false
+}
@@ -0,0 +1,82 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Feature bit set by the kernel on initialized userfaultfds, not part of
+// the API.
+const uffdFeatureInitialized = 1 << 31
+
+// FdInfo is the state of a userfaultfd as reported by
+// /proc/<pid>/fdinfo/<fd>.
+type FdInfo struct {
+	Flags    int    // File status flags, such as O_NONBLOCK
+	Pending  uint64 // Faults not read yet
+	Total    uint64 // Faults not resolved yet, read or not
+	Api      uint64 // API version, UFFD_API
+	Features uint64 // Enabled UFFD_FEATURE_* flags
+	Ioctls   uint64 // Supported ioctls as in UffdioApi.Ioctls, 0 if not reported
+}
+
+// ParseFdInfo parses the fdinfo of a userfaultfd.
+func ParseFdInfo(r io.Reader) (*FdInfo, error) {
+	var info FdInfo
+	var api bool
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "flags":
+			var v uint64
+			v, err = strconv.ParseUint(value, 8, 32)
+			info.Flags = int(v)
+		case "pending":
+			info.Pending, err = strconv.ParseUint(value, 10, 64)
+		case "total":
+			info.Total, err = strconv.ParseUint(value, 10, 64)
+		case "API":
+			api = true
+			err = parseFdInfoApi(&info, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fdinfo line %q: %w", s.Text(), err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if !api {
+		return nil, errors.New("fdinfo is not of a userfaultfd")
+	}
+	return &info, nil
+}
+
+// parseFdInfoApi parses api:features[:ioctls], in hexadecimal.
+func parseFdInfoApi(info *FdInfo, value string) error {
+	fields := strings.Split(value, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return errors.New("expected api:features[:ioctls]")
+	}
+	var v [3]uint64
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.ParseUint(f, 16, 64); err != nil {
+			return err
+		}
+	}
+	info.Api, info.Features, info.Ioctls = v[0], v[1]&^uffdFeatureInitialized, v[2]
+	return nil
+}
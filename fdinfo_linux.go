@@ -0,0 +1,66 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReadFdInfo reads the fdinfo of the userfaultfd fd of process pid, or of
+// this process if pid is 0.
+func ReadFdInfo(pid, fd int) (*FdInfo, error) {
+	proc := "self"
+	if pid != 0 {
+		proc = strconv.Itoa(pid)
+	}
+	f, err := os.Open("/proc/" + proc + "/fdinfo/" + strconv.Itoa(fd))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseFdInfo(f)
+}
+
+// FdInfo returns the state of the userfaultfd as reported by the kernel.
+func (u *Uffd) FdInfo() (*FdInfo, error) {
+	var info *FdInfo
+	err := u.control(func(fd uintptr) (err error) {
+		info, err = ReadFdInfo(0, int(fd))
+		return err
+	})
+	return info, err
+}
+
+// CheckFdInfo compares what the kernel reports of the userfaultfd with the
+// API version, features, ioctls and flags known to u, and returns an error
+// describing the differences if any.
+func (u *Uffd) CheckFdInfo() error {
+	info, err := u.FdInfo()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if info.Api != u.api.Api {
+		errs = append(errs, fmt.Errorf("API %#x, expected %#x", info.Api, u.api.Api))
+	}
+	if info.Features != u.enabled {
+		errs = append(errs, fmt.Errorf("features %s, expected %s", FeatureString(info.Features), FeatureString(u.enabled)))
+	}
+	if info.Ioctls != 0 && u.api.Ioctls&^info.Ioctls != 0 {
+		errs = append(errs, fmt.Errorf("ioctls %s, expected %s", IoctlsString(info.Ioctls), IoctlsString(u.api.Ioctls)))
+	}
+	if nonblock := u.expectNonblock(); info.Flags&unix.O_NONBLOCK != 0 != nonblock {
+		errs = append(errs, fmt.Errorf("O_NONBLOCK is %t, expected %t", info.Flags&unix.O_NONBLOCK != 0, nonblock))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("userfaultfd differs from the kernel: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFdInfo(t *testing.T) {
+	const fdinfo = "pos:\t0\nflags:\t02004002\nmnt_id:\t17\nino:\t153694\npending:\t1\ntotal:\t3\nAPI:\taa:80000100:80000000000001ff\n"
+	info, err := ParseFdInfo(strings.NewReader(fdinfo))
+	if err != nil {
+		t.Fatalf("ParseFdInfo failed: %v", err)
+	}
+	want := FdInfo{Flags: 02004002, Pending: 1, Total: 3, Api: 0xaa, Features: 0x100, Ioctls: 0x80000000000001ff}
+	if *info != want {
+		t.Errorf("ParseFdInfo = %+v, want %+v", *info, want)
+	}
+
+	// Older kernels do not report the ioctls.
+	info, err = ParseFdInfo(strings.NewReader("pending:\t0\ntotal:\t0\nAPI:\taa:0\n"))
+	if err != nil || info.Api != 0xaa || info.Ioctls != 0 {
+		t.Errorf("ParseFdInfo without ioctls = %+v, %v", info, err)
+	}
+
+	for _, bad := range []string{
+		"pos:\t0\nflags:\t02\n",
+		"API:\taa\n",
+		"API:\taa:zz:0\n",
+		"pending:\t-1\nAPI:\taa:0:0\n",
+	} {
+		if _, err := ParseFdInfo(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseFdInfo(%q) succeeded", bad)
+		}
+	}
+}
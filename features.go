@@ -0,0 +1,67 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FeatureSet is a set of UFFD_FEATURE_* bits.
+type FeatureSet uint64
+
+// featureAliases are short names accepted by ParseFeatureSet.
+var featureAliases = map[string]FeatureSet{
+	"wp": UFFD_FEATURE_PAGEFAULT_FLAG_WP,
+}
+
+// ParseFeatureSet parses a comma-separated list of feature names such as
+// "wp,minor_shmem,move". Names are case-insensitive, may carry the
+// UFFD_FEATURE_ prefix and are those printed by FeatureString.
+func ParseFeatureSet(s string) (FeatureSet, error) {
+	var set FeatureSet
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		name = strings.TrimPrefix(name, "UFFD_FEATURE_")
+		if name == "" {
+			continue
+		}
+		f, ok := featureAliases[strings.ToLower(name)]
+		if !ok {
+			for bit, n := range featureNames {
+				if n == name {
+					f, ok = 1<<bit, true
+					break
+				}
+			}
+		}
+		if !ok {
+			return 0, fmt.Errorf("unknown feature %q", name)
+		}
+		set |= f
+	}
+	return set, nil
+}
+
+// Has returns true if all features in f are in s.
+func (s FeatureSet) Has(f FeatureSet) bool {
+	return s&f == f
+}
+
+// Require returns an error wrapping ErrUnsupportedFeature that names the
+// features in f missing from s, or nil if there are none.
+func (s FeatureSet) Require(f FeatureSet) error {
+	if missing := f &^ s; missing != 0 {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFeature, missing)
+	}
+	return nil
+}
+
+// Union returns the features in either s or f.
+func (s FeatureSet) Union(f FeatureSet) FeatureSet {
+	return s | f
+}
+
+func (s FeatureSet) String() string {
+	return FeatureString(uint64(s))
+}
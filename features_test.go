@@ -0,0 +1,53 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFeatureSet(t *testing.T) {
+	cases := []struct {
+		in   string
+		want FeatureSet
+	}{
+		{"", 0},
+		{"wp", UFFD_FEATURE_PAGEFAULT_FLAG_WP},
+		{"wp,minor_shmem,move", UFFD_FEATURE_PAGEFAULT_FLAG_WP | UFFD_FEATURE_MINOR_SHMEM | UFFD_FEATURE_MOVE},
+		{" UFFD_FEATURE_SIGBUS , thread_id", UFFD_FEATURE_SIGBUS | UFFD_FEATURE_THREAD_ID},
+	}
+
+	for _, tc := range cases {
+		got, err := ParseFeatureSet(tc.in)
+		if err != nil {
+			t.Fatalf("ParseFeatureSet(%q) failed: %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Fatalf("ParseFeatureSet(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+
+	if _, err := ParseFeatureSet("wp,bogus"); err == nil {
+		t.Fatalf("ParseFeatureSet accepted an unknown feature")
+	}
+}
+
+func TestFeatureSetRequire(t *testing.T) {
+	have := FeatureSet(UFFD_FEATURE_PAGEFAULT_FLAG_WP).Union(UFFD_FEATURE_MOVE)
+
+	if !have.Has(UFFD_FEATURE_MOVE) || have.Has(UFFD_FEATURE_MOVE|UFFD_FEATURE_SIGBUS) {
+		t.Fatalf("Has returned unexpected results for %s", have)
+	}
+	if err := have.Require(UFFD_FEATURE_PAGEFAULT_FLAG_WP); err != nil {
+		t.Fatalf("Require failed: %v", err)
+	}
+
+	err := have.Require(UFFD_FEATURE_MOVE | UFFD_FEATURE_SIGBUS)
+	if !errors.Is(err, ErrUnsupportedFeature) {
+		t.Fatalf("Require returned %v, want ErrUnsupportedFeature", err)
+	}
+	if want := ErrUnsupportedFeature.Error() + ": SIGBUS"; err.Error() != want {
+		t.Fatalf("Require error = %q, want %q", err, want)
+	}
+}
@@ -2,4 +2,9 @@ module github.com/ricardobranco777/go-userfaultfd
 
 go 1.25.0
 
-require golang.org/x/sys v0.37.0
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/trace v1.46.0
+	golang.org/x/sys v0.37.0
+)
@@ -0,0 +1,132 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxGuardTries bounds the attempts to poison a page filled meanwhile.
+const maxGuardTries = 3
+
+// GuardRange poisons the pages of the region covering off and length, so
+// that any access to them raises SIGBUS, making cheap guard pages or red
+// zones that come and go without splitting the mapping as mprotect(2)
+// does. Their contents are released, and faults on them are resolved with
+// poison until UnguardRange. The region must be registered in missing mode
+// and served meanwhile if its userfaultfd has UFFD_FEATURE_EVENT_REMOVE.
+func (r *Region) GuardRange(off, length int) error {
+	mem, err := r.guardable(off, length)
+	if err != nil || len(mem) == 0 {
+		return err
+	}
+	return r.guardPages(uintptr(unsafe.Pointer(&mem[0])), len(mem))
+}
+
+// UnguardRange removes the poison of the pages of the region covering off
+// and length, filling them again from the provider, or with zeros. With
+// WithMaxResidentBytes, they are left to be filled when next accessed.
+func (r *Region) UnguardRange(off, length int) error {
+	mem, err := r.guardable(off, length)
+	if err != nil || len(mem) == 0 {
+		return err
+	}
+	start := uintptr(unsafe.Pointer(&mem[0]))
+	r.guards.clearRangeAtomic(r.pageIndex(start), r.pageIndex(start)+len(mem)/r.pageSize)
+	// Zapping the pages drops their poison markers.
+	if err := r.release(start, len(mem)); err != nil || r.maxResident > 0 {
+		return err
+	}
+	for page := start; page < start+uintptr(len(mem)); page += uintptr(r.pageSize) {
+		e := r.evictor.Load()
+		if e != nil {
+			e.Touch(page)
+		}
+		// A page faulted in meanwhile was accounted by its fault.
+		if err := r.fill(context.Background(), page, false); err != nil && !errors.Is(err, ErrAlreadyMapped) {
+			if e != nil {
+				e.Forget(page, r.pageSize)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Guarded reports whether the page at off in the region is poisoned by
+// GuardRange.
+func (r *Region) Guarded(off int) bool {
+	return off >= 0 && off < len(r.mem) && r.guards.testAtomic(off/r.pageSize)
+}
+
+// guardable returns the pages covering off and length, checking they can
+// be guarded.
+func (r *Region) guardable(off, length int) ([]byte, error) {
+	switch {
+	case r.mode&UFFDIO_REGISTER_MODE_MISSING == 0 || r.mode&UFFDIO_REGISTER_MODE_MINOR != 0:
+		return nil, errors.New("guard pages need a region registered in missing mode only")
+	case !HaveIoctlPoison:
+		return nil, fmt.Errorf("%w: UFFDIO_POISON", ErrMissingIoctl)
+	}
+	return r.pages(off, length)
+}
+
+// guardPages guards a range of whole pages of the region.
+func (r *Region) guardPages(start uintptr, length int) error {
+	for page := start; page < start+uintptr(length); page += uintptr(r.pageSize) {
+		r.guards.setAtomic(r.pageIndex(page))
+	}
+	if err := r.release(start, length); err != nil {
+		return err
+	}
+	// Only pages filled meanwhile need to be poisoned one by one.
+	if _, err := r.uffd.Poison(start, length, 0); err == nil {
+		for page := start; page < start+uintptr(length); page += uintptr(r.pageSize) {
+			r.onPoison(page)
+		}
+		return nil
+	}
+	for page := start; page < start+uintptr(length); page += uintptr(r.pageSize) {
+		if err := r.guard(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release zaps a range of pages, forgetting they were filled.
+func (r *Region) release(start uintptr, length int) error {
+	if err := r.madvise(int(start-r.Base()), length, unix.MADV_DONTNEED); err != nil {
+		return err
+	}
+	r.unfill(start, length)
+	if e := r.evictor.Load(); e != nil {
+		e.Forget(start, length)
+	}
+	return nil
+}
+
+// guard poisons a guarded page, zapping it again if it was filled by a
+// fault resolved before it was guarded.
+func (r *Region) guard(page uintptr) error {
+	for tries := 1; ; tries++ {
+		_, err := r.uffd.Poison(page, r.pageSize, 0)
+		switch {
+		case err == nil:
+			r.onPoison(page)
+			return nil
+		case !errors.Is(err, ErrAlreadyMapped) || tries == maxGuardTries:
+			return err
+		}
+		if err := r.release(page, r.pageSize); err != nil {
+			return err
+		}
+	}
+}
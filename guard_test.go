@@ -0,0 +1,62 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRegionGuardRange(t *testing.T) {
+	if !HaveIoctlPoison {
+		t.Skip("UFFDIO_POISON not available")
+	}
+	pageSize := unix.Getpagesize()
+	var poisoned []int
+	mem, r := serveRegion(t, 4, WithProvider(patternProvider(4)), WithPageHooks(PageHooks{
+		OnPoison: func(off int) { poisoned = append(poisoned, off) },
+	}))
+	if mem[pageSize] != 2 {
+		t.Fatalf("page 1 = %#x, want 2", mem[pageSize])
+	}
+
+	// Both the filled page 1 and the missing page 2 are guarded.
+	if err := r.GuardRange(pageSize+1, pageSize); err != nil {
+		t.Fatalf("GuardRange failed: %v", err)
+	}
+	if !r.Guarded(pageSize) || !r.Guarded(2*pageSize) || r.Guarded(0) || r.Guarded(3*pageSize) {
+		t.Error("wrong pages guarded")
+	}
+	for _, i := range []int{1, 2} {
+		addr, faulted := CatchFault(func() { mem[i*pageSize+8] = 0xEE })
+		if want := r.Base() + uintptr(i*pageSize+8); !faulted || addr != want {
+			t.Errorf("write to guard page %d: faulted %v at %#x, want %#x", i, faulted, addr, want)
+		}
+	}
+	if mem[0] != 1 || mem[3*pageSize] != 4 {
+		t.Errorf("unguarded pages = %#x %#x, want 1 4", mem[0], mem[3*pageSize])
+	}
+	if len(poisoned) != 2 || poisoned[0] != pageSize || poisoned[1] != 2*pageSize {
+		t.Errorf("OnPoison = %v", poisoned)
+	}
+
+	if err := r.UnguardRange(pageSize, 2*pageSize); err != nil {
+		t.Fatalf("UnguardRange failed: %v", err)
+	}
+	if r.Guarded(pageSize) || !r.Filled().Test(2) {
+		t.Error("pages not restored")
+	}
+	if mem[pageSize] != 2 || mem[2*pageSize] != 3 {
+		t.Errorf("restored pages = %#x %#x, want 2 3", mem[pageSize], mem[2*pageSize])
+	}
+}
+
+func TestRegionGuardRangeMode(t *testing.T) {
+	r := &Region{mem: make([]byte, unix.Getpagesize()), mode: UFFDIO_REGISTER_MODE_MINOR, pageSize: unix.Getpagesize()}
+	if err := r.GuardRange(0, 1); err == nil {
+		t.Error("expected error in minor mode")
+	}
+}
@@ -0,0 +1,398 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+// Command mkconst generates zconst.go and zconst_nocgo.go from
+// linux/userfaultfd.h so new features and ioctls are picked up with:
+//
+//	go run ./hack/mkconst -I /path/to/linux/usr/include
+//
+// where the include directory comes from "make headers_install" in a
+// kernel tree. go generate uses the copy pinned in testdata, which is to
+// be updated from there. Constants of the files being replaced that the
+// headers lack, as those of older kernels do, fail the generation unless
+// -drop is given. The Go types in types.go are maintained by hand, but the
+// sizes of the kernel structures are generated for the cgo-free build.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const header = "// Code generated by hack/mkconst from linux/userfaultfd.h; DO NOT EDIT.\n\n"
+
+// define is a #define of the header.
+type define struct {
+	name  string
+	value string
+}
+
+// ioctl is an ioctl number built with one of the _IO macros.
+type ioctl struct {
+	name string
+	dir  string // "", "R", "W" or "WR"
+	typ  string
+	nr   string
+	arg  string // struct name, empty for _IO
+}
+
+type parsed struct {
+	defines map[string]string
+	order   []define
+	sizes   map[string]int // struct sizes by name
+	ioctls  []ioctl
+}
+
+var (
+	commentRE = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+	defineRE  = regexp.MustCompile(`(?m)^\s*#define\s+(\w+)[ \t]+(.+)$`)
+	structRE  = regexp.MustCompile(`(?s)struct\s+(\w+)\s*\{(.*?)\n\}`)
+	fieldRE   = regexp.MustCompile(`^(__[us](?:8|16|32|64)|struct\s+\w+)\s+\w+;$`)
+	ioctlRE   = regexp.MustCompile(`^_IO(R|W|WR)?\((\w+),\s*(\w+)(?:,\s*struct\s+(\w+))?\)$`)
+	castRE    = regexp.MustCompile(`\(__[us]\d+\)`)
+	shiftRE   = regexp.MustCompile(`^1\s*<<\s*(\d+)$`)
+)
+
+// parse extracts the definitions and structure sizes from the header.
+func parse(src []byte) (*parsed, error) {
+	text := strings.ReplaceAll(string(src), "\\\n", " ")
+	text = commentRE.ReplaceAllString(text, "")
+
+	p := &parsed{
+		defines: make(map[string]string),
+		sizes:   make(map[string]int),
+	}
+	for _, m := range defineRE.FindAllStringSubmatch(text, -1) {
+		value := strings.Join(strings.Fields(m[2]), " ")
+		p.defines[m[1]] = value
+		p.order = append(p.order, define{m[1], value})
+		if im := ioctlRE.FindStringSubmatch(value); im != nil {
+			p.ioctls = append(p.ioctls, ioctl{name: m[1], dir: im[1], typ: im[2], nr: im[3], arg: im[4]})
+		}
+	}
+
+	for _, m := range structRE.FindAllStringSubmatch(text, -1) {
+		if strings.Contains(m[2], "union") {
+			continue
+		}
+		size := 0
+		for line := range strings.Lines(m[2]) {
+			line = strings.Join(strings.Fields(line), " ")
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			f := fieldRE.FindStringSubmatch(line)
+			if f == nil {
+				return nil, fmt.Errorf("struct %s: unsupported field %q", m[1], line)
+			}
+			n, err := p.sizeof(f[1])
+			if err != nil {
+				return nil, fmt.Errorf("struct %s: %w", m[1], err)
+			}
+			size += n
+		}
+		p.sizes[m[1]] = size
+	}
+	return p, nil
+}
+
+func (p *parsed) sizeof(typ string) (int, error) {
+	if name, ok := strings.CutPrefix(typ, "struct "); ok {
+		if n, ok := p.sizes[strings.TrimSpace(name)]; ok {
+			return n, nil
+		}
+		return 0, fmt.Errorf("unknown type %q", typ)
+	}
+	bits, _ := strconv.Atoi(typ[3:])
+	return bits / 8, nil
+}
+
+// value evaluates a simple integer define such as 0x12 or ((__u64)1<<2).
+func (p *parsed) value(name string) (uint64, error) {
+	v, ok := p.defines[name]
+	if !ok {
+		return 0, fmt.Errorf("%s not defined", name)
+	}
+	v = castRE.ReplaceAllString(v, "")
+	v = strings.NewReplacer("(", "", ")", "").Replace(v)
+	v = strings.TrimSpace(v)
+	if m := shiftRE.FindStringSubmatch(v); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return 1 << n, nil
+	}
+	if _, ok := p.defines[v]; ok {
+		return p.value(v)
+	}
+	n, err := strconv.ParseUint(v, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: cannot evaluate %q", name, p.defines[name])
+	}
+	return n, nil
+}
+
+// bits returns the defines with the given prefix, sorted by bit number.
+func (p *parsed) bits(prefix string) ([]define, error) {
+	var ds []define
+	for _, d := range p.order {
+		if !strings.HasPrefix(d.name, prefix) {
+			continue
+		}
+		v, err := p.value(d.name)
+		if err != nil {
+			return nil, err
+		}
+		if v == 0 || v&(v-1) != 0 {
+			return nil, fmt.Errorf("%s is not a single bit", d.name)
+		}
+		ds = append(ds, define{d.name, strconv.Itoa(bitNumber(v))})
+	}
+	slices.SortStableFunc(ds, func(a, b define) int {
+		x, _ := strconv.Atoi(a.value)
+		y, _ := strconv.Atoi(b.value)
+		return x - y
+	})
+	return ds, nil
+}
+
+func bitNumber(v uint64) int {
+	n := 0
+	for v > 1 {
+		v >>= 1
+		n++
+	}
+	return n
+}
+
+// camel converts uffdio_writeprotect to UffdioWriteprotect.
+func camel(s string) string {
+	var b strings.Builder
+	for part := range strings.SplitSeq(s, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// generate returns the contents of zconst.go and zconst_nocgo.go.
+func generate(p *parsed) (shared, nocgo []byte, err error) {
+	var b bytes.Buffer
+	b.WriteString(header + "package userfaultfd\n\n")
+
+	writeBits := func(comment, prefix, names string) error {
+		ds, err := p.bits(prefix)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "// %s\nconst (\n", comment)
+		for _, d := range ds {
+			fmt.Fprintf(&b, "\t%s = 1 << %s\n", d.name, d.value)
+		}
+		b.WriteString(")\n\n")
+		if names != "" {
+			fmt.Fprintf(&b, "var %s = []string{\n", names)
+			next := 0
+			for _, d := range ds {
+				n, _ := strconv.Atoi(d.value)
+				for ; next < n; next++ {
+					b.WriteString("\t\"\",\n")
+				}
+				fmt.Fprintf(&b, "\t%q,\n", strings.TrimPrefix(d.name, prefix))
+				next++
+			}
+			b.WriteString("}\n\n")
+		}
+		return nil
+	}
+
+	if err := writeBits("UFFDIO_API features", "UFFD_FEATURE_", "featureNames"); err != nil {
+		return nil, nil, err
+	}
+
+	b.WriteString("// userfaultfd events\nconst (\n")
+	for _, d := range p.order {
+		if strings.HasPrefix(d.name, "UFFD_EVENT_") {
+			v, err := p.value(d.name)
+			if err != nil {
+				return nil, nil, err
+			}
+			fmt.Fprintf(&b, "\t%s = %#x\n", d.name, v)
+		}
+	}
+	b.WriteString(")\n\n")
+
+	if err := writeBits("UFFD_EVENT_PAGEFAULT flags", "UFFD_PAGEFAULT_FLAG_", "pagefaultFlagNames"); err != nil {
+		return nil, nil, err
+	}
+
+	ioctls := slices.Clone(p.ioctls)
+	slices.SortFunc(ioctls, func(a, b ioctl) int { return strings.Compare(a.name, b.name) })
+	for _, io := range ioctls {
+		prefix := io.name + "_MODE_"
+		if !slices.ContainsFunc(p.order, func(d define) bool { return strings.HasPrefix(d.name, prefix) }) {
+			continue
+		}
+		names := ""
+		if io.name == "UFFDIO_REGISTER" {
+			names = "registerModeNames"
+		}
+		if err := writeBits(io.name+"(2) ioctl mode", prefix, names); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Ioctl numbers, by bit in the ioctls masks.
+	var nrs []define
+	for _, d := range p.order {
+		if strings.HasPrefix(d.name, "_UFFDIO_") {
+			v, err := p.value(d.name)
+			if err != nil {
+				return nil, nil, err
+			}
+			nrs = append(nrs, define{d.name, fmt.Sprintf("%#02x", v)})
+		}
+	}
+	b.WriteString("// Used to check available Ioctls\nconst (\n")
+	for _, d := range nrs {
+		fmt.Fprintf(&b, "\t%s = %s\n", d.name, d.value)
+	}
+	b.WriteString(")\n\nvar ioctlNames = map[int]string{\n")
+	for _, d := range nrs {
+		fmt.Fprintf(&b, "\t%s: %q,\n", d.value, strings.TrimPrefix(d.name, "_UFFDIO_"))
+	}
+	b.WriteString("}\n")
+
+	if shared, err = format.Source(b.Bytes()); err != nil {
+		return nil, nil, err
+	}
+
+	b.Reset()
+	b.WriteString("//go:build !linux || !cgo || userfaultfd_nocgo\n\n" + header + "package userfaultfd\n\n")
+
+	mode, err := p.value("UFFD_USER_MODE_ONLY")
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(&b, "const (\n\t// Create a userfaultfd that can handle page faults only in user mode.\n\tUFFD_USER_MODE_ONLY = %d\n)\n\n", mode)
+
+	b.WriteString("// Sizes of the kernel structures, the same on all architectures.\nconst (\n")
+	var structs []string
+	for _, io := range p.ioctls {
+		if io.arg != "" && !slices.Contains(structs, io.arg) {
+			structs = append(structs, io.arg)
+		}
+	}
+	for _, s := range structs {
+		size, ok := p.sizes[s]
+		if !ok {
+			return nil, nil, fmt.Errorf("struct %s not found", s)
+		}
+		fmt.Fprintf(&b, "\tsizeof%s = %d\n", camel(s), size)
+	}
+	b.WriteString(")\n\n")
+
+	api, err := p.value("UFFD_API")
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(&b, "const (\n\tUFFD_API = %#x\n", api)
+	for _, io := range p.ioctls {
+		typ, err := p.value(io.typ)
+		if err != nil {
+			return nil, nil, err
+		}
+		nr := io.nr
+		if _, ok := p.defines[nr]; !ok {
+			nr = strings.TrimPrefix(nr, "0x")
+			n, err := strconv.ParseUint(nr, 16, 8)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: invalid number %q", io.name, io.nr)
+			}
+			nr = fmt.Sprintf("%#02x", n)
+		}
+		var dir []string
+		switch io.dir {
+		case "":
+			dir = append(dir, "iocNone<<iocDirShift")
+		case "R":
+			dir = append(dir, "iocRead<<iocDirShift")
+		case "W":
+			dir = append(dir, "iocWrite<<iocDirShift")
+		case "WR":
+			dir = append(dir, "iocRead<<iocDirShift", "iocWrite<<iocDirShift")
+		}
+		if io.arg != "" {
+			dir = append(dir, "sizeof"+camel(io.arg)+"<<iocSizeShift")
+		}
+		fmt.Fprintf(&b, "\t%s = %s | %#x<<8 | %s\n", io.name, strings.Join(dir, " | "), typ, nr)
+	}
+	b.WriteString(")\n")
+
+	if nocgo, err = format.Source(b.Bytes()); err != nil {
+		return nil, nil, err
+	}
+	return shared, nocgo, nil
+}
+
+var constRE = regexp.MustCompile(`(?m)^\t(\w+)\s+=`)
+
+// dropped returns the constants of old that src no longer defines.
+func dropped(old, src []byte) []string {
+	defined := make(map[string]bool)
+	for _, m := range constRE.FindAllSubmatch(src, -1) {
+		defined[string(m[1])] = true
+	}
+	var missing []string
+	for _, m := range constRE.FindAllSubmatch(old, -1) {
+		if !defined[string(m[1])] {
+			missing = append(missing, string(m[1]))
+		}
+	}
+	return missing
+}
+
+func main() {
+	include := flag.String("I", "hack/mkconst/testdata", "kernel header include directory")
+	out := flag.String("o", ".", "output directory")
+	drop := flag.Bool("drop", false, "drop the constants missing from the headers")
+	flag.Parse()
+
+	src, err := os.ReadFile(filepath.Join(*include, "linux", "userfaultfd.h"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := parse(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared, nocgo, err := generate(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*drop {
+		for file, src := range map[string][]byte{"zconst.go": shared, "zconst_nocgo.go": nocgo} {
+			old, err := os.ReadFile(filepath.Join(*out, file))
+			if err != nil && !os.IsNotExist(err) {
+				log.Fatal(err)
+			}
+			if missing := dropped(old, src); len(missing) > 0 {
+				log.Fatalf("%s: %s not defined by the headers in %s, older than those of %s? Use -drop to drop them",
+					file, strings.Join(missing, ", "), *include, file)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(*out, "zconst.go"), shared, 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*out, "zconst_nocgo.go"), nocgo, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
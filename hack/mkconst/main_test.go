@@ -0,0 +1,51 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+)
+
+// TestGenerate checks that the committed files match the output for the
+// header pinned in testdata, which should track the latest kernel.
+func TestGenerate(t *testing.T) {
+	src, err := os.ReadFile("testdata/linux/userfaultfd.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := parse(src)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if got := p.sizes["uffdio_copy"]; got != 40 {
+		t.Fatalf("sizeof(struct uffdio_copy) = %d, want 40", got)
+	}
+
+	shared, nocgo, err := generate(p)
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	for file, got := range map[string][]byte{"../../zconst.go": shared, "../../zconst_nocgo.go": nocgo} {
+		want, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date", file)
+		}
+	}
+}
+
+func TestDropped(t *testing.T) {
+	old := []byte("const (\n\tUFFD_API = 0xaa\n\tUFFD_FEATURE_MOVE      = 1 << 16\n)\n")
+	src := []byte("const (\n\tUFFD_API = 0xaa\n)\n")
+	if got := dropped(old, src); !slices.Equal(got, []string{"UFFD_FEATURE_MOVE"}) {
+		t.Errorf("dropped = %q, want [UFFD_FEATURE_MOVE]", got)
+	}
+	if got := dropped(nil, src); got != nil {
+		t.Errorf("dropped from no file = %q", got)
+	}
+}
@@ -0,0 +1,62 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import "context"
+
+// Handler responds to userfaultfd events. The message may be reused once
+// HandleEvent returns.
+type Handler interface {
+	HandleEvent(u *Uffd, msg *UffdMsg) error
+}
+
+// BatchHandler is a Handler that defers work, such as waking up faulting
+// threads, until the end of each batch of events read together. Serve,
+// Server and EventLoop call EndBatch after handling a batch.
+type BatchHandler interface {
+	Handler
+	EndBatch(u *Uffd) error
+}
+
+// DeferringHandler is a Handler that may resolve page faults after
+// HandleFault returns, such as a ServeMux with quotas. Server calls
+// HandleFault for page faults and keeps dropping the faults on the same
+// page until the one deferred is done.
+type DeferringHandler interface {
+	Handler
+	// HandleFault handles a page fault like HandleEvent. If it returns
+	// true, the fault is resolved later and done is called with a copy of
+	// msg once it is.
+	HandleFault(u *Uffd, msg *UffdMsg, done func(*UffdMsg)) (deferred bool, err error)
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(u *Uffd, msg *UffdMsg) error
+
+// HandleEvent calls f(u, msg).
+func (f HandlerFunc) HandleEvent(u *Uffd, msg *UffdMsg) error {
+	return f(u, msg)
+}
+
+// PageProvider supplies the contents of missing pages. Offsets are relative
+// to the start of the region. Data past the end of the provider is zero-filled.
+type PageProvider interface {
+	ReadAt(p []byte, off int64) (n int, err error)
+}
+
+// MappedPageProvider is a PageProvider whose contents are mapped in
+// memory, such as a MappedSource. Missing pages are copied into the region
+// straight from the mapping, without being read into a buffer first.
+// MappedPage returns the n bytes at off, or nil if they are not all mapped,
+// in which case ReadAt is used.
+type MappedPageProvider interface {
+	PageProvider
+	MappedPage(off int64, n int) []byte
+}
+
+// ContextPageProvider is a PageProvider whose reads take the context of the
+// fault being resolved, so that they show up in the same trace.
+type ContextPageProvider interface {
+	PageProvider
+	ReadAtContext(ctx context.Context, p []byte, off int64) (n int, err error)
+}
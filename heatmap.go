@@ -0,0 +1,37 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"sync"
+	"time"
+)
+
+// Heatmap is a snapshot of the fault activity of a region, aggregated
+// into buckets of equal size.
+type Heatmap struct {
+	BucketSize int         // Bytes covered by each bucket
+	Faults     []uint64    // Number of faults per bucket
+	LastFault  []time.Time // Time of the most recent fault per bucket, zero if none
+}
+
+// heatmap records per-page fault counts and timestamps.
+type heatmap struct {
+	mu     sync.Mutex
+	faults []uint32
+	last   []int64 // UnixNano
+}
+
+func newHeatmap(pages int) *heatmap {
+	return &heatmap{
+		faults: make([]uint32, pages),
+		last:   make([]int64, pages),
+	}
+}
+
+func (h *heatmap) record(page int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.faults[page]++
+	h.last[page] = now.UnixNano()
+}
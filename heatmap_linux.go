@@ -0,0 +1,48 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import "time"
+
+// WithHeatmap enables recording of per-page fault counts and timestamps,
+// retrieved with Region.Heatmap.
+func WithHeatmap() RegionOption {
+	return func(r *Region) {
+		r.heatmap = newHeatmap((len(r.mem) + r.pageSize - 1) / r.pageSize)
+	}
+}
+
+// Heatmap returns the fault activity of the region aggregated into at most
+// buckets buckets, or one bucket per page if buckets <= 0. Returns an empty
+// Heatmap if the region was not created with WithHeatmap.
+func (r *Region) Heatmap(buckets int) Heatmap {
+	h := r.heatmap
+	if h == nil {
+		return Heatmap{}
+	}
+
+	pages := len(h.faults)
+	if buckets <= 0 || buckets > pages {
+		buckets = pages
+	}
+	perBucket := (pages + buckets - 1) / buckets
+	buckets = (pages + perBucket - 1) / perBucket
+
+	hm := Heatmap{
+		BucketSize: perBucket * r.pageSize,
+		Faults:     make([]uint64, buckets),
+		LastFault:  make([]time.Time, buckets),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for page := range pages {
+		i := page / perBucket
+		hm.Faults[i] += uint64(h.faults[page])
+		// UnixNano is undefined for the zero time.
+		if last := h.last[page]; last != 0 && (hm.LastFault[i].IsZero() || last > hm.LastFault[i].UnixNano()) {
+			hm.LastFault[i] = time.Unix(0, last)
+		}
+	}
+	return hm
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRegionHeatmap(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 5, WithProvider(patternProvider(5)), WithHeatmap())
+	e := NewEvictor(r, nil)
+
+	start := time.Now()
+	mem[0] = 1
+	mem[3*pageSize] = 1
+	if _, err := e.Evict(2); err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	mem[0] = 1
+
+	hm := r.Heatmap(0)
+	if hm.BucketSize != pageSize || len(hm.Faults) != 5 {
+		t.Fatalf("unexpected per-page heatmap layout: %d buckets of %d bytes", len(hm.Faults), hm.BucketSize)
+	}
+	for i, want := range []uint64{2, 0, 0, 1, 0} {
+		if hm.Faults[i] != want {
+			t.Errorf("page %d: %d faults, want %d", i, hm.Faults[i], want)
+		}
+	}
+	if hm.LastFault[0].Before(start) || !hm.LastFault[1].IsZero() {
+		t.Errorf("unexpected timestamps: %v", hm.LastFault)
+	}
+
+	hm = r.Heatmap(2)
+	if hm.BucketSize != 3*pageSize || len(hm.Faults) != 2 {
+		t.Fatalf("unexpected downsampled layout: %d buckets of %d bytes", len(hm.Faults), hm.BucketSize)
+	}
+	if hm.Faults[0] != 2 || hm.Faults[1] != 1 {
+		t.Errorf("unexpected downsampled faults: %v", hm.Faults)
+	}
+}
+
+func TestRegionHeatmapDisabled(t *testing.T) {
+	_, r := serveRegion(t, 1)
+	if hm := r.Heatmap(0); hm.Faults != nil {
+		t.Fatalf("expected empty heatmap, got %+v", hm)
+	}
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// CreateHugetlbFile creates a file of size bytes, rounded up to whole huge
+// pages of pagesize bytes, in dir, which must be on hugetlbfs. If dir is
+// empty, the first hugetlbfs mount with that page size in /proc/mounts is
+// used. If pagesize is 0, the page size of the mount is used. The caller
+// is responsible for removing the file.
+//
+// The file is ready to be mapped and registered for missing or minor
+// faults, see MapFileMinor.
+func CreateHugetlbFile(dir string, size int64, pagesize int) (*os.File, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid file size %d", size)
+	}
+	if dir == "" {
+		var err error
+		if dir, err = findHugetlbMount(pagesize); err != nil {
+			return nil, err
+		}
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return nil, &os.PathError{Op: "statfs", Path: dir, Err: err}
+	}
+	if int64(st.Type) != unix.HUGETLBFS_MAGIC {
+		return nil, fmt.Errorf("%s is not on hugetlbfs", dir)
+	}
+	if pagesize == 0 {
+		pagesize = int(st.Bsize)
+	} else if int64(pagesize) != int64(st.Bsize) {
+		return nil, fmt.Errorf("%s has %d byte pages, not %d", dir, st.Bsize, pagesize)
+	}
+
+	f, err := os.CreateTemp(dir, "uffd-")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(RoundUp(uintptr(size), uintptr(pagesize)))); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// findHugetlbMount returns a hugetlbfs mount point with the given page
+// size, or any if pagesize is 0.
+func findHugetlbMount(pagesize int) (string, error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	mounts, err := hugetlbMounts(f)
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range mounts {
+		var st unix.Statfs_t
+		if unix.Statfs(dir, &st) == nil && (pagesize == 0 || int64(pagesize) == int64(st.Bsize)) {
+			return dir, nil
+		}
+	}
+	if pagesize == 0 {
+		return "", errors.New("no hugetlbfs mount found")
+	}
+	return "", fmt.Errorf("no hugetlbfs mount with %d byte pages found", pagesize)
+}
+
+// hugetlbMounts returns the hugetlbfs mount points in the format of
+// /proc/mounts.
+func hugetlbMounts(r io.Reader) ([]string, error) {
+	var dirs []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[2] != "hugetlbfs" {
+			continue
+		}
+		dirs = append(dirs, unescapeMount(fields[1]))
+	}
+	return dirs, s.Err()
+}
+
+// unescapeMount decodes the octal escapes of spaces and other characters
+// in /proc/mounts.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHugetlbMounts(t *testing.T) {
+	const mounts = `proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+hugetlbfs /dev/hugepages hugetlbfs rw,relatime,pagesize=2M 0 0
+none /mnt/huge\0401G hugetlbfs rw,relatime,pagesize=1024M 0 0
+`
+	got, err := hugetlbMounts(strings.NewReader(mounts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/dev/hugepages", "/mnt/huge 1G"}; !slices.Equal(got, want) {
+		t.Errorf("hugetlbMounts() = %q, want %q", got, want)
+	}
+}
+
+func TestCreateHugetlbFile(t *testing.T) {
+	if _, err := CreateHugetlbFile(t.TempDir(), 1, 0); err == nil {
+		t.Error("CreateHugetlbFile accepted a directory outside hugetlbfs")
+	}
+
+	f, err := CreateHugetlbFile("", 1, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := HugePageSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(sizes, uintptr(fi.Size())) {
+		t.Errorf("file size %d is not a huge page size in %v", fi.Size(), sizes)
+	}
+}
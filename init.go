@@ -12,7 +12,8 @@ var (
 	// Kernel supports user mode only flag
 	HaveUserModeOnly bool
 
-	// Detect newer UFFDIO ioctls
+	// Newer UFFDIO ioctls known to the headers the package was built
+	// against. Uffd.HasIoctl reports what the running kernel supports.
 	HaveIoctlContinue     bool
 	HaveIoctlMove         bool
 	HaveIoctlPoison       bool
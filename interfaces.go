@@ -0,0 +1,39 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+// The interfaces below are implemented by *Uffd, so that code using it can
+// be tested against a mock. Handlers still receive the concrete type.
+
+// Registerer registers memory ranges with a userfaultfd.
+type Registerer interface {
+	Register(start uintptr, length int, mode int) (*UffdioRegister, error)
+	Unregister(start uintptr, length int) error
+}
+
+// Resolver resolves page faults and wakes up the faulting threads.
+type Resolver interface {
+	Copy(dst, src uintptr, length int, mode int) (int64, error)
+	Zeropage(start uintptr, length int, mode int) (int64, error)
+	Continue(start uintptr, length int, mode int) error
+	Poison(start uintptr, length int, mode int) (int64, error)
+	Move(dst, src uintptr, length int, mode int) (int64, error)
+	WriteProtect(start uintptr, length int, mode int) error
+	Wake(start uintptr, length int) error
+	WakeRanges(ranges []UffdioRange) error
+}
+
+// EventSource reads the events of a userfaultfd.
+type EventSource interface {
+	ReadMsgsTimeout(msgs []UffdMsg, timeout int) (int, error)
+	Close() error
+}
+
+// Userfaultfd is the whole of Uffd's operations.
+type Userfaultfd interface {
+	Registerer
+	Resolver
+	EventSource
+}
+
+var _ Userfaultfd = (*Uffd)(nil)
@@ -0,0 +1,15 @@
+//go:build mips || mipsle || mips64 || mips64le || ppc64 || ppc64le
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+// Encoding of ioctl numbers from <asm/ioctl.h> on mips and powerpc.
+const (
+	iocNone  = 1
+	iocRead  = 2
+	iocWrite = 4
+
+	iocSizeShift = 16
+	iocDirShift  = 29
+)
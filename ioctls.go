@@ -0,0 +1,33 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import "fmt"
+
+// Require returns an error wrapping ErrMissingIoctl that lists the ioctls,
+// given as _UFFDIO_* bit numbers, the kernel did not grant for the range.
+func (r *UffdioRegister) Require(ioctls ...int) error {
+	return requireIoctls(r.Ioctls, ioctls)
+}
+
+func requireIoctls(granted uint64, ioctls []int) error {
+	var missing uint64
+	var unknown bool
+	for _, ioctl := range ioctls {
+		switch {
+		case ioctl == -1:
+			unknown = true
+		case granted&(1<<ioctl) == 0:
+			missing |= 1 << ioctl
+		}
+	}
+	switch {
+	case unknown && missing != 0:
+		return fmt.Errorf("%w: %s and ioctls unknown to the headers", ErrMissingIoctl, IoctlsString(missing))
+	case unknown:
+		return fmt.Errorf("%w: ioctls unknown to the headers", ErrMissingIoctl)
+	case missing != 0:
+		return fmt.Errorf("%w: %s", ErrMissingIoctl, IoctlsString(missing))
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"sync"
+)
+
+// Journal is a PageWriter making the write-back of a region to a file
+// crash consistent. Pages are appended to a write-ahead log, and only
+// written in place once the log holds the whole flush and is synced, so
+// that a crash leaves either the previous or the new contents of the pages
+// of a flush, after recovery by OpenJournal.
+//
+// The log must not be opened with O_APPEND, and the journal must be the
+// only writer of the file.
+//
+// The log is a sequence of records, each a header of 20 bytes followed by
+// its data: a type, 1 for pages and 2 for the commit of a flush, the length
+// of the data, the offset of the pages, and a CRC-32C of the rest of the
+// header and the data. Integers are little endian.
+type Journal struct {
+	log, file *os.File
+
+	mu        sync.Mutex
+	size      int64           // of the log
+	pending   []journalRecord // pages not written in place yet
+	committed int             // of the pending pages
+	buf       []byte
+}
+
+const (
+	journalHeaderSize = 20
+	journalPages      = 1
+	journalCommit     = 2
+)
+
+// journalRecord locates the pages of a record in the log.
+type journalRecord struct {
+	off  int64 // in the file
+	pos  int64 // of the data in the log
+	size int
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// OpenJournal returns a Journal writing back to file through log, after
+// replaying the flushes committed to log into file with ReplayJournal.
+func OpenJournal(log, file *os.File) (*Journal, error) {
+	if _, err := ReplayJournal(log, file); err != nil {
+		return nil, err
+	}
+	return &Journal{log: log, file: file}, nil
+}
+
+// WritePages appends the pages to the log. They are written in place by
+// Sync.
+func (j *Journal) WritePages(p []byte, off int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.append(journalPages, off, p); err != nil {
+		return err
+	}
+	j.pending = append(j.pending, journalRecord{off: off, pos: j.size - int64(len(p)), size: len(p)})
+	return nil
+}
+
+// Sync commits the pages written since the last call: it appends a commit
+// record of their number to the log and syncs it, writes the pages in place
+// and syncs the file, and truncates the log. Flush calls it once all the
+// dirty pages are written.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.pending) == 0 {
+		return nil
+	}
+	// Pages committed by a failed call are only written in place again.
+	if n := len(j.pending) - j.committed; n > 0 {
+		if err := j.append(journalCommit, int64(n), nil); err != nil {
+			return err
+		}
+		if err := j.log.Sync(); err != nil {
+			return err
+		}
+		j.committed = len(j.pending)
+	}
+	for _, rec := range j.pending {
+		if err := j.apply(rec); err != nil {
+			return err
+		}
+	}
+	j.pending = j.pending[:0]
+	j.committed = 0
+	return j.truncate()
+}
+
+// append writes a record to the log.
+func (j *Journal) append(typ uint32, off int64, data []byte) error {
+	j.buf = appendJournalRecord(j.buf[:0], typ, off, data)
+	if _, err := j.log.WriteAt(j.buf, j.size); err != nil {
+		return err
+	}
+	j.size += int64(len(j.buf))
+	return nil
+}
+
+// apply writes the pages of a record in place, read back from the log.
+func (j *Journal) apply(rec journalRecord) error {
+	buf := make([]byte, rec.size)
+	if _, err := j.log.ReadAt(buf, rec.pos); err != nil {
+		return err
+	}
+	_, err := j.file.WriteAt(buf, rec.off)
+	return err
+}
+
+// truncate syncs the file and empties the log, whose pages are all in place.
+func (j *Journal) truncate() error {
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	if err := j.log.Truncate(0); err != nil {
+		return err
+	}
+	j.size = 0
+	return j.log.Sync()
+}
+
+func appendJournalRecord(buf []byte, typ uint32, off int64, data []byte) []byte {
+	start := len(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, typ)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(off))
+	sum := crc32.Update(crc32.Checksum(buf[start:], crc32c), crc32c, data)
+	buf = binary.LittleEndian.AppendUint32(buf, sum)
+	return append(buf, data...)
+}
+
+// ReplayJournal writes the pages of the flushes committed to log into file,
+// syncs file and truncates log. It stops at the first incomplete or corrupt
+// record, left by a crash while appending, whose flush never committed.
+// It returns the number of flushes replayed.
+func ReplayJournal(log, file *os.File) (int, error) {
+	fi, err := log.Stat()
+	if err != nil {
+		return 0, err
+	}
+	j := &Journal{log: log, file: file}
+	flushes := 0
+	var header [journalHeaderSize]byte
+	for pos := int64(0); pos+journalHeaderSize <= fi.Size(); {
+		if _, err := log.ReadAt(header[:], pos); err != nil {
+			return flushes, err
+		}
+		typ := binary.LittleEndian.Uint32(header[0:])
+		size := int(binary.LittleEndian.Uint32(header[4:]))
+		off := int64(binary.LittleEndian.Uint64(header[8:]))
+		if pos+journalHeaderSize+int64(size) > fi.Size() {
+			break
+		}
+		data := make([]byte, size)
+		if _, err := log.ReadAt(data, pos+journalHeaderSize); err != nil {
+			return flushes, err
+		}
+		sum := crc32.Update(crc32.Checksum(header[:16], crc32c), crc32c, data)
+		if sum != binary.LittleEndian.Uint32(header[16:]) {
+			break
+		}
+		pos += journalHeaderSize + int64(size)
+
+		switch typ {
+		case journalPages:
+			j.pending = append(j.pending, journalRecord{off: off, pos: pos - int64(size), size: size})
+		case journalCommit:
+			if off != int64(len(j.pending)) {
+				return flushes, errors.New("journal commit does not match its records")
+			}
+			for _, rec := range j.pending {
+				if err := j.apply(rec); err != nil {
+					return flushes, err
+				}
+			}
+			j.pending = j.pending[:0]
+			flushes++
+		default:
+			return flushes, errors.New("invalid journal record type")
+		}
+	}
+	return flushes, j.truncate()
+}
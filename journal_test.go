@@ -0,0 +1,104 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openJournalFiles(t *testing.T) (log, file *os.File) {
+	t.Helper()
+	dir := t.TempDir()
+	log, err := os.Create(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err = os.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		log.Close()
+		file.Close()
+	})
+	return log, file
+}
+
+func readFile(t *testing.T, f *os.File) []byte {
+	t.Helper()
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJournal(t *testing.T) {
+	log, file := openJournalFiles(t)
+	j, err := OpenJournal(log, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.WritePages([]byte("aaaa"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.WritePages([]byte("bb"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, file); len(got) != 0 {
+		t.Fatalf("pages written in place before Sync: %q", got)
+	}
+	if err := j.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, file); !bytes.Equal(got, []byte("bb\x00\x00aaaa")) {
+		t.Errorf("file = %q", got)
+	}
+	if got := readFile(t, log); len(got) != 0 {
+		t.Errorf("log not truncated: %d bytes", len(got))
+	}
+}
+
+func TestReplayJournal(t *testing.T) {
+	log, file := openJournalFiles(t)
+
+	// A committed flush, then one cut short by a crash.
+	var buf []byte
+	buf = appendJournalRecord(buf, journalPages, 0, []byte("xy"))
+	buf = appendJournalRecord(buf, journalPages, 2, []byte("zz"))
+	buf = appendJournalRecord(buf, journalCommit, 2, nil)
+	buf = appendJournalRecord(buf, journalPages, 0, []byte("lost"))
+	torn := appendJournalRecord(nil, journalCommit, 1, nil)
+	buf = append(buf, torn[:len(torn)-1]...)
+	if _, err := log.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := ReplayJournal(log, file)
+	if err != nil || n != 1 {
+		t.Fatalf("ReplayJournal = %d, %v, want 1 flush", n, err)
+	}
+	if got := readFile(t, file); !bytes.Equal(got, []byte("xyzz")) {
+		t.Errorf("file = %q", got)
+	}
+	if got := readFile(t, log); len(got) != 0 {
+		t.Errorf("log not truncated: %d bytes", len(got))
+	}
+
+	// A corrupt record ends the log.
+	rec := appendJournalRecord(nil, journalPages, 0, []byte("bad!"))
+	rec[len(rec)-1] ^= 1
+	rec = appendJournalRecord(rec, journalCommit, 1, nil)
+	if _, err := log.WriteAt(rec, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ReplayJournal(log, file); err != nil || n != 0 {
+		t.Errorf("ReplayJournal of a corrupt record = %d, %v", n, err)
+	}
+	if got := readFile(t, file); !bytes.Equal(got, []byte("xyzz")) {
+		t.Errorf("corrupt record replayed: %q", got)
+	}
+}
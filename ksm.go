@@ -0,0 +1,67 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// KSMDir is the sysfs directory of Kernel Samepage Merging.
+const KSMDir = "/sys/kernel/mm/ksm"
+
+// KSMStats holds the counters of Kernel Samepage Merging, which merges the
+// identical pages of regions made mergeable with WithMergeable. See
+// Documentation/admin-guide/mm/ksm.rst.
+type KSMStats struct {
+	Run           int    // 0 stopped, 1 running, 2 unmerging
+	PagesShared   uint64 // Merged pages in use
+	PagesSharing  uint64 // Pages sharing them, that is memory saved
+	PagesUnshared uint64 // Unique pages checked repeatedly for merging
+	PagesVolatile uint64 // Pages changing too fast to be merged
+	FullScans     uint64
+	ZeroPages     uint64 // Pages merged with the zero page, if enabled
+	GeneralProfit int64  // Bytes saved, net of the KSM metadata
+}
+
+// ReadKSMStats reads the counters in dir, such as KSMDir. Counters not
+// provided by the kernel are left zero.
+func ReadKSMStats(dir string) (*KSMStats, error) {
+	var st KSMStats
+	for name, p := range map[string]any{
+		"run":            &st.Run,
+		"pages_shared":   &st.PagesShared,
+		"pages_sharing":  &st.PagesSharing,
+		"pages_unshared": &st.PagesUnshared,
+		"pages_volatile": &st.PagesVolatile,
+		"full_scans":     &st.FullScans,
+		"ksm_zero_pages": &st.ZeroPages,
+		"general_profit": &st.GeneralProfit,
+	} {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) && name != "run" {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		s := strings.TrimSpace(string(data))
+		switch p := p.(type) {
+		case *int:
+			*p, err = strconv.Atoi(s)
+		case *uint64:
+			*p, err = strconv.ParseUint(s, 10, 64)
+		case *int64:
+			*p, err = strconv.ParseInt(s, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return &st, nil
+}
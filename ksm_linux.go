@@ -0,0 +1,16 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+// WithMergeable marks the region mergeable by Kernel Samepage Merging with
+// madvise(MADV_MERGEABLE), so that the identical pages it is filled with,
+// such as those of many near-identical VMs restored from a snapshot, are
+// merged once scanned. KSM must be enabled in /sys/kernel/mm/ksm/run, and
+// only merges private anonymous memory. See ReadKSMStats for its savings.
+func WithMergeable() RegionOption {
+	return func(r *Region) {
+		r.mergeable = true
+	}
+}
@@ -0,0 +1,107 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestReadKSMStats(t *testing.T) {
+	dir := t.TempDir()
+	// Older kernels have neither ksm_zero_pages nor general_profit.
+	for name, v := range map[string]string{
+		"run":            "1",
+		"pages_shared":   "10",
+		"pages_sharing":  "90",
+		"pages_unshared": "5",
+		"pages_volatile": "2",
+		"full_scans":     "3",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(v+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st, err := ReadKSMStats(dir)
+	if err != nil {
+		t.Fatalf("ReadKSMStats failed: %v", err)
+	}
+	want := KSMStats{Run: 1, PagesShared: 10, PagesSharing: 90, PagesUnshared: 5, PagesVolatile: 2, FullScans: 3}
+	if *st != want {
+		t.Errorf("ReadKSMStats = %+v, want %+v", *st, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "general_profit"), []byte("-4096\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := ReadKSMStats(dir); err != nil || st.GeneralProfit != -4096 {
+		t.Errorf("ReadKSMStats = %+v, %v", st, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pages_shared"), []byte("lots\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadKSMStats(dir); err == nil {
+		t.Error("expected error on invalid counter")
+	}
+	if _, err := ReadKSMStats(t.TempDir()); err == nil {
+		t.Error("expected error without KSM")
+	}
+}
+
+func TestReadKSMStatsSystem(t *testing.T) {
+	if _, err := os.Stat(KSMDir); err != nil {
+		t.Skip("KSM not available")
+	}
+	if _, err := ReadKSMStats(KSMDir); err != nil {
+		t.Fatalf("ReadKSMStats failed: %v", err)
+	}
+}
+
+func TestRegionMergeable(t *testing.T) {
+	if _, err := os.Stat(KSMDir); err != nil {
+		t.Skip("KSM not available")
+	}
+	mem, _ := serveRegion(t, 4, WithProvider(patternProvider(4)), WithMergeable())
+	if mem[0] != 1 {
+		t.Errorf("page 0 = %#x, want 1", mem[0])
+	}
+	flags, err := vmFlags(uintptr(unsafe.Pointer(&mem[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(flags, "mg") {
+		t.Errorf("region not mergeable: VmFlags %v", flags)
+	}
+}
+
+// vmFlags returns the VmFlags of the mapping starting at addr.
+func vmFlags(addr uintptr) ([]string, error) {
+	f, err := os.Open("/proc/self/smaps")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	start := fmt.Sprintf("%x-", addr)
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, start) {
+			found = true
+		} else if v, ok := strings.CutPrefix(line, "VmFlags:"); ok && found {
+			return strings.Fields(v), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no mapping at %#x", addr)
+}
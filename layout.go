@@ -0,0 +1,38 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import "unsafe"
+
+// The kernel structures are made of __u64 fields only, apart from the
+// packed uffd_msg, so they have no padding and the same layout on 32-bit,
+// 64-bit, little and big-endian architectures. On 32-bit x86 __u64 is only
+// 4-byte aligned, which does not change the sizes either.
+//
+// The assertions below make the build fail on any architecture where the
+// Go types do not match, rather than passing the kernel a wrong size.
+// Each one indexes a single element array with the difference, so it does
+// not compile unless the difference is zero.
+var (
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdMsg{})-32]
+	_ = [1]struct{}{}[unsafe.Offsetof(UffdMsg{}.Data)-8]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdMsgPagefault{})-24]
+	_ = [1]struct{}{}[unsafe.Offsetof(UffdMsgPagefault{}.Ptid)-16]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdMsgRemap{})-24]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdMsgRemove{})-16]
+
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioApi{})-24]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioRange{})-16]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioRegister{})-32]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioCopy{})-40]
+	_ = [1]struct{}{}[unsafe.Offsetof(UffdioCopy{}.Copy)-32]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioZeropage{})-32]
+	_ = [1]struct{}{}[unsafe.Offsetof(UffdioZeropage{}.Zeropage)-24]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioWriteprotect{})-24]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioContinue{})-32]
+	_ = [1]struct{}{}[unsafe.Offsetof(UffdioContinue{}.Mapped)-24]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioPoison{})-32]
+	_ = [1]struct{}{}[unsafe.Offsetof(UffdioPoison{}.Updated)-24]
+	_ = [1]struct{}{}[unsafe.Sizeof(UffdioMove{})-40]
+	_ = [1]struct{}{}[unsafe.Offsetof(UffdioMove{}.Move)-32]
+)
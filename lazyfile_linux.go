@@ -0,0 +1,190 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// LazyFile is a fixed-size file over demand-paged memory: its pages are
+// read from a PageProvider when first accessed, and the pages written to
+// are written back to a PageWriter on Sync, Close and as set by the
+// WriteBackPolicy. It owns its userfaultfd and serves it in a goroutine of
+// its own. ReadAt and WriteAt fill the pages they access beforehand rather
+// than faulting on them, as a goroutine blocked on a fault in the process
+// would hold up the stops of the runtime. It is safe for concurrent use.
+type LazyFile struct {
+	name    string
+	size    int64
+	uffd    *Uffd
+	r       *Region
+	done    chan error
+	modTime atomic.Int64 // in nanoseconds since the epoch
+
+	mu     sync.RWMutex // held for writing by Close, which unmaps the memory
+	closed bool
+}
+
+// OpenLazyFile maps a LazyFile of size bytes, read from p and written back
+// to w. The name is only reported by Name and Stat. The options apply to
+// the underlying Region, registered in missing and write-protect modes as
+// WithWriteBack requires.
+func OpenLazyFile(name string, size int64, p PageProvider, w PageWriter, policy WriteBackPolicy, opts ...RegionOption) (*LazyFile, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid lazy file size %d", size)
+	}
+	flags := unix.O_CLOEXEC | unix.O_NONBLOCK
+	if HaveUserModeOnly {
+		flags |= UFFD_USER_MODE_ONLY
+	}
+	uffd, err := New(flags, UFFD_FEATURE_PAGEFAULT_FLAG_WP)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]RegionOption{WithProvider(p), WithWriteBack(w, policy)}, opts...)
+	r, err := uffd.MapAndRegister(size, UFFDIO_REGISTER_MODE_MISSING|UFFDIO_REGISTER_MODE_WP, opts...)
+	if err != nil {
+		uffd.Close()
+		return nil, err
+	}
+	f := &LazyFile{name: name, size: size, uffd: uffd, r: r, done: make(chan error, 1)}
+	f.modTime.Store(time.Now().UnixNano())
+	go func() {
+		f.done <- r.Serve()
+	}()
+	return f, nil
+}
+
+// Name returns the name the file was opened with.
+func (f *LazyFile) Name() string {
+	return f.name
+}
+
+// Region returns the Region serving the file.
+func (f *LazyFile) Region() *Region {
+	return f.r
+}
+
+// ReadAt implements io.ReaderAt, filling the pages read first.
+func (f *LazyFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.check("read", off); err != nil {
+		return 0, err
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), f.size)
+	if err := f.r.Prefetch(context.Background(), off, end-off); err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	n := copy(p, f.r.Bytes()[off:end])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt. The file does not grow: writes past its
+// end fail, after writing what fits.
+func (f *LazyFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.check("write", off); err != nil {
+		return 0, err
+	}
+	var n int
+	if off < f.size {
+		end := min(off+int64(len(p)), f.size)
+		err := f.r.Prefetch(context.Background(), off, end-off)
+		if err == nil {
+			n, err = f.r.writeDirty(p[:end-off], off)
+		}
+		if err != nil {
+			return 0, &fs.PathError{Op: "write", Path: f.name, Err: err}
+		}
+		f.modTime.Store(time.Now().UnixNano())
+	}
+	if n < len(p) {
+		return n, &fs.PathError{Op: "write", Path: f.name, Err: errors.New("write past end of lazy file")}
+	}
+	return n, nil
+}
+
+func (f *LazyFile) check(op string, off int64) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+	if off < 0 {
+		return &fs.PathError{Op: op, Path: f.name, Err: errors.New("negative offset")}
+	}
+	return nil
+}
+
+// Sync writes back the pages written to.
+func (f *LazyFile) Sync() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.check("sync", 0); err != nil {
+		return err
+	}
+	return f.r.Flush()
+}
+
+// Stat returns a FileInfo describing the file, modified as of the last
+// WriteAt.
+func (f *LazyFile) Stat() (os.FileInfo, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.check("stat", 0); err != nil {
+		return nil, err
+	}
+	return lazyFileInfo{
+		name:    filepath.Base(f.name),
+		size:    f.size,
+		modTime: time.Unix(0, f.modTime.Load()),
+	}, nil
+}
+
+// Close writes back the pages written to, unmaps the file and stops
+// serving it. Only the first call has an effect, later ones failing with
+// os.ErrClosed.
+func (f *LazyFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("close", 0); err != nil {
+		return err
+	}
+	f.closed = true
+	err := f.r.Flush()
+	err = errors.Join(err, f.r.Close(), f.uffd.Close())
+	return errors.Join(err, <-f.done)
+}
+
+// lazyFileInfo is the os.FileInfo of a LazyFile.
+type lazyFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi lazyFileInfo) Name() string       { return fi.name }
+func (fi lazyFileInfo) Size() int64        { return fi.size }
+func (fi lazyFileInfo) Mode() fs.FileMode  { return 0o600 }
+func (fi lazyFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi lazyFileInfo) IsDir() bool        { return false }
+func (fi lazyFileInfo) Sys() any           { return nil }
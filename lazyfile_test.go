@@ -0,0 +1,71 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLazyFile(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	size := int64(3*pageSize + 10)
+	w := &recordingWriter{data: make([]byte, 4*pageSize)}
+	f, err := OpenLazyFile("/tmp/lazy", size, patternProvider(4), w, WriteBackPolicy{})
+	if err != nil {
+		t.Skipf("OpenLazyFile failed: %v", err)
+	}
+
+	buf := make([]byte, 20)
+	if n, err := f.ReadAt(buf, int64(pageSize)); n != len(buf) || err != nil || buf[0] != 2 {
+		t.Errorf("ReadAt = %d, %v, %v", n, err, buf[:1])
+	}
+	if n, err := f.ReadAt(buf, size-5); n != 5 || err != io.EOF || buf[0] != 4 {
+		t.Errorf("ReadAt at end = %d, %v", n, err)
+	}
+
+	if n, err := f.WriteAt([]byte{0xAA, 0xBB}, int64(2*pageSize)); n != 2 || err != nil {
+		t.Fatalf("WriteAt = %d, %v", n, err)
+	}
+	if n, err := f.WriteAt([]byte{1, 2, 3}, size-1); n != 1 || err == nil {
+		t.Errorf("WriteAt past end = %d, %v", n, err)
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Name() != "lazy" || fi.Size() != size || fi.IsDir() {
+		t.Errorf("Stat = %+v, %v", fi, err)
+	}
+
+	if err := f.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if w.data[2*pageSize] != 0xAA || w.data[2*pageSize+1] != 0xBB {
+		t.Errorf("write not written back on Sync")
+	}
+	w.take()
+
+	if _, err := f.WriteAt([]byte{0xCC}, 0); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	// The pages were filled and unprotected rather than faulted on.
+	if s := f.Region().Stats(); s.Missing != 0 || s.WP != 0 {
+		t.Errorf("%d missing and %d write-protect faults, want none", s.Missing, s.WP)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if w.data[0] != 0xCC {
+		t.Errorf("write not written back on Close")
+	}
+	if _, err := f.ReadAt(buf, 0); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("ReadAt after Close = %v", err)
+	}
+	if err := f.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("second Close = %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The madvise(2) helpers take an offset and length within the region,
+// which are widened to whole pages.
+
+// DontNeed releases the pages, which fault again as missing on the next
+// access and are refilled from the provider.
+func (r *Region) DontNeed(off, length int) error {
+	if err := r.madvise(off, length, unix.MADV_DONTNEED); err != nil {
+		return err
+	}
+	if e := r.evictor.Load(); e != nil {
+		mem, _ := r.pages(off, length)
+		e.Forget(uintptr(unsafe.Pointer(unsafe.SliceData(mem))), len(mem))
+	}
+	return nil
+}
+
+// PageOut reclaims the pages to swap or to their file, without a fault
+// when they are accessed again.
+func (r *Region) PageOut(off, length int) error {
+	return r.madvise(off, length, unix.MADV_PAGEOUT)
+}
+
+// Free lets the kernel release the pages lazily under memory pressure.
+// Freed pages that are not written to again before that read as zero.
+func (r *Region) Free(off, length int) error {
+	return r.madvise(off, length, unix.MADV_FREE)
+}
+
+// Collapse synchronously collapses the pages into transparent huge pages.
+// The range should cover whole huge pages.
+func (r *Region) Collapse(off, length int) error {
+	return r.madvise(off, length, unix.MADV_COLLAPSE)
+}
+
+// WillNeed starts reading the pages ahead from swap or their file.
+func (r *Region) WillNeed(off, length int) error {
+	return r.madvise(off, length, unix.MADV_WILLNEED)
+}
+
+// pages returns the whole pages of the region covering off and length.
+func (r *Region) pages(off, length int) ([]byte, error) {
+	if off < 0 || length < 0 || off+length > len(r.mem) {
+		return nil, fmt.Errorf("range %d+%d outside region of %d bytes", off, length, len(r.mem))
+	}
+	start := off &^ (r.pageSize - 1)
+	end := min((off+length+r.pageSize-1)&^(r.pageSize-1), len(r.mem))
+	return r.mem[start:end], nil
+}
+
+func (r *Region) madvise(off, length int, advice int) error {
+	mem, err := r.pages(off, length)
+	if err != nil || len(mem) == 0 {
+		return err
+	}
+	if err := unix.Madvise(mem, advice); err != nil {
+		return os.NewSyscallError("madvise", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRegionMadvise(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 4, WithProvider(patternProvider(4)))
+
+	for i := range 4 {
+		mem[i*pageSize] = 0xFF
+	}
+
+	// Widened to pages 1 and 2.
+	if err := r.DontNeed(pageSize+1, pageSize); err != nil {
+		t.Fatalf("DontNeed failed: %v", err)
+	}
+	b, err := r.Residency()
+	if err != nil {
+		t.Fatalf("Residency failed: %v", err)
+	}
+	for i, want := range []bool{true, false, false, true} {
+		if b.Test(i) != want {
+			t.Errorf("page %d resident = %v, want %v", i, b.Test(i), want)
+		}
+	}
+	if mem[pageSize] != 2 || mem[0] != 0xFF {
+		t.Errorf("got %#x and %#x after DontNeed, want 0x2 and 0xff", mem[pageSize], mem[0])
+	}
+
+	if err := r.WillNeed(0, len(mem)); err != nil {
+		t.Errorf("WillNeed failed: %v", err)
+	}
+	if err := r.Free(3*pageSize, pageSize); err != nil {
+		t.Errorf("Free failed: %v", err)
+	}
+	if err := r.DontNeed(0, 0); err != nil {
+		t.Errorf("DontNeed of empty range failed: %v", err)
+	}
+	if err := r.DontNeed(len(mem), 1); err == nil {
+		t.Errorf("DontNeed past the end succeeded")
+	}
+}
@@ -0,0 +1,75 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// MapFileMinor maps size bytes of f twice with MAP_SHARED, or all of it if
+// size is 0. The first view is registered for minor faults and returned as
+// a Region, which resolves them with UFFDIO_CONTINUE. The second view is not
+// registered: writing to it populates the page cache before resolving the
+// faults. Close unmaps both views.
+//
+// The file must be on tmpfs or hugetlbfs, and u must have been created with
+// UFFD_FEATURE_MINOR_SHMEM or UFFD_FEATURE_MINOR_HUGETLBFS accordingly.
+// Sizes are rounded up to whole pages, which are huge pages on hugetlbfs.
+func (u *Uffd) MapFileMinor(f *os.File, size int64, opts ...RegionOption) (r *Region, populate []byte, err error) {
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &st); err != nil {
+		return nil, nil, os.NewSyscallError("fstatfs", err)
+	}
+	switch int64(st.Type) {
+	case unix.TMPFS_MAGIC, unix.HUGETLBFS_MAGIC:
+	default:
+		return nil, nil, fmt.Errorf("%s is not on tmpfs or hugetlbfs, which minor faults require", f.Name())
+	}
+
+	if size == 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, nil, err
+		}
+		size = fi.Size()
+	}
+	pageSize := uintptr(st.Bsize)
+	length := RoundUp(uintptr(size), pageSize)
+	if size <= 0 || int64(length) < size || int(length) < 0 {
+		return nil, nil, fmt.Errorf("invalid mapping size %d", size)
+	}
+
+	prot := unix.PROT_READ | unix.PROT_WRITE
+	mem, err := unix.Mmap(int(f.Fd()), 0, int(length), prot, unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("mmap", err)
+	}
+	populate, err = unix.Mmap(int(f.Fd()), 0, int(length), prot, unix.MAP_SHARED)
+	if err != nil {
+		unix.Munmap(mem)
+		return nil, nil, os.NewSyscallError("mmap", err)
+	}
+
+	opts = append([]RegionOption{withPageSize(int(pageSize))}, opts...)
+	r, err = u.RegisterRegion(mem, UFFDIO_REGISTER_MODE_MINOR, opts...)
+	if err != nil {
+		unix.Munmap(populate)
+		unix.Munmap(mem)
+		return nil, nil, err
+	}
+	r.mapped = true
+	r.alias = populate
+	return r, populate, nil
+}
+
+// withPageSize sets the unit in which faults are resolved, for hugetlbfs.
+func withPageSize(n int) RegionOption {
+	return func(r *Region) {
+		r.pageSize = n
+	}
+}
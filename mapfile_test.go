@@ -0,0 +1,80 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMapFileMinor(t *testing.T) {
+	needProcs(t, 2)
+	pageSize := unix.Getpagesize()
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, UFFD_FEATURE_MINOR_SHMEM)
+	if errors.Is(err, ErrUnsupportedFeature) {
+		t.Skip("Unsupported UFFD_FEATURE_MINOR_SHMEM")
+	} else if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	fd, err := unix.MemfdCreate("uffd-test", unix.MFD_CLOEXEC)
+	if err != nil {
+		t.Fatalf("memfd_create failed: %v", err)
+	}
+	f := os.NewFile(uintptr(fd), "uffd-test")
+	defer f.Close()
+	if err := f.Truncate(int64(2 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, populate, err := uffd.MapFileMinor(f, 0)
+	if err != nil {
+		t.Fatalf("MapFileMinor failed: %v", err)
+	}
+	defer r.Close()
+	if r.Len() != 2*pageSize || len(populate) != r.Len() {
+		t.Fatalf("Len() = %d, populate %d bytes", r.Len(), len(populate))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+
+	// The page is in the page cache, so reading it through the region is
+	// a minor fault.
+	populate[pageSize] = 42
+	if got := r.Bytes()[pageSize]; got != 42 {
+		t.Errorf("read %d through region, want 42", got)
+	}
+	waitFor(t, func() bool { return r.Stats().Minor == 1 })
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestMapFileMinorRejectsFiles(t *testing.T) {
+	uffd, err := New(flags|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	f, err := os.Open("/proc/self/stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err := uffd.MapFileMinor(f, 4096); err == nil {
+		t.Error("MapFileMinor accepted a file outside tmpfs")
+	}
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMappedSource(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	data := make([]byte, 2*pageSize+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	name := filepath.Join(t.TempDir(), "source")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, err := MapSource(f)
+	if err != nil {
+		t.Fatalf("MapSource failed: %v", err)
+	}
+	defer src.Close()
+	if src.Len() != len(data) {
+		t.Errorf("Len = %d, want %d", src.Len(), len(data))
+	}
+	// The last, partial page is read into a buffer instead.
+	if src.MappedPage(int64(2*pageSize), pageSize) != nil {
+		t.Error("partial page mapped")
+	}
+
+	mem, r := serveRegion(t, 3, WithProvider(src))
+	if !bytes.Equal(mem[:len(data)], data) {
+		t.Error("region differs from the source")
+	}
+	if !bytes.Equal(mem[len(data):], make([]byte, len(mem)-len(data))) {
+		t.Error("region not zero-filled past the source")
+	}
+	waitFor(t, func() bool { return r.Stats().BytesFilled == uint64(3*pageSize) })
+}
@@ -0,0 +1,94 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Mapping is a line of /proc/self/maps.
+type Mapping struct {
+	Start, End uintptr
+	Perms      string // such as "rw-p"
+	Offset     uint64
+	Dev        string // major:minor
+	Inode      uint64
+	Path       string // empty for anonymous mappings
+}
+
+// MappingKind is the kind of memory backing a Mapping.
+type MappingKind int
+
+const (
+	MappingAnonymous MappingKind = iota
+	MappingShmem
+	MappingHugetlb
+	MappingFile    // a regular file
+	MappingSpecial // such as [vdso]
+)
+
+var mappingKindNames = [...]string{"anonymous", "shmem", "hugetlb", "file", "special"}
+
+func (k MappingKind) String() string {
+	if int(k) < len(mappingKindNames) {
+		return mappingKindNames[k]
+	}
+	return "MappingKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Shared reports whether the mapping was created with MAP_SHARED.
+func (m Mapping) Shared() bool {
+	return len(m.Perms) == 4 && m.Perms[3] == 's'
+}
+
+// ParseMaps parses the format of /proc/<pid>/maps.
+func ParseMaps(r io.Reader) ([]Mapping, error) {
+	var maps []Mapping
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		m, err := parseMapping(s.Text())
+		if err != nil {
+			return nil, err
+		}
+		maps = append(maps, m)
+	}
+	return maps, s.Err()
+}
+
+func parseMapping(line string) (Mapping, error) {
+	var m Mapping
+	var fields [5]string
+	rest := line
+	for i := range fields {
+		fields[i], rest, _ = strings.Cut(strings.TrimLeft(rest, " "), " ")
+	}
+	m.Path = strings.TrimLeft(rest, " ")
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return m, fmt.Errorf("invalid maps line %q", line)
+	}
+	var err error
+	var v uint64
+	if v, err = strconv.ParseUint(start, 16, 64); err != nil {
+		return m, fmt.Errorf("invalid maps line %q: %w", line, err)
+	}
+	m.Start = uintptr(v)
+	if v, err = strconv.ParseUint(end, 16, 64); err != nil {
+		return m, fmt.Errorf("invalid maps line %q: %w", line, err)
+	}
+	m.End = uintptr(v)
+	m.Perms = fields[1]
+	if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+		return m, fmt.Errorf("invalid maps line %q: %w", line, err)
+	}
+	m.Dev = fields[3]
+	if m.Inode, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+		return m, fmt.Errorf("invalid maps line %q: %w", line, err)
+	}
+	return m, nil
+}
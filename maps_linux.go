@@ -0,0 +1,104 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Kind returns the kind of memory backing m. Mappings of other files are
+// looked up with statfs(2) to find those in tmpfs or hugetlbfs. Memory file
+// descriptors are reported as shmem, even with MFD_HUGETLB.
+func (m Mapping) Kind() MappingKind {
+	path, deleted := strings.CutSuffix(m.Path, " (deleted)")
+	switch {
+	case path == "" || path == "[heap]" || path == "[stack]" || strings.HasPrefix(path, "[anon:"):
+		return MappingAnonymous
+	case strings.HasPrefix(path, "[anon_shmem:"):
+		return MappingShmem
+	case strings.HasPrefix(path, "["):
+		return MappingSpecial
+	case path == "/anon_hugepage":
+		return MappingHugetlb
+	case path == "/dev/zero" && deleted, strings.HasPrefix(path, "/SYSV"), strings.HasPrefix(path, "/memfd:"):
+		return MappingShmem
+	}
+	if !deleted {
+		var st unix.Statfs_t
+		if unix.Statfs(path, &st) == nil {
+			switch int64(st.Type) {
+			case unix.TMPFS_MAGIC:
+				return MappingShmem
+			case unix.HUGETLBFS_MAGIC:
+				return MappingHugetlb
+			}
+		}
+	}
+	return MappingFile
+}
+
+// ReadMaps returns the mappings of the calling process.
+func ReadMaps() ([]Mapping, error) {
+	f, err := os.Open("/proc/self/maps")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseMaps(f)
+}
+
+// ValidateRange checks that a range can be registered with the given
+// UFFDIO_REGISTER_MODE_* flags: it must be page aligned and fully mapped
+// by anonymous, shmem or hugetlb memory, and only the latter two support
+// minor faults. Its errors explain what UFFDIO_REGISTER would reject with
+// EINVAL. Hugetlb alignment and unsupported kernel features are not
+// checked.
+func ValidateRange(start uintptr, length int, mode int) error {
+	maps, err := ReadMaps()
+	if err != nil {
+		return err
+	}
+	return validateRange(maps, start, length, mode)
+}
+
+func validateRange(maps []Mapping, start uintptr, length int, mode int) error {
+	pageSize := uintptr(os.Getpagesize())
+	end := start + uintptr(length)
+	switch {
+	case length <= 0:
+		return fmt.Errorf("invalid range length %d", length)
+	case start%pageSize != 0 || uintptr(length)%pageSize != 0:
+		return fmt.Errorf("range %#x-%#x not page aligned", start, end)
+	case end < start:
+		return fmt.Errorf("range %#x-%#x wraps around", start, end)
+	case mode&^(UFFDIO_REGISTER_MODE_MISSING|UFFDIO_REGISTER_MODE_WP|UFFDIO_REGISTER_MODE_MINOR) != 0, mode == 0:
+		return fmt.Errorf("invalid register mode %#x", mode)
+	}
+
+	addr := start
+	for _, m := range maps {
+		if m.End <= addr {
+			continue
+		}
+		if m.Start > addr {
+			break
+		}
+		switch kind := m.Kind(); {
+		case kind == MappingFile || kind == MappingSpecial:
+			return fmt.Errorf("range %#x-%#x overlaps %s mapping %#x-%#x %s, which only supports anonymous, shmem or hugetlb memory",
+				start, end, kind, m.Start, m.End, m.Path)
+		case kind == MappingAnonymous && mode&UFFDIO_REGISTER_MODE_MINOR != 0:
+			return fmt.Errorf("range %#x-%#x overlaps anonymous mapping %#x-%#x, which does not support minor faults",
+				start, end, m.Start, m.End)
+		}
+		addr = m.End
+		if addr >= end {
+			return nil
+		}
+	}
+	return fmt.Errorf("range %#x-%#x not mapped at %#x", start, end, addr)
+}
@@ -0,0 +1,113 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseMaps(t *testing.T) {
+	const maps = `55d00000-55d21000 rw-p 00000000 00:00 0                          [heap]
+7f000000-7f200000 rw-s 00000000 00:01 1234                       /dev/zero (deleted)
+7f200000-7f400000 r-xp 00001000 fd:01 5678                       /usr/lib/lib with spaces.so
+bffd0000-bffd2000 r-xp 00000000 00:00 0                          [vdso]
+`
+	got, err := ParseMaps(strings.NewReader(maps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		m    Mapping
+		kind MappingKind
+	}{
+		{Mapping{0x55d00000, 0x55d21000, "rw-p", 0, "00:00", 0, "[heap]"}, MappingAnonymous},
+		{Mapping{0x7f000000, 0x7f200000, "rw-s", 0, "00:01", 1234, "/dev/zero (deleted)"}, MappingShmem},
+		{Mapping{0x7f200000, 0x7f400000, "r-xp", 0x1000, "fd:01", 5678, "/usr/lib/lib with spaces.so"}, MappingFile},
+		{Mapping{0xbffd0000, 0xbffd2000, "r-xp", 0, "00:00", 0, "[vdso]"}, MappingSpecial},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d mappings, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i] != w.m {
+			t.Errorf("mapping %d = %+v, want %+v", i, got[i], w.m)
+		}
+		if kind := got[i].Kind(); kind != w.kind {
+			t.Errorf("mapping %d kind = %v, want %v", i, kind, w.kind)
+		}
+	}
+	if !got[1].Shared() || got[0].Shared() {
+		t.Error("Shared() does not match perms")
+	}
+
+	if _, err := ParseMaps(strings.NewReader("garbage\n")); err == nil {
+		t.Error("ParseMaps accepted an invalid line")
+	}
+}
+
+func TestValidateRange(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, 3*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(mem)
+	start := uintptr(unsafe.Pointer(&mem[0]))
+
+	if err := ValidateRange(start, 2*pageSize, UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Errorf("anonymous range: %v", err)
+	}
+	if err := ValidateRange(start+1, pageSize, UFFDIO_REGISTER_MODE_MISSING); err == nil {
+		t.Error("unaligned range accepted")
+	}
+	if err := ValidateRange(start, pageSize, UFFDIO_REGISTER_MODE_MINOR); err == nil {
+		t.Error("minor mode accepted on anonymous memory")
+	}
+
+	// Punch a hole in the middle.
+	if err := unix.MunmapPtr(unsafe.Pointer(&mem[pageSize]), uintptr(pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRange(start, 3*pageSize, UFFDIO_REGISTER_MODE_MISSING); err == nil || !strings.Contains(err.Error(), "not mapped") {
+		t.Errorf("range with a hole: %v", err)
+	}
+
+	maps, err := ReadMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range maps {
+		if m.Path == "[vdso]" {
+			if err := ValidateRange(m.Start, pageSize, UFFDIO_REGISTER_MODE_MISSING); err == nil {
+				t.Error("vdso accepted")
+			}
+		}
+	}
+}
+
+func TestRegisterExplainsEINVAL(t *testing.T) {
+	uffd, err := New(unix.O_CLOEXEC|UFFD_USER_MODE_ONLY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer uffd.Close()
+
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(mem)
+
+	_, err = uffd.Register(uintptr(unsafe.Pointer(&mem[0])), pageSize, UFFDIO_REGISTER_MODE_MINOR)
+	if !errors.Is(err, unix.EINVAL) || !strings.Contains(err.Error(), "minor faults") {
+		t.Errorf("Register() = %v", err)
+	}
+}
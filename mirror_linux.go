@@ -0,0 +1,289 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// MirroredMapping is a mapping of a process mirrored by a ProcessMirror.
+type MirroredMapping struct {
+	Mapping         // in the process
+	Region  *Region // mirroring it
+}
+
+// ProcessMirror exposes the memory of another process to a debugger: each
+// of its mappings is mirrored by a Region filled lazily by a ProcessMemory,
+// so that browsing a huge address space only copies the pages touched.
+// Writes go through to the process, including to its read-only mappings
+// such as code, as for breakpoints.
+//
+// The process may be attached to with ptrace(2) and stopped while
+// mirrored, so that its memory does not change under the debugger, and is
+// resumed by Close. Otherwise Invalidate drops the pages mirrored so far.
+// The layout is that of when MirrorProcess was called.
+type ProcessMirror struct {
+	pid      int
+	uffd     *Uffd
+	mux      *ServeMux
+	mappings []MirroredMapping
+	mem      *os.File // /proc/<pid>/mem, for writes to read-only mappings
+	tracer   *tracer  // nil if not attached
+	tids     []int    // attached to
+}
+
+// MirrorProcess mirrors the readable mappings of process pid for which
+// filter, if not nil, returns true, with a Region each, registered for
+// missing faults with opts. If attach is true, the threads of the process
+// are attached to and stopped first. The mirror must be served, as by
+// Serve, for its memory to be accessed.
+func (u *Uffd) MirrorProcess(pid int, attach bool, filter func(Mapping) bool, opts ...RegionOption) (*ProcessMirror, error) {
+	m := &ProcessMirror{pid: pid, uffd: u, mux: NewServeMux()}
+	if attach {
+		m.tracer = newTracer()
+		if err := m.attach(); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	if err := m.mirror(filter, opts); err != nil {
+		m.Close()
+		return nil, err
+	}
+	mem, err := os.OpenFile(fmt.Sprintf("/proc/%d/mem", pid), os.O_RDWR, 0)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	m.mem = mem
+	return m, nil
+}
+
+// mirror maps a region for each mapping to mirror.
+func (m *ProcessMirror) mirror(filter func(Mapping) bool, opts []RegionOption) error {
+	maps, err := readableMaps(m.pid, filter)
+	if err != nil {
+		return err
+	}
+	for _, mp := range maps {
+		pm, err := NewProcessMemory(m.pid, []RemoteRange{{Addr: mp.Start, Len: int(mp.End - mp.Start)}})
+		if err != nil {
+			return err
+		}
+		ropts := append(opts[:len(opts):len(opts)], WithProvider(pm))
+		r, err := m.uffd.MapAndRegister(int64(mp.End-mp.Start), UFFDIO_REGISTER_MODE_MISSING, ropts...)
+		if err != nil {
+			return err
+		}
+		m.mappings = append(m.mappings, MirroredMapping{Mapping: mp, Region: r})
+		if err := m.mux.HandleRegion(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mappings returns the mappings mirrored, by address.
+func (m *ProcessMirror) Mappings() []MirroredMapping {
+	return m.mappings
+}
+
+// Mux returns the ServeMux dispatching to the regions, to be served with
+// Serve or a Server.
+func (m *ProcessMirror) Mux() *ServeMux {
+	return m.mux
+}
+
+// Serve resolves faults on the mirror until its userfaultfd is closed.
+func (m *ProcessMirror) Serve() error {
+	return Serve(m.uffd, m.mux)
+}
+
+// Bytes returns the local memory mirroring the n bytes at addr in the
+// process, which must be within a mirrored mapping.
+func (m *ProcessMirror) Bytes(addr uintptr, n int) ([]byte, error) {
+	i, found := slices.BinarySearchFunc(m.mappings, addr, func(mm MirroredMapping, addr uintptr) int {
+		switch {
+		case addr < mm.Start:
+			return 1
+		case addr >= mm.End:
+			return -1
+		}
+		return 0
+	})
+	if !found || n < 0 || addr+uintptr(n) > m.mappings[i].End {
+		return nil, fmt.Errorf("range %#x+%d not mirrored", addr, n)
+	}
+	off := int(addr - m.mappings[i].Start)
+	return m.mappings[i].Region.Bytes()[off : off+n], nil
+}
+
+// ReadAt reads the memory at address off of the process from the mirror.
+func (m *ProcessMirror) ReadAt(p []byte, off int64) (int, error) {
+	b, err := m.Bytes(uintptr(off), len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, b), nil
+}
+
+// WriteAt writes to the memory at address off of the process, and to the
+// mirror.
+func (m *ProcessMirror) WriteAt(p []byte, off int64) (int, error) {
+	b, err := m.Bytes(uintptr(off), len(p))
+	if err != nil || len(p) == 0 {
+		return 0, err
+	}
+	local := []unix.Iovec{{Base: &p[0]}}
+	local[0].SetLen(len(p))
+	n, err := unix.ProcessVMWritev(m.pid, local, []unix.RemoteIovec{{Base: uintptr(off), Len: len(p)}}, 0)
+	if err == unix.EFAULT || err == nil && n < len(p) {
+		// Read-only pages are written with FOLL_FORCE through the mem
+		// file, as ptrace(2) pokes them.
+		n, err = m.mem.WriteAt(p, off)
+	} else if err != nil {
+		err = os.NewSyscallError("process_vm_writev", err)
+	}
+	copy(b, p[:n])
+	return n, err
+}
+
+// Invalidate drops the pages mirrored so far, which are read again from
+// the process when next accessed.
+func (m *ProcessMirror) Invalidate() error {
+	var errs []error
+	for _, mm := range m.mappings {
+		errs = append(errs, mm.Region.DontNeed(0, len(mm.Region.Bytes())))
+	}
+	return errors.Join(errs...)
+}
+
+// Close unmaps the mirror, whose memory must not be accessed anymore, and
+// detaches from the process, which resumes.
+func (m *ProcessMirror) Close() error {
+	var errs []error
+	for _, mm := range m.mappings {
+		errs = append(errs, mm.Region.Close())
+	}
+	if m.mem != nil {
+		errs = append(errs, m.mem.Close())
+	}
+	if m.tracer != nil {
+		errs = append(errs, m.tracer.do(m.detach))
+		m.tracer.close()
+	}
+	return errors.Join(errs...)
+}
+
+// attach seizes the threads of the process and stops them. Threads
+// created meanwhile are missed.
+func (m *ProcessMirror) attach() error {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", m.pid))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if err := m.tracer.do(func() error { return stopThread(tid) }); err != nil {
+			return fmt.Errorf("thread %d: %w", tid, err)
+		}
+		m.tids = append(m.tids, tid)
+	}
+	return nil
+}
+
+// detach detaches from the threads attached to, on the tracer thread.
+func (m *ProcessMirror) detach() error {
+	var errs []error
+	for _, tid := range m.tids {
+		// The thread may have exited meanwhile.
+		if err := unix.PtraceDetach(tid); err != nil && err != unix.ESRCH {
+			errs = append(errs, os.NewSyscallError("ptrace", err))
+		}
+	}
+	m.tids = nil
+	return errors.Join(errs...)
+}
+
+// stopThread seizes thread tid and waits for it to stop, on the tracer
+// thread.
+func stopThread(tid int) error {
+	if err := unix.PtraceSeize(tid); err != nil {
+		return os.NewSyscallError("ptrace", err)
+	}
+	if err := unix.PtraceInterrupt(tid); err != nil {
+		unix.PtraceDetach(tid)
+		return os.NewSyscallError("ptrace", err)
+	}
+	var ws unix.WaitStatus
+	if err := retryOnEINTR(func() error {
+		_, err := unix.Wait4(tid, &ws, unix.WALL, nil)
+		return err
+	}); err != nil {
+		return os.NewSyscallError("wait4", err)
+	}
+	if !ws.Stopped() {
+		return fmt.Errorf("thread not stopped: %#x", ws)
+	}
+	return nil
+}
+
+// readableMaps returns the mappings of process pid that process_vm_readv(2)
+// can read, for which filter, if not nil, returns true.
+func readableMaps(pid int, filter func(Mapping) bool) ([]Mapping, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	maps, err := ParseMaps(f)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(maps, func(mp Mapping) bool {
+		// The vsyscall page and the vDSO data fail with EFAULT.
+		return mp.Perms == "" || mp.Perms[0] != 'r' || mp.Path == "[vsyscall]" || mp.Path == "[vvar]" || mp.Path == "[vvar_vclock]" ||
+			filter != nil && !filter(mp)
+	}), nil
+}
+
+// tracer runs ptrace(2) requests on the thread attached to the tracees, as
+// the kernel requires.
+type tracer struct {
+	calls chan func()
+}
+
+func newTracer() *tracer {
+	t := &tracer{calls: make(chan func())}
+	go func() {
+		// The thread is not unlocked, so that it exits with the
+		// goroutine, detaching from any tracee left.
+		runtime.LockOSThread()
+		for fn := range t.calls {
+			fn()
+		}
+	}()
+	return t
+}
+
+func (t *tracer) do(fn func() error) error {
+	errc := make(chan error, 1)
+	t.calls <- func() { errc <- fn() }
+	return <-errc
+}
+
+func (t *tracer) close() {
+	close(t.calls)
+}
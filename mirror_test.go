@@ -0,0 +1,117 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// processState returns the state of process pid, from /proc/<pid>/stat.
+func processState(t *testing.T, pid int) string {
+	t.Helper()
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rest, _ := strings.Cut(string(data), ") ")
+	return rest[:1]
+}
+
+func TestProcessMirror(t *testing.T) {
+	needProcs(t, 3)
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot run sleep: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	pid := cmd.Process.Pid
+	exe, err := filepath.EvalSymlinks(cmd.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	m, err := uffd.MirrorProcess(pid, true, func(mp Mapping) bool {
+		return mp.Path == exe || mp.Path == "[stack]"
+	})
+	if err != nil {
+		t.Fatalf("MirrorProcess failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Serve()
+	}()
+	closed := false
+	defer func() {
+		if !closed {
+			m.Close()
+		}
+		uffd.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+	}()
+	if s := processState(t, pid); s != "t" {
+		t.Errorf("process in state %s, want t", s)
+	}
+
+	var header, stack *MirroredMapping
+	for i, mm := range m.Mappings() {
+		switch {
+		case mm.Path == exe && mm.Offset == 0:
+			header = &m.Mappings()[i]
+		case mm.Path == "[stack]":
+			stack = &m.Mappings()[i]
+		}
+	}
+	if header == nil || stack == nil {
+		t.Fatalf("mappings not mirrored: %+v", m.Mappings())
+	}
+	if b, err := m.Bytes(header.Start, 4); err != nil || string(b) != "\x7fELF" {
+		t.Errorf("Bytes = %q, %v", b, err)
+	}
+	if _, err := m.Bytes(header.End-1, 2); err == nil {
+		t.Error("expected error on range past a mapping")
+	}
+
+	// Writes reach the process, even in read-only mappings, and the mirror.
+	remote, err := NewProcessMemory(pid, []RemoteRange{{Addr: header.Start, Len: 16}, {Addr: stack.Start, Len: 16}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, addr := range []uintptr{header.Start + 9, stack.Start + 9} {
+		if n, err := m.WriteAt([]byte("uffd"), int64(addr)); n != 4 || err != nil {
+			t.Fatalf("WriteAt %#x = %d, %v", addr, n, err)
+		}
+		buf := make([]byte, 4)
+		if _, err := remote.ReadAt(buf, int64(16*k+9)); err != nil || string(buf) != "uffd" {
+			t.Errorf("process memory at %#x = %q, %v", addr, buf, err)
+		}
+		if _, err := m.ReadAt(buf, int64(addr)); err != nil || !bytes.Equal(buf, []byte("uffd")) {
+			t.Errorf("mirror at %#x = %q, %v", addr, buf, err)
+		}
+	}
+
+	if err := m.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	closed = true
+	waitFor(t, func() bool { return processState(t, pid) != "t" })
+}
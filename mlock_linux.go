@@ -0,0 +1,49 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// handlerMemoryLocked is set by LockHandlerMemory.
+var handlerMemoryLocked atomic.Bool
+
+// LockHandlerMemory locks the memory of the process, present and future,
+// with mlockall(2), so that the handler's own staging buffers, provider
+// caches and goroutine stacks are never swapped out: a fault on them while
+// resolving faults could deadlock the handler. Pages are locked as they are
+// faulted in, with MCL_ONFAULT, so that registered ranges are not
+// populated. Regions registered from then on are unlocked, as locked pages
+// could not be evicted.
+//
+// It should be called before registering regions, and needs CAP_IPC_LOCK
+// or a RLIMIT_MEMLOCK covering the mappings created afterwards, which fail
+// otherwise.
+func LockHandlerMemory() error {
+	if err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE | unix.MCL_ONFAULT); err != nil {
+		return os.NewSyscallError("mlockall", err)
+	}
+	handlerMemoryLocked.Store(true)
+	return nil
+}
+
+// UnlockHandlerMemory undoes LockHandlerMemory with munlockall(2).
+func UnlockHandlerMemory() error {
+	handlerMemoryLocked.Store(false)
+	return os.NewSyscallError("munlockall", unix.Munlockall())
+}
+
+// unlockRegion unlocks mem, registered to be served, if locked by
+// LockHandlerMemory.
+func unlockRegion(mem []byte) error {
+	if !handlerMemoryLocked.Load() {
+		return nil
+	}
+	return os.NewSyscallError("munlock", unix.Munlock(mem))
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLockHandlerMemory(t *testing.T) {
+	if err := LockHandlerMemory(); err != nil {
+		t.Skipf("LockHandlerMemory failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := UnlockHandlerMemory(); err != nil {
+			t.Errorf("UnlockHandlerMemory failed: %v", err)
+		}
+	})
+
+	// Regions are left unlocked, so that their pages can be evicted.
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 2, WithProvider(patternProvider(2)))
+	e := NewEvictor(r, nil)
+	if mem[pageSize] != 2 {
+		t.Fatalf("page 1 = %d, want 2", mem[pageSize])
+	}
+	if _, err := e.Evict(1); err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if got := r.Stats().Evictions; got != 1 {
+		t.Errorf("Stats().Evictions = %d, want 1", got)
+	}
+}
@@ -0,0 +1,119 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// moveStaging holds the staging arena of a region set up with
+// WithMoveStaging.
+type moveStaging struct {
+	n     int    // of slots
+	arena []byte // of n pages
+	slots chan int
+	moves atomic.Bool // cleared once UFFDIO_MOVE turns out not to work
+}
+
+// WithMoveStaging reads the provider pages into a staging arena of up to
+// slots pages and moves them into place with UFFDIO_MOVE, rather than
+// copying them, which saves a copy for large anonymous fills. Up to slots
+// pages are read at once.
+//
+// Moves need a private anonymous region and a userfaultfd with
+// UFFD_FEATURE_MOVE. Pages are copied from the arena instead if not, or if
+// a move fails. Not supported in minor mode, nor with WithWriteBack or
+// WithRegionPolicy, whose pages are copied write-protected.
+func WithMoveStaging(slots int) RegionOption {
+	return func(r *Region) {
+		r.staging = &moveStaging{n: slots}
+	}
+}
+
+func (r *Region) checkMoveStaging() error {
+	switch {
+	case r.staging.n <= 0:
+		return fmt.Errorf("invalid number of staging slots %d", r.staging.n)
+	case r.mode&UFFDIO_REGISTER_MODE_MINOR != 0:
+		return errors.New("move staging is not supported in minor mode")
+	case r.writeBack != nil || r.readOnly != nil:
+		return errors.New("move staging is not compatible with write-protected fills")
+	}
+	return nil
+}
+
+// startMoveStaging maps the staging arena of the region registered as reg.
+func (r *Region) startMoveStaging(reg *UffdioRegister) error {
+	st := r.staging
+	arena, err := unix.Mmap(-1, 0, st.n*r.pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_NORESERVE)
+	if err != nil {
+		return os.NewSyscallError("mmap", err)
+	}
+	st.arena = arena
+	st.slots = make(chan int, st.n)
+	for i := range st.n {
+		st.slots <- i
+	}
+	st.moves.Store(r.uffd.enabled&UFFD_FEATURE_MOVE != 0 && reg.Require(_UFFDIO_MOVE) == nil)
+	return nil
+}
+
+// closeMoveStaging unmaps the staging arena.
+func (r *Region) closeMoveStaging() error {
+	if r.staging.arena == nil {
+		return nil
+	}
+	return os.NewSyscallError("munmap", unix.Munmap(r.staging.arena))
+}
+
+// fillStaged resolves page with the provider through a staging slot.
+func (r *Region) fillStaged(ctx context.Context, page uintptr) error {
+	st := r.staging
+	i := <-st.slots
+	defer func() { st.slots <- i }()
+	buf := st.arena[i*r.pageSize : (i+1)*r.pageSize]
+
+	if err := r.read(ctx, buf, int64(page-r.Base())); err != nil {
+		r.stats.add(&r.stats.ProviderErrors, 1)
+		return err
+	}
+	src := uintptr(unsafe.Pointer(&buf[0]))
+	// The slot is gone once moved.
+	sum := r.pageSum(buf)
+	if st.moves.Load() {
+		_, span := r.startSpan(ctx, "uffd.move")
+		_, err := r.uffd.Move(page, src, r.pageSize, r.wakeMode(UFFDIO_MOVE_MODE_DONTWAKE))
+		span.End(err)
+		switch {
+		case err == nil:
+			// The slot is left unmapped, and faulted in again by the
+			// next read into it.
+			r.stats.add(&r.stats.BytesMoved, uint64(r.pageSize))
+			r.setHash(page, sum)
+			r.copied(page)
+			return nil
+		case errors.Is(err, ErrAlreadyMapped), errors.Is(err, ErrClosed), errors.Is(err, ErrRangeGone):
+			return err
+		case errors.Is(err, ErrMissingIoctl), errors.Is(err, unix.ENOTTY), errors.Is(err, unix.EINVAL):
+			// Not an anonymous region, or not supported by the kernel.
+			st.moves.Store(false)
+		}
+	}
+	_, span := r.startSpan(ctx, "uffd.copy")
+	_, err := r.uffd.Copy(page, src, r.pageSize, r.wakeMode(UFFDIO_COPY_MODE_DONTWAKE))
+	span.End(err)
+	if err == nil {
+		r.setHash(page, sum)
+		r.copied(page)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMoveStaging(t *testing.T) {
+	needProcs(t, 2)
+	uffd, granted, err := NewBestEffort(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, UFFD_FEATURE_MOVE)
+	if err != nil {
+		t.Fatalf("NewBestEffort failed: %v", err)
+	}
+	defer uffd.Close()
+	pageSize := unix.Getpagesize()
+	r, err := uffd.MapAndRegister(int64(4*pageSize), UFFDIO_REGISTER_MODE_MISSING,
+		WithProvider(patternProvider(4)), WithMoveStaging(2))
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+
+	mem := r.Bytes()
+	for i := range 4 {
+		if mem[i*pageSize] != byte(i+1) || mem[i*pageSize+pageSize-1] != byte(i+1) {
+			t.Fatalf("page %d not filled from provider", i)
+		}
+	}
+	// Pages are moved if the kernel and the ioctl numbers allow it, and
+	// copied from the arena otherwise.
+	waitFor(t, func() bool { return r.Stats().BytesFilled == uint64(4*pageSize) })
+	st := r.Stats()
+	if moved := granted&UFFD_FEATURE_MOVE != 0 && HaveIoctlMove; moved != (st.BytesMoved == st.BytesFilled) {
+		t.Errorf("BytesMoved = %d with moves %v", st.BytesMoved, moved)
+	}
+
+	r.Close()
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestMoveStagingMode(t *testing.T) {
+	uffd, err := New(flags|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	for _, slots := range []int{0, -1} {
+		if _, err := uffd.MapAndRegister(int64(unix.Getpagesize()), UFFDIO_REGISTER_MODE_MISSING, WithMoveStaging(slots)); err == nil {
+			t.Errorf("registered with %d staging slots", slots)
+		}
+	}
+}
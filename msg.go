@@ -0,0 +1,71 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MsgSize is the size of an event message read from the userfaultfd.
+const MsgSize = 32
+
+// Event is an event message decoded by DecodeMsg. Only the payload of its
+// Type is set, Remove being that of both UFFD_EVENT_REMOVE and
+// UFFD_EVENT_UNMAP.
+type Event struct {
+	Type      uint8 // UFFD_EVENT_*
+	Pagefault UffdMsgPagefault
+	Fork      UffdMsgFork
+	Remap     UffdMsgRemap
+	Remove    UffdMsgRemove
+}
+
+// DecodeMsg decodes an event message in the layout of the kernel. b must
+// hold exactly one message, of a known type, with its reserved and unused
+// bytes zero as the kernel leaves them. Errors match ErrInvalidMsg.
+func DecodeMsg(b []byte) (Event, error) {
+	if len(b) != MsgSize {
+		return Event{}, fmt.Errorf("%w: %d bytes, expected %d", ErrInvalidMsg, len(b), MsgSize)
+	}
+	e := Event{Type: b[0]}
+	if !zero(b[1:8]) {
+		return Event{}, fmt.Errorf("%w: reserved header bytes set", ErrInvalidMsg)
+	}
+	data := b[8:]
+	var used int
+	switch e.Type {
+	case UFFD_EVENT_PAGEFAULT:
+		e.Pagefault.Flags = binary.NativeEndian.Uint64(data)
+		e.Pagefault.Address = binary.NativeEndian.Uint64(data[8:])
+		e.Pagefault.Ptid = binary.NativeEndian.Uint32(data[16:])
+		used = 20
+	case UFFD_EVENT_FORK:
+		e.Fork.Ufd = binary.NativeEndian.Uint32(data)
+		used = 4
+	case UFFD_EVENT_REMAP:
+		e.Remap.From = binary.NativeEndian.Uint64(data)
+		e.Remap.To = binary.NativeEndian.Uint64(data[8:])
+		e.Remap.Len = binary.NativeEndian.Uint64(data[16:])
+		used = 24
+	case UFFD_EVENT_REMOVE, UFFD_EVENT_UNMAP:
+		e.Remove.Start = binary.NativeEndian.Uint64(data)
+		e.Remove.End = binary.NativeEndian.Uint64(data[8:])
+		used = 16
+	default:
+		return Event{}, fmt.Errorf("%w: unknown event %s", ErrInvalidMsg, EventString(e.Type))
+	}
+	if !zero(data[used:]) {
+		return Event{}, fmt.Errorf("%w: unused %s payload bytes set", ErrInvalidMsg, EventString(e.Type))
+	}
+	return e, nil
+}
+
+func zero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+)
+
+func TestDecodeMsg(t *testing.T) {
+	if MsgSize != unsafe.Sizeof(UffdMsg{}) {
+		t.Fatalf("MsgSize = %d, want %d", MsgSize, unsafe.Sizeof(UffdMsg{}))
+	}
+
+	msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	pf := msg.GetPagefault()
+	pf.Flags = UFFD_PAGEFAULT_FLAG_WRITE
+	pf.Address = 0x7f0000001000
+	pf.Ptid = 42
+	e, err := DecodeMsg(msgBytes(msg))
+	if err != nil {
+		t.Fatalf("DecodeMsg failed: %v", err)
+	}
+	if e.Type != UFFD_EVENT_PAGEFAULT || e.Pagefault != *pf {
+		t.Errorf("DecodeMsg = %+v", e)
+	}
+
+	msg = &UffdMsg{Event: UFFD_EVENT_REMAP}
+	*msg.GetRemap() = UffdMsgRemap{From: 0x1000, To: 0x2000, Len: 0x3000}
+	if e, err := DecodeMsg(msgBytes(msg)); err != nil || e.Remap != *msg.GetRemap() {
+		t.Errorf("DecodeMsg = %+v, %v", e, err)
+	}
+
+	msg = &UffdMsg{Event: UFFD_EVENT_UNMAP}
+	*msg.GetRemove() = UffdMsgRemove{Start: 0x1000, End: 0x2000}
+	if e, err := DecodeMsg(msgBytes(msg)); err != nil || e.Type != UFFD_EVENT_UNMAP || e.Remove != *msg.GetRemove() {
+		t.Errorf("DecodeMsg = %+v, %v", e, err)
+	}
+
+	bad := map[string][]byte{
+		"short":    make([]byte, MsgSize-1),
+		"long":     make([]byte, MsgSize+1),
+		"unknown":  make([]byte, MsgSize),
+		"reserved": msgBytes(&UffdMsg{Event: UFFD_EVENT_FORK}),
+		"unused":   msgBytes(&UffdMsg{Event: UFFD_EVENT_FORK}),
+	}
+	bad["reserved"][3] = 1
+	bad["unused"][8+4] = 1
+	for name, b := range bad {
+		if _, err := DecodeMsg(b); !errors.Is(err, ErrInvalidMsg) {
+			t.Errorf("DecodeMsg of %s message = %v, want ErrInvalidMsg", name, err)
+		}
+	}
+}
+
+func FuzzDecodeMsg(f *testing.F) {
+	msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	msg.GetPagefault().Address = 0x1000
+	f.Add(msgBytes(msg))
+	f.Add(msgBytes(&UffdMsg{Event: UFFD_EVENT_FORK}))
+	f.Add([]byte{UFFD_EVENT_REMOVE})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		e, err := DecodeMsg(b)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidMsg) {
+				t.Fatalf("DecodeMsg error %v does not match ErrInvalidMsg", err)
+			}
+			return
+		}
+		// Valid messages decode as the accessors read them.
+		var msg UffdMsg
+		copy(msgBytes(&msg), b)
+		if e.Type != msg.Event {
+			t.Fatalf("Type = %d, want %d", e.Type, msg.Event)
+		}
+		switch e.Type {
+		case UFFD_EVENT_PAGEFAULT:
+			if e.Pagefault != *msg.GetPagefault() {
+				t.Fatalf("Pagefault = %+v, want %+v", e.Pagefault, *msg.GetPagefault())
+			}
+		case UFFD_EVENT_FORK:
+			if e.Fork != *msg.GetFork() {
+				t.Fatalf("Fork = %+v, want %+v", e.Fork, *msg.GetFork())
+			}
+		}
+	})
+}
@@ -0,0 +1,259 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// NBD protocol constants, see
+// https://github.com/NetworkBlockDevice/nbd/blob/master/doc/proto.md.
+const (
+	nbdMagic         = 0x4e42444d41474943 // "NBDMAGIC"
+	nbdOptMagic      = 0x49484156454f5054 // "IHAVEOPT"
+	nbdOptReplyMagic = 0x3e889045565a9
+	nbdRequestMagic  = 0x25609513
+	nbdReplyMagic    = 0x67446698
+
+	nbdFlagFixedNewstyle = 1 << 0
+	nbdFlagNoZeroes      = 1 << 1
+
+	nbdOptExportName = 1
+	nbdOptGo         = 7
+
+	nbdRepAck      = 1
+	nbdRepInfo     = 3
+	nbdRepErrUnsup = 1<<31 | 1
+	nbdInfoExport  = 0
+
+	nbdCmdRead = 0
+	nbdCmdDisc = 2
+
+	// nbdMaxRead is the largest read request, well under the 32MB that
+	// servers are expected to accept.
+	nbdMaxRead = 1 << 20
+)
+
+// NBDClient is a PageProvider reading from an export of a Network Block
+// Device server, such as qemu-nbd or nbdkit. Only the fixed newstyle
+// handshake and simple replies are supported. Reads are sent one at a time
+// over its connection: use several clients for parallel reads.
+type NBDClient struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	size   int64
+	cookie uint64
+}
+
+// DialNBD connects to an NBD server and opens the named export, see
+// net.Dial.
+func DialNBD(network, address, export string) (*NBDClient, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := NewNBDClient(c, export)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return nc, nil
+}
+
+// NewNBDClient performs the NBD handshake on an established connection,
+// opening the named export with NBD_OPT_GO, or NBD_OPT_EXPORT_NAME with
+// servers not supporting it. The client then owns the connection.
+func NewNBDClient(c net.Conn, export string) (*NBDClient, error) {
+	nc := &NBDClient{conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+	var hello struct {
+		Magic, OptMagic uint64
+		Flags           uint16
+	}
+	if err := binary.Read(nc.r, binary.BigEndian, &hello); err != nil {
+		return nil, err
+	}
+	if hello.Magic != nbdMagic || hello.OptMagic != nbdOptMagic {
+		return nil, errors.New("not a newstyle NBD server")
+	}
+	if hello.Flags&nbdFlagFixedNewstyle == 0 {
+		return nil, errors.New("NBD server does not support the fixed newstyle handshake")
+	}
+	flags := uint32(nbdFlagFixedNewstyle)
+	if hello.Flags&nbdFlagNoZeroes != 0 {
+		flags |= nbdFlagNoZeroes
+	}
+	binary.Write(nc.w, binary.BigEndian, flags)
+
+	ok, err := nc.optGo(export)
+	if err == nil && !ok {
+		err = nc.optExportName(export, flags&nbdFlagNoZeroes != 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nc, nil
+}
+
+// sendOption sends an option request with its data.
+func (c *NBDClient) sendOption(opt uint32, data []byte) error {
+	binary.Write(c.w, binary.BigEndian, struct {
+		Magic    uint64
+		Opt, Len uint32
+	}{nbdOptMagic, opt, uint32(len(data))})
+	c.w.Write(data)
+	return c.w.Flush()
+}
+
+// optGo opens the export with NBD_OPT_GO, returning false if the server
+// does not support it.
+func (c *NBDClient) optGo(export string) (bool, error) {
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(export)))
+	data = append(data, export...)
+	data = binary.BigEndian.AppendUint16(data, 0) // no information requests
+	if err := c.sendOption(nbdOptGo, data); err != nil {
+		return false, err
+	}
+	for sized := false; ; {
+		var reply struct {
+			Magic          uint64
+			Opt, Type, Len uint32
+		}
+		if err := binary.Read(c.r, binary.BigEndian, &reply); err != nil {
+			return false, err
+		}
+		if reply.Magic != nbdOptReplyMagic || reply.Opt != nbdOptGo || reply.Len > 1<<16 {
+			return false, errors.New("invalid NBD option reply")
+		}
+		data := make([]byte, reply.Len)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return false, err
+		}
+		switch {
+		case reply.Type == nbdRepErrUnsup:
+			return false, nil
+		case reply.Type&(1<<31) != 0:
+			return false, fmt.Errorf("NBD server refused export %q: error %#x %s", export, reply.Type, data)
+		case reply.Type == nbdRepInfo && len(data) >= 12 && binary.BigEndian.Uint16(data) == nbdInfoExport:
+			c.size = int64(binary.BigEndian.Uint64(data[2:]))
+			sized = true
+		case reply.Type == nbdRepAck:
+			if !sized {
+				return false, errors.New("NBD server did not send the export size")
+			}
+			return true, nil
+		}
+	}
+}
+
+// optExportName opens the export with the older NBD_OPT_EXPORT_NAME.
+func (c *NBDClient) optExportName(export string, noZeroes bool) error {
+	if err := c.sendOption(nbdOptExportName, []byte(export)); err != nil {
+		return err
+	}
+	var reply struct {
+		Size  uint64
+		Flags uint16
+	}
+	if err := binary.Read(c.r, binary.BigEndian, &reply); err != nil {
+		return fmt.Errorf("NBD server refused export %q: %w", export, err)
+	}
+	if !noZeroes {
+		if _, err := c.r.Discard(124); err != nil {
+			return err
+		}
+	}
+	c.size = int64(reply.Size)
+	return nil
+}
+
+// Size returns the size of the export.
+func (c *NBDClient) Size() int64 {
+	return c.size
+}
+
+// ReadAt reads len(p) bytes at off from the export, in as many requests as
+// needed. Errors reported by the server are returned as *NBDError.
+func (c *NBDClient) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	want := int(min(int64(len(p)), c.size-off))
+	var n int
+	for n < want {
+		chunk := p[n:min(want, n+nbdMaxRead)]
+		if err := c.read(chunk, off+int64(n)); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// read sends a read request and reads its simple reply into p.
+func (c *NBDClient) read(p []byte, off int64) error {
+	c.cookie++
+	if err := c.send(nbdCmdRead, uint64(off), uint32(len(p))); err != nil {
+		return err
+	}
+	var reply struct {
+		Magic, Errno uint32
+		Cookie       uint64
+	}
+	if err := binary.Read(c.r, binary.BigEndian, &reply); err != nil {
+		return err
+	}
+	if reply.Magic != nbdReplyMagic || reply.Cookie != c.cookie {
+		return errors.New("invalid NBD reply")
+	}
+	if reply.Errno != 0 {
+		return &NBDError{Errno: reply.Errno}
+	}
+	_, err := io.ReadFull(c.r, p)
+	return err
+}
+
+func (c *NBDClient) send(cmd uint16, off uint64, length uint32) error {
+	binary.Write(c.w, binary.BigEndian, struct {
+		Magic       uint32
+		Flags, Type uint16
+		Cookie      uint64
+		Offset      uint64
+		Length      uint32
+	}{nbdRequestMagic, 0, cmd, c.cookie, off, length})
+	return c.w.Flush()
+}
+
+// Close disconnects from the server and closes the connection.
+func (c *NBDClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.send(nbdCmdDisc, 0, 0)
+	return c.conn.Close()
+}
+
+// NBDError is an error reported by an NBD server, with an errno value as
+// defined by the protocol.
+type NBDError struct {
+	Errno uint32
+}
+
+func (e *NBDError) Error() string {
+	return fmt.Sprintf("NBD server: error %d", e.Errno)
+}
@@ -0,0 +1,155 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// fakeNBDServer serves data as the export "disk" on one connection, with
+// or without NBD_OPT_GO.
+func fakeNBDServer(c net.Conn, data []byte, optGo bool) error {
+	defer c.Close()
+	be := binary.BigEndian
+	binary.Write(c, be, struct {
+		Magic, OptMagic uint64
+		Flags           uint16
+	}{nbdMagic, nbdOptMagic, nbdFlagFixedNewstyle})
+	var flags uint32
+	if err := binary.Read(c, be, &flags); err != nil {
+		return err
+	}
+	reply := func(opt, typ uint32, data []byte) {
+		binary.Write(c, be, struct {
+			Magic          uint64
+			Opt, Type, Len uint32
+		}{nbdOptReplyMagic, opt, typ, uint32(len(data))})
+		if len(data) > 0 { // empty writes to a net.Pipe wait for a read
+			c.Write(data)
+		}
+	}
+
+	for done := false; !done; {
+		var opt struct {
+			Magic    uint64
+			Opt, Len uint32
+		}
+		if err := binary.Read(c, be, &opt); err != nil {
+			return err
+		}
+		body := make([]byte, opt.Len)
+		if _, err := io.ReadFull(c, body); err != nil {
+			return err
+		}
+		switch {
+		case opt.Opt == nbdOptGo && optGo:
+			if name := string(body[4 : 4+be.Uint32(body)]); name != "disk" {
+				reply(opt.Opt, 1<<31|6, []byte("unknown export"))
+				continue
+			}
+			info := be.AppendUint16(nil, nbdInfoExport)
+			info = be.AppendUint64(info, uint64(len(data)))
+			info = be.AppendUint16(info, 0)
+			reply(opt.Opt, nbdRepInfo, info)
+			reply(opt.Opt, nbdRepAck, nil)
+			done = true
+		case opt.Opt == nbdOptExportName:
+			binary.Write(c, be, uint64(len(data)))
+			binary.Write(c, be, uint16(0))
+			c.Write(make([]byte, 124))
+			done = true
+		default:
+			reply(opt.Opt, nbdRepErrUnsup, nil)
+		}
+	}
+
+	for {
+		var req struct {
+			Magic       uint32
+			Flags, Type uint16
+			Cookie      uint64
+			Offset      uint64
+			Length      uint32
+		}
+		if err := binary.Read(c, be, &req); err != nil {
+			return err
+		}
+		if req.Type == nbdCmdDisc {
+			return nil
+		}
+		var errno uint32
+		if req.Offset+uint64(req.Length) > uint64(len(data)) {
+			errno = 22 // EINVAL
+		}
+		binary.Write(c, be, struct {
+			Magic, Errno uint32
+			Cookie       uint64
+		}{nbdReplyMagic, errno, req.Cookie})
+		if errno == 0 {
+			c.Write(data[req.Offset : req.Offset+uint64(req.Length)])
+		}
+	}
+}
+
+func TestNBDClient(t *testing.T) {
+	data := make([]byte, nbdMaxRead+5000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	for _, optGo := range []bool{true, false} {
+		client, server := net.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			done <- fakeNBDServer(server, data, optGo)
+		}()
+		c, err := NewNBDClient(client, "disk")
+		if err != nil {
+			t.Fatalf("NewNBDClient (NBD_OPT_GO %v) failed: %v", optGo, err)
+		}
+		if c.Size() != int64(len(data)) {
+			t.Errorf("Size = %d, want %d", c.Size(), len(data))
+		}
+		// Reads are split and stop at the end of the export.
+		got := make([]byte, len(data))
+		if n, err := c.ReadAt(got, 10); n != len(data)-10 || err != io.EOF {
+			t.Errorf("ReadAt = %d, %v", n, err)
+		}
+		if !bytes.Equal(got[:len(data)-10], data[10:]) {
+			t.Error("data read differs")
+		}
+		if err := c.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("server failed: %v", err)
+		}
+	}
+}
+
+func TestNBDClientErrors(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go fakeNBDServer(server, make([]byte, 4096), true)
+	if _, err := NewNBDClient(client, "other"); err == nil {
+		t.Error("unknown export opened")
+	}
+
+	// Errors of reads are reported as NBDError.
+	client, server = net.Pipe()
+	go fakeNBDServer(server, make([]byte, 4096), true)
+	c, err := NewNBDClient(client, "disk")
+	if err != nil {
+		t.Fatalf("NewNBDClient failed: %v", err)
+	}
+	defer c.Close()
+	c.size = 8192 // past the end of the export
+	var nerr *NBDError
+	if _, err := c.ReadAt(make([]byte, 10), 4090); !errors.As(err, &nerr) || nerr.Errno != 22 {
+		t.Errorf("ReadAt past the export = %v", err)
+	}
+}
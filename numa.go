@@ -0,0 +1,126 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// From <linux/mempolicy.h>.
+const mpolMFMove = 1 << 1
+
+// WithNUMAPlacement migrates each page filled by the region to the NUMA
+// node of the CPU the faulting thread last ran on, right after it is
+// filled. Needs UFFD_FEATURE_THREAD_ID, faults without a thread ID are left
+// where the kernel placed them.
+func WithNUMAPlacement() RegionOption {
+	return func(r *Region) {
+		r.numa = true
+	}
+}
+
+// cpuNodes maps CPUs to their NUMA node, from sysfs.
+var cpuNodes = sync.OnceValues(func() (map[int]int, error) {
+	links, err := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/node[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[int]int, len(links))
+	for _, link := range links {
+		cpu, err1 := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(link)), "cpu"))
+		node, err2 := strconv.Atoi(strings.TrimPrefix(filepath.Base(link), "node"))
+		if err := errors.Join(err1, err2); err != nil {
+			return nil, err
+		}
+		nodes[cpu] = node
+	}
+	return nodes, nil
+})
+
+// ThreadNode returns the NUMA node of the CPU that thread tid of the
+// calling process last ran on.
+func ThreadNode(tid int) (int, error) {
+	cpu, err := threadCPU(tid)
+	if err != nil {
+		return 0, err
+	}
+	nodes, err := cpuNodes()
+	if err != nil {
+		return 0, err
+	}
+	node, ok := nodes[cpu]
+	if !ok {
+		return 0, fmt.Errorf("no NUMA node for CPU %d", cpu)
+	}
+	return node, nil
+}
+
+// threadCPU returns the processor field of /proc/self/task/<tid>/stat.
+func threadCPU(tid int) (int, error) {
+	data, err := os.ReadFile("/proc/self/task/" + strconv.Itoa(tid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces, fields are counted after it.
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, errors.New("malformed stat")
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	const processor = 39 - 3 // field 39, the first after the name is 3
+	if len(fields) <= processor {
+		return 0, errors.New("malformed stat")
+	}
+	return strconv.Atoi(fields[processor])
+}
+
+// MovePages migrates the pages containing addrs of the calling process to
+// the given NUMA node, as move_pages(2).
+func MovePages(addrs []uintptr, node int) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	nodes := make([]int32, len(addrs))
+	status := make([]int32, len(addrs))
+	for i := range nodes {
+		nodes[i] = int32(node)
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_MOVE_PAGES, 0, uintptr(len(addrs)),
+		uintptr(unsafe.Pointer(&addrs[0])), uintptr(unsafe.Pointer(&nodes[0])),
+		uintptr(unsafe.Pointer(&status[0])), mpolMFMove)
+	if errno != 0 {
+		return os.NewSyscallError("move_pages", errno)
+	}
+	for i, st := range status {
+		// Negative errno if the page could not be moved, otherwise its node.
+		if st < 0 {
+			return fmt.Errorf("move page %#x: %w", addrs[i], unix.Errno(-st))
+		}
+	}
+	return nil
+}
+
+// place moves page to the node of thread tid, ignoring failures: the page
+// is usable wherever it is.
+func (r *Region) place(page uintptr, tid uint32) {
+	if tid == 0 {
+		return
+	}
+	node, err := ThreadNode(int(tid))
+	if err != nil {
+		return
+	}
+	MovePages([]uintptr{page}, node)
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestThreadNode(t *testing.T) {
+	nodes, err := cpuNodes()
+	if err != nil || len(nodes) == 0 {
+		t.Skipf("no NUMA topology: %v", err)
+	}
+
+	node, err := ThreadNode(unix.Gettid())
+	if err != nil {
+		t.Fatalf("ThreadNode failed: %v", err)
+	}
+
+	mem, err := unix.Mmap(-1, 0, unix.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+	mem[0] = 1
+
+	if err := MovePages([]uintptr{uintptr(unsafe.Pointer(&mem[0]))}, node); err != nil {
+		t.Fatalf("MovePages failed: %v", err)
+	}
+}
+
+func TestRegionNUMAPlacement(t *testing.T) {
+	if nodes, err := cpuNodes(); err != nil || len(nodes) == 0 {
+		t.Skipf("no NUMA topology: %v", err)
+	}
+	needProcs(t, 2)
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, UFFD_FEATURE_THREAD_ID)
+	if err != nil {
+		t.Skipf("New with UFFD_FEATURE_THREAD_ID failed: %v", err)
+	}
+	defer uffd.Close()
+
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, 2*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+
+	r, err := uffd.RegisterRegion(mem, UFFDIO_REGISTER_MODE_MISSING, WithProvider(patternProvider(2)), WithNUMAPlacement())
+	if err != nil {
+		t.Fatalf("RegisterRegion failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+
+	if mem[0] != 1 || mem[pageSize] != 2 {
+		t.Errorf("got %d and %d, want 1 and 2", mem[0], mem[pageSize])
+	}
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default size of the ranges of an OCIBlob fetched at once.
+const defaultBlobChunkSize = 1 << 20
+
+// OCIBlobOption configures an OCIBlob.
+type OCIBlobOption func(*OCIBlob)
+
+// WithHTTPClient fetches the blob with c instead of http.DefaultClient.
+func WithHTTPClient(c *http.Client) OCIBlobOption {
+	return func(b *OCIBlob) { b.client = c }
+}
+
+// WithRegistryToken authenticates to the registry with a bearer token.
+func WithRegistryToken(token string) OCIBlobOption {
+	return func(b *OCIBlob) { b.token = token }
+}
+
+// WithChunkSize fetches the blob in ranges of n bytes, rounded up to a
+// multiple of the page size, instead of 1 MiB.
+func WithChunkSize(n int64) OCIBlobOption {
+	return func(b *OCIBlob) {
+		if n > 0 {
+			b.chunkSize = int64(RoundUp(uintptr(n), uintptr(os.Getpagesize())))
+		}
+	}
+}
+
+// OCIBlob is a PageProvider fetching a memory snapshot stored as a blob of
+// an OCI registry lazily, as a remote snapshotter does for container
+// images: the ranges of the blob holding the pages faulted are fetched on
+// demand with HTTP range requests and cached in a local file, so that a
+// container or VM can be restored before the snapshot is downloaded.
+// Download fetches the rest meanwhile and checks the digest of the blob,
+// failing the reads that follow if it does not match. It is safe for
+// concurrent use.
+//
+// The cache file is written at the offsets of the blob. Which ranges were
+// fetched is only known to the OCIBlob.
+type OCIBlob struct {
+	url       string
+	digest    string
+	size      int64
+	cache     *os.File
+	client    *http.Client
+	token     string
+	chunkSize int64
+
+	mu       sync.Mutex
+	fetched  Bitmap                  // chunks cached
+	inflight map[int64]chan struct{} // chunks being fetched, closed when done
+	bytes    int64                   // fetched
+	err      error                   // of the digest check, failing the reads
+}
+
+// NewOCIBlob returns an OCIBlob for the blob of the given digest, such as
+// "sha256:...", and size in the repository of the registry, such as
+// "https://registry.example.com", caching it in cache.
+func NewOCIBlob(registry, repository, digest string, size int64, cache *os.File, opts ...OCIBlobOption) (*OCIBlob, error) {
+	if _, _, err := blobHash(digest); err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid blob size %d", size)
+	}
+	b := &OCIBlob{
+		url:       strings.TrimSuffix(registry, "/") + "/v2/" + repository + "/blobs/" + digest,
+		digest:    digest,
+		size:      size,
+		cache:     cache,
+		client:    http.DefaultClient,
+		chunkSize: defaultBlobChunkSize,
+		inflight:  make(map[int64]chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.fetched = NewBitmap(int((size + b.chunkSize - 1) / b.chunkSize))
+	return b, nil
+}
+
+// blobHash returns the hash of the algorithm of a digest, and the digest
+// value.
+func blobHash(digest string) (hash.Hash, []byte, error) {
+	alg, value, _ := strings.Cut(digest, ":")
+	var h hash.Hash
+	switch alg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, nil, fmt.Errorf("unsupported digest %q", digest)
+	}
+	sum, err := hex.DecodeString(value)
+	if err != nil || len(sum) != h.Size() {
+		return nil, nil, fmt.Errorf("invalid digest %q", digest)
+	}
+	return h, sum, nil
+}
+
+// Size returns the size of the blob.
+func (b *OCIBlob) Size() int64 {
+	return b.size
+}
+
+// Fetched returns the number of bytes of the blob fetched so far.
+func (b *OCIBlob) Fetched() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bytes
+}
+
+// ReadAt implements PageProvider.
+func (b *OCIBlob) ReadAt(p []byte, off int64) (int, error) {
+	return b.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext implements ContextPageProvider, fetching the ranges of the
+// blob not cached yet.
+func (b *OCIBlob) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), b.size)
+	for chunk := off / b.chunkSize; chunk*b.chunkSize < end; chunk++ {
+		if err := b.fetch(ctx, chunk); err != nil {
+			return 0, err
+		}
+	}
+	n, err := b.cache.ReadAt(p[:end-off], off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Download fetches the ranges of the blob not cached yet, in order, then
+// checks the digest of the blob.
+func (b *OCIBlob) Download(ctx context.Context) error {
+	for chunk := int64(0); chunk*b.chunkSize < b.size; chunk++ {
+		if err := b.fetch(ctx, chunk); err != nil {
+			return err
+		}
+	}
+	h, sum, _ := blobHash(b.digest)
+	if _, err := io.Copy(h, io.NewSectionReader(b.cache, 0, b.size)); err != nil {
+		return err
+	}
+	if got := h.Sum(nil); string(got) != string(sum) {
+		// The pages cached cannot be trusted anymore.
+		err := fmt.Errorf("blob %s has digest %x", b.digest, got)
+		b.mu.Lock()
+		b.err = err
+		b.fetched = NewBitmap(b.fetched.Len())
+		b.bytes = 0
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// fetch caches a chunk, unless it is or another fetch of it completes.
+func (b *OCIBlob) fetch(ctx context.Context, chunk int64) error {
+	for {
+		b.mu.Lock()
+		if b.err != nil {
+			b.mu.Unlock()
+			return b.err
+		}
+		if b.fetched.Test(int(chunk)) {
+			b.mu.Unlock()
+			return nil
+		}
+		done, busy := b.inflight[chunk]
+		if !busy {
+			done = make(chan struct{})
+			b.inflight[chunk] = done
+		}
+		b.mu.Unlock()
+		if !busy {
+			break
+		}
+		select {
+		case <-done:
+			// Try again if that fetch failed.
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	n, err := b.get(ctx, chunk)
+	b.mu.Lock()
+	if err == nil && b.err != nil {
+		err = b.err
+	}
+	if err == nil {
+		b.fetched.Set(int(chunk))
+		b.bytes += n
+	}
+	close(b.inflight[chunk])
+	delete(b.inflight, chunk)
+	b.mu.Unlock()
+	return err
+}
+
+// get fetches a chunk into the cache and returns its length.
+func (b *OCIBlob) get(ctx context.Context, chunk int64) (int64, error) {
+	start := chunk * b.chunkSize
+	length := min(b.chunkSize, b.size-start)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		// The registry may serve another range than the one asked for.
+		want := fmt.Sprintf("bytes %d-%d/", start, start+length-1)
+		cr := resp.Header.Get("Content-Range")
+		if total, ok := strings.CutPrefix(cr, want); !ok || total != "*" && total != strconv.FormatInt(b.size, 10) {
+			return 0, fmt.Errorf("fetching %s bytes %d-%d: got Content-Range %q", b.url, start, start+length-1, cr)
+		}
+	case resp.StatusCode == http.StatusOK && length == b.size:
+		// The whole blob was asked for.
+	default:
+		return 0, fmt.Errorf("fetching %s bytes %d-%d: %s", b.url, start, start+length-1, resp.Status)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return 0, fmt.Errorf("fetching %s bytes %d-%d: %w", b.url, start, start+length-1, err)
+	}
+	if _, err := b.cache.WriteAt(buf, start); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
@@ -0,0 +1,156 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blobRegistry serves a blob of a repository with range requests, counting
+// them, whatever the digest asked for.
+func blobRegistry(t *testing.T, repo string, blob []byte) (url, digest string, requests *atomic.Int64) {
+	t.Helper()
+	digest = fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	requests = new(atomic.Int64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v2/"+repo+"/blobs/sha256:") || r.Header.Get("Authorization") != "Bearer secret" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, digest, requests
+}
+
+func TestOCIBlob(t *testing.T) {
+	pageSize := os.Getpagesize()
+	blob := make([]byte, 10*pageSize+100)
+	for i := range blob {
+		blob[i] = byte(i / pageSize)
+	}
+	url, digest, requests := blobRegistry(t, "vm/snapshot", blob)
+	cache, err := os.Create(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	b, err := NewOCIBlob(url+"/", "vm/snapshot", digest, int64(len(blob)), cache,
+		WithRegistryToken("secret"), WithChunkSize(int64(4*pageSize)))
+	if err != nil {
+		t.Fatalf("NewOCIBlob failed: %v", err)
+	}
+	if b.Size() != int64(len(blob)) {
+		t.Errorf("Size = %d, want %d", b.Size(), len(blob))
+	}
+
+	// Only the chunk holding the page read is fetched, once.
+	page := make([]byte, pageSize)
+	for range 2 {
+		if _, err := b.ReadAt(page, int64(5*pageSize)); err != nil {
+			t.Fatalf("ReadAt failed: %v", err)
+		}
+	}
+	if !bytes.Equal(page, blob[5*pageSize:6*pageSize]) {
+		t.Error("page 5 differs")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+	if got, want := b.Fetched(), int64(4*pageSize); got != want {
+		t.Errorf("Fetched = %d, want %d", got, want)
+	}
+
+	// The last page is short.
+	n, err := b.ReadAt(page, int64(10*pageSize))
+	if n != 100 || err != io.EOF || !bytes.Equal(page[:n], blob[10*pageSize:]) {
+		t.Errorf("ReadAt of the last page = %d, %v", n, err)
+	}
+	if _, err := b.ReadAt(page, int64(len(blob))); err != io.EOF {
+		t.Errorf("ReadAt past the end: %v, want io.EOF", err)
+	}
+
+	if err := b.Download(context.Background()); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests after Download, want 3", n)
+	}
+	if b.Fetched() != int64(len(blob)) {
+		t.Errorf("Fetched = %d after Download, want %d", b.Fetched(), len(blob))
+	}
+	cached, err := os.ReadFile(cache.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, blob) {
+		t.Error("cache differs from the blob")
+	}
+}
+
+func TestOCIBlobErrors(t *testing.T) {
+	blob := []byte("snapshot")
+	url, digest, _ := blobRegistry(t, "vm", blob)
+	cache, err := os.Create(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	for _, d := range []string{"md5:00", "sha256:00", "sha256"} {
+		if _, err := NewOCIBlob(url, "vm", d, 8, cache); err == nil {
+			t.Errorf("expected error on digest %q", d)
+		}
+	}
+
+	// Without the token the blob is not found.
+	b, err := NewOCIBlob(url, "vm", digest, int64(len(blob)), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAt(make([]byte, 4), 0); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("ReadAt without token: %v, want 404", err)
+	}
+
+	// A blob not matching its digest fails to download.
+	wrong := strings.Replace(digest, digest[len(digest)-4:], "0000", 1)
+	b, err = NewOCIBlob(url, "vm", wrong, int64(len(blob)), cache, WithRegistryToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Download(context.Background()); err == nil {
+		t.Error("expected error on a wrong digest")
+	}
+	if _, err := b.ReadAt(make([]byte, 4), 0); err == nil || b.Fetched() != 0 {
+		t.Errorf("ReadAt after a wrong digest: %v, %d bytes fetched", err, b.Fetched())
+	}
+
+	// A registry serving another range than the one asked for is caught.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-3/%d", len(blob)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(blob[:4])
+	}))
+	defer srv.Close()
+	b, err = NewOCIBlob(srv.URL, "vm", digest, int64(len(blob)), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAt(make([]byte, 4), 0); err == nil || !strings.Contains(err.Error(), "Content-Range") {
+		t.Errorf("ReadAt of another range: %v, want a Content-Range error", err)
+	}
+}
@@ -0,0 +1,113 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"iter"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// pageHashes holds the hashes of the pages of a region.
+type pageHashes struct {
+	sums  []atomic.Uint64
+	known Bitmap // pages whose sum is up to date, updated atomically
+	zero  uint64 // sum of a zero page
+}
+
+// WithPageHashes keeps an xxHash64 of every page of the region, computed
+// as it is filled and as it is written back, so that dedup, delta sync or
+// integrity audits need not rescan its memory. Writes are only seen with
+// write-back or a read-only policy: pages written to lose their hash until
+// written back. Pages resolved by minor faults are hashed if mapped by
+// MapFileMinor. As with Filled, the hashes of pages dropped by madvise(2)
+// are only forgotten with UFFD_FEATURE_EVENT_REMOVE.
+func WithPageHashes() RegionOption {
+	return func(r *Region) {
+		n := (len(r.mem) + r.pageSize - 1) / r.pageSize
+		r.hashes = &pageHashes{
+			sums:  make([]atomic.Uint64, n),
+			known: NewBitmap(n),
+			zero:  xxhash.Sum64(make([]byte, r.pageSize)),
+		}
+	}
+}
+
+// PageHash returns the xxHash64 of the page at off in the region, as last
+// filled or written back, and whether it is known.
+func (r *Region) PageHash(off int) (uint64, bool) {
+	h := r.hashes
+	if h == nil || off < 0 || off >= len(r.mem) {
+		return 0, false
+	}
+	i := off / r.pageSize
+	sum := h.sums[i].Load()
+	return sum, h.known.testAtomic(i)
+}
+
+// PageHashes returns an iterator over the known hashes of the pages of the
+// region, by offset.
+func (r *Region) PageHashes() iter.Seq2[int, uint64] {
+	return func(yield func(int, uint64) bool) {
+		h := r.hashes
+		if h == nil {
+			return
+		}
+		for i := range h.sums {
+			if h.known.testAtomic(i) && !yield(i*r.pageSize, h.sums[i].Load()) {
+				return
+			}
+		}
+	}
+}
+
+// hashPage records the hash of the contents of page, or of a zero page if
+// data is nil.
+func (r *Region) hashPage(page uintptr, data []byte) {
+	r.setHash(page, r.pageSum(data))
+}
+
+// pageSum returns the hash of data, or of a zero page if nil.
+func (r *Region) pageSum(data []byte) uint64 {
+	h := r.hashes
+	switch {
+	case h == nil:
+		return 0
+	case data == nil:
+		return h.zero
+	}
+	return xxhash.Sum64(data)
+}
+
+// setHash records sum as the hash of page.
+func (r *Region) setHash(page uintptr, sum uint64) {
+	if h := r.hashes; h != nil {
+		i := r.pageIndex(page)
+		h.sums[i].Store(sum)
+		h.known.setAtomic(i)
+	}
+}
+
+// unhash forgets the hashes of a range, which may extend beyond the region.
+func (r *Region) unhash(start uintptr, length int) {
+	end := min(start+uintptr(length), r.Base()+uintptr(len(r.mem)))
+	start = max(start, r.Base())
+	if r.hashes == nil || start >= end {
+		return
+	}
+	r.hashes.known.clearRangeAtomic(r.pageIndex(start), r.pageIndex(end-1)+1)
+}
+
+// hashContinued records the hash of page, resolved by a minor fault, from
+// the populate view of MapFileMinor, which does not fault.
+func (r *Region) hashContinued(page uintptr) {
+	if r.alias == nil {
+		r.unhash(page, r.pageSize)
+		return
+	}
+	off := page - r.Base()
+	r.hashPage(page, r.alias[off:off+uintptr(r.pageSize)])
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/sys/unix"
+)
+
+func TestRegionPageHashes(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	w := &recordingWriter{data: make([]byte, 4*pageSize)}
+	r := serveWriteProtected(t, 4, WithWriteBack(w, WriteBackPolicy{}), WithPageHashes())
+	mem := r.Bytes()
+
+	if _, ok := r.PageHash(0); ok {
+		t.Error("hash of a page not filled yet")
+	}
+	want := make(map[int]uint64)
+	for _, i := range []int{0, 2} {
+		want[i*pageSize] = xxhash.Sum64(mem[i*pageSize : (i+1)*pageSize])
+	}
+	// The faulter may be woken before the hash is recorded.
+	waitFor(t, func() bool { return maps.Equal(maps.Collect(r.PageHashes()), want) })
+
+	// Writes forget the hash until written back.
+	mem[2*pageSize] = 0xEE
+	if _, ok := r.PageHash(2 * pageSize); ok {
+		t.Error("hash of a written page")
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	sum, ok := r.PageHash(2 * pageSize)
+	if want := xxhash.Sum64(mem[2*pageSize : 3*pageSize]); !ok || sum != want {
+		t.Errorf("PageHash after Flush = %#x %v, want %#x", sum, ok, want)
+	}
+
+}
@@ -0,0 +1,16 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+// PageHooks are called on the lifecycle events of the pages of a region,
+// with their offset and length in the region, so that caches, trackers or
+// replicators can follow them without their own serving loop. Hooks run on
+// the goroutine resolving the fault or doing the work, so they must be
+// quick, safe for concurrent use and must not access the region's memory.
+// Nil hooks are skipped.
+type PageHooks struct {
+	OnFill   func(off int)         // Page filled, from the provider or with zeros, or continued
+	OnEvict  func(off, length int) // Pages released by the Evictor
+	OnFlush  func(off, length int) // Pages written back by a flush
+	OnPoison func(off int)         // Page poisoned, by a read-only policy or a Server watchdog
+}
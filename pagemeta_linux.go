@@ -0,0 +1,56 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+// WithPageHooks sets hooks called on the lifecycle events of the pages of
+// the region.
+func WithPageHooks(h PageHooks) RegionOption {
+	return func(r *Region) {
+		r.hooks = h
+	}
+}
+
+// SetPageMeta attaches v to the page at off in the region, replacing any
+// previous value, or detaches it if v is nil. Values are kept whatever
+// happens to the page, until detached. It is safe for concurrent use.
+func (r *Region) SetPageMeta(off int, v any) {
+	i := off / r.pageSize
+	if v == nil {
+		r.meta.Delete(i)
+		return
+	}
+	r.meta.Store(i, v)
+}
+
+// PageMeta returns the value attached to the page at off in the region,
+// or nil.
+func (r *Region) PageMeta(off int) any {
+	v, _ := r.meta.Load(off / r.pageSize)
+	return v
+}
+
+func (r *Region) onFill(page uintptr) {
+	if fn := r.hooks.OnFill; fn != nil {
+		fn(int(page - r.Base()))
+	}
+}
+
+func (r *Region) onEvict(start uintptr, length int) {
+	if fn := r.hooks.OnEvict; fn != nil {
+		fn(int(start-r.Base()), length)
+	}
+}
+
+func (r *Region) onFlush(off, length int) {
+	if fn := r.hooks.OnFlush; fn != nil {
+		fn(off, length)
+	}
+}
+
+func (r *Region) onPoison(page uintptr) {
+	if fn := r.hooks.OnPoison; fn != nil {
+		fn(int(page - r.Base()))
+	}
+}
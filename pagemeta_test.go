@@ -0,0 +1,112 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// hookLog records the calls of PageHooks.
+type hookLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *hookLog) hooks() PageHooks {
+	add := func(ev string) {
+		l.mu.Lock()
+		l.events = append(l.events, ev)
+		l.mu.Unlock()
+	}
+	pages := func(off int) string { return string(rune('0' + off/unix.Getpagesize())) }
+	return PageHooks{
+		OnFill:   func(off int) { add("fill " + pages(off)) },
+		OnEvict:  func(off, length int) { add("evict " + pages(off) + "+" + pages(length)) },
+		OnFlush:  func(off, length int) { add("flush " + pages(off) + "+" + pages(length)) },
+		OnPoison: func(off int) { add("poison " + pages(off)) },
+	}
+}
+
+// wait waits for n events to be recorded and returns them.
+func (l *hookLog) wait(t *testing.T, n int) []string {
+	t.Helper()
+	waitFor(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.events) >= n
+	})
+	return l.take()
+}
+
+func (l *hookLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events
+	l.events = nil
+	return events
+}
+
+func TestPageHooks(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	var log hookLog
+	mem, r := serveRegion(t, 3, WithProvider(patternProvider(3)), WithPageHooks(log.hooks()))
+	e := NewEvictor(r, nil)
+
+	if mem[0] != 1 || mem[2*pageSize] != 3 {
+		t.Fatal("pages not filled from provider")
+	}
+	// The faulting thread may be woken up before the hook is called.
+	if got := log.wait(t, 2); !slices.Equal(got, []string{"fill 0", "fill 2"}) {
+		t.Errorf("events = %q", got)
+	}
+	waitFor(t, func() bool { return e.Resident() == 2 })
+	if _, err := e.Evict(1); err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if got := log.take(); !slices.Equal(got, []string{"evict 0+1"}) {
+		t.Errorf("events = %q", got)
+	}
+}
+
+func TestPageHooksFlush(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	var log hookLog
+	w := &recordingWriter{data: make([]byte, 4*pageSize)}
+	r := serveWriteProtected(t, 4, WithWriteBack(w, WriteBackPolicy{}), WithPageHooks(log.hooks()))
+
+	r.Bytes()[pageSize] = 1
+	r.Bytes()[2*pageSize] = 1
+	log.wait(t, 2)
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := log.take(); !slices.Equal(got, []string{"flush 1+2"}) {
+		t.Errorf("events = %q", got)
+	}
+}
+
+func TestPageMeta(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	_, r := serveRegion(t, 2)
+
+	if r.PageMeta(0) != nil {
+		t.Error("unset metadata not nil")
+	}
+	r.SetPageMeta(pageSize+10, "hot")
+	if got := r.PageMeta(pageSize); got != "hot" {
+		t.Errorf("PageMeta = %v, want hot", got)
+	}
+	if r.PageMeta(0) != nil {
+		t.Error("metadata attached to the wrong page")
+	}
+	r.SetPageMeta(pageSize, nil)
+	if r.PageMeta(pageSize) != nil {
+		t.Error("metadata not detached")
+	}
+}
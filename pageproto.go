@@ -0,0 +1,410 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// The page-transfer protocol serves ranges of a source over a stream
+// connection. Both sides start by sending pageProtoMagic, followed from the
+// server by the size of the source. Then the client sends requests, each
+// the offset and length of a range, and the server answers them in order,
+// each with the number of bytes read and the length of an error message,
+// followed by the bytes and the message. Fewer bytes than requested with
+// no error means the end of the source. Integers are little endian.
+const pageProtoMagic = "UFFDPGS1"
+
+// MaxPageRequest is the largest range that can be requested at once.
+const MaxPageRequest = 1 << 20
+
+// requestBufs holds the buffers of the connections of PageServers.
+var requestBufs = newStagingPool(MaxPageRequest, false)
+
+type pageRequest struct {
+	Offset uint64
+	Length uint32
+}
+
+type pageResponse struct {
+	N      uint32
+	ErrLen uint32
+}
+
+// PageServer serves a source to PageClients with the page-transfer
+// protocol.
+type PageServer struct {
+	src  io.ReaderAt
+	size int64
+
+	conns    chan struct{} // slots for connections, nil if unlimited
+	inflight chan struct{} // slots for reads, nil if unlimited
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	active    map[net.Conn]struct{}
+	closed    bool
+	done      chan struct{} // closed by Close
+	wg        sync.WaitGroup
+
+	stats struct {
+		conns, requests, bytes, errors atomic.Uint64
+	}
+}
+
+// PageServerStats holds the counters of a PageServer.
+type PageServerStats struct {
+	Conns    uint64 // Connections accepted
+	Active   int    // Connections open
+	Requests uint64 // Ranges requested
+	Bytes    uint64 // Bytes sent
+	Errors   uint64 // Reads that failed
+}
+
+// NewPageServer returns a PageServer of size bytes read from src. It
+// accepts at most maxConns connections at once and runs at most
+// maxInflight reads of src at once. Zero means no limit.
+func NewPageServer(src io.ReaderAt, size int64, maxConns, maxInflight int) *PageServer {
+	s := &PageServer{
+		src:       src,
+		size:      size,
+		listeners: make(map[net.Listener]struct{}),
+		active:    make(map[net.Conn]struct{}),
+		done:      make(chan struct{}),
+	}
+	if maxConns > 0 {
+		s.conns = make(chan struct{}, maxConns)
+	}
+	if maxInflight > 0 {
+		s.inflight = make(chan struct{}, maxInflight)
+	}
+	return s
+}
+
+// Serve accepts connections on l and serves each in its own goroutine until
+// l fails or the server is closed, in which case it returns nil. Accepting
+// waits while there are maxConns connections.
+func (s *PageServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		if s.conns != nil {
+			select {
+			case s.conns <- struct{}{}:
+			case <-s.done:
+				return nil
+			}
+		}
+		c, err := l.Accept()
+		if err != nil {
+			if s.conns != nil {
+				<-s.conns
+			}
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return nil
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(c)
+			if s.conns != nil {
+				<-s.conns
+			}
+		}()
+	}
+}
+
+// ServeConn serves the requests of a single connection until the client
+// closes it, and closes it.
+func (s *PageServer) ServeConn(c net.Conn) error {
+	defer c.Close()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.active[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.active, c)
+		s.mu.Unlock()
+	}()
+	s.stats.conns.Add(1)
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	if err := readMagic(r); err != nil {
+		return err
+	}
+	w.WriteString(pageProtoMagic)
+	binary.Write(w, binary.LittleEndian, uint64(s.size))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	bufp := requestBufs.Get()
+	defer requestBufs.Put(bufp)
+	buf := *bufp
+	for {
+		var req pageRequest
+		if err := binary.Read(r, binary.LittleEndian, &req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := s.serveRequest(w, req, buf); err != nil {
+			return err
+		}
+		// Answer pipelined requests together.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *PageServer) serveRequest(w *bufio.Writer, req pageRequest, buf []byte) error {
+	s.stats.requests.Add(1)
+
+	var rerr error
+	n := 0
+	switch {
+	case req.Length > MaxPageRequest:
+		rerr = fmt.Errorf("request of %d bytes larger than %d", req.Length, MaxPageRequest)
+	case int64(req.Offset) < 0:
+		rerr = fmt.Errorf("invalid offset %d", req.Offset)
+	case int64(req.Offset) < s.size:
+		buf = buf[:min(int64(req.Length), s.size-int64(req.Offset))]
+		if s.inflight != nil {
+			s.inflight <- struct{}{}
+		}
+		n, rerr = s.src.ReadAt(buf, int64(req.Offset))
+		if s.inflight != nil {
+			<-s.inflight
+		}
+		if rerr == io.EOF {
+			rerr = nil
+		}
+	}
+
+	var msg string
+	if rerr != nil {
+		s.stats.errors.Add(1)
+		msg = rerr.Error()
+		n = 0
+	}
+	s.stats.bytes.Add(uint64(n))
+	resp := pageResponse{N: uint32(n), ErrLen: uint32(len(msg))}
+	if err := binary.Write(w, binary.LittleEndian, &resp); err != nil {
+		return err
+	}
+	w.Write(buf[:n])
+	_, err := w.WriteString(msg)
+	return err
+}
+
+// Stats returns a snapshot of the server counters.
+func (s *PageServer) Stats() PageServerStats {
+	s.mu.Lock()
+	active := len(s.active)
+	s.mu.Unlock()
+	return PageServerStats{
+		Conns:    s.stats.conns.Load(),
+		Active:   active,
+		Requests: s.stats.requests.Load(),
+		Bytes:    s.stats.bytes.Load(),
+		Errors:   s.stats.errors.Load(),
+	}
+}
+
+// Close closes the listeners and connections, and waits for the
+// connections to be done.
+func (s *PageServer) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	var errs []error
+	for l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	for c := range s.active {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
+
+func readMagic(r io.Reader) error {
+	var magic [len(pageProtoMagic)]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return err
+	}
+	if string(magic[:]) != pageProtoMagic {
+		return errors.New("not a page-transfer protocol peer")
+	}
+	return nil
+}
+
+// PageClient is a PageProvider reading from a PageServer. Reads are sent
+// one at a time over its connection: use several clients for parallel
+// reads.
+type PageClient struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	size int64
+	err  error // set once the stream is out of sync
+}
+
+// DialPages connects to a PageServer, see net.Dial.
+func DialPages(network, address string) (*PageClient, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := NewPageClient(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// NewPageClient performs the protocol handshake on an established
+// connection, which the client then owns.
+func NewPageClient(c net.Conn) (*PageClient, error) {
+	pc := &PageClient{conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+	pc.w.WriteString(pageProtoMagic)
+	if err := pc.w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := readMagic(pc.r); err != nil {
+		return nil, err
+	}
+	var size uint64
+	if err := binary.Read(pc.r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	pc.size = int64(size)
+	return pc, nil
+}
+
+// Size returns the size of the source served.
+func (c *PageClient) Size() int64 {
+	return c.size
+}
+
+// ReadAt reads len(p) bytes at off from the server, in as many requests as
+// needed. Errors reported by the server are returned as *PageServerError.
+// Any other error leaves the connection unusable, and is returned by all
+// the later calls.
+func (c *PageClient) ReadAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	// Send all requests at once and read the responses in order.
+	for done := 0; done < len(p); done += MaxPageRequest {
+		req := pageRequest{Offset: uint64(off) + uint64(done), Length: uint32(min(len(p)-done, MaxPageRequest))}
+		if err := binary.Write(c.w, binary.LittleEndian, &req); err != nil {
+			return 0, c.fail(err)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return 0, c.fail(err)
+	}
+
+	var n int
+	var rerr error
+	for done := 0; done < len(p); done += MaxPageRequest {
+		var resp pageResponse
+		if err := binary.Read(c.r, binary.LittleEndian, &resp); err != nil {
+			return n, c.fail(err)
+		}
+		want := min(len(p)-done, MaxPageRequest)
+		if int(resp.N) > want || resp.ErrLen > MaxPageRequest {
+			return n, c.fail(errors.New("invalid page-transfer response"))
+		}
+		if _, err := io.ReadFull(c.r, p[done:done+int(resp.N)]); err != nil {
+			return n, c.fail(err)
+		}
+		msg := make([]byte, resp.ErrLen)
+		if _, err := io.ReadFull(c.r, msg); err != nil {
+			return n, c.fail(err)
+		}
+		// Keep reading the responses to the remaining requests.
+		if rerr != nil {
+			continue
+		}
+		switch {
+		case resp.ErrLen > 0:
+			rerr = &PageServerError{Msg: string(msg)}
+		case int(resp.N) < want:
+			n += int(resp.N)
+			rerr = io.EOF
+		default:
+			n += int(resp.N)
+		}
+	}
+	return n, rerr
+}
+
+// fail marks the client unusable after err left requests or responses
+// partly sent or read, and returns err. It must be called with c.mu held.
+func (c *PageClient) fail(err error) error {
+	c.err = err
+	return err
+}
+
+// Close closes the connection.
+func (c *PageClient) Close() error {
+	return c.conn.Close()
+}
+
+// PageServerError is an error reported by a PageServer.
+type PageServerError struct {
+	Msg string
+}
+
+func (e *PageServerError) Error() string {
+	return "page server: " + e.Msg
+}
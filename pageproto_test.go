@@ -0,0 +1,131 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// servePages serves src on a unix socket until cleanup.
+func servePages(t *testing.T, src io.ReaderAt, size int64, maxConns, maxInflight int) (*PageServer, string) {
+	t.Helper()
+	addr := filepath.Join(t.TempDir(), "pages.sock")
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewPageServer(src, size, maxConns, maxInflight)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+	})
+	return s, addr
+}
+
+func TestPageClient(t *testing.T) {
+	data := make([]byte, 2*MaxPageRequest+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	s, addr := servePages(t, bytes.NewReader(data), int64(len(data)), 1, 1)
+
+	c, err := DialPages("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Size() != int64(len(data)) {
+		t.Errorf("Size() = %d, want %d", c.Size(), len(data))
+	}
+
+	buf := make([]byte, 4096)
+	if n, err := c.ReadAt(buf, 100); n != len(buf) || err != nil || !bytes.Equal(buf, data[100:100+len(buf)]) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+
+	// Larger than a request, and past the end.
+	big := make([]byte, len(data))
+	n, err := c.ReadAt(big, 50)
+	if n != len(data)-50 || err != io.EOF || !bytes.Equal(big[:n], data[50:]) {
+		t.Errorf("ReadAt past the end = %d, %v", n, err)
+	}
+	if n, err := c.ReadAt(buf, int64(len(data))); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v", n, err)
+	}
+
+	st := s.Stats()
+	if st.Conns != 1 || st.Active != 1 || st.Requests != 5 || st.Bytes != uint64(len(buf)+len(data)-50) {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("disk on fire")
+}
+
+func TestPageClientServerError(t *testing.T) {
+	s, addr := servePages(t, failingReader{}, 1<<20, 0, 0)
+	c, err := DialPages("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var serr *PageServerError
+	if _, err := c.ReadAt(make([]byte, 10), 0); !errors.As(err, &serr) || serr.Msg != "disk on fire" {
+		t.Errorf("ReadAt = %v", err)
+	}
+	// The connection is still usable.
+	if _, err := c.ReadAt(make([]byte, 10), 1<<20); err != io.EOF {
+		t.Errorf("ReadAt at the end = %v", err)
+	}
+	if st := s.Stats(); st.Errors != 1 {
+		t.Errorf("Stats().Errors = %d, want 1", st.Errors)
+	}
+}
+
+func TestPageClientBroken(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		if err := readMagic(server); err != nil {
+			return
+		}
+		server.Write([]byte(pageProtoMagic))
+		binary.Write(server, binary.LittleEndian, uint64(1<<20))
+		var req pageRequest
+		if err := binary.Read(server, binary.LittleEndian, &req); err != nil {
+			return
+		}
+		// More data than requested.
+		binary.Write(server, binary.LittleEndian, &pageResponse{N: req.Length + 1})
+	}()
+	c, err := NewPageClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.ReadAt(make([]byte, 10), 0)
+	if err == nil {
+		t.Fatal("ReadAt succeeded on an invalid response")
+	}
+	// Later reads do not resume in the middle of the stream.
+	if _, err2 := c.ReadAt(make([]byte, 10), 0); err2 != err {
+		t.Errorf("ReadAt after %v = %v", err, err2)
+	}
+}
@@ -0,0 +1,384 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"iter"
+	"math/bits"
+	"slices"
+)
+
+// PageSet is a compressed set of page indexes, in the manner of roaring
+// bitmaps: indexes are grouped in containers of 65536 consecutive pages,
+// each a sorted array while sparse or a bitmap once dense. It suits sets
+// of pages of large address spaces, such as the pages to transfer or
+// snapshot, where a Bitmap of every page would be mostly empty.
+//
+// The zero value is an empty set. It is not safe for concurrent use.
+type PageSet struct {
+	keys  []uint64 // sorted indexes of the containers, the upper bits
+	conts []*pageContainer
+}
+
+// Sparse containers of up to arrayMax indexes are arrays, smaller than
+// the bitmap of 8KiB.
+const (
+	containerBits  = 16
+	containerWords = 1 << containerBits / 64
+	arrayMax       = 4096
+)
+
+var errPageSetEncoding = errors.New("invalid page set encoding")
+
+type pageContainer struct {
+	array []uint16 // sorted, while words is nil
+	words []uint64
+	n     int
+}
+
+// NewPageSet returns a set of the given page indexes.
+func NewPageSet(pages ...uint64) *PageSet {
+	s := new(PageSet)
+	for _, i := range pages {
+		s.Add(i)
+	}
+	return s
+}
+
+func (s *PageSet) find(key uint64) (int, bool) {
+	return slices.BinarySearch(s.keys, key)
+}
+
+// Add adds page i and reports whether it was not in the set.
+func (s *PageSet) Add(i uint64) bool {
+	key := i >> containerBits
+	j, ok := s.find(key)
+	if !ok {
+		s.keys = slices.Insert(s.keys, j, key)
+		s.conts = slices.Insert(s.conts, j, &pageContainer{})
+	}
+	return s.conts[j].add(uint16(i))
+}
+
+// AddRange adds pages start to end excluded.
+func (s *PageSet) AddRange(start, end uint64) {
+	for i := start; i < end; i++ {
+		s.Add(i)
+	}
+}
+
+// Remove removes page i and reports whether it was in the set.
+func (s *PageSet) Remove(i uint64) bool {
+	j, ok := s.find(i >> containerBits)
+	if !ok || !s.conts[j].remove(uint16(i)) {
+		return false
+	}
+	if s.conts[j].n == 0 {
+		s.keys = slices.Delete(s.keys, j, j+1)
+		s.conts = slices.Delete(s.conts, j, j+1)
+	}
+	return true
+}
+
+// Contains reports whether page i is in the set.
+func (s *PageSet) Contains(i uint64) bool {
+	j, ok := s.find(i >> containerBits)
+	return ok && s.conts[j].contains(uint16(i))
+}
+
+// Len returns the number of pages in the set.
+func (s *PageSet) Len() int {
+	n := 0
+	for _, c := range s.conts {
+		n += c.n
+	}
+	return n
+}
+
+// Rank returns the number of pages in the set lower than i.
+func (s *PageSet) Rank(i uint64) int {
+	j, ok := s.find(i >> containerBits)
+	n := 0
+	for _, c := range s.conts[:j] {
+		n += c.n
+	}
+	if ok {
+		n += s.conts[j].rank(uint16(i))
+	}
+	return n
+}
+
+// Select returns the k-th lowest page of the set, counting from 0, and
+// false if the set has k pages or less.
+func (s *PageSet) Select(k int) (uint64, bool) {
+	if k < 0 {
+		return 0, false
+	}
+	for j, c := range s.conts {
+		if k < c.n {
+			return s.keys[j]<<containerBits | uint64(c.selectAt(k)), true
+		}
+		k -= c.n
+	}
+	return 0, false
+}
+
+// All returns the pages of the set in increasing order. The set must not
+// be modified meanwhile.
+func (s *PageSet) All() iter.Seq[uint64] {
+	return func(yield func(uint64) bool) {
+		for j, c := range s.conts {
+			high := s.keys[j] << containerBits
+			if !c.each(func(lo uint16) bool { return yield(high | uint64(lo)) }) {
+				return
+			}
+		}
+	}
+}
+
+// Union adds the pages of o.
+func (s *PageSet) Union(o *PageSet) {
+	for i := range o.All() {
+		s.Add(i)
+	}
+}
+
+// Intersect removes the pages not in o.
+func (s *PageSet) Intersect(o *PageSet) {
+	var remove []uint64
+	for i := range s.All() {
+		if !o.Contains(i) {
+			remove = append(remove, i)
+		}
+	}
+	for _, i := range remove {
+		s.Remove(i)
+	}
+}
+
+// Clone returns a copy of the set.
+func (s *PageSet) Clone() *PageSet {
+	c := &PageSet{keys: slices.Clone(s.keys), conts: make([]*pageContainer, len(s.conts))}
+	for j, pc := range s.conts {
+		c.conts[j] = &pageContainer{array: slices.Clone(pc.array), words: slices.Clone(pc.words), n: pc.n}
+	}
+	return c
+}
+
+// Bitmap returns the pages of the set lower than n as a Bitmap of n pages.
+func (s *PageSet) Bitmap(n int) Bitmap {
+	b := NewBitmap(n)
+	for i := range s.All() {
+		if i >= uint64(n) {
+			break
+		}
+		b.Set(int(i))
+	}
+	return b
+}
+
+// PageSet returns the bits set in b as a PageSet.
+func (b Bitmap) PageSet() *PageSet {
+	s := new(PageSet)
+	for w, word := range b.words {
+		for word != 0 {
+			s.Add(uint64(w*64 + bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+	return s
+}
+
+// MarshalBinary encodes the set as its number of containers followed by
+// each container: its index, its number of pages minus one and either the
+// sorted low 16 bits of its pages, or its bitmap of 1024 words if it has
+// more than 4096 pages. Integers are little endian.
+func (s *PageSet) MarshalBinary() ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(s.conts)))
+	for j, c := range s.conts {
+		buf = binary.LittleEndian.AppendUint64(buf, s.keys[j])
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(c.n-1))
+		if c.words == nil {
+			for _, lo := range c.array {
+				buf = binary.LittleEndian.AppendUint16(buf, lo)
+			}
+			continue
+		}
+		for _, w := range c.words {
+			buf = binary.LittleEndian.AppendUint64(buf, w)
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a set encoded by MarshalBinary.
+func (s *PageSet) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errPageSetEncoding
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	var ns PageSet
+	for range count {
+		if len(data) < 10 {
+			return errPageSetEncoding
+		}
+		key := binary.LittleEndian.Uint64(data)
+		n := int(binary.LittleEndian.Uint16(data[8:])) + 1
+		data = data[10:]
+		if len(ns.keys) > 0 && key <= ns.keys[len(ns.keys)-1] {
+			return errPageSetEncoding
+		}
+		c := &pageContainer{n: n}
+		if n <= arrayMax {
+			if len(data) < 2*n {
+				return errPageSetEncoding
+			}
+			c.array = make([]uint16, n)
+			for k := range c.array {
+				c.array[k] = binary.LittleEndian.Uint16(data[2*k:])
+				if k > 0 && c.array[k] <= c.array[k-1] {
+					return errPageSetEncoding
+				}
+			}
+			data = data[2*n:]
+		} else {
+			if len(data) < 8*containerWords {
+				return errPageSetEncoding
+			}
+			c.words = make([]uint64, containerWords)
+			count := 0
+			for k := range c.words {
+				c.words[k] = binary.LittleEndian.Uint64(data[8*k:])
+				count += bits.OnesCount64(c.words[k])
+			}
+			if count != n {
+				return errPageSetEncoding
+			}
+			data = data[8*containerWords:]
+		}
+		ns.keys = append(ns.keys, key)
+		ns.conts = append(ns.conts, c)
+	}
+	if len(data) != 0 {
+		return errPageSetEncoding
+	}
+	*s = ns
+	return nil
+}
+
+func (c *pageContainer) add(lo uint16) bool {
+	if c.words != nil {
+		w, bit := &c.words[lo/64], uint64(1)<<(lo%64)
+		if *w&bit != 0 {
+			return false
+		}
+		*w |= bit
+		c.n++
+		return true
+	}
+	k, ok := slices.BinarySearch(c.array, lo)
+	if ok {
+		return false
+	}
+	c.array = slices.Insert(c.array, k, lo)
+	c.n++
+	if c.n > arrayMax {
+		c.words = make([]uint64, containerWords)
+		for _, v := range c.array {
+			c.words[v/64] |= 1 << (v % 64)
+		}
+		c.array = nil
+	}
+	return true
+}
+
+func (c *pageContainer) remove(lo uint16) bool {
+	if c.words == nil {
+		k, ok := slices.BinarySearch(c.array, lo)
+		if !ok {
+			return false
+		}
+		c.array = slices.Delete(c.array, k, k+1)
+		c.n--
+		return true
+	}
+	w, bit := &c.words[lo/64], uint64(1)<<(lo%64)
+	if *w&bit == 0 {
+		return false
+	}
+	*w &^= bit
+	c.n--
+	if c.n <= arrayMax {
+		c.array = make([]uint16, 0, c.n)
+		c.each(func(v uint16) bool {
+			c.array = append(c.array, v)
+			return true
+		})
+		c.words = nil
+	}
+	return true
+}
+
+func (c *pageContainer) contains(lo uint16) bool {
+	if c.words != nil {
+		return c.words[lo/64]&(1<<(lo%64)) != 0
+	}
+	_, ok := slices.BinarySearch(c.array, lo)
+	return ok
+}
+
+// rank returns the number of pages lower than lo.
+func (c *pageContainer) rank(lo uint16) int {
+	if c.words == nil {
+		k, _ := slices.BinarySearch(c.array, lo)
+		return k
+	}
+	n := 0
+	for _, w := range c.words[:lo/64] {
+		n += bits.OnesCount64(w)
+	}
+	return n + bits.OnesCount64(c.words[lo/64]&(1<<(lo%64)-1))
+}
+
+// selectAt returns the k-th lowest page, with k < c.n.
+func (c *pageContainer) selectAt(k int) uint16 {
+	if c.words == nil {
+		return c.array[k]
+	}
+	for j, w := range c.words {
+		if n := bits.OnesCount64(w); k >= n {
+			k -= n
+			continue
+		}
+		for range k {
+			w &= w - 1
+		}
+		return uint16(j*64 + bits.TrailingZeros64(w))
+	}
+	panic("unreachable")
+}
+
+// each calls fn with the pages in increasing order until it returns false,
+// and reports whether it never did.
+func (c *pageContainer) each(fn func(uint16) bool) bool {
+	if c.words == nil {
+		for _, lo := range c.array {
+			if !fn(lo) {
+				return false
+			}
+		}
+		return true
+	}
+	for j, w := range c.words {
+		for w != 0 {
+			if !fn(uint16(j*64 + bits.TrailingZeros64(w))) {
+				return false
+			}
+			w &= w - 1
+		}
+	}
+	return true
+}
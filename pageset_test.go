@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPageSet(t *testing.T) {
+	var s PageSet
+	pages := []uint64{3, 70000, 1 << 40, 5, 65535, 65536}
+	for _, i := range pages {
+		if !s.Add(i) {
+			t.Fatalf("Add(%d) = false", i)
+		}
+	}
+	if s.Add(5) {
+		t.Error("Add of a present page = true")
+	}
+	want := slices.Sorted(slices.Values(pages))
+	if got := slices.Collect(s.All()); !slices.Equal(got, want) {
+		t.Fatalf("All = %v, want %v", got, want)
+	}
+	if s.Len() != len(pages) {
+		t.Errorf("Len = %d, want %d", s.Len(), len(pages))
+	}
+	for k, i := range want {
+		if r := s.Rank(i); r != k {
+			t.Errorf("Rank(%d) = %d, want %d", i, r, k)
+		}
+		if got, ok := s.Select(k); !ok || got != i {
+			t.Errorf("Select(%d) = %d, %v, want %d", k, got, ok, i)
+		}
+	}
+	if _, ok := s.Select(len(pages)); ok {
+		t.Error("Select beyond Len succeeded")
+	}
+	if !s.Remove(70000) || s.Remove(70000) || s.Contains(70000) || !s.Contains(65536) {
+		t.Error("Remove")
+	}
+	if !s.Remove(1<<40) || len(s.keys) != 2 {
+		t.Errorf("empty container kept: %v", s.keys)
+	}
+}
+
+func TestPageSetDense(t *testing.T) {
+	s := new(PageSet)
+	s.AddRange(100, 100+2*arrayMax)
+	if s.conts[0].words == nil {
+		t.Fatal("dense container is not a bitmap")
+	}
+	if s.Len() != 2*arrayMax || s.Rank(100+arrayMax) != arrayMax {
+		t.Errorf("Len = %d, Rank = %d", s.Len(), s.Rank(100+arrayMax))
+	}
+	if i, ok := s.Select(arrayMax + 1); !ok || i != 100+arrayMax+1 {
+		t.Errorf("Select = %d, %v", i, ok)
+	}
+	for i := uint64(100); i < 100+arrayMax+1; i++ {
+		s.Remove(i)
+	}
+	if s.conts[0].words != nil || s.Len() != arrayMax-1 || !s.Contains(100+2*arrayMax-1) {
+		t.Error("sparse container is not an array")
+	}
+}
+
+func TestPageSetOps(t *testing.T) {
+	a := NewPageSet(1, 2, 3, 1<<20)
+	b := NewPageSet(2, 3, 4)
+	u := a.Clone()
+	u.Union(b)
+	if got := slices.Collect(u.All()); !slices.Equal(got, []uint64{1, 2, 3, 4, 1 << 20}) {
+		t.Errorf("Union = %v", got)
+	}
+	a.Intersect(b)
+	if got := slices.Collect(a.All()); !slices.Equal(got, []uint64{2, 3}) {
+		t.Errorf("Intersect = %v", got)
+	}
+
+	bm := NewBitmap(130)
+	bm.Set(0)
+	bm.Set(64)
+	bm.Set(129)
+	s := bm.PageSet()
+	if got := slices.Collect(s.All()); !slices.Equal(got, []uint64{0, 64, 129}) {
+		t.Errorf("Bitmap.PageSet = %v", got)
+	}
+	s.Add(500)
+	if back := s.Bitmap(130); !slices.Equal(back.words, bm.words) || back.Len() != 130 {
+		t.Error("PageSet.Bitmap does not round trip")
+	}
+}
+
+func TestPageSetMarshal(t *testing.T) {
+	s := NewPageSet(7, 1<<33)
+	s.AddRange(1<<16, 1<<16+arrayMax+10)
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got PageSet
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(slices.Collect(got.All()), slices.Collect(s.All())) {
+		t.Error("round trip mismatch")
+	}
+	for _, bad := range [][]byte{nil, data[:len(data)-1], append(data, 0)} {
+		if err := got.UnmarshalBinary(bad); err == nil {
+			t.Errorf("UnmarshalBinary of %d bytes succeeded", len(bad))
+		}
+	}
+}
@@ -0,0 +1,432 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// EvictionPolicy chooses which resident pages to evict.
+// Implementations need not be safe for concurrent use.
+type EvictionPolicy interface {
+	// Touch records an access to page, starting to track it if needed.
+	Touch(page uintptr)
+	// Remove stops tracking page.
+	Remove(page uintptr)
+	// Victim removes and returns the next page to evict.
+	// Returns false if no pages are tracked.
+	Victim() (uintptr, bool)
+	// Len returns the number of tracked pages.
+	Len() int
+}
+
+// AdmissionPolicy is an EvictionPolicy that also decides whether pages are
+// worth keeping once the resident limit is reached, such as TinyLFU. Pages
+// it does not admit are still filled, as their faults must be resolved, but
+// are evicted before any other.
+type AdmissionPolicy interface {
+	EvictionPolicy
+	// Admit is called instead of Touch for a page faulted at the resident
+	// limit. It reports whether the page is worth more than the next
+	// victim, and if so touches it. Tracked pages should be admitted.
+	Admit(page uintptr) bool
+}
+
+var (
+	policiesMu sync.Mutex
+	policies   = map[string]func() EvictionPolicy{
+		"lru":     NewLRUPolicy,
+		"clock":   NewClockPolicy,
+		"random":  NewRandomPolicy,
+		"lru-2":   func() EvictionPolicy { return NewLRUKPolicy(2) },
+		"tinylfu": func() EvictionPolicy { return NewTinyLFUPolicy(0) },
+	}
+)
+
+// RegisterEvictionPolicy makes a custom policy available to
+// NewEvictionPolicy under name, replacing any policy of that name.
+func RegisterEvictionPolicy(name string, fn func() EvictionPolicy) {
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+	policies[name] = fn
+}
+
+// NewEvictionPolicy returns a new policy by name, as for a command-line
+// flag: "lru", "clock", "random", "lru-2", "tinylfu" or a name registered
+// with RegisterEvictionPolicy.
+func NewEvictionPolicy(name string) (EvictionPolicy, error) {
+	policiesMu.Lock()
+	fn, ok := policies[name]
+	policiesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown eviction policy %q", name)
+	}
+	return fn(), nil
+}
+
+// EvictionPolicies returns the names of the available policies, sorted.
+func EvictionPolicies() []string {
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type lruPolicy struct {
+	lru   *list.List // of uintptr, most recently used first
+	pages map[uintptr]*list.Element
+}
+
+// NewLRUPolicy returns a policy that evicts the least recently used page.
+func NewLRUPolicy() EvictionPolicy {
+	return &lruPolicy{
+		lru:   list.New(),
+		pages: make(map[uintptr]*list.Element),
+	}
+}
+
+func (p *lruPolicy) Touch(page uintptr) {
+	if el, ok := p.pages[page]; ok {
+		p.lru.MoveToFront(el)
+		return
+	}
+	p.pages[page] = p.lru.PushFront(page)
+}
+
+func (p *lruPolicy) Remove(page uintptr) {
+	if el, ok := p.pages[page]; ok {
+		p.lru.Remove(el)
+		delete(p.pages, page)
+	}
+}
+
+func (p *lruPolicy) Victim() (uintptr, bool) {
+	el := p.lru.Back()
+	if el == nil {
+		return 0, false
+	}
+	page := p.lru.Remove(el).(uintptr)
+	delete(p.pages, page)
+	return page, true
+}
+
+func (p *lruPolicy) Len() int {
+	return len(p.pages)
+}
+
+// pageSet is a set of pages supporting removal and random access in O(1).
+type pageSet struct {
+	pages []uintptr
+	index map[uintptr]int
+}
+
+func (s *pageSet) add(page uintptr) bool {
+	if _, ok := s.index[page]; ok {
+		return false
+	}
+	s.index[page] = len(s.pages)
+	s.pages = append(s.pages, page)
+	return true
+}
+
+// remove deletes page by moving the last page into its slot.
+// Returns the slot and false if page was not in the set.
+func (s *pageSet) remove(page uintptr) (int, bool) {
+	i, ok := s.index[page]
+	if !ok {
+		return 0, false
+	}
+	last := len(s.pages) - 1
+	s.pages[i] = s.pages[last]
+	s.index[s.pages[i]] = i
+	s.pages = s.pages[:last]
+	delete(s.index, page)
+	return i, true
+}
+
+type clockPolicy struct {
+	pageSet
+	ref  []bool
+	hand int
+}
+
+// NewClockPolicy returns a policy implementing the CLOCK (second chance)
+// algorithm: recently touched pages are skipped once by the sweeping hand.
+func NewClockPolicy() EvictionPolicy {
+	return &clockPolicy{pageSet: pageSet{index: make(map[uintptr]int)}}
+}
+
+func (p *clockPolicy) Touch(page uintptr) {
+	if p.add(page) {
+		p.ref = append(p.ref, true)
+		return
+	}
+	p.ref[p.index[page]] = true
+}
+
+func (p *clockPolicy) Remove(page uintptr) {
+	i, ok := p.remove(page)
+	if !ok {
+		return
+	}
+	last := len(p.ref) - 1
+	p.ref[i] = p.ref[last]
+	p.ref = p.ref[:last]
+	if p.hand >= len(p.ref) {
+		p.hand = 0
+	}
+}
+
+func (p *clockPolicy) Victim() (uintptr, bool) {
+	if len(p.pages) == 0 {
+		return 0, false
+	}
+	for p.ref[p.hand] {
+		p.ref[p.hand] = false
+		p.hand = (p.hand + 1) % len(p.ref)
+	}
+	page := p.pages[p.hand]
+	p.Remove(page)
+	return page, true
+}
+
+func (p *clockPolicy) Len() int {
+	return len(p.pages)
+}
+
+type randomPolicy struct {
+	pageSet
+}
+
+// NewRandomPolicy returns a policy that evicts a page chosen at random.
+func NewRandomPolicy() EvictionPolicy {
+	return &randomPolicy{pageSet{index: make(map[uintptr]int)}}
+}
+
+func (p *randomPolicy) Touch(page uintptr) {
+	p.add(page)
+}
+
+func (p *randomPolicy) Remove(page uintptr) {
+	p.remove(page)
+}
+
+func (p *randomPolicy) Victim() (uintptr, bool) {
+	if len(p.pages) == 0 {
+		return 0, false
+	}
+	page := p.pages[rand.IntN(len(p.pages))]
+	p.remove(page)
+	return page, true
+}
+
+func (p *randomPolicy) Len() int {
+	return len(p.pages)
+}
+
+type lrukPolicy struct {
+	k     int
+	clock uint64
+	pages map[uintptr]*lrukPage
+	young *list.List // of pages accessed less than k times, most recent first
+	old   lrukHeap   // of pages accessed k times, by their k-th last access
+}
+
+type lrukPage struct {
+	page  uintptr
+	hist  []uint64      // last accesses, up to k, oldest first
+	elem  *list.Element // in young, nil if in old
+	index int           // in old
+}
+
+// NewLRUKPolicy returns a policy implementing LRU-K: it evicts the page
+// whose k-th most recent access is the oldest, pages accessed less than k
+// times first, the least recently used of them. Unlike LRU, it is not
+// fooled by scans touching many pages once. NewLRUKPolicy(1) is LRU.
+func NewLRUKPolicy(k int) EvictionPolicy {
+	return &lrukPolicy{
+		k:     max(k, 1),
+		pages: make(map[uintptr]*lrukPage),
+		young: list.New(),
+	}
+}
+
+func (p *lrukPolicy) Touch(page uintptr) {
+	p.clock++
+	lp, ok := p.pages[page]
+	if !ok {
+		lp = &lrukPage{page: page}
+		p.pages[page] = lp
+	}
+	if len(lp.hist) == p.k {
+		lp.hist = append(lp.hist[:0], lp.hist[1:]...)
+	}
+	lp.hist = append(lp.hist, p.clock)
+
+	switch {
+	case len(lp.hist) < p.k && lp.elem == nil:
+		lp.elem = p.young.PushFront(lp)
+	case len(lp.hist) < p.k:
+		p.young.MoveToFront(lp.elem)
+	case ok && lp.elem == nil:
+		heap.Fix(&p.old, lp.index)
+	default:
+		if lp.elem != nil {
+			p.young.Remove(lp.elem)
+			lp.elem = nil
+		}
+		heap.Push(&p.old, lp)
+	}
+}
+
+func (p *lrukPolicy) Remove(page uintptr) {
+	lp, ok := p.pages[page]
+	if !ok {
+		return
+	}
+	delete(p.pages, page)
+	if lp.elem != nil {
+		p.young.Remove(lp.elem)
+	} else {
+		heap.Remove(&p.old, lp.index)
+	}
+}
+
+func (p *lrukPolicy) Victim() (uintptr, bool) {
+	var lp *lrukPage
+	switch {
+	case p.young.Len() > 0:
+		lp = p.young.Remove(p.young.Back()).(*lrukPage)
+	case len(p.old) > 0:
+		lp = heap.Pop(&p.old).(*lrukPage)
+	default:
+		return 0, false
+	}
+	delete(p.pages, lp.page)
+	return lp.page, true
+}
+
+func (p *lrukPolicy) Len() int {
+	return len(p.pages)
+}
+
+type lrukHeap []*lrukPage
+
+func (h lrukHeap) Len() int           { return len(h) }
+func (h lrukHeap) Less(i, j int) bool { return h[i].hist[0] < h[j].hist[0] }
+func (h lrukHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lrukHeap) Push(x any) {
+	lp := x.(*lrukPage)
+	lp.index = len(*h)
+	*h = append(*h, lp)
+}
+
+func (h *lrukHeap) Pop() any {
+	old := *h
+	lp := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return lp
+}
+
+type tinyLFUPolicy struct {
+	lruPolicy
+	sketch frequencySketch
+}
+
+// NewTinyLFUPolicy returns a policy evicting the least recently used page,
+// with TinyLFU admission: at the resident limit, a page is only kept if it
+// was accessed more often than the next victim, as estimated by a sketch of
+// the access frequencies sized for the given number of pages, 65536 if
+// zero. Pages touched once, as by scans, do not push out frequently used
+// ones.
+func NewTinyLFUPolicy(pages int) AdmissionPolicy {
+	if pages <= 0 {
+		pages = 1 << 16
+	}
+	return &tinyLFUPolicy{
+		lruPolicy: lruPolicy{lru: list.New(), pages: make(map[uintptr]*list.Element)},
+		sketch:    newFrequencySketch(pages),
+	}
+}
+
+func (p *tinyLFUPolicy) Touch(page uintptr) {
+	p.sketch.add(page)
+	p.lruPolicy.Touch(page)
+}
+
+func (p *tinyLFUPolicy) Admit(page uintptr) bool {
+	p.sketch.add(page)
+	if _, ok := p.pages[page]; ok {
+		p.lru.MoveToFront(p.pages[page])
+		return true
+	}
+	if el := p.lru.Back(); el != nil && p.sketch.estimate(page) <= p.sketch.estimate(el.Value.(uintptr)) {
+		return false
+	}
+	p.lruPolicy.Touch(page)
+	return true
+}
+
+// frequencySketch is a count-min sketch of 4 rows of saturating counters,
+// halved every 10 accesses per counter so that old accesses fade away.
+type frequencySketch struct {
+	rows    [4][]uint8
+	mask    uint64
+	added   int
+	resetAt int
+}
+
+var sketchSeeds = [4]uint64{0x9e3779b97f4a7c15, 0xc2b2ae3d27d4eb4f, 0x165667b19e3779f9, 0x27d4eb2f165667c5}
+
+func newFrequencySketch(n int) frequencySketch {
+	width := 1 << bits.Len(uint(n-1))
+	s := frequencySketch{mask: uint64(width - 1), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *frequencySketch) index(row int, page uintptr) uint64 {
+	h := (uint64(page) >> 12) * sketchSeeds[row]
+	return (h ^ h>>32) & s.mask
+}
+
+func (s *frequencySketch) add(page uintptr) {
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(i, page)]; *c < 255 {
+			*c++
+		}
+	}
+	if s.added++; s.added >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] /= 2
+			}
+		}
+		s.added /= 2
+	}
+}
+
+func (s *frequencySketch) estimate(page uintptr) uint8 {
+	n := uint8(255)
+	for i := range s.rows {
+		n = min(n, s.rows[i][s.index(i, page)])
+	}
+	return n
+}
@@ -0,0 +1,129 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLRUPolicy(t *testing.T) {
+	p := NewLRUPolicy()
+	for _, page := range []uintptr{1, 2, 3} {
+		p.Touch(page)
+	}
+	p.Touch(1)
+	p.Remove(2)
+
+	for _, want := range []uintptr{3, 1} {
+		got, ok := p.Victim()
+		if !ok || got != want {
+			t.Fatalf("Victim() = %d, %v, want %d", got, ok, want)
+		}
+	}
+	if _, ok := p.Victim(); ok {
+		t.Fatalf("Victim() on empty policy returned a page")
+	}
+}
+
+func TestClockPolicy(t *testing.T) {
+	p := NewClockPolicy()
+	for _, page := range []uintptr{1, 2, 3} {
+		p.Touch(page)
+	}
+
+	// All pages are referenced: the hand clears them and comes back to the first.
+	if got, _ := p.Victim(); got != 1 {
+		t.Fatalf("Victim() = %d, want 1", got)
+	}
+
+	// Give page 3 a second chance.
+	p.Touch(3)
+	if got, _ := p.Victim(); got != 2 {
+		t.Fatalf("Victim() = %d, want 2", got)
+	}
+	if got, _ := p.Victim(); got != 3 {
+		t.Fatalf("Victim() = %d, want 3", got)
+	}
+	if p.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0", p.Len())
+	}
+}
+
+func TestRandomPolicy(t *testing.T) {
+	p := NewRandomPolicy()
+	for _, page := range []uintptr{1, 2, 3} {
+		p.Touch(page)
+	}
+	p.Touch(3)
+	p.Remove(2)
+	if p.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", p.Len())
+	}
+
+	seen := make(map[uintptr]bool)
+	for p.Len() > 0 {
+		page, _ := p.Victim()
+		seen[page] = true
+	}
+	if !seen[1] || !seen[3] || seen[2] {
+		t.Fatalf("unexpected victims: %v", seen)
+	}
+}
+
+func TestLRUKPolicy(t *testing.T) {
+	p := NewLRUKPolicy(2)
+	for _, page := range []uintptr{1, 2, 3, 1, 2, 4, 3} {
+		p.Touch(page)
+	}
+	p.Remove(3)
+	// Page 4 was accessed once, then page 1 has the oldest second-to-last
+	// access.
+	for _, want := range []uintptr{4, 1, 2} {
+		got, ok := p.Victim()
+		if !ok || got != want {
+			t.Fatalf("Victim() = %d, %v, want %d", got, ok, want)
+		}
+	}
+	if _, ok := p.Victim(); ok || p.Len() != 0 {
+		t.Fatalf("pages left after evicting all")
+	}
+}
+
+func TestTinyLFUPolicy(t *testing.T) {
+	p := NewTinyLFUPolicy(16)
+	const hot, cold = 0x1000, 0x2000
+	for range 3 {
+		p.Touch(hot)
+	}
+	if p.Admit(cold) {
+		t.Error("page accessed once admitted over a frequent one")
+	}
+	if p.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", p.Len())
+	}
+	for range 3 {
+		p.Admit(cold)
+	}
+	if !p.Admit(cold) || p.Len() != 2 {
+		t.Error("frequent page not admitted")
+	}
+	if got, _ := p.Victim(); got != hot {
+		t.Errorf("Victim() = %#x, want %#x", got, hot)
+	}
+}
+
+func TestNewEvictionPolicy(t *testing.T) {
+	RegisterEvictionPolicy("test", NewRandomPolicy)
+	for _, name := range EvictionPolicies() {
+		if p, err := NewEvictionPolicy(name); err != nil || p == nil {
+			t.Errorf("NewEvictionPolicy(%q) = %v, %v", name, p, err)
+		}
+	}
+	if !slices.Contains(EvictionPolicies(), "tinylfu") {
+		t.Error("tinylfu not registered")
+	}
+	if _, err := NewEvictionPolicy("none"); err == nil {
+		t.Error("NewEvictionPolicy of an unknown policy succeeded")
+	}
+}
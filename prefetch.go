@@ -0,0 +1,63 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Prefetch fills the missing pages of the length bytes at off in the
+// region, in order, before they are faulted in. Provider reads are limited
+// by the rate limiter set with WithRateLimiter. It returns once done or ctx
+// is done: run it in its own goroutine to prefetch in the background.
+//
+// Pages resident when Prefetch starts are skipped. With
+// WithMaxResidentBytes, it stops when the budget is used up rather than
+// evicting pages.
+func (r *Region) Prefetch(ctx context.Context, off, length int64) error {
+	if r.mode&UFFDIO_REGISTER_MODE_MISSING == 0 {
+		return errors.New("prefetch of a region not registered in missing mode")
+	}
+	if off < 0 || length < 0 || off+length > int64(len(r.mem)) {
+		return fmt.Errorf("invalid prefetch range %d+%d of region of %d bytes", off, length, len(r.mem))
+	}
+	mem, err := r.pages(int(off), int(length))
+	if err != nil || len(mem) == 0 {
+		return err
+	}
+	resident := make([]byte, (len(mem)+r.pageSize-1)/r.pageSize)
+	if err := mincore(mem, resident); err != nil {
+		return err
+	}
+
+	first := int(off) / r.pageSize
+	for k, v := range resident {
+		if v&1 != 0 {
+			continue
+		}
+		i := first + k
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		e := r.evictor.Load()
+		if r.maxResident > 0 && int64(e.Resident()+1)*int64(r.pageSize) > r.maxResident {
+			return nil
+		}
+		page := r.Base() + uintptr(i*r.pageSize)
+		if e != nil {
+			e.Touch(page)
+		}
+		// A page faulted in meanwhile was accounted by its fault.
+		if err := r.fill(ctx, page, true); err != nil && !errors.Is(err, ErrAlreadyMapped) {
+			if e != nil {
+				e.Forget(page, r.pageSize)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPrefetch(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	pageSize := unix.Getpagesize()
+
+	l := NewRateLimiter(0, 1000)
+	r, err := uffd.MapAndRegister(int64(4*pageSize), UFFDIO_REGISTER_MODE_MISSING, WithProvider(patternProvider(4)), WithRateLimiter(l, false))
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	defer r.Close()
+
+	if err := r.Prefetch(context.Background(), int64(pageSize), int64(5*pageSize)); err == nil {
+		t.Error("Prefetch beyond the region succeeded")
+	}
+	// Nothing serves the region: prefetched pages must not fault.
+	if err := r.Prefetch(context.Background(), int64(pageSize)+1, int64(pageSize)); err != nil {
+		t.Fatalf("Prefetch failed: %v", err)
+	}
+	resident, err := r.Residency()
+	if err != nil {
+		t.Fatalf("Residency failed: %v", err)
+	}
+	if resident.Test(0) || !resident.Test(1) || !resident.Test(2) || resident.Test(3) {
+		t.Fatalf("pages 1 and 2 not the only prefetched ones")
+	}
+	if m := r.Bytes(); m[pageSize] != 2 || m[2*pageSize] != 3 {
+		t.Errorf("prefetched %d and %d, want 2 and 3", m[pageSize], m[2*pageSize])
+	}
+
+	// Resident pages are skipped.
+	if err := r.Prefetch(context.Background(), 0, int64(r.Len())); err != nil {
+		t.Fatalf("Prefetch failed: %v", err)
+	}
+	if resident, _, _ := r.ResidentBytes(); resident != int64(r.Len()) {
+		t.Errorf("%d bytes resident after prefetching all", resident)
+	}
+	if s := r.Stats(); s.Missing != 0 || s.BytesFilled != uint64(r.Len()) {
+		t.Errorf("%d missing faults and %d bytes filled counted", s.Missing, s.BytesFilled)
+	}
+}
+
+// countingProvider counts the reads of a provider.
+type countingProvider struct {
+	PageProvider
+	reads int
+}
+
+func (p *countingProvider) ReadAt(b []byte, off int64) (int, error) {
+	p.reads++
+	return p.PageProvider.ReadAt(b, off)
+}
+
+func TestRegionFilled(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, UFFD_FEATURE_EVENT_REMOVE)
+	if err != nil {
+		t.Skipf("UFFD_FEATURE_EVENT_REMOVE not available: %v", err)
+	}
+	defer uffd.Close()
+	pageSize := unix.Getpagesize()
+
+	p := &countingProvider{PageProvider: patternProvider(3)}
+	r, err := uffd.MapAndRegister(int64(3*pageSize), UFFDIO_REGISTER_MODE_MISSING, WithProvider(p))
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	defer r.Close()
+
+	if err := r.Prefetch(context.Background(), 0, int64(2*pageSize)); err != nil {
+		t.Fatalf("Prefetch failed: %v", err)
+	}
+	if f := r.Filled(); f.Count() != 2 || !f.Test(0) || !f.Test(1) {
+		t.Fatalf("Filled() has %d pages after prefetching 2", f.Count())
+	}
+
+	// A fault read before the page was prefetched only wakes up.
+	if err := r.HandleEvent(uffd, pagefaultMsg(uint64(r.Base()), 0)); err != nil {
+		t.Fatalf("HandleEvent failed: %v", err)
+	}
+	if p.reads != 2 {
+		t.Errorf("%d provider reads, want 2", p.reads)
+	}
+
+	// Removed pages are not filled anymore.
+	rm := &UffdMsg{Event: UFFD_EVENT_REMOVE}
+	rm.GetRemove().Start = uint64(r.Base() - uintptr(pageSize))
+	rm.GetRemove().End = uint64(r.Base() + uintptr(pageSize))
+	if err := r.HandleEvent(uffd, rm); err != nil {
+		t.Fatalf("HandleEvent failed: %v", err)
+	}
+	if f := r.Filled(); f.Count() != 1 || !f.Test(1) {
+		t.Errorf("Filled() has %d pages after removing the first", f.Count())
+	}
+}
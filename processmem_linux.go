@@ -0,0 +1,114 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxRemoteIovecs bounds the remote ranges of a process_vm_readv(2) call,
+// as IOV_MAX does.
+const maxRemoteIovecs = 1024
+
+// RemoteRange is a range of the address space of another process.
+type RemoteRange struct {
+	Addr uintptr
+	Len  int
+}
+
+// ProcessMemory is a PageProvider reading the memory of another process
+// with process_vm_readv(2), so that a lazy region mirrors it and only the
+// pages touched are copied, as when a debugger or an inspector browses a
+// huge remote address space. The remote ranges are laid out one after the
+// other. Remote pages that cannot be read, such as holes between mappings,
+// read as zeros.
+//
+// Reading the memory of another process needs the permission to ptrace(2)
+// it. The contents are read when faulted in, and not updated afterwards.
+type ProcessMemory struct {
+	pid    int
+	ranges []RemoteRange
+	starts []int64 // offset of each range
+	size   int64
+}
+
+// NewProcessMemory returns a ProcessMemory reading the ranges of the
+// process pid.
+func NewProcessMemory(pid int, ranges []RemoteRange) (*ProcessMemory, error) {
+	m := &ProcessMemory{pid: pid, ranges: slices.Clone(ranges)}
+	for i, r := range ranges {
+		if r.Len <= 0 || r.Addr+uintptr(r.Len) < r.Addr {
+			return nil, fmt.Errorf("invalid remote range %d: %#x+%d", i, r.Addr, r.Len)
+		}
+		m.starts = append(m.starts, m.size)
+		m.size += int64(r.Len)
+	}
+	if m.size == 0 {
+		return nil, errors.New("no remote ranges")
+	}
+	return m, nil
+}
+
+// Size returns the total length of the remote ranges.
+func (m *ProcessMemory) Size() int64 {
+	return m.size
+}
+
+// ReadAt implements PageProvider.
+func (m *ProcessMemory) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	want := int(min(int64(len(p)), m.size-off))
+	pageSize := uintptr(os.Getpagesize())
+	for done := 0; done < want; {
+		remote := m.remote(off+int64(done), want-done)
+		local := []unix.Iovec{{Base: &p[done]}}
+		local[0].SetLen(want - done)
+		n, err := unix.ProcessVMReadv(m.pid, local, remote, 0)
+		if err != nil && err != unix.EFAULT {
+			return done, os.NewSyscallError("process_vm_readv", err)
+		}
+		if n > 0 {
+			done += n
+			continue
+		}
+		// The reads stop at the first page that cannot be read.
+		addr := remote[0].Base
+		skip := min(int(pageSize-addr%pageSize), remote[0].Len)
+		clear(p[done : done+skip])
+		done += skip
+	}
+	if want < len(p) {
+		return want, io.EOF
+	}
+	return want, nil
+}
+
+// remote returns the remote ranges of the length bytes at off.
+func (m *ProcessMemory) remote(off int64, length int) []unix.RemoteIovec {
+	i, found := slices.BinarySearch(m.starts, off)
+	if !found {
+		i--
+	}
+	var iov []unix.RemoteIovec
+	for ; length > 0 && i < len(m.ranges) && len(iov) < maxRemoteIovecs; i++ {
+		skip := int(off - m.starts[i])
+		n := min(m.ranges[i].Len-skip, length)
+		iov = append(iov, unix.RemoteIovec{Base: m.ranges[i].Addr + uintptr(skip), Len: n})
+		off += int64(n)
+		length -= n
+	}
+	return iov
+}
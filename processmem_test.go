@@ -0,0 +1,69 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestProcessMemory(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	remote, err := unix.Mmap(-1, 0, 3*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(remote)
+	for i := range remote {
+		remote[i] = byte(i/pageSize + 1)
+	}
+	// A page in the middle that cannot be read, and reads as zeros.
+	if err := unix.Mprotect(remote[pageSize:2*pageSize], unix.PROT_NONE); err != nil {
+		t.Fatal(err)
+	}
+	base := uintptr(unsafe.Pointer(&remote[0]))
+
+	// The calling process is as remote as any other.
+	m, err := NewProcessMemory(os.Getpid(), []RemoteRange{
+		{Addr: base + uintptr(pageSize) - 10, Len: pageSize + 20},
+		{Addr: base, Len: 5},
+	})
+	if err != nil {
+		t.Fatalf("NewProcessMemory failed: %v", err)
+	}
+	if m.Size() != int64(pageSize+25) {
+		t.Errorf("Size = %d", m.Size())
+	}
+	want := bytes.Join([][]byte{
+		bytes.Repeat([]byte{1}, 10),
+		make([]byte, pageSize),
+		bytes.Repeat([]byte{3}, 10),
+		bytes.Repeat([]byte{1}, 5),
+	}, nil)
+
+	buf := make([]byte, pageSize+30)
+	n, err := m.ReadAt(buf, 0)
+	if n != len(want) || err != io.EOF || !bytes.Equal(buf[:n], want) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+	n, err = m.ReadAt(buf[:8], int64(pageSize+12))
+	if n != 8 || err != nil || !bytes.Equal(buf[:8], want[pageSize+12:pageSize+20]) {
+		t.Errorf("ReadAt across ranges = %d, %v: %v", n, err, buf[:8])
+	}
+
+	mem, _ := serveRegion(t, 2, WithProvider(m))
+	if !bytes.Equal(mem[:len(want)], want) || mem[len(want)] != 0 {
+		t.Error("region does not mirror the remote ranges")
+	}
+
+	if _, err := NewProcessMemory(os.Getpid(), nil); err == nil {
+		t.Error("expected error without ranges")
+	}
+}
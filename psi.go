@@ -0,0 +1,90 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SystemMemoryPressure is the system-wide memory PSI file.
+const SystemMemoryPressure = "/proc/pressure/memory"
+
+// PressureStat holds the stall averages (in percent) and total stall time
+// (in microseconds) of one line of a PSI file.
+type PressureStat struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  uint64
+}
+
+// Pressure holds the contents of a PSI file such as /proc/pressure/memory.
+type Pressure struct {
+	Some PressureStat // Some tasks stalled
+	Full PressureStat // All non-idle tasks stalled
+}
+
+// ReadPressure parses the PSI file at path.
+func ReadPressure(path string) (*Pressure, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Pressure
+	for line := range strings.Lines(string(data)) {
+		var kind string
+		var st PressureStat
+		if _, err := fmt.Sscanf(line, "%s avg10=%f avg60=%f avg300=%f total=%d", &kind, &st.Avg10, &st.Avg60, &st.Avg300, &st.Total); err != nil {
+			return nil, fmt.Errorf("%s: invalid line %q: %w", path, line, err)
+		}
+		switch kind {
+		case "some":
+			p.Some = st
+		case "full":
+			p.Full = st
+		}
+	}
+	return &p, nil
+}
+
+// CgroupMemoryPressure returns the path of the memory.pressure file of the
+// cgroup v2 the calling process belongs to.
+func CgroupMemoryPressure() (string, error) {
+	cgroup, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	var dir string
+	for line := range strings.Lines(string(cgroup)) {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(line), "0::"); ok {
+			dir = path
+		}
+	}
+	if dir == "" {
+		return "", errors.New("not in a cgroup v2 hierarchy")
+	}
+
+	mounts, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer mounts.Close()
+
+	scanner := bufio.NewScanner(mounts)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[2] == "cgroup2" {
+			return filepath.Join(fields[1], dir, "memory.pressure"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("cgroup2 filesystem not mounted")
+}
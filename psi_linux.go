@@ -0,0 +1,117 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// PressureMonitor waits for memory stall events using a PSI trigger. Close
+// interrupts the calls to Wait, as it does for a Uffd.
+type PressureMonitor struct {
+	file      *os.File
+	fd        uintptr      // valid while mu is held for reading and not closed
+	wake      int          // eventfd signalled by Close to interrupt Wait
+	mu        sync.RWMutex // held for reading while the descriptor is used
+	closed    atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewPressureMonitor registers a trigger on the PSI file at path that fires
+// when some tasks were stalled for more than threshold within window.
+// The kernel requires window to be between 500ms and 10s, and a multiple
+// of 2s for unprivileged users.
+func NewPressureMonitor(path string, threshold, window time.Duration) (*PressureMonitor, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	trigger := fmt.Sprintf("some %d %d", threshold.Microseconds(), window.Microseconds())
+	if _, err := file.Write(append([]byte(trigger), 0)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		file.Close()
+		return nil, os.NewSyscallError("eventfd", err)
+	}
+	return &PressureMonitor{file: file, fd: file.Fd(), wake: wake}, nil
+}
+
+// Close removes the trigger. Calls to Wait return, and later ones fail
+// with ErrClosed.
+func (m *PressureMonitor) Close() error {
+	m.closeOnce.Do(func() {
+		m.closed.Store(true)
+		unix.Write(m.wake, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.closeErr = m.file.Close()
+		unix.Close(m.wake)
+	})
+	return m.closeErr
+}
+
+// Wait waits up to timeout milliseconds for the trigger to fire, or
+// indefinitely if timeout is negative. Returns true if it fired, and
+// ErrClosed once m is closed, including while waiting.
+func (m *PressureMonitor) Wait(timeout int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed.Load() {
+		return false, ErrClosed
+	}
+	pfd := []unix.PollFd{{
+		Fd:     int32(m.fd),
+		Events: unix.POLLPRI,
+	}, {
+		Fd:     int32(m.wake),
+		Events: unix.POLLIN,
+	}}
+
+	if err := retryOnEINTR(func() error {
+		_, err := unix.Poll(pfd, timeout)
+		return err
+	}); err != nil {
+		return false, os.NewSyscallError("poll", err)
+	}
+	if pfd[1].Revents != 0 {
+		return false, ErrClosed
+	}
+
+	re := pfd[0].Revents
+	if re&(unix.POLLERR|unix.POLLHUP|unix.POLLNVAL) != 0 {
+		return false, &PollError{Revents: re}
+	}
+	return re&unix.POLLPRI != 0, nil
+}
+
+// EvictOnPressure evicts n pages every time m fires, until m is closed.
+func (e *Evictor) EvictOnPressure(m *PressureMonitor, n int) error {
+	for {
+		fired, err := m.Wait(-1)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrClosed):
+			return nil
+		default:
+			return err
+		}
+		if !fired {
+			continue
+		}
+		if _, err := e.Evict(n); err != nil {
+			return err
+		}
+	}
+}
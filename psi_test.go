@@ -0,0 +1,94 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadPressure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory")
+	data := "some avg10=1.50 avg60=0.25 avg300=0.00 total=12345\n" +
+		"full avg10=0.75 avg60=0.00 avg300=0.00 total=678\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ReadPressure(path)
+	if err != nil {
+		t.Fatalf("ReadPressure failed: %v", err)
+	}
+	if p.Some.Avg10 != 1.5 || p.Some.Avg60 != 0.25 || p.Some.Total != 12345 {
+		t.Errorf("unexpected some line: %+v", p.Some)
+	}
+	if p.Full.Avg10 != 0.75 || p.Full.Total != 678 {
+		t.Errorf("unexpected full line: %+v", p.Full)
+	}
+}
+
+func TestReadPressureInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory")
+	if err := os.WriteFile(path, []byte("garbage\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPressure(path); err == nil {
+		t.Fatalf("expected error on invalid PSI file")
+	}
+}
+
+func TestReadPressureSystem(t *testing.T) {
+	if _, err := os.Stat(SystemMemoryPressure); err != nil {
+		t.Skip("PSI not available")
+	}
+	if _, err := ReadPressure(SystemMemoryPressure); err != nil {
+		t.Fatalf("ReadPressure failed: %v", err)
+	}
+}
+
+func TestCgroupMemoryPressure(t *testing.T) {
+	path, err := CgroupMemoryPressure()
+	if err != nil {
+		t.Skipf("cgroup v2 not available: %v", err)
+	}
+	if filepath.Base(path) != "memory.pressure" {
+		t.Fatalf("unexpected path %q", path)
+	}
+}
+
+func TestPressureMonitor(t *testing.T) {
+	m, err := NewPressureMonitor(SystemMemoryPressure, 100*time.Millisecond, 2*time.Second)
+	if err != nil {
+		t.Skipf("PSI triggers not available: %v", err)
+	}
+
+	if _, err := m.Wait(0); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	_, r := serveRegion(t, 1)
+	e := NewEvictor(r, nil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- e.EvictOnPressure(m, 1)
+	}()
+
+	m.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("EvictOnPressure failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("EvictOnPressure did not return after Close")
+	}
+	if _, err := m.Wait(0); !errors.Is(err, ErrClosed) {
+		t.Errorf("Wait after Close: %v, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,330 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Qcow2 is a read-only qcow2 image, version 2 or 3, serving as the provider
+// of a region: VM disk and memory images are mapped lazily without being
+// converted to raw first. Guest clusters are looked up through the L1 and
+// L2 tables, compressed clusters are inflated, and unallocated ones are
+// read from the backing file, or zero-filled without one. It is safe for
+// concurrent use.
+//
+// Encrypted images, external data files, extended L2 entries and
+// compression types other than deflate are not supported. Snapshots are
+// ignored.
+type Qcow2 struct {
+	r           io.ReaderAt
+	size        int64
+	clusterBits uint
+	l1          []uint64
+	backing     io.ReaderAt // nil without a backing file
+	backingName string
+	closers     []io.Closer // of the files of the chain, by OpenQcow2
+
+	mu sync.Mutex
+	l2 map[uint64][]uint64 // L2 tables by offset, up to qcow2CachedTables
+}
+
+const (
+	qcow2Magic        = "QFI\xfb"
+	qcow2CachedTables = 64
+
+	qcow2OffsetMask = 0x00fffffffffffe00 // bits 9-55 of L1 and L2 entries
+	qcow2Compressed = 1 << 62
+	qcow2ZeroFlag   = 1 // of L2 entries, version 3
+
+	// Incompatible features: the dirty bit is harmless to reads, and the
+	// compression type is checked to be deflate.
+	qcow2IncompatDirty     = 1 << 0
+	qcow2IncompatCompress  = 1 << 3
+	qcow2SupportedIncompat = qcow2IncompatDirty | qcow2IncompatCompress
+)
+
+// NewQcow2 reads the header and L1 table of the qcow2 image in r. The guest
+// clusters not allocated in the image are read from backing, which may be
+// nil. The name of the backing file in the header is returned by
+// BackingFile but not opened, see OpenQcow2.
+func NewQcow2(r io.ReaderAt, backing io.ReaderAt) (*Qcow2, error) {
+	var hdr [112]byte
+	n, err := r.ReadAt(hdr[:], 0)
+	if n < 72 {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("reading qcow2 header: %w", err)
+	}
+	if string(hdr[:4]) != qcow2Magic {
+		return nil, errors.New("not a qcow2 image")
+	}
+	be := binary.BigEndian
+	version := be.Uint32(hdr[4:])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported qcow2 version %d", version)
+	}
+	q := &Qcow2{
+		r:           r,
+		size:        int64(be.Uint64(hdr[24:])),
+		clusterBits: uint(be.Uint32(hdr[20:])),
+		backing:     backing,
+		l2:          make(map[uint64][]uint64),
+	}
+	if q.clusterBits < 9 || q.clusterBits > 21 {
+		return nil, fmt.Errorf("invalid qcow2 cluster bits %d", q.clusterBits)
+	}
+	if q.size < 0 {
+		return nil, fmt.Errorf("invalid qcow2 size %d", q.size)
+	}
+	if crypt := be.Uint32(hdr[32:]); crypt != 0 {
+		return nil, fmt.Errorf("encrypted qcow2 images are not supported (method %d)", crypt)
+	}
+	if version == 3 {
+		if n < 104 {
+			return nil, fmt.Errorf("reading qcow2 header: %w", io.ErrUnexpectedEOF)
+		}
+		incompat := be.Uint64(hdr[72:])
+		if unknown := incompat &^ qcow2SupportedIncompat; unknown != 0 {
+			return nil, fmt.Errorf("unsupported qcow2 incompatible features %#x", unknown)
+		}
+		if incompat&qcow2IncompatCompress != 0 && (be.Uint32(hdr[100:]) <= 104 || n <= 104 || hdr[104] != 0) {
+			return nil, errors.New("unsupported qcow2 compression type")
+		}
+	}
+
+	if off, size := be.Uint64(hdr[8:]), be.Uint32(hdr[16:]); off != 0 {
+		if size > 1023 {
+			return nil, fmt.Errorf("invalid qcow2 backing file name size %d", size)
+		}
+		name := make([]byte, size)
+		if _, err := r.ReadAt(name, int64(off)); err != nil {
+			return nil, fmt.Errorf("reading qcow2 backing file name: %w", err)
+		}
+		q.backingName = string(name)
+	}
+
+	// Each L1 entry covers the clusters of an L2 table.
+	l1Size := be.Uint32(hdr[36:])
+	l2Bits := q.clusterBits - 3
+	if need := (uint64(q.size) + 1<<(q.clusterBits+l2Bits) - 1) >> (q.clusterBits + l2Bits); uint64(l1Size) < need {
+		return nil, fmt.Errorf("qcow2 L1 table of %d entries too small for %d bytes", l1Size, q.size)
+	}
+	if l1Size > 32<<20 {
+		return nil, fmt.Errorf("qcow2 L1 table of %d entries too large", l1Size)
+	}
+	if q.l1, err = q.readTable(be.Uint64(hdr[40:]), int(l1Size)); err != nil {
+		return nil, fmt.Errorf("reading qcow2 L1 table: %w", err)
+	}
+	return q, nil
+}
+
+// OpenQcow2 opens the qcow2 image at name, and its chain of backing files,
+// each a qcow2 or raw image, relative to the directory of the image
+// referring to it. Close closes them all.
+func OpenQcow2(name string) (*Qcow2, error) {
+	return openQcow2(name, 0)
+}
+
+func openQcow2(name string, depth int) (*Qcow2, error) {
+	if depth > 16 {
+		return nil, errors.New("qcow2 backing chain too long")
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	q, err := NewQcow2(f, nil)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	q.closers = []io.Closer{f}
+	if q.backingName == "" {
+		return q, nil
+	}
+
+	path := q.backingName
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(name), path)
+	}
+	var magic [4]byte
+	bf, err := os.Open(path)
+	if err == nil {
+		_, err = bf.ReadAt(magic[:], 0)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		if bf != nil {
+			bf.Close()
+		}
+		q.Close()
+		return nil, fmt.Errorf("backing file of %s: %w", name, err)
+	}
+	if string(magic[:]) != qcow2Magic {
+		q.backing = bf
+		q.closers = append(q.closers, bf)
+		return q, nil
+	}
+	bf.Close()
+	b, err := openQcow2(path, depth+1)
+	if err != nil {
+		q.Close()
+		return nil, err
+	}
+	q.backing = b
+	q.closers = append(q.closers, b)
+	return q, nil
+}
+
+// Size returns the virtual size of the image.
+func (q *Qcow2) Size() int64 {
+	return q.size
+}
+
+// BackingFile returns the name of the backing file in the header, if any.
+func (q *Qcow2) BackingFile() string {
+	return q.backingName
+}
+
+// ReadAt implements PageProvider.
+func (q *Qcow2) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= q.size {
+		return 0, io.EOF
+	}
+	want := int(min(int64(len(p)), q.size-off))
+	clusterSize := int64(1) << q.clusterBits
+	var n int
+	for n < want {
+		pos := off + int64(n)
+		chunk := p[n:min(want, n+int(clusterSize-pos&(clusterSize-1)))]
+		if err := q.readCluster(chunk, pos); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readCluster reads p at guest offset off, within a single cluster.
+func (q *Qcow2) readCluster(p []byte, off int64) error {
+	l2Bits := q.clusterBits - 3
+	cluster := uint64(off) >> q.clusterBits
+	within := off & (int64(1)<<q.clusterBits - 1)
+
+	var entry uint64
+	if l2Off := q.l1[cluster>>l2Bits] & qcow2OffsetMask; l2Off != 0 {
+		l2, err := q.table(l2Off)
+		if err != nil {
+			return err
+		}
+		entry = l2[cluster&(1<<l2Bits-1)]
+	}
+
+	switch {
+	case entry&qcow2Compressed != 0:
+		data, err := q.inflate(entry)
+		if err != nil {
+			return fmt.Errorf("qcow2 cluster %d: %w", cluster, err)
+		}
+		copy(p, data[within:])
+	case entry&qcow2ZeroFlag != 0:
+		clear(p)
+	case entry&qcow2OffsetMask != 0:
+		if _, err := q.r.ReadAt(p, int64(entry&qcow2OffsetMask)+within); err != nil {
+			return fmt.Errorf("qcow2 cluster %d: %w", cluster, err)
+		}
+	case q.backing != nil:
+		n, err := q.backing.ReadAt(p, off)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		clear(p[n:]) // past the end of the backing file
+	default:
+		clear(p)
+	}
+	return nil
+}
+
+// table returns the L2 table at off, from the cache if possible.
+func (q *Qcow2) table(off uint64) ([]uint64, error) {
+	q.mu.Lock()
+	l2, ok := q.l2[off]
+	q.mu.Unlock()
+	if ok {
+		return l2, nil
+	}
+	l2, err := q.readTable(off, 1<<(q.clusterBits-3))
+	if err != nil {
+		return nil, fmt.Errorf("reading qcow2 L2 table: %w", err)
+	}
+	q.mu.Lock()
+	if len(q.l2) >= qcow2CachedTables {
+		clear(q.l2)
+	}
+	q.l2[off] = l2
+	q.mu.Unlock()
+	return l2, nil
+}
+
+// readTable reads a table of n big-endian entries at off.
+func (q *Qcow2) readTable(off uint64, n int) ([]uint64, error) {
+	buf := make([]byte, 8*n)
+	if _, err := q.r.ReadAt(buf, int64(off)); err != nil {
+		return nil, err
+	}
+	table := make([]uint64, n)
+	for i := range table {
+		table[i] = binary.BigEndian.Uint64(buf[8*i:])
+	}
+	return table, nil
+}
+
+// inflate returns the contents of the compressed cluster of an L2 entry.
+func (q *Qcow2) inflate(entry uint64) ([]byte, error) {
+	// The host offset takes the low 62-(clusterBits-8) bits, followed by
+	// the number of additional 512-byte sectors.
+	shift := 62 - (q.clusterBits - 8)
+	host := entry & (1<<shift - 1)
+	sectors := (entry >> shift) & (1<<(q.clusterBits-8) - 1)
+	size := (sectors+1)*512 - host&511
+
+	// The last compressed cluster may end before the last sector.
+	buf := make([]byte, size)
+	n, err := q.r.ReadAt(buf, int64(host))
+	if err != nil && (err != io.EOF || n == 0) {
+		return nil, err
+	}
+	buf = buf[:n]
+	data := make([]byte, 1<<q.clusterBits)
+	if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(buf)), data); err != nil {
+		return nil, fmt.Errorf("inflating compressed cluster: %w", err)
+	}
+	return data, nil
+}
+
+// Close closes the files opened by OpenQcow2, including backing files.
+func (q *Qcow2) Close() error {
+	var errs []error
+	for _, c := range q.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,147 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testClusterBits = 12
+
+// Kinds of guest clusters of buildQcow2.
+const (
+	qcowUnallocated = iota
+	qcowData
+	qcowCompressed
+	qcowZero
+)
+
+// buildQcow2 returns a version 3 image of size bytes whose guest clusters
+// are of the given kinds, filled with the cluster index plus fill. Host
+// clusters: the header, the L1 table, the L2 table, then the data.
+func buildQcow2(t *testing.T, size int64, kinds []int, fill byte, backing string) []byte {
+	t.Helper()
+	const cs = 1 << testClusterBits
+	img := make([]byte, 3*cs)
+	be := binary.BigEndian
+	copy(img, qcow2Magic)
+	be.PutUint32(img[4:], 3)
+	if backing != "" {
+		be.PutUint64(img[8:], 512)
+		be.PutUint32(img[16:], uint32(len(backing)))
+		copy(img[512:], backing)
+	}
+	be.PutUint32(img[20:], testClusterBits)
+	be.PutUint64(img[24:], uint64(size))
+	be.PutUint32(img[36:], 1)
+	be.PutUint64(img[40:], cs)
+	be.PutUint32(img[100:], 104)
+	be.PutUint64(img[cs:], 2*cs|1<<63)
+
+	for i, kind := range kinds {
+		data := bytes.Repeat([]byte{byte(i) + fill}, cs)
+		var entry uint64
+		switch kind {
+		case qcowData:
+			img = append(img, make([]byte, -len(img)&(cs-1))...)
+			entry = uint64(len(img)) | 1<<63
+			img = append(img, data...)
+		case qcowCompressed:
+			var buf bytes.Buffer
+			w, _ := flate.NewWriter(&buf, flate.BestCompression)
+			w.Write(data)
+			w.Close()
+			// Not on a sector boundary, to check the size computation.
+			host := uint64(len(img) + 100)
+			img = append(img, make([]byte, 100)...)
+			img = append(img, buf.Bytes()...)
+			sectors := (host&511+uint64(buf.Len())+511)/512 - 1
+			entry = qcow2Compressed | sectors<<(62-(testClusterBits-8)) | host
+		case qcowZero:
+			entry = qcow2ZeroFlag
+		}
+		be.PutUint64(img[2*cs+8*i:], entry)
+	}
+	return img
+}
+
+func TestQcow2(t *testing.T) {
+	const cs = 1 << testClusterBits
+	size := int64(5*cs + 100)
+	img := buildQcow2(t, size, []int{qcowData, qcowCompressed, qcowZero, qcowUnallocated, qcowUnallocated, qcowData}, 1, "")
+	backing := bytes.Repeat([]byte{0xBB}, 4*cs-10) // ends within guest cluster 3
+
+	q, err := NewQcow2(bytes.NewReader(img), bytes.NewReader(backing))
+	if err != nil {
+		t.Fatalf("NewQcow2 failed: %v", err)
+	}
+	if q.Size() != size {
+		t.Errorf("Size = %d, want %d", q.Size(), size)
+	}
+	got := make([]byte, 6*cs)
+	n, err := q.ReadAt(got, 0)
+	if n != int(size) || err != io.EOF {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+	want := slices.Concat(
+		bytes.Repeat([]byte{1}, cs),
+		bytes.Repeat([]byte{2}, cs),
+		make([]byte, cs),
+		bytes.Repeat([]byte{0xBB}, cs-10), make([]byte, 10),
+		make([]byte, cs),
+		bytes.Repeat([]byte{6}, 100),
+	)
+	if !bytes.Equal(got[:n], want) {
+		t.Error("image contents differ")
+	}
+
+	// Reads across clusters.
+	buf := make([]byte, 20)
+	if _, err := q.ReadAt(buf, cs-10); err != nil || !bytes.Equal(buf, want[cs-10:cs+10]) {
+		t.Errorf("ReadAt across clusters = %v, %v", buf, err)
+	}
+
+	img[0] = 0
+	if _, err := NewQcow2(bytes.NewReader(img), nil); err == nil {
+		t.Error("NewQcow2 accepted a bad magic")
+	}
+}
+
+func TestOpenQcow2(t *testing.T) {
+	const cs = 1 << testClusterBits
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// top -> base.qcow2 -> base.raw
+	write("base.raw", bytes.Repeat([]byte{0xAA}, 3*cs))
+	write("base.qcow2", buildQcow2(t, 3*cs, []int{qcowUnallocated, qcowData}, 0x10, "base.raw"))
+	write("top.qcow2", buildQcow2(t, 3*cs, []int{qcowData}, 0x20, "base.qcow2"))
+
+	q, err := OpenQcow2(filepath.Join(dir, "top.qcow2"))
+	if err != nil {
+		t.Fatalf("OpenQcow2 failed: %v", err)
+	}
+	defer q.Close()
+	if q.BackingFile() != "base.qcow2" {
+		t.Errorf("BackingFile = %q", q.BackingFile())
+	}
+	got := make([]byte, 3*cs)
+	if _, err := q.ReadAt(got, 0); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	for i, want := range []byte{0x20, 0x11, 0xAA} {
+		if got[i*cs] != want || got[(i+1)*cs-1] != want {
+			t.Errorf("cluster %d = %#x, want %#x", i, got[i*cs], want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Extent is a range of bytes of a region.
+type Extent struct {
+	Off, Len int
+}
+
+// Quarantine helps the authors of allocators over a Region catch use after
+// free: freed extents are poisoned, as with GuardRange, so that any access
+// to them raises SIGBUS right away, and are held in the order they were
+// freed until recycled. Recycled extents are zero filled afresh, in a
+// single UFFDIO_ZEROPAGE each, ready for reuse. Both work on batches of
+// extents, which must be of whole pages. It is safe for concurrent use.
+//
+// The region must be registered in missing mode only, without a resident
+// limit, and be served meanwhile if its userfaultfd has
+// UFFD_FEATURE_EVENT_REMOVE.
+type Quarantine struct {
+	r        *Region
+	maxBytes int64
+
+	mu      sync.Mutex
+	extents []Extent // oldest first
+	bytes   int64
+}
+
+// NewQuarantine returns a Quarantine over r holding up to maxBytes of freed
+// extents, with no limit if 0.
+func NewQuarantine(r *Region, maxBytes int64) (*Quarantine, error) {
+	switch {
+	case r.mode != UFFDIO_REGISTER_MODE_MISSING:
+		return nil, errors.New("quarantine needs a region registered in missing mode only")
+	case r.maxResident > 0:
+		return nil, errors.New("quarantine is not compatible with a resident limit")
+	case !HaveIoctlPoison:
+		return nil, fmt.Errorf("%w: UFFDIO_POISON", ErrMissingIoctl)
+	}
+	return &Quarantine{r: r, maxBytes: maxBytes}, nil
+}
+
+// Free poisons the extents and quarantines them. If that holds more than
+// the limit, the oldest extents are recycled and returned. On error, the
+// extents before the failing one are quarantined.
+func (q *Quarantine) Free(extents ...Extent) ([]Extent, error) {
+	for _, x := range extents {
+		if err := q.check(x); err != nil {
+			return nil, err
+		}
+	}
+	for _, x := range extents {
+		if err := q.r.guardPages(q.r.Base()+uintptr(x.Off), x.Len); err != nil {
+			return nil, err
+		}
+		q.mu.Lock()
+		q.extents = append(q.extents, x)
+		q.bytes += int64(x.Len)
+		q.mu.Unlock()
+	}
+
+	var n int
+	q.mu.Lock()
+	for over := q.bytes; q.maxBytes > 0 && over > q.maxBytes; n++ {
+		over -= int64(q.extents[n].Len)
+	}
+	q.mu.Unlock()
+	return q.Recycle(n)
+}
+
+// Recycle takes the n oldest extents out of quarantine, or as many as
+// there are, and returns them zero filled.
+func (q *Quarantine) Recycle(n int) ([]Extent, error) {
+	q.mu.Lock()
+	n = min(n, len(q.extents))
+	extents := q.extents[:n:n]
+	q.extents = q.extents[n:]
+	for _, x := range extents {
+		q.bytes -= int64(x.Len)
+	}
+	q.mu.Unlock()
+
+	for k, x := range extents {
+		if err := q.r.recycle(q.r.Base()+uintptr(x.Off), x.Len); err != nil {
+			// Requarantine the extents left.
+			q.mu.Lock()
+			q.extents = append(extents[k:len(extents):len(extents)], q.extents...)
+			for _, x := range extents[k:] {
+				q.bytes += int64(x.Len)
+			}
+			q.mu.Unlock()
+			return extents[:k], err
+		}
+	}
+	return extents, nil
+}
+
+// Len returns the number of quarantined extents.
+func (q *Quarantine) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.extents)
+}
+
+// Bytes returns the size of the quarantined extents.
+func (q *Quarantine) Bytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+func (q *Quarantine) check(x Extent) error {
+	ps := q.r.pageSize
+	if x.Off < 0 || x.Len <= 0 || x.Off+x.Len > len(q.r.mem) || x.Off%ps != 0 || x.Len%ps != 0 {
+		return fmt.Errorf("extent %d+%d not of whole pages of region of %d bytes", x.Off, x.Len, len(q.r.mem))
+	}
+	return nil
+}
+
+// recycle unguards a range of whole pages of the region and fills it with
+// zeros.
+func (r *Region) recycle(start uintptr, length int) error {
+	r.guards.clearRangeAtomic(r.pageIndex(start), r.pageIndex(start)+length/r.pageSize)
+	if err := r.release(start, length); err != nil {
+		return err
+	}
+	if _, err := r.uffd.Zeropage(start, length, 0); err == nil {
+		for page := start; page < start+uintptr(length); page += uintptr(r.pageSize) {
+			r.zeroFilled(page)
+		}
+		return nil
+	}
+	// Some pages were faulted in meanwhile.
+	for page := start; page < start+uintptr(length); page += uintptr(r.pageSize) {
+		if _, err := r.uffd.Zeropage(page, r.pageSize, 0); err == nil {
+			r.zeroFilled(page)
+		} else if !errors.Is(err, ErrAlreadyMapped) {
+			return err
+		}
+	}
+	return nil
+}
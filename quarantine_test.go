@@ -0,0 +1,70 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestQuarantine(t *testing.T) {
+	if !HaveIoctlPoison {
+		t.Skip("UFFDIO_POISON not available")
+	}
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 8)
+	for i := range 8 {
+		mem[i*pageSize] = 0xAA
+	}
+	q, err := NewQuarantine(r, int64(3*pageSize))
+	if err != nil {
+		t.Fatalf("NewQuarantine failed: %v", err)
+	}
+	if _, err := q.Free(Extent{Off: 1, Len: pageSize}); err == nil {
+		t.Error("expected error on unaligned extent")
+	}
+
+	a, b, c := Extent{0, pageSize}, Extent{2 * pageSize, 2 * pageSize}, Extent{6 * pageSize, pageSize}
+	recycled, err := q.Free(a, b)
+	if err != nil || len(recycled) != 0 {
+		t.Fatalf("Free = %v, %v", recycled, err)
+	}
+	if q.Len() != 2 || q.Bytes() != int64(3*pageSize) {
+		t.Errorf("quarantined %d extents of %d bytes", q.Len(), q.Bytes())
+	}
+	for _, off := range []int{0, 3 * pageSize} {
+		if addr, faulted := CatchFault(func() { mem[off] = 0xEE }); !faulted || addr != r.Base()+uintptr(off) {
+			t.Errorf("use after free at %d: faulted %v at %#x", off, faulted, addr)
+		}
+	}
+	if mem[pageSize] != 0xAA {
+		t.Errorf("page 1 = %#x, want 0xaa", mem[pageSize])
+	}
+
+	// Going over the limit recycles the oldest extent.
+	recycled, err = q.Free(c)
+	if err != nil || !slices.Equal(recycled, []Extent{a}) {
+		t.Fatalf("Free = %v, %v, want [%v]", recycled, err, a)
+	}
+	if mem[0] != 0 || r.Guarded(0) {
+		t.Errorf("recycled page 0 = %#x, want 0", mem[0])
+	}
+	mem[0] = 0xBB
+
+	recycled, err = q.Recycle(5)
+	if err != nil || !slices.Equal(recycled, []Extent{b, c}) {
+		t.Fatalf("Recycle = %v, %v", recycled, err)
+	}
+	if q.Len() != 0 || q.Bytes() != 0 {
+		t.Errorf("quarantined %d extents of %d bytes", q.Len(), q.Bytes())
+	}
+	for _, i := range []int{2, 3, 6} {
+		if mem[i*pageSize] != 0 {
+			t.Errorf("recycled page %d = %#x, want 0", i, mem[i*pageSize])
+		}
+	}
+}
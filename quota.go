@@ -0,0 +1,12 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+// Quota limits the resources a ServeMux lets a range, or the ranges of a
+// tenant together, use to resolve page faults, so that one misbehaving
+// mapping cannot starve the others. Zero fields are unlimited.
+type Quota struct {
+	MaxResidentBytes   int64   // Memory filled by Region handlers, evicted past the limit
+	MaxFillBytesPerSec float64 // Bandwidth of missing faults
+	MaxConcurrentFills int     // Page faults resolved at once
+}
@@ -0,0 +1,258 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// quotaState enforces a Quota.
+type quotaState struct {
+	q       Quota
+	limiter *RateLimiter
+	fills   chan struct{} // semaphore of MaxConcurrentFills
+	mu      sync.Mutex    // serializes evictions for MaxResidentBytes
+}
+
+func newQuotaState(q Quota) *quotaState {
+	if q == (Quota{}) {
+		return nil
+	}
+	s := &quotaState{q: q}
+	if q.MaxFillBytesPerSec > 0 {
+		s.limiter = NewRateLimiter(q.MaxFillBytesPerSec, 0)
+	}
+	if q.MaxConcurrentFills > 0 {
+		s.fills = make(chan struct{}, q.MaxConcurrentFills)
+	}
+	return s
+}
+
+// quotaScope is a quota and the regions it applies to: those of tenant,
+// if set, or else region, if a Region.
+type quotaScope struct {
+	*quotaState
+	tenant string
+	region *Region
+}
+
+// SetQuota limits the resources used to resolve the page faults of the
+// range starting at start, as set by q. A zero Quota removes the limits.
+//
+// MaxResidentBytes only accounts for the pages of Region handlers filled
+// from then on, and releases them with their Evictor, attached if needed.
+// As with WithMaxResidentBytes, it is not compatible with
+// UFFD_FEATURE_EVENT_REMOVE.
+func (m *ServeMux) SetQuota(start uintptr, q Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.find(start)
+	if !ok {
+		return errors.New("range not handled")
+	}
+	m.routes[i].quota = newQuotaState(q)
+	if q.MaxResidentBytes > 0 {
+		trackResident(m.routes[i].handler)
+	}
+	return nil
+}
+
+// SetTenant assigns the range starting at start to tenant, whose ranges
+// share the quota set by SetTenantQuota, besides their own. An empty
+// tenant unassigns the range.
+func (m *ServeMux) SetTenant(start uintptr, tenant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.find(start)
+	if !ok {
+		return errors.New("range not handled")
+	}
+	m.routes[i].tenant = tenant
+	if s := m.tenants[tenant]; s != nil && s.q.MaxResidentBytes > 0 {
+		trackResident(m.routes[i].handler)
+	}
+	return nil
+}
+
+// SetTenantQuota limits the resources used to resolve the page faults of
+// the ranges of tenant together, as SetQuota does for a range.
+func (m *ServeMux) SetTenantQuota(tenant string, q Quota) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := newQuotaState(q)
+	if s == nil {
+		delete(m.tenants, tenant)
+		return
+	}
+	if m.tenants == nil {
+		m.tenants = make(map[string]*quotaState)
+	}
+	m.tenants[tenant] = s
+	if q.MaxResidentBytes > 0 {
+		for _, rt := range m.routes {
+			if rt.tenant == tenant {
+				trackResident(rt.handler)
+			}
+		}
+	}
+}
+
+// find returns the index of the route starting at start. It must be
+// called with m.mu held.
+func (m *ServeMux) find(start uintptr) (int, bool) {
+	return slices.BinarySearchFunc(m.routes, start, func(r muxRoute, start uintptr) int {
+		return cmp.Compare(r.start, start)
+	})
+}
+
+// trackResident attaches an Evictor to h if it is a Region without one.
+func trackResident(h Handler) {
+	if r, ok := h.(*Region); ok && r.evictor.Load() == nil {
+		NewEvictor(r, r.policy)
+	}
+}
+
+// quotas returns the quotas of the route at index i, its own first. It must
+// be called with m.mu held.
+func (m *ServeMux) quotas(i int) (scopes [2]quotaScope, n int) {
+	rt := m.routes[i]
+	if rt.quota != nil {
+		scopes[n] = quotaScope{quotaState: rt.quota}
+		scopes[n].region, _ = rt.handler.(*Region)
+		n++
+	}
+	if s := m.tenants[rt.tenant]; s != nil && rt.tenant != "" {
+		scopes[n] = quotaScope{quotaState: s, tenant: rt.tenant}
+		n++
+	}
+	return scopes, n
+}
+
+// admission is a page fault going through the quotas of its range.
+type admission struct {
+	scopes  [2]quotaScope
+	n       int
+	missing bool
+	held    int           // scopes whose fill and rate quotas were taken
+	delay   time.Duration // until the rate quotas allow the fill
+}
+
+func newAdmission(scopes [2]quotaScope, n int, msg *UffdMsg) admission {
+	missing := msg.GetPagefault().Flags&(UFFD_PAGEFAULT_FLAG_MINOR|UFFD_PAGEFAULT_FLAG_WP) == 0
+	return admission{scopes: scopes, n: n, missing: missing}
+}
+
+// try takes the quotas of the fault without waiting, and reports whether
+// it can be resolved now. Otherwise wait takes the rest.
+func (a *admission) try() bool {
+	for ; a.held < a.n; a.held++ {
+		s := a.scopes[a.held]
+		if s.fills != nil {
+			select {
+			case s.fills <- struct{}{}:
+			default:
+				return false
+			}
+		}
+		a.charge(s)
+	}
+	return a.delay <= 0
+}
+
+// wait takes the quotas of the fault not taken by try.
+func (a *admission) wait() {
+	for ; a.held < a.n; a.held++ {
+		s := a.scopes[a.held]
+		if s.fills != nil {
+			s.fills <- struct{}{}
+		}
+		a.charge(s)
+	}
+	time.Sleep(a.delay)
+}
+
+func (a *admission) charge(s quotaScope) {
+	if a.missing && s.limiter != nil {
+		a.delay = max(a.delay, s.limiter.take(os.Getpagesize()))
+	}
+}
+
+// reserve makes room for the page filled by a missing fault within the
+// resident quotas.
+func (a *admission) reserve(m *ServeMux) error {
+	if !a.missing {
+		return nil
+	}
+	for _, s := range a.scopes[:a.n] {
+		if err := s.reserve(m, os.Getpagesize()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release gives back the fill quotas taken.
+func (a *admission) release() {
+	for _, s := range a.scopes[:a.held] {
+		if s.fills != nil {
+			<-s.fills
+		}
+	}
+	a.held = 0
+}
+
+// reserve evicts pages of the regions of the scope, the most resident
+// first, to make room for one more within MaxResidentBytes.
+func (s quotaScope) reserve(m *ServeMux, pageSize int) error {
+	if s.q.MaxResidentBytes <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type evictor struct {
+		e        *Evictor
+		resident int
+	}
+	var evictors []evictor
+	excess := int64(pageSize) - s.q.MaxResidentBytes
+	add := func(r *Region) {
+		if e := r.evictor.Load(); e != nil {
+			n := e.Resident()
+			evictors = append(evictors, evictor{e, n})
+			excess += int64(n) * int64(pageSize)
+		}
+	}
+	if s.tenant != "" {
+		m.mu.RLock()
+		for _, rt := range m.routes {
+			if r, ok := rt.handler.(*Region); ok && rt.tenant == s.tenant {
+				add(r)
+			}
+		}
+		m.mu.RUnlock()
+	} else if s.region != nil {
+		add(s.region)
+	}
+	slices.SortFunc(evictors, func(a, b evictor) int {
+		return cmp.Compare(b.resident, a.resident)
+	})
+	for _, ev := range evictors {
+		if excess <= 0 {
+			break
+		}
+		n, err := ev.e.Evict(int((excess + int64(pageSize) - 1) / int64(pageSize)))
+		if err != nil {
+			return err
+		}
+		excess -= int64(n) * int64(pageSize)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate of page fills with two token buckets, one of
+// bytes and one of fills, each holding up to a second worth of tokens. It
+// is safe for concurrent use, and can be shared by several regions.
+type RateLimiter struct {
+	mu    sync.Mutex
+	bytes bucket
+	fills bucket
+	last  time.Time // of the last refill
+}
+
+type bucket struct {
+	rate   float64 // tokens per second, 0 if unlimited
+	tokens float64 // negative while in debt
+}
+
+// refill adds the tokens of d, up to a second worth.
+func (b *bucket) refill(d time.Duration) {
+	b.tokens = min(b.tokens+d.Seconds()*b.rate, b.rate)
+}
+
+// take removes n tokens and returns how long to wait for the debt to be
+// paid off.
+func (b *bucket) take(n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSec bytes and
+// fillsPerSec fills per second. Zero means no limit.
+func NewRateLimiter(bytesPerSec, fillsPerSec float64) *RateLimiter {
+	return &RateLimiter{
+		bytes: bucket{rate: bytesPerSec, tokens: bytesPerSec},
+		fills: bucket{rate: fillsPerSec, tokens: fillsPerSec},
+		last:  time.Now(),
+	}
+}
+
+// Wait waits until a fill of n bytes is allowed, or ctx is done. Fills
+// take their tokens at once, going into debt if needed, and wait for the
+// debt to be paid off, so fills larger than a second worth of bytes are
+// allowed too.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	wait := l.take(n)
+	if wait <= 0 {
+		return nil
+	}
+	if err := sleepContext(ctx, wait); err != nil {
+		// Give the tokens back for the fill that did not happen.
+		l.mu.Lock()
+		if l.bytes.rate > 0 {
+			l.bytes.tokens += float64(n)
+		}
+		if l.fills.rate > 0 {
+			l.fills.tokens++
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// take takes the tokens of a fill of n bytes and returns how long to wait
+// before filling.
+func (l *RateLimiter) take(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.bytes.refill(now.Sub(l.last))
+	l.fills.refill(now.Sub(l.last))
+	l.last = now
+	return max(l.bytes.take(float64(n)), l.fills.take(1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
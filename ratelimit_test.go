@@ -0,0 +1,50 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	// A second worth of fills is allowed at once.
+	l := NewRateLimiter(0, 20)
+	start := time.Now()
+	for range 20 {
+		if err := l.Wait(ctx, 1<<20); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 25*time.Millisecond {
+		t.Errorf("burst of 20 fills took %v", d)
+	}
+	start = time.Now()
+	l.Wait(ctx, 1)
+	if d := time.Since(start); d < 25*time.Millisecond {
+		t.Errorf("fill beyond the burst took %v, want about 50ms", d)
+	}
+
+	// A fill larger than the burst goes into debt.
+	l = NewRateLimiter(1000, 0)
+	start = time.Now()
+	l.Wait(ctx, 1050)
+	if d := time.Since(start); d < 25*time.Millisecond {
+		t.Errorf("fill of 1.05 seconds worth took %v, want about 50ms", d)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.Wait(cctx, 2000); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait with canceled context = %v", err)
+	}
+	start = time.Now()
+	l.Wait(ctx, 1)
+	if d := time.Since(start); d > 25*time.Millisecond {
+		t.Errorf("canceled fill kept its tokens: next fill took %v", d)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ReadAt implements io.ReaderAt over the memory of the region. In missing
+// mode, the pages read are filled with Prefetch first rather than faulted
+// on, as a goroutine blocked on a fault in the process would hold up the
+// stops of the runtime, deadlocking with the goroutine serving the region
+// if it allocates. Otherwise, or past the budget of WithMaxResidentBytes,
+// or for pages evicted meanwhile, reading a page that is not resident
+// faults it in like any other access, with that risk.
+func (r *Region) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(r.mem)) {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), int64(len(r.mem)))
+	if r.mode&UFFDIO_REGISTER_MODE_MISSING != 0 {
+		if err := r.Prefetch(context.Background(), off, end-off); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.mem[off:end])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Reader returns an io.ReadSeeker over the memory of the region, with the
+// same requirements as ReadAt.
+func (r *Region) Reader() *io.SectionReader {
+	return io.NewSectionReader(r, 0, int64(len(r.mem)))
+}
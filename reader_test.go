@@ -0,0 +1,47 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRegionReader(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	_, r := serveRegion(t, 3, WithProvider(patternProvider(3)))
+
+	want := make([]byte, 3*pageSize)
+	for i := range want {
+		want[i] = byte(i/pageSize + 1)
+	}
+	if err := iotest.TestReader(r.Reader(), want); err != nil {
+		t.Error(err)
+	}
+
+	buf := make([]byte, 2)
+	if n, err := r.ReadAt(buf, int64(3*pageSize-1)); n != 1 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v", n, err)
+	}
+	if _, err := r.ReadAt(buf, -1); err == nil {
+		t.Error("ReadAt accepted a negative offset")
+	}
+
+	sr := r.Reader()
+	if _, err := sr.Seek(int64(pageSize), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want[pageSize:]) {
+		t.Error("read after Seek does not match")
+	}
+}
@@ -0,0 +1,26 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+// WriteAction is what a read-only region does with a write to a page.
+type WriteAction int
+
+const (
+	WritePoison WriteAction = iota // Poison the page, raising SIGBUS in the writer and later accessors
+	WriteAllow                     // Let the write through, and later ones to the page
+	WriteCopy                      // Let the write through to a private copy of the page, tracked by Overlay
+)
+
+// WriteViolation describes a write caught in a read-only region.
+type WriteViolation struct {
+	Addr   uintptr     // Faulting address
+	Tid    int         // Writing thread, 0 if UFFD_FEATURE_THREAD_ID is not available
+	Action WriteAction // What is done with the write
+}
+
+// RegionPolicy restricts the accesses to the pages of a region.
+type RegionPolicy struct {
+	ReadOnly bool                 // Catch the writes to the pages filled by the region
+	Writes   WriteAction          // What to do with caught writes
+	OnWrite  func(WriteViolation) // Called for each caught write if not nil, before the action
+}
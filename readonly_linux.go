@@ -0,0 +1,115 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// readOnly intercepts the writes to a region set up with WithRegionPolicy.
+type readOnly struct {
+	policy RegionPolicy
+
+	mu      sync.Mutex
+	overlay PageSet
+}
+
+// WithRegionPolicy restricts the accesses to the region as set by p. With
+// ReadOnly, the region must be registered in missing and write-protect
+// modes, on a userfaultfd with UFFD_FEATURE_PAGEFAULT_FLAG_WP: pages are
+// filled write-protected, so that reads are served as usual while writes
+// fault and are handled as set by Writes. This enforces the immutability of
+// a dataset shared by many processes, where tracking writes is not enough.
+//
+// WritePoison releases the page before poisoning it, and so is not
+// compatible with UFFD_FEATURE_EVENT_REMOVE, whose event the serving
+// goroutine would wait on. WriteCopy is not compatible with
+// WithMaxResidentBytes, which would drop the private copies. Neither is
+// compatible with WithWriteBack.
+func WithRegionPolicy(p RegionPolicy) RegionOption {
+	return func(r *Region) {
+		r.readOnly = nil
+		if p.ReadOnly {
+			r.readOnly = &readOnly{policy: p}
+		}
+	}
+}
+
+func (r *Region) checkReadOnly() error {
+	switch {
+	case r.mode&UFFDIO_REGISTER_MODE_MISSING == 0 || r.mode&UFFDIO_REGISTER_MODE_WP == 0:
+		return errors.New("read-only policy needs missing and write-protect modes")
+	case r.mode&UFFDIO_REGISTER_MODE_MINOR != 0:
+		return errors.New("read-only policy is not supported in minor mode")
+	case r.writeBack != nil:
+		return errors.New("read-only policy is not compatible with write-back")
+	case r.readOnly.policy.Writes == WritePoison && !HaveIoctlPoison:
+		return fmt.Errorf("%w: UFFDIO_POISON", ErrMissingIoctl)
+	case r.readOnly.policy.Writes == WritePoison && r.fillsTracked():
+		return errors.New("poisoning writes is not compatible with UFFD_FEATURE_EVENT_REMOVE")
+	case r.readOnly.policy.Writes == WriteCopy && r.maxResident > 0:
+		return errors.New("copying writes is not compatible with a resident limit")
+	}
+	return nil
+}
+
+// interceptWrite resolves a write-protect fault of a read-only region.
+func (r *Region) interceptWrite(addr, page uintptr, tid uint32) error {
+	ro := r.readOnly
+	if fn := ro.policy.OnWrite; fn != nil {
+		fn(WriteViolation{Addr: addr, Tid: int(tid), Action: ro.policy.Writes})
+	}
+	switch ro.policy.Writes {
+	case WritePoison:
+		return r.poison(page)
+	case WriteCopy:
+		ro.mu.Lock()
+		ro.overlay.Add(uint64(r.pageIndex(page)))
+		ro.mu.Unlock()
+	}
+	return r.uffd.WriteProtect(page, r.pageSize, r.wakeMode(UFFDIO_WRITEPROTECT_MODE_DONTWAKE))
+}
+
+// poison replaces a filled page with a poisoned one.
+func (r *Region) poison(page uintptr) error {
+	if err := r.madvise(int(page-r.Base()), r.pageSize, unix.MADV_DONTNEED); err != nil {
+		return err
+	}
+	r.unfill(page, r.pageSize)
+	if e := r.evictor.Load(); e != nil {
+		e.Forget(page, r.pageSize)
+	}
+	_, err := r.uffd.Poison(page, r.pageSize, r.wakeMode(UFFDIO_POISON_MODE_DONTWAKE))
+	if err == nil {
+		r.onPoison(page)
+	}
+	return err
+}
+
+// Overlay returns the pages of the region privately copied on write with
+// WriteCopy, by index. Pages removed by madvise(2) are dropped if the
+// region is told about it by UFFD_FEATURE_EVENT_REMOVE, as they are filled
+// from the provider again.
+func (r *Region) Overlay() *PageSet {
+	ro := r.readOnly
+	if ro == nil {
+		return new(PageSet)
+	}
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+	return ro.overlay.Clone()
+}
+
+// discardOverlay forgets the private copies of a range, which may extend
+// beyond the region.
+func (r *Region) discardOverlay(start uintptr, length int) {
+	if ro := r.readOnly; ro != nil {
+		r.removePages(&ro.mu, &ro.overlay, start, length)
+	}
+}
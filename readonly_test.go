@@ -0,0 +1,78 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRegionReadOnlyCopy(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	var mu sync.Mutex
+	var violations []WriteViolation
+	r := serveWriteProtected(t, 4, WithRegionPolicy(RegionPolicy{
+		ReadOnly: true,
+		Writes:   WriteCopy,
+		OnWrite: func(v WriteViolation) {
+			mu.Lock()
+			violations = append(violations, v)
+			mu.Unlock()
+		},
+	}))
+	mem := r.Bytes()
+
+	if mem[0] != 1 || mem[2*pageSize] != 3 {
+		t.Fatalf("unexpected page contents")
+	}
+	mem[2*pageSize+1] = 0xEE
+	mem[2*pageSize+2] = 0xEE
+	if mem[2*pageSize+1] != 0xEE {
+		t.Errorf("write not let through")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(violations) != 1 || violations[0].Addr&^uintptr(pageSize-1) != r.Base()+uintptr(2*pageSize) || violations[0].Action != WriteCopy {
+		t.Errorf("violations = %+v", violations)
+	}
+	if got := slices.Collect(r.Overlay().All()); !slices.Equal(got, []uint64{2}) {
+		t.Errorf("Overlay = %v, want [2]", got)
+	}
+}
+
+func TestRegionReadOnlyPoison(t *testing.T) {
+	if !HaveIoctlPoison {
+		t.Skip("UFFDIO_POISON not available")
+	}
+	pageSize := unix.Getpagesize()
+	r := serveWriteProtected(t, 2, WithRegionPolicy(RegionPolicy{ReadOnly: true, Writes: WritePoison}))
+	mem := r.Bytes()
+
+	if mem[pageSize] != 2 {
+		t.Fatalf("page 1 = %#x, want 2", mem[pageSize])
+	}
+	addr, faulted := CatchFault(func() { mem[pageSize] = 0xEE })
+	if !faulted || addr != r.Base()+uintptr(pageSize) {
+		t.Fatalf("write raised no SIGBUS: %#x, %v", addr, faulted)
+	}
+	if mem[0] != 1 {
+		t.Errorf("other page changed")
+	}
+}
+
+func TestRegionReadOnlyAllow(t *testing.T) {
+	r := serveWriteProtected(t, 1, WithRegionPolicy(RegionPolicy{ReadOnly: true, Writes: WriteAllow}))
+	mem := r.Bytes()
+	mem[0] = 0xEE
+	if mem[0] != 0xEE || r.Overlay().Len() != 0 {
+		t.Errorf("write not let through untracked")
+	}
+	// The fault is counted after the writer is woken up.
+	waitFor(t, func() bool { return r.Stats().WP == 1 })
+}
@@ -0,0 +1,225 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Traces start with traceMagic, the start time in nanoseconds since the
+// epoch as a little-endian int64 and the byte order of the recording
+// machine, 'L' or 'B', followed by one record per event: the nanoseconds
+// elapsed since the previous event as an uvarint and the raw UffdMsg, in
+// that byte order. Traces of the other byte order are rejected.
+const traceMagic = "UFFDTRC1"
+
+// traceByteOrder returns the byte order of the raw messages of the traces
+// written by this machine.
+func traceByteOrder() byte {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) == 1 {
+		return 'L'
+	}
+	return 'B'
+}
+
+// Recorder is a Handler that writes every event to a trace before passing
+// it on to the next handler. It is safe for concurrent use.
+type Recorder struct {
+	mu   sync.Mutex
+	w    *bufio.Writer
+	next Handler
+	last time.Time
+}
+
+// NewRecorder writes a trace header to w and returns a Recorder passing
+// events on to next, which may be nil.
+func NewRecorder(w io.Writer, next Handler) (*Recorder, error) {
+	now := time.Now()
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(traceMagic); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, now.UnixNano()); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(traceByteOrder()); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: bw, next: next, last: now}, nil
+}
+
+// HandleEvent records msg and passes it on to the next handler.
+func (r *Recorder) HandleEvent(u *Uffd, msg *UffdMsg) error {
+	if err := r.record(msg); err != nil {
+		return err
+	}
+	if r.next == nil {
+		return nil
+	}
+	return r.next.HandleEvent(u, msg)
+}
+
+// EndBatch passes the end of a batch on to the next handler, if it is a
+// BatchHandler.
+func (r *Recorder) EndBatch(u *Uffd) error {
+	if bh, ok := r.next.(BatchHandler); ok {
+		return bh.EndBatch(u)
+	}
+	return nil
+}
+
+func (r *Recorder) record(msg *UffdMsg) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	delta := max(now.Sub(r.last), 0)
+	r.last = now
+
+	var buf [binary.MaxVarintLen64]byte
+	if _, err := r.w.Write(binary.AppendUvarint(buf[:0], uint64(delta))); err != nil {
+		return err
+	}
+	_, err := r.w.Write(msgBytes(msg))
+	return err
+}
+
+// Flush writes any buffered records to the underlying writer.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Flush()
+}
+
+// TraceReader reads the events of a trace written by a Recorder.
+type TraceReader struct {
+	r    *bufio.Reader
+	last time.Time
+}
+
+// NewTraceReader reads the trace header from r.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	br := bufio.NewReader(r)
+
+	var hdr [len(traceMagic) + 8 + 1]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading trace header: %w", err)
+	}
+	if string(hdr[:len(traceMagic)]) != traceMagic {
+		return nil, errors.New("not a userfaultfd trace")
+	}
+	if order := hdr[len(hdr)-1]; order != traceByteOrder() {
+		return nil, fmt.Errorf("trace recorded with byte order %q, not %q", order, traceByteOrder())
+	}
+	start := int64(binary.LittleEndian.Uint64(hdr[len(traceMagic):]))
+	return &TraceReader{r: br, last: time.Unix(0, start)}, nil
+}
+
+// Next returns the next event and the time it was recorded.
+// Returns io.EOF at the end of the trace.
+func (t *TraceReader) Next() (time.Time, *UffdMsg, error) {
+	delta, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	var msg UffdMsg
+	if _, err := io.ReadFull(t.r, msgBytes(&msg)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return time.Time{}, nil, err
+	}
+
+	t.last = t.last.Add(time.Duration(delta))
+	return t.last, &msg, nil
+}
+
+// msgBytes returns the bytes of msg, in the layout of the kernel.
+func msgBytes(msg *UffdMsg) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(msg)), MsgSize)
+}
+
+// EventReader decodes the events of a trace written by a Recorder, or of a
+// raw capture of messages as read from a userfaultfd, for offline analysis
+// without a live userfaultfd.
+type EventReader struct {
+	trace *TraceReader // nil for raw captures
+	r     *bufio.Reader
+	n     int // events read
+}
+
+// NewEventReader reads the events in r, telling traces from raw captures
+// by their header.
+func NewEventReader(r io.Reader) (*EventReader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(traceMagic)); err == nil && string(magic) == traceMagic {
+		t, err := NewTraceReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &EventReader{trace: t}, nil
+	}
+	return &EventReader{r: br}, nil
+}
+
+// Next decodes the next event with DecodeMsg and returns it with the time
+// it was recorded, the zero time in raw captures. Returns io.EOF at the
+// end of the stream.
+func (e *EventReader) Next() (time.Time, Event, error) {
+	var when time.Time
+	var msg UffdMsg
+	var err error
+	if e.trace != nil {
+		var m *UffdMsg
+		if when, m, err = e.trace.Next(); err == nil {
+			msg = *m
+		}
+	} else if _, err = io.ReadFull(e.r, msgBytes(&msg)); err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("event %d: %w", e.n, err)
+	}
+	if err != nil {
+		return time.Time{}, Event{}, err
+	}
+	ev, err := DecodeMsg(msgBytes(&msg))
+	if err != nil {
+		return time.Time{}, Event{}, fmt.Errorf("event %d: %w", e.n, err)
+	}
+	e.n++
+	return when, ev, nil
+}
+
+// Replay feeds the events of the trace in r through h, passing u along.
+// If realtime is true, the original spacing between events is reproduced.
+func Replay(r io.Reader, u *Uffd, h Handler, realtime bool) error {
+	t, err := NewTraceReader(r)
+	if err != nil {
+		return err
+	}
+
+	var prev time.Time
+	for {
+		when, msg, err := t.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if realtime && !prev.IsZero() {
+			time.Sleep(when.Sub(prev))
+		}
+		prev = when
+
+		if err := h.HandleEvent(u, msg); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pagefaultMsg(addr, flags uint64) *UffdMsg {
+	msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	pf := msg.GetPagefault()
+	pf.Address = addr
+	pf.Flags = flags
+	return msg
+}
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	var passed int
+
+	rec, err := NewRecorder(&buf, HandlerFunc(func(u *Uffd, msg *UffdMsg) error {
+		passed++
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+
+	start := time.Now()
+	msgs := []*UffdMsg{
+		pagefaultMsg(0x1000, 0),
+		pagefaultMsg(0x2000, UFFD_PAGEFAULT_FLAG_WRITE),
+		{Event: UFFD_EVENT_UNMAP},
+	}
+	for _, msg := range msgs {
+		if err := rec.HandleEvent(nil, msg); err != nil {
+			t.Fatalf("HandleEvent failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if passed != len(msgs) {
+		t.Fatalf("%d events passed on, want %d", passed, len(msgs))
+	}
+
+	tr, err := NewTraceReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewTraceReader failed: %v", err)
+	}
+	var last time.Time
+	for i, want := range msgs {
+		when, msg, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if *msg != *want {
+			t.Errorf("event %d = %+v, want %+v", i, msg, want)
+		}
+		if when.Before(start.Truncate(time.Nanosecond)) || when.Before(last) {
+			t.Errorf("event %d has bad timestamp %v", i, when)
+		}
+		last = when
+	}
+	if _, _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	var replayed []*UffdMsg
+	start = time.Now()
+	err = Replay(bytes.NewReader(buf.Bytes()), nil, HandlerFunc(func(u *Uffd, msg *UffdMsg) error {
+		replayed = append(replayed, msg)
+		return nil
+	}), true)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(replayed) != len(msgs) {
+		t.Fatalf("replayed %d events, want %d", len(replayed), len(msgs))
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("realtime replay took %v, expected at least 20ms", elapsed)
+	}
+}
+
+// endBatchCounter is a BatchHandler counting the batches ended.
+type endBatchCounter struct {
+	Handler
+	batches int
+}
+
+func (h *endBatchCounter) EndBatch(u *Uffd) error {
+	h.batches++
+	return nil
+}
+
+func TestRecorderEndBatch(t *testing.T) {
+	next := &endBatchCounter{Handler: HandlerFunc(func(*Uffd, *UffdMsg) error { return nil })}
+	rec, err := NewRecorder(io.Discard, next)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	var bh BatchHandler = rec
+	if err := bh.EndBatch(nil); err != nil || next.batches != 1 {
+		t.Errorf("EndBatch = %v, %d batches ended, want 1", err, next.batches)
+	}
+
+	// Without a BatchHandler to pass it on to, EndBatch does nothing.
+	rec, err = NewRecorder(io.Discard, nil)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	if err := rec.EndBatch(nil); err != nil {
+		t.Errorf("EndBatch without a next handler: %v", err)
+	}
+}
+
+func TestTraceReaderInvalid(t *testing.T) {
+	if _, err := NewTraceReader(strings.NewReader("NOTATRACE.......")); err == nil {
+		t.Fatalf("expected error for bad magic")
+	}
+
+	var buf bytes.Buffer
+	rec, _ := NewRecorder(&buf, nil)
+	rec.HandleEvent(nil, pagefaultMsg(0x1000, 0))
+	rec.Flush()
+
+	tr, err := NewTraceReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err != nil {
+		t.Fatalf("NewTraceReader failed: %v", err)
+	}
+	if _, _, err := tr.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF for truncated record, got %v", err)
+	}
+
+	// The messages of a trace from a machine of the other byte order
+	// cannot be read.
+	other := bytes.Clone(buf.Bytes())
+	other[len(traceMagic)+8] ^= 'L' ^ 'B'
+	if _, err := NewTraceReader(bytes.NewReader(other)); err == nil {
+		t.Fatalf("expected error for the other byte order")
+	}
+}
+
+func TestEventReader(t *testing.T) {
+	msgs := []*UffdMsg{
+		pagefaultMsg(0x1000, UFFD_PAGEFAULT_FLAG_WRITE),
+		{Event: UFFD_EVENT_FORK},
+		{Event: UFFD_EVENT_UNMAP},
+	}
+	msgs[1].GetFork().Ufd = 7
+	*msgs[2].GetRemove() = UffdMsgRemove{Start: 0x1000, End: 0x3000}
+
+	var trace, raw bytes.Buffer
+	rec, err := NewRecorder(&trace, nil)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	for _, msg := range msgs {
+		if err := rec.HandleEvent(nil, msg); err != nil {
+			t.Fatalf("HandleEvent failed: %v", err)
+		}
+		raw.Write(msgBytes(msg))
+	}
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	for name, stream := range map[string][]byte{"trace": trace.Bytes(), "raw": raw.Bytes()} {
+		er, err := NewEventReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("NewEventReader of %s failed: %v", name, err)
+		}
+		for i, msg := range msgs {
+			when, e, err := er.Next()
+			if err != nil {
+				t.Fatalf("%s event %d: Next failed: %v", name, i, err)
+			}
+			if (name == "trace") == when.IsZero() {
+				t.Errorf("%s event %d recorded at %v", name, i, when)
+			}
+			want := Event{Type: msg.Event}
+			switch msg.Event {
+			case UFFD_EVENT_PAGEFAULT:
+				want.Pagefault = *msg.GetPagefault()
+			case UFFD_EVENT_FORK:
+				want.Fork = *msg.GetFork()
+			case UFFD_EVENT_UNMAP:
+				want.Remove = *msg.GetRemove()
+			}
+			if e != want {
+				t.Errorf("%s event %d = %+v, want %+v", name, i, e, want)
+			}
+		}
+		if _, _, err := er.Next(); err != io.EOF {
+			t.Errorf("%s: expected EOF, got %v", name, err)
+		}
+	}
+
+	// Truncated and invalid captures.
+	er, _ := NewEventReader(bytes.NewReader(raw.Bytes()[:MsgSize+1]))
+	er.Next()
+	if _, _, err := er.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated capture: %v", err)
+	}
+	er, _ = NewEventReader(bytes.NewReader(make([]byte, MsgSize)))
+	if _, _, err := er.Next(); !errors.Is(err, ErrInvalidMsg) {
+		t.Errorf("invalid capture: %v", err)
+	}
+}
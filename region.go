@@ -0,0 +1,668 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Region is a memory range registered with a userfaultfd whose page faults
+// are resolved from a PageProvider, or with zero pages if there is none.
+type Region struct {
+	uffd     *Uffd
+	mem      []byte
+	mode     int
+	provider PageProvider
+	pageSize int
+	bufs     *stagingPool // staging buffers for provider pages
+	evictor  atomic.Pointer[Evictor]
+
+	maxResident int64
+	policy      EvictionPolicy
+	store       EvictionStore
+	storeMu     sync.Mutex
+	stored      []UffdioRange // evicted to the store, UFFD_EVENT_REMOVE not seen yet
+
+	numa       bool
+	mergeable  bool
+	dontWake   bool
+	selfFaults bool // set by WithSelfFaultDetection
+	wakeMu     sync.Mutex
+	pending    []UffdioRange // resolved but not woken up yet
+	endMu      sync.Mutex    // serializes EndBatch, which owns spare
+	spare      []UffdioRange
+
+	heatmap *heatmap
+	hashes  *pageHashes
+	stats   regionStats
+	tracer  Tracer
+
+	limiter     *RateLimiter
+	limitDemand bool // limit the fills of faults too
+
+	filled    Bitmap // pages filled or continued, updated atomically
+	guards    Bitmap // pages poisoned by GuardRange, updated atomically
+	writeBack *writeBack
+	readOnly  *readOnly
+	staging   *moveStaging
+	hooks     PageHooks
+	meta      sync.Map // of page index to value set by SetPageMeta
+
+	origin  *Region // of a clone
+	cloneMu sync.RWMutex
+	clones  []*Region
+
+	mapped    bool   // mem was mapped by MapAndRegister or MapFileMinor
+	alias     []byte // populate view of MapFileMinor
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// RegionOption configures a Region.
+type RegionOption func(*Region)
+
+// WithProvider sets the source of page contents for missing faults.
+func WithProvider(p PageProvider) RegionOption {
+	return func(r *Region) {
+		r.provider = p
+	}
+}
+
+// WithMaxResidentBytes limits the memory filled by the region. Before
+// resolving a missing fault that would exceed the budget, pages chosen by
+// the eviction policy are released with MADV_DONTNEED.
+//
+// Not compatible with UFFD_FEATURE_EVENT_REMOVE, as the serving goroutine
+// would wait on its own event.
+func WithMaxResidentBytes(n int64) RegionOption {
+	return func(r *Region) {
+		r.maxResident = n
+	}
+}
+
+// WithEvictionPolicy sets the policy used to enforce WithMaxResidentBytes.
+// Defaults to NewLRUPolicy.
+func WithEvictionPolicy(p EvictionPolicy) RegionOption {
+	return func(r *Region) {
+		r.policy = p
+	}
+}
+
+// WithEvictionStore keeps the pages evicted from the region in s, such as
+// a CompressedStore or a SpillStore, and resolves their next faults from it
+// rather than from the provider. Pages s fails to keep are dropped as
+// without it.
+func WithEvictionStore(s EvictionStore) RegionOption {
+	return func(r *Region) {
+		r.store = s
+	}
+}
+
+// WithDeferredWake resolves faults without waking up the faulting threads,
+// which are woken up by EndBatch with one UFFDIO_WAKE per run of contiguous
+// pages. This saves ioctls when bursts of faults are read at once, as Serve
+// does. Callers of HandleEvent must then call EndBatch.
+func WithDeferredWake() RegionOption {
+	return func(r *Region) {
+		r.dontWake = true
+	}
+}
+
+// WithRateLimiter limits the provider reads of Prefetch with l, and those
+// of missing faults too if demand is true. Limiting faults delays the
+// faulting threads but leaves the bandwidth to prefetching.
+func WithRateLimiter(l *RateLimiter, demand bool) RegionOption {
+	return func(r *Region) {
+		r.limiter = l
+		r.limitDemand = demand
+	}
+}
+
+// RegisterRegion registers mem with the given mode and returns a Region
+// that resolves its faults when passed to Serve.
+func (u *Uffd) RegisterRegion(mem []byte, mode int, opts ...RegionOption) (*Region, error) {
+	r := &Region{
+		uffd:     u,
+		mem:      mem,
+		mode:     mode,
+		pageSize: os.Getpagesize(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.filled = NewBitmap((len(mem) + r.pageSize - 1) / r.pageSize)
+	r.guards = NewBitmap(r.filled.Len())
+	r.bufs = newStagingPool(r.pageSize, r.pageSize >= hugeStagingSize)
+
+	if r.maxResident > 0 {
+		if r.maxResident < int64(r.pageSize) {
+			return nil, fmt.Errorf("resident limit %d smaller than page size", r.maxResident)
+		}
+		NewEvictor(r, r.policy)
+	}
+	if r.writeBack != nil {
+		if err := r.checkWriteBack(); err != nil {
+			return nil, err
+		}
+	}
+	if r.readOnly != nil {
+		if err := r.checkReadOnly(); err != nil {
+			return nil, err
+		}
+	}
+	if r.selfFaults {
+		if err := r.checkSelfFaults(); err != nil {
+			return nil, err
+		}
+	}
+	if r.staging != nil {
+		if err := r.checkMoveStaging(); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.mergeable {
+		if err := r.madvise(0, len(mem), unix.MADV_MERGEABLE); err != nil {
+			return nil, err
+		}
+	}
+	if err := unlockRegion(mem); err != nil {
+		return nil, err
+	}
+	reg, err := u.Register(r.Base(), len(mem), mode)
+	if err != nil {
+		return nil, err
+	}
+	var need []int
+	if mode&UFFDIO_REGISTER_MODE_MINOR != 0 {
+		need = append(need, _UFFDIO_CONTINUE)
+	}
+	if mode&UFFDIO_REGISTER_MODE_WP != 0 {
+		need = append(need, _UFFDIO_WRITEPROTECT)
+	}
+	if r.readOnly != nil && r.readOnly.policy.Writes == WritePoison {
+		need = append(need, _UFFDIO_POISON)
+	}
+	if err := reg.Require(need...); err != nil {
+		u.Unregister(r.Base(), len(mem))
+		return nil, err
+	}
+	if r.staging != nil {
+		if err := r.startMoveStaging(reg); err != nil {
+			u.Unregister(r.Base(), len(mem))
+			return nil, err
+		}
+	}
+	if r.writeBack != nil {
+		r.startWriteBack()
+	}
+	return r, nil
+}
+
+// MapAndRegister maps size bytes of anonymous memory, rounded up to whole
+// pages, and registers them with the given mode. The mapping is shared for
+// UFFDIO_REGISTER_MODE_MINOR, which needs shmem, and private otherwise.
+// Close unregisters and unmaps it.
+func (u *Uffd) MapAndRegister(size int64, mode int, opts ...RegionOption) (*Region, error) {
+	length := RoundUpPage(uintptr(size))
+	if size <= 0 || int64(length) < size || int(length) < 0 {
+		return nil, fmt.Errorf("invalid mapping size %d", size)
+	}
+	flags := unix.MAP_PRIVATE | unix.MAP_ANONYMOUS | unix.MAP_NORESERVE
+	if mode&UFFDIO_REGISTER_MODE_MINOR != 0 {
+		flags = unix.MAP_SHARED | unix.MAP_ANONYMOUS | unix.MAP_NORESERVE
+	}
+	mem, err := unix.Mmap(-1, 0, int(length), unix.PROT_READ|unix.PROT_WRITE, flags)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	r, err := u.RegisterRegion(mem, mode, opts...)
+	if err != nil {
+		unix.Munmap(mem)
+		return nil, err
+	}
+	r.mapped = true
+	return r, nil
+}
+
+// Close unregisters the region, and unmaps it if it was mapped by
+// MapAndRegister or MapFileMinor, in which case its memory must not be
+// accessed anymore. Otherwise the memory is left mapped. With write-back,
+// it first stops the background flushes and flushes if the policy says so.
+// The staging arena of WithMoveStaging is unmapped too, so no fault may be
+// being resolved. Clones also close their userfaultfd. Only the first call
+// has an effect.
+func (r *Region) Close() error {
+	r.closeOnce.Do(func() {
+		if r.origin != nil {
+			r.detach()
+		}
+		if r.writeBack != nil {
+			r.closeErr = r.closeWriteBack()
+		}
+		r.closeErr = errors.Join(r.closeErr, r.uffd.Unregister(r.Base(), len(r.mem)))
+		if r.mapped {
+			r.closeErr = errors.Join(r.closeErr, unix.Munmap(r.mem))
+		}
+		if r.alias != nil {
+			r.closeErr = errors.Join(r.closeErr, unix.Munmap(r.alias))
+		}
+		if r.staging != nil {
+			r.closeErr = errors.Join(r.closeErr, r.closeMoveStaging())
+		}
+		if r.origin != nil {
+			r.closeErr = errors.Join(r.closeErr, r.uffd.Close())
+		}
+	})
+	return r.closeErr
+}
+
+// Provider returns the provider set with WithProvider, or nil.
+func (r *Region) Provider() PageProvider {
+	return r.provider
+}
+
+// Bytes returns the memory of the region.
+func (r *Region) Bytes() []byte {
+	return r.mem
+}
+
+// Len returns the size of the region in bytes.
+func (r *Region) Len() int {
+	return len(r.mem)
+}
+
+// Contains returns true if addr lies within the region.
+func (r *Region) Contains(addr uintptr) bool {
+	return addr >= r.Base() && addr < r.Base()+uintptr(len(r.mem))
+}
+
+// Serve resolves faults on the region until its userfaultfd is closed.
+func (r *Region) Serve() error {
+	return Serve(r.uffd, r)
+}
+
+// HandleEvent resolves a page fault within the region and keeps track of
+// pages removed by madvise(2) or munmap(2). It is safe for concurrent use,
+// as by a Server with several workers.
+func (r *Region) HandleEvent(u *Uffd, msg *UffdMsg) error {
+	switch msg.Event {
+	case UFFD_EVENT_PAGEFAULT:
+		pf := msg.GetPagefault()
+		if !r.Contains(uintptr(pf.Address)) {
+			return fmt.Errorf("page fault at %#x outside region", pf.Address)
+		}
+		if r.selfFaults {
+			exit, err := r.enterResolve(uintptr(pf.Address), pf.Flags, pf.Ptid)
+			if err != nil {
+				return err
+			}
+			defer exit()
+		}
+		start := time.Now()
+		ctx, span := r.startFault(uintptr(pf.Address), pf.Flags)
+		err := r.resolve(ctx, uintptr(pf.Address), pf.Flags, pf.Ptid)
+		span.End(err)
+		r.stats.record(pf.Flags, start, time.Since(start), err)
+		return err
+	case UFFD_EVENT_REMOVE, UFFD_EVENT_UNMAP:
+		rm := msg.GetRemove()
+		r.unfill(uintptr(rm.Start), int(rm.End-rm.Start))
+		r.discardDirty(uintptr(rm.Start), int(rm.End-rm.Start))
+		r.discardOverlay(uintptr(rm.Start), int(rm.End-rm.Start))
+		if msg.Event != UFFD_EVENT_REMOVE || !r.evicted(rm.Start, rm.End) {
+			r.dropStored(uintptr(rm.Start), int(rm.End-rm.Start))
+		}
+		if e := r.evictor.Load(); e != nil {
+			e.Forget(uintptr(rm.Start), int(rm.End-rm.Start))
+		}
+	}
+	return nil
+}
+
+func (r *Region) Base() uintptr {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(r.mem)))
+}
+
+func (r *Region) resolve(ctx context.Context, addr uintptr, flags uint64, tid uint32) error {
+	page := addr &^ uintptr(r.pageSize-1)
+
+	if r.heatmap != nil {
+		r.heatmap.record(int(page-r.Base())/r.pageSize, time.Now())
+	}
+
+	e := r.evictor.Load()
+	missing := flags&(UFFD_PAGEFAULT_FLAG_MINOR|UFFD_PAGEFAULT_FLAG_WP) == 0
+	if missing && r.maxResident > 0 {
+		if err := e.reserve(r.maxResident); err != nil {
+			return err
+		}
+	}
+
+	// Account for the page before the faulting thread is woken up.
+	if e != nil {
+		e.Touch(page)
+	}
+
+	var err error
+	switch {
+	case flags&UFFD_PAGEFAULT_FLAG_MINOR != 0:
+		_, span := r.startSpan(ctx, "uffd.continue")
+		err = r.uffd.Continue(page, r.pageSize, r.wakeMode(UFFDIO_CONTINUE_MODE_DONTWAKE))
+		span.End(err)
+		if err == nil {
+			r.filled.setAtomic(r.pageIndex(page))
+			r.hashContinued(page)
+			r.onFill(page)
+		}
+	case flags&UFFD_PAGEFAULT_FLAG_WP != 0:
+		_, span := r.startSpan(ctx, "uffd.writeprotect")
+		// Clones keep the page as it was before the write.
+		err = r.diverge(page)
+		if err == nil {
+			// Forget the hash before the writer is woken up.
+			r.unhash(page, r.pageSize)
+		}
+		if err == nil && r.readOnly != nil {
+			err = r.interceptWrite(addr, page, tid)
+		} else if err == nil {
+			err = r.unprotect(page)
+		}
+		span.End(err)
+	case r.guards.testAtomic(r.pageIndex(page)):
+		_, span := r.startSpan(ctx, "uffd.poison")
+		_, err = r.uffd.Poison(page, r.pageSize, r.wakeMode(UFFDIO_POISON_MODE_DONTWAKE))
+		span.End(err)
+		if err == nil {
+			r.onPoison(page)
+		}
+		if e != nil {
+			e.Forget(page, r.pageSize)
+		}
+	case r.fillsTracked() && r.filled.testAtomic(r.pageIndex(page)):
+		// Filled since the fault, as by Prefetch.
+		err = ErrAlreadyMapped
+	default:
+		if err = r.fill(ctx, page, r.limitDemand); err == nil && r.numa {
+			r.place(page, tid)
+		}
+	}
+	// Raced with another resolution of the same page: just wake the faulter.
+	if errors.Is(err, ErrAlreadyMapped) {
+		if flags&UFFD_PAGEFAULT_FLAG_WP == 0 {
+			r.filled.setAtomic(r.pageIndex(page))
+		}
+		err = nil
+		if !r.dontWake {
+			_, span := r.startSpan(ctx, "uffd.wake")
+			err = r.uffd.Wake(page, r.pageSize)
+			span.End(err)
+		}
+	}
+	if err == nil && r.dontWake {
+		r.wakeMu.Lock()
+		r.pending = append(r.pending, UffdioRange{Start: uint64(page), Len: uint64(r.pageSize)})
+		r.wakeMu.Unlock()
+	}
+	if err != nil && e != nil {
+		e.Forget(page, r.pageSize)
+	}
+	return err
+}
+
+// fill resolves page with the provider or a zero page, with the provider
+// read limited by the rate limiter if limit is true. With write-back or a
+// read-only policy, or clones, the page is copied write-protected, zeros
+// included.
+// Pages of a MappedPageProvider are copied from its mapping. Evicted pages
+// are restored from the EvictionStore first.
+func (r *Region) fill(ctx context.Context, page uintptr, limit bool) error {
+	protect := r.writeBack != nil || r.readOnly != nil || r.cloned()
+	if r.store != nil {
+		if ok, err := r.restore(ctx, page, protect); ok || err != nil {
+			return err
+		}
+	}
+	if r.provider == nil && !protect {
+		_, span := r.startSpan(ctx, "uffd.zeropage")
+		_, err := r.uffd.Zeropage(page, r.pageSize, r.wakeMode(UFFDIO_ZEROPAGE_MODE_DONTWAKE))
+		span.End(err)
+		if err == nil {
+			r.zeroFilled(page)
+		}
+		return err
+	}
+	if limit && r.limiter != nil && r.provider != nil {
+		if err := r.limiter.Wait(ctx, r.pageSize); err != nil {
+			return err
+		}
+	}
+
+	// Mapped pages are copied from where they are.
+	var buf []byte
+	if p, ok := r.provider.(MappedPageProvider); ok {
+		buf = p.MappedPage(int64(page-r.Base()), r.pageSize)
+	}
+	if buf == nil && r.staging != nil && r.provider != nil {
+		return r.fillStaged(ctx, page)
+	}
+	if buf == nil {
+		bufp := r.bufs.Get()
+		defer r.bufs.Put(bufp)
+		buf = *bufp
+
+		if r.provider == nil {
+			clear(buf)
+		} else if err := r.read(ctx, buf, int64(page-r.Base())); err != nil {
+			r.stats.add(&r.stats.ProviderErrors, 1)
+			return err
+		}
+	}
+
+	mode := r.wakeMode(UFFDIO_COPY_MODE_DONTWAKE)
+	if protect {
+		mode |= UFFDIO_COPY_MODE_WP
+	}
+	_, span := r.startSpan(ctx, "uffd.copy")
+	_, err := r.uffd.Copy(page, uintptr(unsafe.Pointer(&buf[0])), r.pageSize, mode)
+	span.End(err)
+	if err == nil {
+		r.hashPage(page, buf)
+		r.copied(page)
+	}
+	return err
+}
+
+// restore resolves page from the EvictionStore, reporting false if it is
+// not kept there.
+func (r *Region) restore(ctx context.Context, page uintptr, protect bool) (bool, error) {
+	bufp := r.bufs.Get()
+	defer r.bufs.Put(bufp)
+	buf := *bufp
+
+	off := int64(page - r.Base())
+	if ok, err := r.store.Take(off, buf); !ok || err != nil {
+		return false, err
+	}
+	mode := r.wakeMode(UFFDIO_COPY_MODE_DONTWAKE)
+	if protect {
+		mode |= UFFDIO_COPY_MODE_WP
+	}
+	_, span := r.startSpan(ctx, "uffd.copy")
+	_, err := r.uffd.Copy(page, uintptr(unsafe.Pointer(&buf[0])), r.pageSize, mode)
+	span.End(err)
+	switch {
+	case err == nil:
+		r.filled.setAtomic(r.pageIndex(page))
+		r.stats.add(&r.stats.Restored, 1)
+		r.hashPage(page, buf)
+		r.onFill(page)
+	case !errors.Is(err, ErrAlreadyMapped):
+		// Keep the page for the next attempt.
+		r.store.Put(off, buf)
+	}
+	return true, err
+}
+
+// dropStored forgets the stored pages of a range, which may extend beyond
+// the region.
+func (r *Region) dropStored(start uintptr, length int) {
+	end := min(start+uintptr(length), r.Base()+uintptr(len(r.mem)))
+	start = max(start, r.Base())
+	if r.store == nil || start >= end {
+		return
+	}
+	r.store.Drop(int64(start-r.Base()), int(end-start))
+}
+
+// evicting records a range about to be evicted to the store, so that its
+// UFFD_EVENT_REMOVE does not drop it.
+func (r *Region) evicting(start uintptr, length int) {
+	r.storeMu.Lock()
+	defer r.storeMu.Unlock()
+	r.stored = append(r.stored, UffdioRange{Start: uint64(start), Len: uint64(length)})
+}
+
+// evicted reports whether a removed range was evicted to the store, and
+// forgets it.
+func (r *Region) evicted(start, end uint64) bool {
+	r.storeMu.Lock()
+	defer r.storeMu.Unlock()
+	i := slices.Index(r.stored, UffdioRange{Start: start, Len: end - start})
+	if i < 0 {
+		return false
+	}
+	r.stored = slices.Delete(r.stored, i, i+1)
+	return true
+}
+
+// zeroFilled accounts for page filled with zeros.
+func (r *Region) zeroFilled(page uintptr) {
+	r.filled.setAtomic(r.pageIndex(page))
+	r.stats.add(&r.stats.Zeropages, 1)
+	r.hashPage(page, nil)
+	r.onFill(page)
+}
+
+// copied accounts for page filled from the provider.
+func (r *Region) copied(page uintptr) {
+	r.filled.setAtomic(r.pageIndex(page))
+	r.stats.add(&r.stats.BytesFilled, uint64(r.pageSize))
+	r.onFill(page)
+}
+
+func (r *Region) pageIndex(page uintptr) int {
+	return int(page-r.Base()) / r.pageSize
+}
+
+// fillsTracked reports whether the filled pages are known to still be, as
+// the region is told about the pages removed by madvise(2).
+func (r *Region) fillsTracked() bool {
+	return r.uffd.enabled&UFFD_FEATURE_EVENT_REMOVE != 0
+}
+
+// unfill forgets the filled pages of a range, which may extend beyond the
+// region.
+func (r *Region) unfill(start uintptr, length int) {
+	end := min(start+uintptr(length), r.Base()+uintptr(len(r.mem)))
+	start = max(start, r.Base())
+	if start >= end {
+		return
+	}
+	r.filled.clearRangeAtomic(r.pageIndex(start), r.pageIndex(end-1)+1)
+	r.unhash(start, int(end-start))
+}
+
+// removePages removes the pages of a range, which may extend beyond the
+// region, from a set guarded by mu.
+func (r *Region) removePages(mu *sync.Mutex, s *PageSet, start uintptr, length int) {
+	end := min(start+uintptr(length), r.Base()+uintptr(len(r.mem)))
+	start = max(start, r.Base())
+	if start >= end {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i := r.pageIndex(start); i <= r.pageIndex(end-1); i++ {
+		s.Remove(uint64(i))
+	}
+}
+
+// Filled returns a bitmap with a bit set for every page of the region
+// filled by it, from the provider or with zeros, or resolved by a minor
+// fault. Pages removed since are cleared if the region is told about it,
+// by evicting them or with UFFD_FEATURE_EVENT_REMOVE; otherwise Residency
+// is authoritative.
+//
+// With UFFD_FEATURE_EVENT_REMOVE, faults on pages filled meanwhile, as by
+// Prefetch, only wake up the faulting thread.
+func (r *Region) Filled() Bitmap {
+	return r.filled.cloneAtomic()
+}
+
+// wakeMode returns dontwake if waking up is deferred, 0 otherwise.
+func (r *Region) wakeMode(dontwake int) int {
+	if r.dontWake {
+		return dontwake
+	}
+	return 0
+}
+
+// EndBatch wakes up the threads whose faults were resolved with
+// WithDeferredWake. It implements BatchHandler.
+func (r *Region) EndBatch(u *Uffd) error {
+	r.endMu.Lock()
+	defer r.endMu.Unlock()
+
+	r.wakeMu.Lock()
+	pending := r.pending
+	r.pending = r.spare[:0]
+	r.wakeMu.Unlock()
+
+	err := r.uffd.WakeRanges(pending)
+	r.spare = pending
+	return err
+}
+
+// read fills buf with the page at off from the provider.
+func (r *Region) read(ctx context.Context, buf []byte, off int64) (err error) {
+	ctx, span := r.startSpan(ctx, "provider.read")
+	defer func() { span.End(err) }()
+
+	var n int
+	if p, ok := r.provider.(ContextPageProvider); ok {
+		n, err = p.ReadAtContext(ctx, buf, off)
+	} else {
+		n, err = r.provider.ReadAt(buf, off)
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	clear(buf[n:])
+	return nil
+}
+
+// Stats returns a snapshot of the region's fault counters.
+func (r *Region) Stats() Stats {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	return r.stats.Stats
+}
+
+// ResetStats zeroes the region's fault counters.
+func (r *Region) ResetStats() {
+	r.stats.reset()
+}
@@ -0,0 +1,268 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// patternProvider returns a provider whose n-th page is filled with byte n+1.
+func patternProvider(pages int) PageProvider {
+	pageSize := unix.Getpagesize()
+	data := make([]byte, pages*pageSize)
+	for i := range data {
+		data[i] = byte(i/pageSize + 1)
+	}
+	return bytes.NewReader(data)
+}
+
+// waitFor waits up to a second for cond to become true.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// needProcs raises GOMAXPROCS to n until the end of the test, for the
+// goroutines faulting on memory served by the test process, which keep
+// their P while blocked, see Serve, and those serving it.
+func needProcs(t *testing.T, n int) {
+	if prev := runtime.GOMAXPROCS(0); prev < n {
+		runtime.GOMAXPROCS(n)
+		t.Cleanup(func() { runtime.GOMAXPROCS(prev) })
+	}
+}
+
+// serveRegion maps an anonymous area of the given number of pages, registers
+// it in missing mode and serves it in the background until cleanup.
+func serveRegion(t *testing.T, pages int, opts ...RegionOption) (mem []byte, r *Region) {
+	t.Helper()
+
+	// A GC worker waiting to scan the stack of a faulting goroutine keeps
+	// its P too.
+	needProcs(t, 3)
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	mem, err = unix.Mmap(-1, 0, pages*unix.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		uffd.Close()
+		t.Fatalf("mmap failed: %v", err)
+	}
+
+	r, err = uffd.RegisterRegion(mem, UFFDIO_REGISTER_MODE_MISSING, opts...)
+	if err != nil {
+		uffd.Close()
+		unix.Munmap(mem)
+		t.Fatalf("RegisterRegion failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+
+	t.Cleanup(func() {
+		_ = r.Close()
+		_ = uffd.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+		_ = unix.Munmap(mem)
+	})
+	return mem, r
+}
+
+func TestRegionServeProvider(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, _ := serveRegion(t, 4, WithProvider(patternProvider(4)))
+
+	for i := 0; i < 4; i++ {
+		if got := mem[i*pageSize+1]; got != byte(i+1) {
+			t.Fatalf("page %d: got %#x, want %#x", i, got, i+1)
+		}
+	}
+}
+
+func TestRegionServeShortProvider(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, _ := serveRegion(t, 2, WithProvider(bytes.NewReader([]byte{0xAA, 0xBB})))
+
+	if mem[0] != 0xAA || mem[1] != 0xBB || mem[2] != 0 {
+		t.Fatalf("unexpected page contents: % x", mem[:3])
+	}
+	if mem[pageSize] != 0 {
+		t.Fatalf("page past end of provider not zero-filled: %#x", mem[pageSize])
+	}
+}
+
+func TestRegionDeferredWake(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 4, WithProvider(patternProvider(4)), WithDeferredWake())
+
+	for i := 0; i < 4; i++ {
+		if got := mem[i*pageSize]; got != byte(i+1) {
+			t.Fatalf("page %d: got %#x, want %#x", i, got, i+1)
+		}
+	}
+	r.wakeMu.Lock()
+	defer r.wakeMu.Unlock()
+	if n := len(r.pending); n != 0 {
+		t.Errorf("%d ranges left to wake", n)
+	}
+}
+
+func TestMapAndRegister(t *testing.T) {
+	needProcs(t, 2)
+	pageSize := unix.Getpagesize()
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	r, err := uffd.MapAndRegister(int64(2*pageSize+1), UFFDIO_REGISTER_MODE_MISSING, WithProvider(patternProvider(3)))
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	if r.Len() != 3*pageSize || len(r.Bytes()) != r.Len() || r.Base() != uintptr(unsafe.Pointer(&r.Bytes()[0])) {
+		t.Fatalf("Len() = %d, Base() = %#x", r.Len(), r.Base())
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+	if got := r.Bytes()[2*pageSize]; got != 3 {
+		t.Errorf("page 2 = %d, want 3", got)
+	}
+
+	base := r.Base()
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+	maps, err := ReadMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range maps {
+		if m.Start <= base && base < m.End && m.Path == "" {
+			t.Errorf("region still mapped: %+v", m)
+		}
+	}
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+
+	if _, err := uffd.MapAndRegister(0, UFFDIO_REGISTER_MODE_MISSING); err == nil {
+		t.Error("MapAndRegister accepted an empty mapping")
+	}
+}
+
+func TestRegionServeZeropage(t *testing.T) {
+	mem, _ := serveRegion(t, 1)
+
+	if mem[0] != 0 {
+		t.Fatalf("expected zero page, got %#x", mem[0])
+	}
+	mem[0] = 1
+	if mem[0] != 1 {
+		t.Fatalf("write to zero page lost")
+	}
+}
+
+func TestRegionContains(t *testing.T) {
+	mem, r := serveRegion(t, 2)
+
+	base := r.Base()
+	if !r.Contains(base) || !r.Contains(base+uintptr(len(mem))-1) {
+		t.Fatalf("Contains rejected address within region")
+	}
+	if r.Contains(base-1) || r.Contains(base+uintptr(len(mem))) {
+		t.Fatalf("Contains accepted address outside region")
+	}
+}
+
+func TestRegionMaxResidentBytes(t *testing.T) {
+	pageSize := unix.Getpagesize()
+
+	for name, policy := range map[string]EvictionPolicy{
+		"lru":    NewLRUPolicy(),
+		"clock":  NewClockPolicy(),
+		"random": NewRandomPolicy(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			mem, r := serveRegion(t, 8,
+				WithProvider(patternProvider(8)),
+				WithMaxResidentBytes(int64(2*pageSize)),
+				WithEvictionPolicy(policy))
+
+			for round := 0; round < 2; round++ {
+				for i := 0; i < 8; i++ {
+					if got := mem[i*pageSize]; got != byte(i+1) {
+						t.Fatalf("page %d: got %#x, want %#x", i, got, i+1)
+					}
+				}
+			}
+			if got := r.evictor.Load().Resident(); got > 2 {
+				t.Fatalf("%d pages resident, budget is 2", got)
+			}
+		})
+	}
+}
+
+func TestRegionHandleEventAllocs(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+
+	r, err := uffd.RegisterRegion(mem, UFFDIO_REGISTER_MODE_MISSING, WithProvider(patternProvider(1)))
+	if err != nil {
+		t.Fatalf("RegisterRegion failed: %v", err)
+	}
+
+	// Resolve a fault on the page over and over, without a faulting thread.
+	msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	msg.GetPagefault().Address = uint64(r.Base())
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := unix.Madvise(mem, unix.MADV_DONTNEED); err != nil {
+			t.Fatalf("madvise failed: %v", err)
+		}
+		if err := r.HandleEvent(uffd, msg); err != nil {
+			t.Fatalf("HandleEvent failed: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("HandleEvent allocates %v times per fault", allocs)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Residency returns a bitmap with a bit set for every page of the region
+// that is currently resident, as reported by mincore(2). Pages not set are
+// either still missing or were evicted.
+func (r *Region) Residency() (Bitmap, error) {
+	vec := make([]byte, (len(r.mem)+r.pageSize-1)/r.pageSize)
+	if err := mincore(r.mem, vec); err != nil {
+		return Bitmap{}, err
+	}
+
+	b := NewBitmap(len(vec))
+	for i, v := range vec {
+		if v&1 != 0 {
+			b.Set(i)
+		}
+	}
+	return b, nil
+}
+
+// ResidentBytes returns the number of resident and missing bytes of the region.
+func (r *Region) ResidentBytes() (resident, missing int64, err error) {
+	b, err := r.Residency()
+	if err != nil {
+		return 0, 0, err
+	}
+	resident = int64(b.Count()) * int64(r.pageSize)
+	return resident, int64(len(r.mem)) - resident, nil
+}
+
+func mincore(mem []byte, vec []byte) error {
+	_, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(unsafe.SliceData(mem))), uintptr(len(mem)), uintptr(unsafe.Pointer(unsafe.SliceData(vec))))
+	if errno != 0 {
+		return os.NewSyscallError("mincore", errno)
+	}
+	return nil
+}
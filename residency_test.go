@@ -0,0 +1,45 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRegionResidency(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 4, WithProvider(patternProvider(4)))
+
+	b, err := r.Residency()
+	if err != nil {
+		t.Fatalf("Residency failed: %v", err)
+	}
+	if b.Len() != 4 || b.Count() != 0 {
+		t.Fatalf("expected 4 missing pages, got %d of %d resident", b.Count(), b.Len())
+	}
+
+	mem[pageSize] = 0xFF
+	mem[3*pageSize] = 0xFF
+
+	b, err = r.Residency()
+	if err != nil {
+		t.Fatalf("Residency failed: %v", err)
+	}
+	for i, want := range []bool{false, true, false, true} {
+		if b.Test(i) != want {
+			t.Errorf("page %d resident = %v, want %v", i, b.Test(i), want)
+		}
+	}
+
+	resident, missing, err := r.ResidentBytes()
+	if err != nil {
+		t.Fatalf("ResidentBytes failed: %v", err)
+	}
+	if resident != int64(2*pageSize) || missing != int64(2*pageSize) {
+		t.Fatalf("ResidentBytes() = %d, %d, want %d, %d", resident, missing, 2*pageSize, 2*pageSize)
+	}
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// resolvers holds the threads resolving the faults of regions set up with
+// WithSelfFaultDetection, by thread ID, with the number of faults each.
+var resolvers struct {
+	mu   sync.Mutex
+	tids map[int]int
+}
+
+// WithSelfFaultDetection fails the faults of the region caused by a thread
+// of the process while resolving a fault of such a region, typically a
+// provider reading served memory, with an error matching ErrSelfFault
+// rather than leaving the thread and the handler blocked forever. The
+// faulting thread is unblocked: a missing page is poisoned, or filled with
+// zeros without UFFDIO_POISON, so that a system call reading it fails with
+// EFAULT and other reads raise SIGBUS, see debug.SetPanicOnFault. The
+// userfaultfd must have UFFD_FEATURE_THREAD_ID, and the self fault must be
+// handled by another goroutine than the faulting one, as by a Server with
+// more than one worker.
+//
+// The threads resolving faults are locked to their goroutine meanwhile.
+func WithSelfFaultDetection() RegionOption {
+	return func(r *Region) {
+		r.selfFaults = true
+	}
+}
+
+func (r *Region) checkSelfFaults() error {
+	if r.uffd.enabled&UFFD_FEATURE_THREAD_ID == 0 {
+		return errors.New("self-fault detection needs UFFD_FEATURE_THREAD_ID")
+	}
+	return nil
+}
+
+// enterResolve records the thread resolving the fault of tid at addr, and
+// returns the function to call once resolved, unless the fault was caused
+// by a thread resolving another one, which is then unblocked.
+func (r *Region) enterResolve(addr uintptr, flags uint64, tid uint32) (exit func(), err error) {
+	resolvers.mu.Lock()
+	defer resolvers.mu.Unlock()
+	if resolvers.tids[int(tid)] > 0 {
+		err := fmt.Errorf("%w: thread %d faulted at %#x while resolving a fault", ErrSelfFault, tid, addr)
+		return nil, errors.Join(err, r.failSelfFault(addr, flags))
+	}
+	if resolvers.tids == nil {
+		resolvers.tids = make(map[int]int)
+	}
+	runtime.LockOSThread()
+	self := unix.Gettid()
+	resolvers.tids[self]++
+	return func() {
+		resolvers.mu.Lock()
+		if resolvers.tids[self]--; resolvers.tids[self] == 0 {
+			delete(resolvers.tids, self)
+		}
+		resolvers.mu.Unlock()
+		runtime.UnlockOSThread()
+	}, nil
+}
+
+// failSelfFault unblocks a thread that faulted at addr while resolving a
+// fault, without resolving it with the contents of the page.
+func (r *Region) failSelfFault(addr uintptr, flags uint64) error {
+	page := addr &^ uintptr(r.pageSize-1)
+	var err error
+	switch {
+	case flags&UFFD_PAGEFAULT_FLAG_WP != 0:
+		err = r.uffd.WriteProtect(page, r.pageSize, 0)
+	case flags&UFFD_PAGEFAULT_FLAG_MINOR != 0:
+		err = r.uffd.Continue(page, r.pageSize, 0)
+	default:
+		_, err = r.uffd.Poison(page, r.pageSize, 0)
+		if errors.Is(err, ErrMissingIoctl) {
+			_, err = r.uffd.Zeropage(page, r.pageSize, 0)
+		}
+	}
+	if errors.Is(err, ErrAlreadyMapped) {
+		err = r.uffd.Wake(page, r.pageSize)
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// selfFaultingProvider has the kernel read the second page of r while
+// reading the first one.
+type selfFaultingProvider struct {
+	r   *Region
+	err error
+}
+
+func (p *selfFaultingProvider) ReadAt(b []byte, off int64) (int, error) {
+	if off == 0 {
+		var fds [2]int
+		if err := unix.Pipe2(fds[:], unix.O_CLOEXEC); err != nil {
+			return 0, err
+		}
+		defer unix.Close(fds[0])
+		defer unix.Close(fds[1])
+		page := p.r.Bytes()[len(b):]
+		if _, p.err = unix.Write(fds[1], page[:1]); p.err != nil {
+			return 0, p.err
+		}
+	}
+	clear(b)
+	return len(b), nil
+}
+
+func TestSelfFaultDetection(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, UFFD_FEATURE_THREAD_ID)
+	if err != nil {
+		t.Skipf("thread IDs not available: %v", err)
+	}
+	defer uffd.Close()
+	pageSize := unix.Getpagesize()
+	p := &selfFaultingProvider{}
+	r, err := uffd.MapAndRegister(int64(2*pageSize), UFFDIO_REGISTER_MODE_MISSING, WithProvider(p), WithSelfFaultDetection())
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	defer r.Close()
+	p.r = r
+
+	// The provider faulting on the region while resolving a fault stops
+	// the server rather than hanging it, and its read fails.
+	s := NewServer(uffd, r, WithWorkers(2))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve()
+	}()
+	faulted := kernelRead(t, r.Bytes())
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSelfFault) {
+			t.Errorf("Serve: %v, want %v", err, ErrSelfFault)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve hung on a self fault")
+	}
+	// Without UFFDIO_POISON, the page is filled with zeros instead.
+	if HaveIoctlPoison && !errors.Is(p.err, unix.EFAULT) {
+		t.Errorf("provider read of the region: %v, want EFAULT", p.err)
+	}
+	uffd.Close()
+	<-faulted
+
+	// Thread IDs are needed.
+	plain, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer plain.Close()
+	if _, err := plain.MapAndRegister(int64(pageSize), UFFDIO_REGISTER_MODE_MISSING, WithSelfFaultDetection()); err == nil {
+		t.Error("self-fault detection registered without thread IDs")
+	}
+}
@@ -0,0 +1,77 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Serve reads events from u and dispatches them to h until u is closed or
+// h returns an error. It returns nil once u has been closed, without
+// waiting for another event, including when h fails because of it on an
+// event read just before.
+//
+// A goroutine blocked on a fault in memory served by the same process keeps
+// its P, so GOMAXPROCS must be larger than the number of goroutines that may
+// fault concurrently or Serve will never get to run.
+func Serve(u *Uffd, h Handler) error {
+	bp := msgBatches.Get().(*[]UffdMsg)
+	defer msgBatches.Put(bp)
+	msgs := *bp
+	bh, _ := h.(BatchHandler)
+
+	for {
+		n, err := u.ReadMsgsTimeout(msgs, -1)
+		switch {
+		case err == nil:
+		case errors.Is(err, unix.EAGAIN):
+			continue
+		case isClosed(err):
+			return nil
+		default:
+			return err
+		}
+		for i := range msgs[:n] {
+			if err := h.HandleEvent(u, &msgs[i]); err != nil {
+				return closedOr(u, err)
+			}
+		}
+		if bh != nil {
+			if err := bh.EndBatch(u); err != nil {
+				return closedOr(u, err)
+			}
+		}
+	}
+}
+
+// Number of messages read at once by the serving loops.
+const msgBatchSize = 16
+
+// msgBatches holds the buffers the serving loops read messages into.
+var msgBatches = sync.Pool{
+	New: func() any {
+		msgs := make([]UffdMsg, msgBatchSize)
+		return &msgs
+	},
+}
+
+// closedOr returns nil if err means that u was closed, or err. The errors
+// of handlers are only ignored once u was closed, since they may come from
+// other userfaultfds.
+func closedOr(u *Uffd, err error) error {
+	if u.closed.Load() && isClosed(err) {
+		return nil
+	}
+	return err
+}
+
+// isClosed returns true if err means that the userfaultfd was closed.
+func isClosed(err error) bool {
+	var perr *PollError
+	return errors.Is(err, ErrClosed) || errors.As(err, &perr) && perr.IsInvalid()
+}
@@ -0,0 +1,305 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ServeMux dispatches the events of a userfaultfd with several registered
+// ranges to the Handler of each range, typically a Region.
+//
+// Page faults go to the handler of the faulting address. Remove and unmap
+// events go to the handlers of the ranges they overlap and other events to
+// every handler.
+type ServeMux struct {
+	mu     sync.RWMutex
+	routes []muxRoute // sorted by start, not overlapping
+	shard  ShardFunc
+	pause  pauser
+
+	tenants map[string]*quotaState // set by SetTenantQuota
+
+	pendingMu sync.Mutex
+	pending   map[*UffdMsg]inflightFault // faults being handled
+	err       error                      // of a deferred fault, under pendingMu
+}
+
+type muxRoute struct {
+	start, end uintptr
+	handler    Handler
+	quota      *quotaState // set by SetQuota
+	tenant     string      // set by SetTenant
+}
+
+// NewServeMux returns an empty ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{pending: make(map[*UffdMsg]inflightFault)}
+}
+
+// Handle dispatches the events of the given range to h.
+func (m *ServeMux) Handle(start uintptr, length int, h Handler) error {
+	if length <= 0 {
+		return fmt.Errorf("invalid range length %d", length)
+	}
+	rt := muxRoute{start: start, end: start + uintptr(length), handler: h}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, _ := slices.BinarySearchFunc(m.routes, start, func(r muxRoute, start uintptr) int {
+		return cmp.Compare(r.start, start)
+	})
+	if i > 0 && m.routes[i-1].end > rt.start || i < len(m.routes) && m.routes[i].start < rt.end {
+		return fmt.Errorf("range %#x-%#x overlaps a handled range", rt.start, rt.end)
+	}
+	m.routes = slices.Insert(m.routes, i, rt)
+	return nil
+}
+
+// HandleRegion dispatches the events of r's range to r.
+func (m *ServeMux) HandleRegion(r *Region) error {
+	return m.Handle(r.Base(), len(r.mem), r)
+}
+
+// Remove stops dispatching the events of the range starting at start.
+func (m *ServeMux) Remove(start uintptr) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.find(start)
+	if !ok {
+		return errors.New("range not handled")
+	}
+	m.routes = slices.Delete(m.routes, i, i+1)
+	return nil
+}
+
+// Handler returns the handler of the range containing addr, or nil.
+func (m *ServeMux) Handler(addr uintptr) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if i := m.lookup(addr); i >= 0 {
+		return m.routes[i].handler
+	}
+	return nil
+}
+
+// lookup returns the index of the route containing addr, or -1. It must
+// be called with m.mu held.
+func (m *ServeMux) lookup(addr uintptr) int {
+	i, ok := slices.BinarySearchFunc(m.routes, addr, func(r muxRoute, addr uintptr) int {
+		return cmp.Compare(r.start, addr)
+	})
+	if !ok {
+		i--
+	}
+	if i >= 0 && addr < m.routes[i].end {
+		return i
+	}
+	return -1
+}
+
+// HandleEvent dispatches msg. Page faults outside of any handled range are
+// an error. Page faults wait for the quotas of their range and tenant, if
+// any, before being dispatched: those that cannot be resolved right away
+// wait in a goroutine of their own, so as not to hold up the reading of
+// the other faults, and their errors are returned by the next call.
+func (m *ServeMux) HandleEvent(u *Uffd, msg *UffdMsg) error {
+	if msg.Event == UFFD_EVENT_PAGEFAULT {
+		_, err := m.handleFault(u, msg, nil)
+		return errors.Join(err, m.deferredErr())
+	}
+	m.pause.active.RLock()
+	defer m.pause.active.RUnlock()
+
+	m.mu.RLock()
+	routes := slices.Clone(m.routes)
+	m.mu.RUnlock()
+
+	var start, end uintptr = 0, ^uintptr(0)
+	if msg.Event == UFFD_EVENT_REMOVE || msg.Event == UFFD_EVENT_UNMAP {
+		rm := msg.GetRemove()
+		start, end = uintptr(rm.Start), uintptr(rm.End)
+	}
+	var errs []error
+	for _, rt := range routes {
+		if rt.start >= end || start >= rt.end {
+			continue
+		}
+		if err := rt.handler.HandleEvent(u, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// HandleFault implements DeferringHandler: it handles a page fault like
+// HandleEvent, and returns true if it waits for its quotas.
+func (m *ServeMux) HandleFault(u *Uffd, msg *UffdMsg, done func(*UffdMsg)) (bool, error) {
+	deferred, err := m.handleFault(u, msg, done)
+	return deferred, errors.Join(err, m.deferredErr())
+}
+
+// handleFault dispatches a page fault once its quotas allow it, and
+// returns true if it was deferred, calling done once resolved.
+func (m *ServeMux) handleFault(u *Uffd, msg *UffdMsg, done func(*UffdMsg)) (bool, error) {
+	// Pending includes the faults waiting for Resume or their quotas.
+	m.pendingMu.Lock()
+	m.pending[msg] = newInflightFault(msg)
+	m.pendingMu.Unlock()
+
+	m.pause.active.RLock()
+	addr := uintptr(msg.GetPagefault().Address)
+	m.mu.RLock()
+	i := m.lookup(addr)
+	if i < 0 {
+		m.mu.RUnlock()
+		m.pause.active.RUnlock()
+		m.untrack(msg)
+		return false, fmt.Errorf("page fault at %#x not handled", addr)
+	}
+	h := m.routes[i].handler
+	scopes, n := m.quotas(i)
+	m.mu.RUnlock()
+
+	var err error
+	a := newAdmission(scopes, n, msg)
+	deferred := !a.try()
+	if !deferred {
+		err = m.admitted(u, h, &a, msg)
+	} else {
+		// The message is reused by the caller once HandleEvent returns.
+		d := new(UffdMsg)
+		*d = *msg
+		m.pendingMu.Lock()
+		m.pending[d] = m.pending[msg]
+		m.pendingMu.Unlock()
+		go m.deferFault(u, h, a, d, done)
+	}
+	m.pause.active.RUnlock()
+	m.untrack(msg)
+	return deferred, err
+}
+
+// deferFault resolves a fault once its quotas allow it. Its batch is over
+// by then, so it ends one of its own, lest the wake up be deferred until
+// other events come.
+func (m *ServeMux) deferFault(u *Uffd, h Handler, a admission, msg *UffdMsg, done func(*UffdMsg)) {
+	a.wait()
+	m.pause.active.RLock()
+	err := m.admitted(u, h, &a, msg)
+	if bh, ok := h.(BatchHandler); ok {
+		err = errors.Join(err, bh.EndBatch(u))
+	}
+	m.pause.active.RUnlock()
+	if err != nil {
+		m.pendingMu.Lock()
+		if m.err == nil {
+			m.err = err
+		}
+		m.pendingMu.Unlock()
+	}
+	m.untrack(msg)
+	if done != nil {
+		done(msg)
+	}
+}
+
+func (m *ServeMux) untrack(msg *UffdMsg) {
+	m.pendingMu.Lock()
+	delete(m.pending, msg)
+	m.pendingMu.Unlock()
+}
+
+// admitted resolves a fault allowed by its quotas.
+func (m *ServeMux) admitted(u *Uffd, h Handler, a *admission, msg *UffdMsg) error {
+	defer a.release()
+	if err := a.reserve(m); err != nil {
+		return err
+	}
+	return h.HandleEvent(u, msg)
+}
+
+// deferredErr returns the error of a deferred fault, once.
+func (m *ServeMux) deferredErr() error {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	err := m.err
+	m.err = nil
+	return err
+}
+
+// Pending returns the faults being handled, from the oldest. Their age is
+// counted from when HandleEvent was called.
+func (m *ServeMux) Pending() []PendingFault {
+	now := time.Now()
+	m.pendingMu.Lock()
+	faults := make([]PendingFault, 0, len(m.pending))
+	for _, f := range m.pending {
+		faults = append(faults, f.pending(now))
+	}
+	m.pendingMu.Unlock()
+	return sortPending(faults)
+}
+
+// EndBatch calls EndBatch on the handlers that implement BatchHandler.
+func (m *ServeMux) EndBatch(u *Uffd) error {
+	m.pause.active.RLock()
+	defer m.pause.active.RUnlock()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var errs []error
+	for _, rt := range m.routes {
+		if bh, ok := rt.handler.(BatchHandler); ok {
+			if err := bh.EndBatch(u); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Pause makes HandleEvent and EndBatch block, once the calls in progress
+// are done, until Resume. Meanwhile the events are left in the userfaultfd
+// and the faulting threads blocked, as the loop serving the mux is
+// blocked. Pause must not be called by the handlers. See Server.Pause for
+// the faults of goroutines of this process.
+func (m *ServeMux) Pause() {
+	m.pause.Pause()
+}
+
+// Resume unblocks the calls blocked by Pause.
+func (m *ServeMux) Resume() {
+	m.pause.Resume()
+}
+
+// Paused reports whether the mux is paused.
+func (m *ServeMux) Paused() bool {
+	return m.pause.Paused()
+}
+
+// SetSharding sets how Shard assigns pages to Server workers. By default,
+// each handled range is assigned to a worker, so that the faults of a
+// region are always resolved by the same worker.
+func (m *ServeMux) SetSharding(f ShardFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shard = f
+}
+
+// Shard implements Sharder.
+func (m *ServeMux) Shard(page uintptr) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.shard != nil {
+		return m.shard(page)
+	}
+	return max(m.lookup(page), 0)
+}
@@ -0,0 +1,323 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestServeMux(t *testing.T) {
+	needProcs(t, 4)
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	pageSize := unix.Getpagesize()
+
+	mux := NewServeMux()
+	var mems [][]byte
+	for i := range 2 {
+		mem, err := unix.Mmap(-1, 0, 2*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+		if err != nil {
+			t.Fatalf("mmap failed: %v", err)
+		}
+		defer unix.Munmap(mem)
+		var opts []RegionOption
+		if i == 0 {
+			opts = append(opts, WithProvider(patternProvider(2)))
+		}
+		r, err := uffd.RegisterRegion(mem, UFFDIO_REGISTER_MODE_MISSING, opts...)
+		if err != nil {
+			t.Fatalf("RegisterRegion failed: %v", err)
+		}
+		if err := mux.HandleRegion(r); err != nil {
+			t.Fatalf("HandleRegion failed: %v", err)
+		}
+		if err := mux.HandleRegion(r); err == nil {
+			t.Fatalf("HandleRegion of overlapping range succeeded")
+		}
+		mems = append(mems, mem)
+	}
+
+	base := uintptr(unsafe.Pointer(&mems[1][0]))
+	if mux.Handler(base) == nil || mux.Handler(0) != nil {
+		t.Errorf("Handler lookup failed")
+	}
+	if a, b := mux.Shard(base), mux.Shard(uintptr(unsafe.Pointer(&mems[0][0]))); a == b {
+		t.Errorf("regions share shard %d", a)
+	}
+
+	s := NewServer(uffd, mux, WithWorkers(2))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve()
+	}()
+
+	if mems[0][pageSize] != 2 || mems[1][pageSize] != 0 {
+		t.Errorf("got %d and %d, want 2 and 0", mems[0][pageSize], mems[1][pageSize])
+	}
+
+	mux.Pause()
+	faulted := kernelRead(t, mems[0])
+	select {
+	case <-faulted:
+		t.Fatal("fault resolved while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if p := s.Pending(); len(p) != 1 || p[0].Addr != uintptr(unsafe.Pointer(&mems[0][0])) || p[0].Age <= 0 {
+		t.Errorf("Pending() = %+v while paused", p)
+	}
+	mux.Resume()
+	<-faulted
+	if mems[0][0] != 1 {
+		t.Errorf("got %d after Resume, want 1", mems[0][0])
+	}
+	waitFor(t, func() bool { return len(s.Pending()) == 0 })
+
+	if err := mux.Remove(base); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+	if mux.Handler(base) != nil {
+		t.Errorf("Handler after Remove is not nil")
+	}
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+}
+
+func TestServeMuxPending(t *testing.T) {
+	needProcs(t, 2)
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	p := &blockingProvider{release: make(chan struct{})}
+	r, err := uffd.MapAndRegister(int64(unix.Getpagesize()), UFFDIO_REGISTER_MODE_MISSING, WithProvider(p))
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	defer r.Close()
+	mux := NewServeMux()
+	if err := mux.HandleRegion(r); err != nil {
+		t.Fatalf("HandleRegion failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(uffd, mux)
+	}()
+
+	faulted := kernelRead(t, r.Bytes())
+	waitFor(t, func() bool { return p.reads.Load() == 1 })
+	if p := mux.Pending(); len(p) != 1 || p[0].Addr != r.Base() || p[0].Age <= 0 {
+		t.Errorf("Pending() = %+v while the provider is blocked", p)
+	}
+	close(p.release)
+	<-faulted
+	waitFor(t, func() bool { return len(mux.Pending()) == 0 })
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+}
+
+func TestServeMuxTenantQuota(t *testing.T) {
+	needProcs(t, 2)
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	pageSize := unix.Getpagesize()
+	mux := NewServeMux()
+	mux.SetTenantQuota("a", Quota{MaxResidentBytes: int64(3 * pageSize)})
+	var regions []*Region
+	for range 2 {
+		r, err := uffd.MapAndRegister(int64(4*pageSize), UFFDIO_REGISTER_MODE_MISSING, WithProvider(patternProvider(4)))
+		if err != nil {
+			t.Fatalf("MapAndRegister failed: %v", err)
+		}
+		defer r.Close()
+		if err := mux.HandleRegion(r); err != nil {
+			t.Fatalf("HandleRegion failed: %v", err)
+		}
+		if err := mux.SetTenant(r.Base(), "a"); err != nil {
+			t.Fatalf("SetTenant failed: %v", err)
+		}
+		regions = append(regions, r)
+	}
+	if err := mux.SetTenant(0, "a"); err == nil {
+		t.Error("SetTenant of an unhandled range succeeded")
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(uffd, mux)
+	}()
+
+	resident := func() int {
+		return regions[0].evictor.Load().Resident() + regions[1].evictor.Load().Resident()
+	}
+	for _, r := range regions {
+		for i := range 4 {
+			if got := r.Bytes()[i*pageSize]; got != byte(i+1) {
+				t.Fatalf("page %d = %d, want %d", i, got, i+1)
+			}
+			if n := resident(); n > 3 {
+				t.Fatalf("%d pages resident over a quota of 3", n)
+			}
+		}
+	}
+	// The first region was the most resident when the second one faulted.
+	if n := regions[0].Stats().Evictions; n == 0 {
+		t.Error("no pages of the first region evicted")
+	}
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+}
+
+func TestServeMuxQuota(t *testing.T) {
+	var active, peak atomic.Int32
+	h := HandlerFunc(func(u *Uffd, msg *UffdMsg) error {
+		n := active.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+		active.Add(-1)
+		return nil
+	})
+	pageSize := unix.Getpagesize()
+	mux := NewServeMux()
+	if err := mux.Handle(0x10000, 64*pageSize, h); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if err := mux.SetQuota(0x10000, Quota{MaxConcurrentFills: 2, MaxFillBytesPerSec: float64(20 * pageSize)}); err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+
+	// A second worth of fills is allowed at once, the rest at the rate.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Go(func() {
+			msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+			msg.GetPagefault().Address = uint64(0x10000 + i*pageSize)
+			if err := mux.HandleEvent(nil, msg); err != nil {
+				t.Errorf("HandleEvent failed: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+	// The faults over the quotas are not waited for.
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("HandleEvent waited %v for the quotas", d)
+	}
+	waitFor(t, func() bool { return len(mux.Pending()) == 0 })
+	if p := peak.Load(); p != 2 {
+		t.Errorf("%d concurrent fills, want 2", p)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("30 fills at 20 per second took %v", d)
+	}
+
+	// Faults within their quotas are dispatched without allocating.
+	if err := mux.SetQuota(0x10000, Quota{MaxConcurrentFills: 1}); err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+	msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	msg.GetPagefault().Address = 0x10000
+	allocs := testing.AllocsPerRun(100, func() {
+		mux.HandleEvent(nil, msg)
+	})
+	if allocs != 0 {
+		t.Errorf("HandleEvent allocated %v times per fault, want 0", allocs)
+	}
+}
+
+// batchRecorder is a BatchHandler blocking on release, counting the batches
+// ended.
+type batchRecorder struct {
+	release chan struct{}
+	batches atomic.Int32
+}
+
+func (h *batchRecorder) HandleEvent(u *Uffd, msg *UffdMsg) error {
+	<-h.release
+	return nil
+}
+
+func (h *batchRecorder) EndBatch(u *Uffd) error {
+	h.batches.Add(1)
+	return nil
+}
+
+// A fault deferred by its quotas ends a batch of its own and is done after.
+func TestServeMuxDeferredFault(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	h := &batchRecorder{release: make(chan struct{})}
+	mux := NewServeMux()
+	if err := mux.Handle(0x10000, 2*pageSize, h); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if err := mux.SetQuota(0x10000, Quota{MaxConcurrentFills: 1}); err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+
+	first := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	first.GetPagefault().Address = 0x10000
+	handled := make(chan error, 1)
+	go func() {
+		_, err := mux.HandleFault(nil, first, nil)
+		handled <- err
+	}()
+	waitFor(t, func() bool { return len(mux.Pending()) == 1 })
+
+	msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	msg.GetPagefault().Address = uint64(0x10000 + pageSize)
+	done := make(chan *UffdMsg, 1)
+	deferred, err := mux.HandleFault(nil, msg, func(m *UffdMsg) { done <- m })
+	if err != nil || !deferred {
+		t.Fatalf("HandleFault over the quota = %v, %v, want deferred", deferred, err)
+	}
+	close(h.release)
+	if err := <-handled; err != nil {
+		t.Fatalf("HandleFault failed: %v", err)
+	}
+	if m := <-done; m.GetPagefault().Address != msg.GetPagefault().Address {
+		t.Errorf("done with the fault at %#x", m.GetPagefault().Address)
+	}
+	if n := h.batches.Load(); n != 1 {
+		t.Errorf("%d batches ended, want 1", n)
+	}
+}
+
+func TestShardFuncs(t *testing.T) {
+	pageSize := uintptr(unix.Getpagesize())
+
+	f := RangeShard(4 * pageSize)
+	if f(0) != f(3*pageSize) || f(0) == f(4*pageSize) {
+		t.Errorf("RangeShard does not group ranges")
+	}
+
+	seen := make(map[int]bool)
+	for i := range uintptr(64) {
+		seen[HashShard(i*pageSize)%4] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("HashShard used %d of 4 workers", len(seen))
+	}
+}
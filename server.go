@@ -0,0 +1,482 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Default settings of a Server.
+const (
+	defaultWorkers   = 4
+	defaultQueueSize = 64
+)
+
+// How long the reader of a Server waits for events before checking whether
+// a worker failed.
+const serverPollInterval = 100 // milliseconds
+
+// Server resolves the page faults of a userfaultfd with a pool of workers,
+// so that a slow PageProvider read for one page does not delay unrelated
+// faults. Faults for a page that is already being resolved are dropped, as
+// resolving a page wakes up every thread waiting on it.
+//
+// Other events are passed to the handler by the goroutine reading them, in
+// order. The handler must be safe for concurrent use, as Region is.
+//
+// With sharding, each page is assigned to a worker that resolves all its
+// faults, which helps the locality of provider caches. Handlers that
+// implement Sharder, like ServeMux, are sharded by default.
+type Server struct {
+	uffd      *Uffd
+	handler   Handler
+	workers   int
+	queueSize int
+	pageSize  uintptr
+	shard     ShardFunc
+	raw       bool
+	watchdog  *watchdog
+	cpus      []int // of the workers, set by WithWorkerCPUs
+	nice      int
+	setNice   bool
+
+	mu       sync.Mutex
+	inflight map[faultKey]inflightFault
+	dedup    atomic.Uint64
+	pause    pauser
+}
+
+// pauser pauses the handling of events. Handlers hold active for reading
+// while handling an event, and Pause holds it for writing until Resume.
+type pauser struct {
+	mu     sync.Mutex // serializes Pause and Resume
+	paused bool
+	active sync.RWMutex
+}
+
+func (p *pauser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		p.active.Lock()
+		p.paused = true
+	}
+}
+
+func (p *pauser) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		p.paused = false
+		p.active.Unlock()
+	}
+}
+
+func (p *pauser) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// faultKey identifies faults that are resolved the same way.
+type faultKey struct {
+	page  uintptr
+	flags uint64 // UFFD_PAGEFAULT_FLAG_WP and UFFD_PAGEFAULT_FLAG_MINOR
+}
+
+// inflightFault is a fault being resolved.
+type inflightFault struct {
+	addr     uintptr
+	flags    uint64
+	tid      uint32
+	read     time.Time
+	reported bool // to the watchdog
+	dups     bool // faults on the page were dropped meanwhile
+}
+
+func newInflightFault(msg *UffdMsg) inflightFault {
+	pf := msg.GetPagefault()
+	return inflightFault{addr: uintptr(pf.Address), flags: pf.Flags, tid: pf.Ptid, read: time.Now()}
+}
+
+func (f inflightFault) pending(now time.Time) PendingFault {
+	return PendingFault{Addr: f.addr, Flags: f.flags, Tid: int(f.tid), Age: now.Sub(f.read)}
+}
+
+// sortPending sorts faults from the oldest.
+func sortPending(faults []PendingFault) []PendingFault {
+	slices.SortFunc(faults, func(a, b PendingFault) int {
+		return cmp.Compare(b.Age, a.Age)
+	})
+	return faults
+}
+
+// ShardFunc assigns a page to one of the workers of a Server, modulo the
+// number of workers.
+type ShardFunc func(page uintptr) int
+
+// Sharder is implemented by handlers that choose the worker for each page.
+type Sharder interface {
+	Shard(page uintptr) int
+}
+
+// HashShard spreads pages evenly across workers.
+func HashShard(page uintptr) int {
+	return int(uint64(page) * 0x9e3779b97f4a7c15 >> 33)
+}
+
+// RangeShard assigns contiguous ranges of size bytes to the same worker.
+func RangeShard(size uintptr) ShardFunc {
+	return func(page uintptr) int {
+		return int(page / size)
+	}
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithWorkers sets the number of goroutines resolving faults. Defaults
+// to 4.
+//
+// A goroutine blocked on a fault keeps its P, so GOMAXPROCS must also
+// leave room for the workers, see Serve.
+func WithWorkers(n int) ServerOption {
+	return func(s *Server) {
+		s.workers = n
+	}
+}
+
+// WithQueueSize sets the number of faults read ahead of the workers.
+// Reading stops while the queue is full. Defaults to 64.
+func WithQueueSize(n int) ServerOption {
+	return func(s *Server) {
+		s.queueSize = n
+	}
+}
+
+// WithRawSyscalls makes the Server read events and resolve faults with
+// raw system calls, see Uffd.SetRawSyscalls.
+func WithRawSyscalls() ServerOption {
+	return func(s *Server) {
+		s.raw = true
+	}
+}
+
+// WithSharding assigns the faults of each page to a single worker.
+func WithSharding(f ShardFunc) ServerOption {
+	return func(s *Server) {
+		s.shard = f
+	}
+}
+
+// WithWorkerCPUs pins the workers to cpus, worker i to cpus[i%len(cpus)],
+// as fault resolution latency directly stalls the faulting threads. Each
+// worker is locked to its own OS thread, whose affinity is set with
+// sched_setaffinity(2). With sharding, the faults of a page are then always
+// resolved on the same CPU.
+func WithWorkerCPUs(cpus ...int) ServerOption {
+	return func(s *Server) {
+		s.cpus = slices.Clone(cpus)
+	}
+}
+
+// WithWorkerNice sets the nice value of the workers, each locked to its own
+// OS thread. Raising their priority, with a negative value, needs
+// CAP_SYS_NICE or a suitable RLIMIT_NICE.
+func WithWorkerNice(nice int) ServerOption {
+	return func(s *Server) {
+		s.nice = nice
+		s.setNice = true
+	}
+}
+
+// NewServer returns a Server dispatching the events of u to h.
+func NewServer(u *Uffd, h Handler, opts ...ServerOption) *Server {
+	s := &Server{
+		uffd:      u,
+		handler:   h,
+		workers:   defaultWorkers,
+		queueSize: defaultQueueSize,
+		pageSize:  uintptr(os.Getpagesize()),
+		inflight:  make(map[faultKey]inflightFault),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.workers = max(s.workers, 1)
+	s.queueSize = max(s.queueSize, 0)
+	if sh, ok := h.(Sharder); ok && s.shard == nil {
+		s.shard = sh.Shard
+	}
+	if s.raw {
+		u.SetRawSyscalls(true)
+	}
+	return s
+}
+
+// Serve reads and resolves events until the userfaultfd is closed, in which
+// case it returns nil, or the handler returns an error. Faults already
+// queued are resolved before it returns.
+func (s *Server) Serve() error {
+	// One queue shared by the workers, or one each with sharding.
+	queues := []chan *UffdMsg{make(chan *UffdMsg, s.queueSize)}
+	if s.shard != nil {
+		queues = make([]chan *UffdMsg, s.workers)
+		for i := range queues {
+			queues[i] = make(chan *UffdMsg, s.queueSize/s.workers)
+		}
+	}
+	stop := make(chan struct{})
+
+	var (
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	bh, _ := s.handler.(BatchHandler)
+	dh, _ := s.handler.(DeferringHandler)
+	release := s.release
+	var wg sync.WaitGroup
+	for i := range s.workers {
+		wg.Go(func() {
+			// The worker still drains its queue if it fails.
+			if err := s.setupWorker(i); err != nil {
+				fail(err)
+			}
+			queue := queues[i%len(queues)]
+			for msg := range queue {
+				s.pause.active.RLock()
+				var deferred bool
+				var err error
+				if dh != nil {
+					// Duplicates are dropped until a deferred fault is done.
+					deferred, err = dh.HandleFault(s.uffd, msg, release)
+				} else {
+					err = s.handler.HandleEvent(s.uffd, msg)
+				}
+				if err != nil {
+					fail(err)
+				}
+				if !deferred {
+					s.release(msg)
+				}
+				msgPool.Put(msg)
+				// The batch ends when the worker runs out of faults.
+				if bh != nil && len(queue) == 0 {
+					if err := bh.EndBatch(s.uffd); err != nil {
+						fail(err)
+					}
+				}
+				s.pause.active.RUnlock()
+			}
+		})
+	}
+
+	// The watchdog keeps running while waiting for stuck workers.
+	done := make(chan struct{})
+	var wd sync.WaitGroup
+	if s.watchdog != nil {
+		wd.Go(func() {
+			if err := s.watch(done); err != nil {
+				fail(err)
+			}
+		})
+	}
+
+	if err := s.read(queues, stop); err != nil {
+		fail(err)
+	}
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+	close(done)
+	wd.Wait()
+	return firstErr
+}
+
+// setupWorker sets the scheduling of the thread of worker i, which is
+// locked to it and so terminated with it.
+func (s *Server) setupWorker(i int) error {
+	if len(s.cpus) == 0 && !s.setNice {
+		return nil
+	}
+	runtime.LockOSThread()
+	if len(s.cpus) > 0 {
+		var set unix.CPUSet
+		set.Set(s.cpus[i%len(s.cpus)])
+		if err := unix.SchedSetaffinity(0, &set); err != nil {
+			return os.NewSyscallError("sched_setaffinity", err)
+		}
+	}
+	if s.setNice {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, unix.Gettid(), s.nice); err != nil {
+			return os.NewSyscallError("setpriority", err)
+		}
+	}
+	return nil
+}
+
+// read dispatches events until the userfaultfd is closed or stop is closed.
+func (s *Server) read(queues []chan *UffdMsg, stop <-chan struct{}) error {
+	bp := msgBatches.Get().(*[]UffdMsg)
+	defer msgBatches.Put(bp)
+	msgs := *bp
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		// Wake up now and then to notice a failed worker.
+		n, err := s.uffd.ReadMsgsTimeout(msgs, serverPollInterval)
+		switch {
+		case err == nil:
+		case errors.Is(err, unix.EAGAIN):
+			continue
+		case isClosed(err):
+			return nil
+		default:
+			return err
+		}
+
+		for i := range msgs[:n] {
+			if err := s.dispatch(queues, &msgs[i], stop); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// dispatch queues a page fault for the workers, or handles other events.
+func (s *Server) dispatch(queues []chan *UffdMsg, msg *UffdMsg, stop <-chan struct{}) error {
+	if msg.Event != UFFD_EVENT_PAGEFAULT {
+		s.pause.active.RLock()
+		defer s.pause.active.RUnlock()
+		return s.handler.HandleEvent(s.uffd, msg)
+	}
+	k := s.key(msg)
+	if !s.claim(k, msg) {
+		s.dedup.Add(1)
+		return nil
+	}
+	queue := queues[0]
+	if s.shard != nil {
+		queue = queues[uint(s.shard(k.page))%uint(len(queues))]
+	}
+
+	// The batch is reused, so each queued fault gets its own copy.
+	m := msgPool.Get().(*UffdMsg)
+	*m = *msg
+	select {
+	case queue <- m:
+	case <-stop:
+		s.release(m)
+		msgPool.Put(m)
+	}
+	return nil
+}
+
+// msgPool holds the messages queued to Server workers.
+var msgPool = sync.Pool{
+	New: func() any {
+		return new(UffdMsg)
+	},
+}
+
+func (s *Server) key(msg *UffdMsg) faultKey {
+	pf := msg.GetPagefault()
+	return faultKey{
+		page:  uintptr(pf.Address) &^ (s.pageSize - 1),
+		flags: pf.Flags & (UFFD_PAGEFAULT_FLAG_WP | UFFD_PAGEFAULT_FLAG_MINOR),
+	}
+}
+
+// claim returns false if the fault is being resolved already.
+func (s *Server) claim(k faultKey, msg *UffdMsg) bool {
+	f := newInflightFault(msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.inflight[k]; ok {
+		g.dups = true
+		s.inflight[k] = g
+		return false
+	}
+	s.inflight[k] = f
+	return true
+}
+
+// release forgets a fault once handled. The faults dropped meanwhile may
+// have been read after it was resolved, as when the page was
+// write-protected again, so their threads are woken up to fault again if
+// need be.
+func (s *Server) release(msg *UffdMsg) {
+	k := s.key(msg)
+	s.mu.Lock()
+	f := s.inflight[k]
+	delete(s.inflight, k)
+	s.mu.Unlock()
+	if f.dups {
+		s.uffd.Wake(k.page, int(s.pageSize))
+	}
+}
+
+// Pending returns the faults read and not resolved yet, from the oldest.
+// Faults dropped as duplicates are not included, as they wait for the same
+// resolution.
+func (s *Server) Pending() []PendingFault {
+	now := time.Now()
+	s.mu.Lock()
+	faults := make([]PendingFault, 0, len(s.inflight))
+	for _, f := range s.inflight {
+		faults = append(faults, f.pending(now))
+	}
+	s.mu.Unlock()
+	return sortPending(faults)
+}
+
+// Deduplicated returns the number of faults dropped because the same page
+// was being resolved.
+func (s *Server) Deduplicated() uint64 {
+	return s.dedup.Load()
+}
+
+// Pause stops resolving faults and handling events, once those being
+// handled are done. Meanwhile faults are queued, and then left in the
+// userfaultfd with the faulting threads blocked, until Resume. Serve does
+// not return while paused. Pause must not be called by the handler.
+//
+// A goroutine of this process faulting in user space while paused stops
+// the garbage collector, and any goroutine that waits for it, until Resume.
+func (s *Server) Pause() {
+	s.pause.Pause()
+}
+
+// Resume resumes handling events after Pause, starting with those queued.
+func (s *Server) Resume() {
+	s.pause.Resume()
+}
+
+// Paused reports whether the server is paused.
+func (s *Server) Paused() bool {
+	return s.pause.Paused()
+}
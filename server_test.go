@@ -0,0 +1,323 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// blockingProvider blocks reads of page 0 until release is closed.
+type blockingProvider struct {
+	release chan struct{}
+	reads   atomic.Int64
+}
+
+func (p *blockingProvider) ReadAt(b []byte, off int64) (int, error) {
+	p.reads.Add(1)
+	if off == 0 {
+		<-p.release
+	}
+	for i := range b {
+		b[i] = 0xaa
+	}
+	return len(b), nil
+}
+
+func TestServer(t *testing.T) {
+	// Two faulting goroutines, the reader and the workers all need a P.
+	needProcs(t, 6)
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, 2*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+
+	p := &blockingProvider{release: make(chan struct{})}
+	r, err := uffd.RegisterRegion(mem, UFFDIO_REGISTER_MODE_MISSING, WithProvider(p))
+	if err != nil {
+		t.Fatalf("RegisterRegion failed: %v", err)
+	}
+
+	s := NewServer(uffd, r, WithWorkers(2), WithQueueSize(4))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve()
+	}()
+
+	// Two faults on the slow page, of which only one is resolved.
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			if mem[0] != 0xaa {
+				t.Errorf("mem[0] = %#x, want 0xaa", mem[0])
+			}
+		})
+	}
+	waitFor(t, func() bool { return p.reads.Load() == 1 })
+
+	// The other page is resolved while the first one is still pending.
+	if mem[pageSize] != 0xaa {
+		t.Errorf("mem[%d] = %#x, want 0xaa", pageSize, mem[pageSize])
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(p.release)
+	wg.Wait()
+
+	if n := p.reads.Load(); n != 2 {
+		t.Errorf("provider reads = %d, want 2", n)
+	}
+	t.Logf("deduplicated %d faults", s.Deduplicated())
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+}
+
+// kernelRead makes the kernel read the first byte of b, returning a
+// channel that is closed once done. A goroutine blocked on a fault in a
+// system call does not hold up the stops of the runtime, unlike one
+// faulting in user space, which would block the garbage collector while
+// its fault is not resolved.
+func kernelRead(t *testing.T, b []byte) <-chan struct{} {
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC); err != nil {
+		t.Fatalf("pipe failed: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer unix.Close(p[0])
+		defer unix.Close(p[1])
+		if _, err := unix.Write(p[1], b[:1]); err != nil {
+			t.Errorf("write failed: %v", err)
+		}
+	}()
+	return done
+}
+
+// A fault on a page read after its previous fault was resolved, but before
+// the Server is done with it, is not left waiting.
+func TestServerRefault(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+	if _, err := uffd.RegisterRegion(mem, UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatalf("RegisterRegion failed: %v", err)
+	}
+
+	var (
+		s        *Server
+		calls    atomic.Int64
+		refaults = make(chan (<-chan struct{}), 1)
+	)
+	s = NewServer(uffd, HandlerFunc(func(u *Uffd, msg *UffdMsg) error {
+		n := calls.Add(1)
+		page := uintptr(msg.GetPagefault().Address) &^ uintptr(pageSize-1)
+		if _, err := u.Zeropage(page, pageSize, 0); err != nil {
+			return err
+		}
+		if n == 1 {
+			// Drop the page and fault on it again before returning.
+			if err := unix.Madvise(mem, unix.MADV_DONTNEED); err != nil {
+				return err
+			}
+			refaults <- kernelRead(t, mem)
+			waitFor(t, func() bool { return s.Deduplicated() > 0 })
+		}
+		return nil
+	}), WithWorkers(1))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve()
+	}()
+
+	<-kernelRead(t, mem)
+	select {
+	case <-<-refaults:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fault dropped as a duplicate left waiting")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d faults handled, want 2", n)
+	}
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+}
+
+func TestServerPause(t *testing.T) {
+	needProcs(t, 2)
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	r, err := uffd.MapAndRegister(int64(2*unix.Getpagesize()), UFFDIO_REGISTER_MODE_MISSING, WithProvider(patternProvider(2)))
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	defer r.Close()
+
+	s := NewServer(uffd, r, WithWorkers(1))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve()
+	}()
+
+	s.Pause()
+	s.Pause()
+	if !s.Paused() {
+		t.Fatal("Paused() = false after Pause")
+	}
+	faulted := kernelRead(t, r.Bytes())
+	select {
+	case <-faulted:
+		t.Fatal("fault resolved while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.Resume()
+	if s.Paused() {
+		t.Fatal("Paused() = true after Resume")
+	}
+	<-faulted
+	if b := r.Bytes()[0]; b != 1 {
+		t.Errorf("mem[0] = %d, want 1", b)
+	}
+
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+}
+
+func TestServerWatchdog(t *testing.T) {
+	needProcs(t, 2)
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	p := &blockingProvider{release: make(chan struct{})}
+	r, err := uffd.MapAndRegister(int64(unix.Getpagesize()), UFFDIO_REGISTER_MODE_MISSING, WithProvider(p))
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	defer r.Close()
+
+	const threshold = 20 * time.Millisecond
+	stuck := make(chan StuckFault, 2)
+	s := NewServer(uffd, r, WithWatchdog(threshold, func(f StuckFault) StuckAction {
+		stuck <- f
+		return StuckZeropage
+	}))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve()
+	}()
+
+	<-kernelRead(t, r.Bytes())
+	f := <-stuck
+	if f.Addr != r.Base() || f.Handler != r || f.Age < threshold {
+		t.Errorf("unexpected stuck fault %+v", f)
+	}
+	if b := r.Bytes()[0]; b != 0 {
+		t.Errorf("mem[0] = %#x, want a zero page", b)
+	}
+	// The provider read is still blocked.
+	if p := s.Pending(); len(p) != 1 || p[0].Addr != r.Base() || p[0].Age < threshold {
+		t.Errorf("Pending() = %+v", p)
+	}
+
+	// The provider read completes after the zero page was mapped.
+	close(p.release)
+	waitFor(t, func() bool { return r.Stats().Missing == 1 })
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	if len(stuck) != 0 {
+		t.Errorf("fault reported more than once")
+	}
+}
+
+func TestServerWorkerScheduling(t *testing.T) {
+	needProcs(t, 3)
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		t.Fatalf("sched_getaffinity failed: %v", err)
+	}
+	cpu := 0
+	for !allowed.IsSet(cpu) {
+		cpu++
+	}
+
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	r, err := uffd.MapAndRegister(int64(unix.Getpagesize()), UFFDIO_REGISTER_MODE_MISSING)
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	defer r.Close()
+
+	var (
+		set  unix.CPUSet
+		nice int
+		gerr error
+	)
+	h := HandlerFunc(func(u *Uffd, msg *UffdMsg) error {
+		if gerr = unix.SchedGetaffinity(0, &set); gerr == nil {
+			// The raw value of getpriority(2) is 20 - nice.
+			nice, gerr = unix.Getpriority(unix.PRIO_PROCESS, unix.Gettid())
+			nice = 20 - nice
+		}
+		return r.HandleEvent(u, msg)
+	})
+	s := NewServer(uffd, h, WithWorkers(1), WithWorkerCPUs(cpu), WithWorkerNice(5))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve()
+	}()
+
+	if r.Bytes()[0] != 0 {
+		t.Error("page not zero-filled")
+	}
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	if gerr != nil {
+		t.Fatal(gerr)
+	}
+	if set.Count() != 1 || !set.IsSet(cpu) {
+		t.Errorf("worker allowed on %d CPUs, want CPU %d only", set.Count(), cpu)
+	}
+	if nice != 5 {
+		t.Errorf("worker nice = %d, want 5", nice)
+	}
+}
@@ -0,0 +1,101 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// SigbusDispatcher resolves faults on regions registered on a userfaultfd
+// created with UFFD_FEATURE_SIGBUS. In this mode the kernel raises SIGBUS
+// in the faulting thread instead of queueing a message, so faults are
+// resolved in-thread by the region's handler and no serving goroutine is
+// needed.
+//
+// The Go runtime owns the SIGBUS handler, so instead of installing one
+// with sigaction(2), the dispatcher relies on CatchFault to turn the signal
+// into a recoverable panic carrying the faulting address. A handler of its
+// own could not call into Go to resolve the fault, and os/signal is not
+// told about synchronous signals, so only the faults of functions run by
+// Do are resolved: those of other goroutines crash the program as usual.
+type SigbusDispatcher struct {
+	mu      sync.RWMutex
+	regions []*Region
+}
+
+// NewSigbusDispatcher returns a dispatcher without regions.
+func NewSigbusDispatcher() *SigbusDispatcher {
+	return &SigbusDispatcher{}
+}
+
+// Add resolves faults within r from now on.
+func (d *SigbusDispatcher) Add(r *Region) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.regions = append(d.regions, r)
+}
+
+// Remove stops resolving faults within r.
+func (d *SigbusDispatcher) Remove(r *Region) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.regions = slices.DeleteFunc(d.regions, func(x *Region) bool { return x == r })
+}
+
+// Do runs fn, resolving faults within the dispatcher's regions. Each time
+// fn faults, the page is resolved and fn is run again from the start, so
+// fn must be safe to restart. Faults outside the regions, or that persist
+// after being resolved, are returned as a *FaultError.
+func (d *SigbusDispatcher) Do(fn func()) error {
+	var last uintptr
+	for n := 0; ; n++ {
+		addr, faulted := CatchFault(fn)
+		if !faulted {
+			return nil
+		}
+		if n > 0 && addr == last {
+			return &FaultError{Addr: addr, Err: fmt.Errorf("page fault not resolved")}
+		}
+		last = addr
+
+		r := d.lookup(addr)
+		if r == nil {
+			return &FaultError{Addr: addr}
+		}
+		msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+		pf := msg.GetPagefault()
+		pf.Address = uint64(addr)
+		pf.Flags = r.sigbusFlags()
+		if err := r.HandleEvent(r.uffd, msg); err != nil {
+			return &FaultError{Addr: addr, Err: err}
+		}
+	}
+}
+
+func (d *SigbusDispatcher) lookup(addr uintptr) *Region {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, r := range d.regions {
+		if r.Contains(addr) {
+			return r
+		}
+	}
+	return nil
+}
+
+// sigbusFlags guesses the page fault flags from the registration mode,
+// since SIGBUS does not tell which kind of fault occurred.
+func (r *Region) sigbusFlags() uint64 {
+	switch {
+	case r.mode&UFFDIO_REGISTER_MODE_MISSING != 0:
+		return 0
+	case r.mode&UFFDIO_REGISTER_MODE_MINOR != 0:
+		return UFFD_PAGEFAULT_FLAG_MINOR
+	default:
+		return UFFD_PAGEFAULT_FLAG_WP | UFFD_PAGEFAULT_FLAG_WRITE
+	}
+}
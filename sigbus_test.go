@@ -0,0 +1,69 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSigbusDispatcher(t *testing.T) {
+	pageSize := unix.Getpagesize()
+
+	uffd, err := New(flags|unix.O_CLOEXEC, UFFD_FEATURE_SIGBUS)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	mem, err := unix.Mmap(-1, 0, 3*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+
+	r, err := uffd.RegisterRegion(mem[:2*pageSize], UFFDIO_REGISTER_MODE_MISSING, WithProvider(patternProvider(2)))
+	if err != nil {
+		t.Fatalf("RegisterRegion failed: %v", err)
+	}
+	defer r.Close()
+	// The last page is registered but not handled by the dispatcher.
+	if _, err := uffd.Register(r.Base()+uintptr(2*pageSize), pageSize, UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	d := NewSigbusDispatcher()
+	d.Add(r)
+
+	var got byte
+	if err := d.Do(func() { got = mem[pageSize] }); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if got != 2 {
+		t.Fatalf("read %#x, want 2", got)
+	}
+	if err := d.Do(func() { mem[0]++; mem[pageSize]++ }); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if mem[0] != 2 || mem[pageSize] != 3 {
+		t.Fatalf("unexpected contents %#x %#x", mem[0], mem[pageSize])
+	}
+	if st := r.Stats(); st.Missing != 2 {
+		t.Fatalf("Missing = %d, want 2", st.Missing)
+	}
+
+	var ferr *FaultError
+	err = d.Do(func() { mem[2*pageSize] = 1 })
+	if !errors.As(err, &ferr) || ferr.Addr != r.Base()+uintptr(2*pageSize) || ferr.Err != nil {
+		t.Fatalf("Do outside the region returned %v", err)
+	}
+
+	d.Remove(r)
+	if err := d.Do(func() { mem[0]++ }); err != nil {
+		t.Fatalf("Do on a resident page failed: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// spilledPage is the index entry of a page of a SpillStore.
+type spilledPage struct {
+	slot  int64 // -1 for zero pages, which are not written
+	nonce []byte
+}
+
+// SpillStore is an EvictionStore writing pages to a spill file, as swap
+// does but for a single region and without touching system swap. The file
+// is created unlinked, so nothing is left behind, and its slots are reused
+// as pages are refaulted. Zero pages are only kept in the index.
+//
+// With a key, pages are encrypted with AES-GCM and bound to their offset,
+// so that the file does not leak the memory of the region and tampering
+// with it is detected on refault.
+type SpillStore struct {
+	f        *os.File
+	aead     cipher.AEAD // nil if not encrypted
+	maxBytes int64
+
+	mu       sync.Mutex
+	index    map[int64]spilledPage
+	pageSize int     // set by the first Put
+	slots    int64   // in the file
+	free     []int64 // free slots
+}
+
+// NewSpillStore creates a SpillStore in dir, or the default directory for
+// temporary files if empty, holding up to maxBytes of spilled pages, with
+// no limit if 0. Pages that do not fit are refused with ErrStoreFull. If
+// key is not nil, it is the AES key, of 16, 24 or 32 bytes, to encrypt the
+// pages with.
+func NewSpillStore(dir string, maxBytes int64, key []byte) (*SpillStore, error) {
+	s := &SpillStore{maxBytes: maxBytes, index: make(map[int64]spilledPage)}
+	if key != nil {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.CreateTemp(dir, "uffd-spill-")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.f = f
+	return s, nil
+}
+
+// slotSize returns the size of the slots, with mu held.
+func (s *SpillStore) slotSize() int64 {
+	if s.aead != nil {
+		return int64(s.pageSize + s.aead.Overhead())
+	}
+	return int64(s.pageSize)
+}
+
+// Put implements EvictionStore.
+func (s *SpillStore) Put(off int64, page []byte) error {
+	s.mu.Lock()
+	if s.pageSize == 0 {
+		s.pageSize = len(page)
+	}
+	if len(page) != s.pageSize {
+		s.mu.Unlock()
+		return fmt.Errorf("page of %d bytes in a spill store of %d byte pages", len(page), s.pageSize)
+	}
+	p := spilledPage{slot: -1}
+	if !zero(page) {
+		var err error
+		if p.slot, err = s.alloc(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	slotSize := s.slotSize()
+	s.mu.Unlock()
+
+	if p.slot >= 0 {
+		data := page
+		if s.aead != nil {
+			p.nonce = make([]byte, s.aead.NonceSize())
+			rand.Read(p.nonce)
+			data = s.aead.Seal(nil, p.nonce, page, binary.LittleEndian.AppendUint64(nil, uint64(off)))
+		}
+		if _, err := s.f.WriteAt(data, p.slot*slotSize); err != nil {
+			s.release(p)
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	old, ok := s.index[off]
+	s.index[off] = p
+	s.mu.Unlock()
+	if ok {
+		s.release(old)
+	}
+	return nil
+}
+
+// alloc returns a free slot, with mu held.
+func (s *SpillStore) alloc() (int64, error) {
+	if n := len(s.free); n > 0 {
+		slot := s.free[n-1]
+		s.free = s.free[:n-1]
+		return slot, nil
+	}
+	if s.maxBytes > 0 && (s.slots+1)*s.slotSize() > s.maxBytes {
+		return 0, ErrStoreFull
+	}
+	s.slots++
+	return s.slots - 1, nil
+}
+
+// release frees the slot of p.
+func (s *SpillStore) release(p spilledPage) {
+	if p.slot < 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.free = append(s.free, p.slot)
+}
+
+// Take implements EvictionStore.
+func (s *SpillStore) Take(off int64, page []byte) (bool, error) {
+	s.mu.Lock()
+	p, ok := s.index[off]
+	delete(s.index, off)
+	slotSize := s.slotSize()
+	s.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	defer s.release(p)
+	if p.slot < 0 {
+		clear(page)
+		return true, nil
+	}
+	if s.aead == nil {
+		if _, err := s.f.ReadAt(page, p.slot*slotSize); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	data := make([]byte, slotSize)
+	if _, err := s.f.ReadAt(data, p.slot*slotSize); err != nil {
+		return false, err
+	}
+	if _, err := s.aead.Open(page[:0], p.nonce, data, binary.LittleEndian.AppendUint64(nil, uint64(off))); err != nil {
+		return false, fmt.Errorf("spilled page at %d: %w", off, err)
+	}
+	return true, nil
+}
+
+// Drop implements EvictionStore.
+func (s *SpillStore) Drop(off int64, length int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for o, p := range s.index {
+		if o >= off && o < off+int64(length) {
+			delete(s.index, o)
+			if p.slot >= 0 {
+				s.free = append(s.free, p.slot)
+			}
+		}
+	}
+}
+
+// Len returns the number of pages kept.
+func (s *SpillStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.index)
+}
+
+// Bytes returns the size of the spill file.
+func (s *SpillStore) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.slots * s.slotSize()
+}
+
+// Close closes the spill file, which is then gone.
+func (s *SpillStore) Close() error {
+	return s.f.Close()
+}
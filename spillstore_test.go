@@ -0,0 +1,68 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSpillStore(t *testing.T) {
+	const pageSize = 4096
+	page := bytes.Repeat([]byte{1, 2, 3}, pageSize/3+1)[:pageSize]
+
+	for _, key := range [][]byte{nil, make([]byte, 32)} {
+		s, err := NewSpillStore(t.TempDir(), 2*pageSize+64, key)
+		if err != nil {
+			t.Fatalf("NewSpillStore failed: %v", err)
+		}
+		defer s.Close()
+
+		for i, p := range [][]byte{page, make([]byte, pageSize), page} {
+			if err := s.Put(int64(i*pageSize), p); err != nil {
+				t.Fatalf("Put(%d) failed: %v", i, err)
+			}
+		}
+		// Zero pages take no slot.
+		if err := s.Put(3*pageSize, page); !errors.Is(err, ErrStoreFull) {
+			t.Errorf("Put past the limit = %v", err)
+		}
+		if s.Len() != 3 {
+			t.Errorf("Len = %d, want 3", s.Len())
+		}
+		got := make([]byte, pageSize)
+		if ok, err := s.Take(0, got); !ok || err != nil || !bytes.Equal(got, page) {
+			t.Fatalf("Take(0) = %v, %v", ok, err)
+		}
+		if ok, err := s.Take(pageSize, got); !ok || err != nil || !bytes.Equal(got, make([]byte, pageSize)) {
+			t.Fatalf("Take of a zero page = %v, %v", ok, err)
+		}
+		if ok, _ := s.Take(0, got); ok {
+			t.Error("page taken twice")
+		}
+		// Slots are reused.
+		size := s.Bytes()
+		if err := s.Put(0, page); err != nil || s.Bytes() != size {
+			t.Errorf("Put reusing a slot = %v, file of %d bytes, was %d", err, s.Bytes(), size)
+		}
+		if key != nil {
+			// Pages are not written in the clear, and tampering is detected.
+			buf := make([]byte, size)
+			s.f.ReadAt(buf, 0)
+			if bytes.Contains(buf, page[:64]) {
+				t.Error("page written in the clear")
+			}
+			for _, off := range []int64{0, size / 2} {
+				s.f.WriteAt([]byte{^buf[off]}, off)
+			}
+			if ok, err := s.Take(0, got); err == nil {
+				t.Errorf("Take of a tampered page = %v, %v", ok, err)
+			}
+		}
+		s.Drop(0, 3*pageSize)
+		if s.Len() != 0 {
+			t.Errorf("Len after Drop = %d", s.Len())
+		}
+	}
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Size and alignment of the staging buffers backed by huge pages.
+const hugeStagingSize = 2 << 20
+
+// stagingMapping is the memory of a staging buffer, unmapped once the
+// buffer is unreachable.
+type stagingMapping struct {
+	addr   unsafe.Pointer
+	length uintptr
+}
+
+// allocStaging maps a buffer of size bytes, aligned to a page or to a huge
+// page. It falls back to the Go heap if mmap(2) fails.
+func allocStaging(size int, huge bool) *[]byte {
+	align := uintptr(os.Getpagesize())
+	if huge && size >= hugeStagingSize {
+		align = hugeStagingSize
+	}
+	length := RoundUp(uintptr(size), align)
+
+	// Map more to align the start, and trim the excess.
+	extra := align - uintptr(os.Getpagesize())
+	p, err := unix.MmapPtr(-1, 0, nil, length+extra, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		buf := make([]byte, size)
+		return &buf
+	}
+	head := RoundUp(uintptr(p), align) - uintptr(p)
+	if head > 0 {
+		unix.MunmapPtr(p, head)
+	}
+	start := unsafe.Add(p, head)
+	if tail := extra - head; tail > 0 {
+		unix.MunmapPtr(unsafe.Add(start, length), tail)
+	}
+	mem := unsafe.Slice((*byte)(start), length)
+	if align == hugeStagingSize {
+		// Best effort, as transparent huge pages may be disabled.
+		unix.Madvise(mem, unix.MADV_HUGEPAGE)
+	}
+
+	buf := mem[:size:size]
+	runtime.AddCleanup(&buf, func(m stagingMapping) {
+		unix.MunmapPtr(m.addr, m.length)
+	}, stagingMapping{start, length})
+	return &buf
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStagingPool(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	for _, tc := range []struct {
+		size  int
+		huge  bool
+		align int
+	}{
+		{pageSize, false, pageSize},
+		{100, true, pageSize},
+		{hugeStagingSize, false, pageSize},
+		{hugeStagingSize + 1, true, hugeStagingSize},
+	} {
+		p := newStagingPool(tc.size, tc.huge)
+		bp := p.Get()
+		buf := *bp
+		if len(buf) != tc.size {
+			t.Errorf("len = %d, want %d", len(buf), tc.size)
+		}
+		if addr := uintptr(unsafe.Pointer(&buf[0])); addr%uintptr(tc.align) != 0 {
+			t.Errorf("buffer of %d bytes at %#x not aligned to %d", tc.size, addr, tc.align)
+		}
+		buf[0], buf[len(buf)-1] = 1, 1
+		p.Put(bp)
+	}
+}
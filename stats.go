@@ -0,0 +1,208 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	histSubBits    = 4 // 16 sub-buckets per power of two: ~6% precision
+	histSubBuckets = 1 << histSubBits
+	histBuckets    = (64 - histSubBits + 1) * histSubBuckets
+)
+
+// Histogram is a log-linear (HDR-style) histogram of durations with a
+// relative precision of about 6%.
+type Histogram struct {
+	counts [histBuckets]uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func histIndex(v uint64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histSubBits - 1
+	return (shift+1)*histSubBuckets + int(v>>shift&(histSubBuckets-1))
+}
+
+// histValue returns the lowest value of bucket i.
+func histValue(i int) uint64 {
+	if i < histSubBuckets {
+		return uint64(i)
+	}
+	shift := i/histSubBuckets - 1
+	return uint64(histSubBuckets+i%histSubBuckets) << shift
+}
+
+// Record adds a duration to the histogram. Negative durations count as 0.
+func (h *Histogram) Record(d time.Duration) {
+	d = max(d, 0)
+	h.counts[histIndex(uint64(d))]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.count++
+	h.sum += d
+}
+
+// Merge adds the durations recorded by o to the histogram.
+func (h *Histogram) Merge(o *Histogram) {
+	if o.count == 0 {
+		return
+	}
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	h.max = max(h.max, o.max)
+	h.count += o.count
+	h.sum += o.sum
+}
+
+// Count returns the number of recorded durations.
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Min returns the smallest recorded duration.
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest recorded duration.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the average of the recorded durations.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Quantile returns the duration below which the fraction q of the recorded
+// durations fall, e.g. Quantile(0.99) for the 99th percentile.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.count) + 0.5)
+	rank = min(max(rank, 1), h.count)
+
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return min(max(time.Duration(histValue(i)), h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// Stats holds counters of the faults handled by a region.
+type Stats struct {
+	Missing        uint64    // Missing faults resolved
+	WP             uint64    // Write-protect faults resolved
+	Minor          uint64    // Minor faults resolved
+	Errors         uint64    // Faults that failed to resolve
+	BytesFilled    uint64    // Bytes copied from the provider, prefetched included
+	BytesMoved     uint64    // Bytes of BytesFilled moved into place with UFFDIO_MOVE
+	Zeropages      uint64    // Pages filled with zeros
+	Evictions      uint64    // Pages evicted to stay within the resident limit
+	Restored       uint64    // Evicted pages refaulted from the EvictionStore
+	ProviderErrors uint64    // Provider reads that failed
+	FirstFault     time.Time // When the first fault was handled, zero if none
+	LastFault      time.Time // When the last fault was handled, zero if none
+	Latency        Histogram // Time from reading a fault to resolving it
+}
+
+// BufferPoolStats counts the pages pinned by a BufferPool.
+type BufferPoolStats struct {
+	Hits   uint64 // Pages resident when pinned
+	Misses uint64 // Pages faulted in when pinned
+	Pinned int    // Pages pinned now
+}
+
+// regionStats guards the Stats of a region.
+type regionStats struct {
+	mu sync.Mutex
+	Stats
+}
+
+// record counts a fault read at start and handled after d.
+func (s *regionStats) record(flags uint64, start time.Time, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.FirstFault.IsZero() {
+		s.FirstFault = start
+	}
+	s.LastFault = start
+	if err != nil {
+		s.Errors++
+		return
+	}
+	switch {
+	case flags&UFFD_PAGEFAULT_FLAG_MINOR != 0:
+		s.Minor++
+	case flags&UFFD_PAGEFAULT_FLAG_WP != 0:
+		s.WP++
+	default:
+		s.Missing++
+	}
+	s.Latency.Record(d)
+}
+
+// add adds n to a counter of s.
+func (s *regionStats) add(counter *uint64, n uint64) {
+	s.mu.Lock()
+	*counter += n
+	s.mu.Unlock()
+}
+
+func (s *regionStats) reset() {
+	s.mu.Lock()
+	s.Stats = Stats{}
+	s.mu.Unlock()
+}
+
+// EventStats holds the number of events read from a userfaultfd by type.
+type EventStats struct {
+	Pagefault uint64
+	Fork      uint64
+	Remap     uint64
+	Remove    uint64
+	Unmap     uint64
+}
+
+type eventCounters struct {
+	pagefault, fork, remap, remove, unmap atomic.Uint64
+}
+
+func (c *eventCounters) count(event uint8) {
+	switch event {
+	case UFFD_EVENT_PAGEFAULT:
+		c.pagefault.Add(1)
+	case UFFD_EVENT_FORK:
+		c.fork.Add(1)
+	case UFFD_EVENT_REMAP:
+		c.remap.Add(1)
+	case UFFD_EVENT_REMOVE:
+		c.remove.Add(1)
+	case UFFD_EVENT_UNMAP:
+		c.unmap.Add(1)
+	}
+}
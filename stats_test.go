@@ -0,0 +1,117 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestHistIndex(t *testing.T) {
+	for _, v := range []uint64{0, 1, 15, 16, 17, 31, 32, 33, 1000, 123456789, 1 << 62, 1<<64 - 1} {
+		i := histIndex(v)
+		if i < 0 || i >= histBuckets {
+			t.Fatalf("histIndex(%d) = %d out of range", v, i)
+		}
+		lo := histValue(i)
+		if lo > v {
+			t.Fatalf("bucket %d lower bound %d above value %d", i, lo, v)
+		}
+		if v >= histSubBuckets && float64(v-lo)/float64(v) > 1.0/histSubBuckets {
+			t.Fatalf("value %d too far from bucket %d lower bound %d", v, i, lo)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	if h.Quantile(0.5) != 0 || h.Mean() != 0 {
+		t.Fatalf("empty histogram must report zero")
+	}
+
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	if h.Count() != 100 {
+		t.Fatalf("Count() = %d, want 100", h.Count())
+	}
+	if h.Min() != time.Microsecond || h.Max() != 100*time.Microsecond {
+		t.Fatalf("Min/Max = %v/%v", h.Min(), h.Max())
+	}
+	if h.Mean() != 50500*time.Nanosecond {
+		t.Fatalf("Mean() = %v", h.Mean())
+	}
+
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.5, 50 * time.Microsecond},
+		{0.99, 99 * time.Microsecond},
+		{1, 100 * time.Microsecond},
+	} {
+		got := h.Quantile(tc.q)
+		if got > tc.want || float64(tc.want-got)/float64(tc.want) > 0.07 {
+			t.Errorf("Quantile(%v) = %v, want ~%v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b, all Histogram
+	for i := 1; i <= 100; i++ {
+		d := time.Duration(i) * time.Microsecond
+		if i%2 == 0 {
+			a.Record(d)
+		} else {
+			b.Record(d)
+		}
+		all.Record(d)
+	}
+	var empty Histogram
+	a.Merge(&empty)
+	empty.Merge(&a)
+	if empty != a {
+		t.Errorf("merging into an empty histogram does not copy it")
+	}
+	a.Merge(&b)
+	if a != all {
+		t.Errorf("merged histogram differs: count %d, min %v, max %v, mean %v", a.Count(), a.Min(), a.Max(), a.Mean())
+	}
+}
+
+func TestRegionStats(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 3)
+
+	for i := 0; i < 3; i++ {
+		mem[i*pageSize] = 1
+	}
+
+	// Counters are updated after the faulting thread is woken up.
+	waitFor(t, func() bool { return r.Stats().Missing == 3 })
+
+	st := r.Stats()
+	if st.WP != 0 || st.Minor != 0 || st.Errors != 0 {
+		t.Fatalf("unexpected counters: missing %d, wp %d, minor %d, errors %d", st.Missing, st.WP, st.Minor, st.Errors)
+	}
+	if st.Latency.Count() != 3 || st.Latency.Max() <= 0 {
+		t.Fatalf("unexpected latency histogram: count %d, max %v", st.Latency.Count(), st.Latency.Max())
+	}
+	if st.Zeropages != 3 || st.BytesFilled != 0 || st.Evictions != 0 || st.ProviderErrors != 0 {
+		t.Errorf("unexpected fill counters: %+v", st)
+	}
+	if st.FirstFault.IsZero() || st.LastFault.Before(st.FirstFault) {
+		t.Errorf("first fault at %v, last at %v", st.FirstFault, st.LastFault)
+	}
+
+	r.ResetStats()
+	if st := r.Stats(); st.Missing != 0 || st.Zeropages != 0 || !st.FirstFault.IsZero() || st.Latency.Count() != 0 {
+		t.Errorf("counters not reset: %+v", st)
+	}
+}
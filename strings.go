@@ -0,0 +1,81 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// flagString joins the names of the bits set in v, followed by the
+// unknown bits in hex.
+func flagString(v uint64, name func(bit int) string) string {
+	if v == 0 {
+		return "0x0"
+	}
+	var parts []string
+	var unknown uint64
+	for bit := 0; bit < 64; bit++ {
+		if v&(1<<bit) == 0 {
+			continue
+		}
+		if s := name(bit); s != "" {
+			parts = append(parts, s)
+		} else {
+			unknown |= 1 << bit
+		}
+	}
+	if unknown != 0 {
+		parts = append(parts, fmt.Sprintf("0x%x", unknown))
+	}
+	return strings.Join(parts, "|")
+}
+
+func sliceName(names []string) func(int) string {
+	return func(bit int) string {
+		if bit < len(names) {
+			return names[bit]
+		}
+		return ""
+	}
+}
+
+// FeatureString converts a UFFD_FEATURE_* mask into a human-readable flag list.
+func FeatureString(features uint64) string {
+	return flagString(features, sliceName(featureNames))
+}
+
+// IoctlsString converts the ioctls mask reported by UFFDIO_API or
+// UFFDIO_REGISTER into a human-readable list.
+func IoctlsString(ioctls uint64) string {
+	return flagString(ioctls, func(bit int) string { return ioctlNames[bit] })
+}
+
+// RegisterModeString converts a UFFDIO_REGISTER_MODE_* mask into a
+// human-readable flag list.
+func RegisterModeString(mode uint64) string {
+	return flagString(mode, sliceName(registerModeNames))
+}
+
+// PagefaultFlagsString converts UFFD_PAGEFAULT_FLAG_* flags into a
+// human-readable flag list.
+func PagefaultFlagsString(flags uint64) string {
+	return flagString(flags, sliceName(pagefaultFlagNames))
+}
+
+// EventString returns the name of a UFFD_EVENT_* event.
+func EventString(event uint8) string {
+	switch event {
+	case UFFD_EVENT_PAGEFAULT:
+		return "PAGEFAULT"
+	case UFFD_EVENT_FORK:
+		return "FORK"
+	case UFFD_EVENT_REMAP:
+		return "REMAP"
+	case UFFD_EVENT_REMOVE:
+		return "REMOVE"
+	case UFFD_EVENT_UNMAP:
+		return "UNMAP"
+	}
+	return fmt.Sprintf("0x%x", event)
+}
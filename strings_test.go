@@ -0,0 +1,30 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+)
+
+func TestFlagStrings(t *testing.T) {
+	cases := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"FeatureString", FeatureString(UFFD_FEATURE_PAGEFAULT_FLAG_WP | UFFD_FEATURE_MOVE), "PAGEFAULT_FLAG_WP|MOVE"},
+		{"FeatureString unknown", FeatureString(UFFD_FEATURE_SIGBUS | 1<<40), "SIGBUS|0x10000000000"},
+		{"FeatureString zero", FeatureString(0), "0x0"},
+		{"IoctlsString", IoctlsString(1<<0x3F | 1<<0x00 | 1<<0x01), "REGISTER|UNREGISTER|API"},
+		{"RegisterModeString", RegisterModeString(UFFDIO_REGISTER_MODE_MISSING | UFFDIO_REGISTER_MODE_MINOR), "MISSING|MINOR"},
+		{"PagefaultFlagsString", PagefaultFlagsString(UFFD_PAGEFAULT_FLAG_WRITE | UFFD_PAGEFAULT_FLAG_WP), "WRITE|WP"},
+		{"EventString", EventString(UFFD_EVENT_REMOVE), "REMOVE"},
+		{"EventString unknown", EventString(0x42), "0x42"},
+	}
+
+	for _, tc := range cases {
+		if tc.got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.name, tc.got, tc.want)
+		}
+	}
+}
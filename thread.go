@@ -0,0 +1,29 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ThreadComm returns the command name of thread tid of process pid,
+// as found in /proc/<pid>/task/<tid>/comm.
+func ThreadComm(pid, tid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/comm", pid, tid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// Comm returns the command name of the faulting thread, which must belong
+// to the calling process. Needs UFFD_FEATURE_THREAD_ID.
+func (pf *UffdMsgPagefault) Comm() (string, error) {
+	if pf.Ptid == 0 {
+		return "", errors.New("thread ID not reported")
+	}
+	return ThreadComm(os.Getpid(), int(pf.Ptid))
+}
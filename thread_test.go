@@ -0,0 +1,43 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestThreadComm(t *testing.T) {
+	runtime.LockOSThread()
+	// Leave the renamed thread to exit with the goroutine.
+
+	name := []byte("uffd-test\x00")
+	if err := unix.Prctl(unix.PR_SET_NAME, uintptr(unsafe.Pointer(&name[0])), 0, 0, 0); err != nil {
+		t.Fatalf("prctl failed: %v", err)
+	}
+
+	msg := pagefaultMsg(0, 0)
+	pf := msg.GetPagefault()
+	if _, err := pf.Comm(); err == nil {
+		t.Fatalf("Comm without thread ID succeeded")
+	}
+
+	pf.Ptid = uint32(unix.Gettid())
+	comm, err := pf.Comm()
+	if err != nil {
+		t.Fatalf("Comm failed: %v", err)
+	}
+	if comm != "uffd-test" {
+		t.Fatalf("Comm() = %q, want %q", comm, "uffd-test")
+	}
+
+	if _, err := ThreadComm(os.Getpid(), 0); err == nil {
+		t.Fatalf("ThreadComm of invalid thread succeeded")
+	}
+}
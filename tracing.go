@@ -0,0 +1,23 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Tracer starts the spans of the faults resolved by a region set up with
+// WithTracer. The uffdotel package adapts OpenTelemetry tracers to it.
+type Tracer interface {
+	// StartSpan starts a span, a child of the span of ctx if any, and
+	// returns a context holding it. The span of a fault has the attributes
+	// uffd.region, uffd.offset and uffd.flags.
+	StartSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// End ends the span, recording err if not nil.
+	End(err error)
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// WithTracer enables spans around every fault resolution, with child spans
+// for the provider read, the resolving ioctl and the wake up.
+func WithTracer(t Tracer) RegionOption {
+	return func(r *Region) {
+		r.tracer = t
+	}
+}
+
+// noSpan is the span of the faults of regions without a tracer.
+type noSpan struct{}
+
+func (noSpan) End(error) {}
+
+// startFault starts the span of a fault at addr, if tracing is enabled.
+func (r *Region) startFault(addr uintptr, flags uint64) (context.Context, Span) {
+	ctx := context.Background()
+	if r.tracer == nil {
+		return ctx, noSpan{}
+	}
+	return r.tracer.StartSpan(ctx, "uffd.fault",
+		slog.String("uffd.region", fmt.Sprintf("%#x", r.Base())),
+		slog.Int64("uffd.offset", int64(addr-r.Base())),
+		slog.Int64("uffd.flags", int64(flags)),
+	)
+}
+
+// startSpan starts a child span of ctx, if tracing is enabled.
+func (r *Region) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if r.tracer == nil {
+		return ctx, noSpan{}
+	}
+	return r.tracer.StartSpan(ctx, name)
+}
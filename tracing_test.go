@@ -0,0 +1,65 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// recordingTracer records the names of the spans started, and of those
+// ended with an error.
+type recordingTracer struct {
+	mu     sync.Mutex
+	names  []string
+	failed []string
+}
+
+type recordingSpan struct {
+	t    *recordingTracer
+	name string
+}
+
+func (t *recordingTracer) StartSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	t.mu.Lock()
+	t.names = append(t.names, name)
+	t.mu.Unlock()
+	return ctx, recordingSpan{t, name}
+}
+
+func (s recordingSpan) End(err error) {
+	if err != nil {
+		s.t.mu.Lock()
+		s.t.failed = append(s.t.failed, s.name)
+		s.t.mu.Unlock()
+	}
+}
+
+func (t *recordingTracer) spans() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.names)
+}
+
+func TestRegionTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	mem, r := serveRegion(t, 1, WithProvider(patternProvider(1)), WithTracer(tracer))
+
+	if mem[0] != 1 {
+		t.Fatalf("page not filled from provider")
+	}
+	waitFor(t, func() bool { return r.Stats().Missing == 1 })
+
+	want := []string{"uffd.fault", "provider.read", "uffd.copy"}
+	if got := tracer.spans(); !slices.Equal(got, want) {
+		t.Fatalf("spans = %v, want %v", got, want)
+	}
+	if len(tracer.failed) != 0 {
+		t.Errorf("failed spans: %v", tracer.failed)
+	}
+}
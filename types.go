@@ -71,7 +71,11 @@ type UffdioMove struct {
 	Move int64  // Returns: Number of bytes moved, or negated error
 }
 
+// UffdMsg is read from the userfaultfd. The kernel structure is packed,
+// but the Go type is aligned so that the Data accessors below do not
+// perform unaligned loads on strict-alignment architectures.
 type UffdMsg struct {
+	_     [0]uint64
 	Event uint8
 	_     [7]byte // padding
 	Data  [24]byte
@@ -88,6 +92,13 @@ func (m *UffdMsg) GetPagefault() *UffdMsgPagefault {
 	return (*UffdMsgPagefault)(unsafe.Pointer(&m.Data[0]))
 }
 
+// Addr returns the faulting address and the page containing it. The
+// address is rounded down to the page unless UFFD_FEATURE_EXACT_ADDRESS
+// is enabled.
+func (pf *UffdMsgPagefault) Addr() (exact, page uintptr) {
+	return uintptr(pf.Address), RoundDownPage(uintptr(pf.Address))
+}
+
 type UffdMsgFork struct {
 	Ufd uint32 // Userfault file descriptor of the child process
 }
@@ -3,6 +3,7 @@
 package userfaultfd
 
 import (
+	"os"
 	"testing"
 	"unsafe"
 )
@@ -32,3 +33,68 @@ func TestStructSizes(t *testing.T) {
 		}
 	}
 }
+
+func TestPagefaultAddr(t *testing.T) {
+	pageSize := uintptr(os.Getpagesize())
+
+	msg := &UffdMsg{Event: UFFD_EVENT_PAGEFAULT}
+	msg.GetPagefault().Address = uint64(3*pageSize + 5)
+	exact, page := msg.GetPagefault().Addr()
+	if exact != 3*pageSize+5 || page != 3*pageSize {
+		t.Fatalf("Addr() = %#x, %#x", exact, page)
+	}
+
+	tests := []struct {
+		addr, down, up uintptr
+	}{
+		{0, 0, 0},
+		{1, 0, pageSize},
+		{pageSize, pageSize, pageSize},
+		{pageSize + 1, pageSize, 2 * pageSize},
+	}
+	for _, tt := range tests {
+		if got := PageAlignDown(tt.addr); got != tt.down {
+			t.Errorf("PageAlignDown(%#x) = %#x, want %#x", tt.addr, got, tt.down)
+		}
+		if got := PageAlignUp(tt.addr); got != tt.up {
+			t.Errorf("PageAlignUp(%#x) = %#x, want %#x", tt.addr, got, tt.up)
+		}
+	}
+}
+
+// TestIoctlSizes checks that the argument size encoded in each ioctl number,
+// from the headers or derived in Go, matches the Go type passed with it.
+func TestIoctlSizes(t *testing.T) {
+	tests := []struct {
+		name  string
+		ioctl uint64
+		size  uintptr
+	}{
+		{"UFFDIO_API", UFFDIO_API, unsafe.Sizeof(UffdioApi{})},
+		{"UFFDIO_REGISTER", UFFDIO_REGISTER, unsafe.Sizeof(UffdioRegister{})},
+		{"UFFDIO_UNREGISTER", UFFDIO_UNREGISTER, unsafe.Sizeof(UffdioRange{})},
+		{"UFFDIO_WAKE", UFFDIO_WAKE, unsafe.Sizeof(UffdioRange{})},
+		{"UFFDIO_COPY", UFFDIO_COPY, unsafe.Sizeof(UffdioCopy{})},
+		{"UFFDIO_ZEROPAGE", UFFDIO_ZEROPAGE, unsafe.Sizeof(UffdioZeropage{})},
+		{"UFFDIO_MOVE", UFFDIO_MOVE, unsafe.Sizeof(UffdioMove{})},
+		{"UFFDIO_WRITEPROTECT", UFFDIO_WRITEPROTECT, unsafe.Sizeof(UffdioWriteprotect{})},
+		{"UFFDIO_CONTINUE", UFFDIO_CONTINUE, unsafe.Sizeof(UffdioContinue{})},
+		{"UFFDIO_POISON", UFFDIO_POISON, unsafe.Sizeof(UffdioPoison{})},
+	}
+
+	for _, tt := range tests {
+		if tt.ioctl == 0 {
+			continue // not in the headers
+		}
+		// 13 size bits on mips and powerpc, 14 elsewhere.
+		if got := uintptr(tt.ioctl>>iocSizeShift) & 0x1fff; got != tt.size {
+			t.Errorf("%s encodes size %d, want %d", tt.name, got, tt.size)
+		}
+	}
+}
+
+func TestUffdMsgAlignment(t *testing.T) {
+	if align := unsafe.Alignof(UffdMsg{}); align < unsafe.Alignof(uint64(0)) {
+		t.Errorf("UffdMsg alignment = %d, want at least %d", align, unsafe.Alignof(uint64(0)))
+	}
+}
@@ -1,25 +1,76 @@
+//go:build linux
+
 /* SPDX-License-Identifier: BSD-2-Clause */
 
 package userfaultfd
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
 // Uffd wraps a userfaultfd file descriptor.
+//
+// Its methods are safe for concurrent use: one goroutine can read events
+// while others resolve them, and Close waits for the calls using the
+// descriptor to return. Use Dup for a separately closed handle on the same
+// userfaultfd.
 type Uffd struct {
-	File  *os.File
-	api   *UffdioApi
-	flags int
+	File    *os.File
+	api     *UffdioApi
+	enabled uint64 // features requested in the handshake
+	flags   int
+	events  eventCounters
+	ranges  atomic.Uint64 // ioctls granted by the kernel on registered ranges
+
+	fd        uintptr      // valid while mu is held for reading and not closed
+	wake      int          // eventfd signalled by Close to interrupt ReadMsg
+	mu        sync.RWMutex // held for reading while the descriptor is used
+	closed    atomic.Bool
+	raw       atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+
+	desc *description // shared with dups
+
+	readMu   sync.Mutex    // guards kick
+	deadline atomic.Int64  // of reads, in Unix nanoseconds, 0 if none
+	kick     *deadlineKick // of the current deadline
+}
+
+// description is the state of the open file description of a userfaultfd,
+// shared by a Uffd and its dups, as is its O_NONBLOCK flag.
+type description struct {
+	mu       sync.Mutex
+	nonblock bool // O_NONBLOCK as set by New or SetNonblock
+	readers  int  // reads in progress, see beginRead
 }
 
 // New creates a new userfaultfd and performs the two-step API handshake.
 // Returns an *Uffd or an error.
 func New(flags int, features uint64) (*Uffd, error) {
+	return newUffd(flags, features, false)
+}
+
+// NewBestEffort is like New but enables the wanted features supported by
+// the kernel instead of failing when some are not, and returns them.
+func NewBestEffort(flags int, wanted uint64) (u *Uffd, granted uint64, err error) {
+	if u, err = newUffd(flags, wanted, true); err != nil {
+		return nil, 0, err
+	}
+	return u, u.api.Features & wanted, nil
+}
+
+func newUffd(flags int, features uint64, bestEffort bool) (*Uffd, error) {
 	file, err := Open(flags)
 	if err != nil {
 		return nil, err
@@ -32,6 +83,7 @@ func New(flags int, features uint64) (*Uffd, error) {
 	}
 
 	if api.Api != UFFD_API {
+		file.Close()
 		return nil, ErrInvalidApi
 	}
 
@@ -40,10 +92,13 @@ func New(flags int, features uint64) (*Uffd, error) {
 	// the userfaultfd file descriptor must be closed after the first UFFDIO_API operation that
 	// queries features availability and reopened before the second UFFDIO_API operation that
 	// actually enables the desired features.
+	if bestEffort {
+		features &= api.Features
+	}
 	if features != 0 {
 		file.Close()
 		if api.Features&features != features {
-			return nil, ErrUnsupportedFeature
+			return nil, &ErrFeatureUnavailable{Feature: features &^ api.Features}
 		}
 		if file, err = Open(flags); err != nil {
 			return nil, err
@@ -54,19 +109,165 @@ func New(flags int, features uint64) (*Uffd, error) {
 		}
 	}
 
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		file.Close()
+		return nil, os.NewSyscallError("eventfd", err)
+	}
+
 	return &Uffd{
-		File:  file,
-		api:   api,
-		flags: flags,
+		File:    file,
+		api:     api,
+		enabled: features,
+		flags:   flags,
+		fd:      file.Fd(),
+		wake:    wake,
+		desc:    &description{nonblock: flags&unix.O_NONBLOCK != 0},
 	}, nil
 }
 
-// Close closes the underlying file descriptor.
+// Close closes the underlying file descriptor. It is safe to call more than
+// once and concurrently with other methods, which then fail with
+// ErrClosed. Blocked calls to ReadMsg return, and the descriptor is only
+// released once ioctls in flight have returned.
 func (u *Uffd) Close() error {
-	return u.File.Close()
+	u.closeOnce.Do(func() {
+		u.closed.Store(true)
+		unix.Write(u.wake, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		u.closeErr = u.File.Close()
+		unix.Close(u.wake)
+		u.readMu.Lock()
+		if u.kick != nil {
+			unix.Close(u.kick.fd)
+			u.kick = nil
+		}
+		u.readMu.Unlock()
+	})
+	return u.closeErr
+}
+
+// Dup returns a Uffd with a duplicate descriptor, with FD_CLOEXEC set, of
+// the same userfaultfd. Events can be read and faults resolved through
+// either, and the userfaultfd lives until both are closed. They share the
+// O_NONBLOCK flag.
+func (u *Uffd) Dup() (*Uffd, error) {
+	var nfd int
+	if err := u.control(func(fd uintptr) (err error) {
+		nfd, err = unix.FcntlInt(fd, unix.F_DUPFD_CLOEXEC, 0)
+		return err
+	}); err != nil {
+		if errors.Is(err, ErrClosed) {
+			return nil, err
+		}
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		unix.Close(nfd)
+		return nil, os.NewSyscallError("eventfd", err)
+	}
+	file := os.NewFile(uintptr(nfd), "userfaultfd")
+	d := &Uffd{
+		File:    file,
+		api:     u.api,
+		enabled: u.enabled,
+		flags:   u.flags,
+		fd:      file.Fd(),
+		wake:    wake,
+		desc:    u.desc,
+	}
+	d.ranges.Store(u.ranges.Load())
+	d.raw.Store(u.raw.Load())
+	return d, nil
+}
+
+// SetNonblock sets or clears O_NONBLOCK on the userfaultfd, with
+// fcntl(2), for the code reading it other than through u or its dups, such
+// as another process it was sent to. The reads of u behave the same either
+// way. A userfaultfd added to an EventLoop must stay non-blocking.
+func (u *Uffd) SetNonblock(nonblock bool) error {
+	return u.control(func(fd uintptr) error {
+		d := u.desc
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		// Reads in progress restore the flag when done.
+		if d.readers == 0 {
+			if err := unix.SetNonblock(int(fd), nonblock); err != nil {
+				return os.NewSyscallError("fcntl", err)
+			}
+		}
+		d.nonblock = nonblock
+		return nil
+	})
+}
+
+// Nonblock reports whether the userfaultfd is non-blocking, as set by New
+// or SetNonblock.
+func (u *Uffd) Nonblock() bool {
+	u.desc.mu.Lock()
+	defer u.desc.mu.Unlock()
+	return u.desc.nonblock
+}
+
+// beginRead makes a blocking descriptor non-blocking until the last read
+// through u or its dups ends, as poll(2) always reports blocking
+// userfaultfds in error. Other processes sharing the descriptor see the
+// flag set meanwhile. It must be called with u.mu held for reading.
+func (u *Uffd) beginRead() error {
+	d := u.desc
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.readers == 0 && !d.nonblock {
+		if err := unix.SetNonblock(int(u.fd), true); err != nil {
+			return os.NewSyscallError("fcntl", err)
+		}
+	}
+	d.readers++
+	return nil
+}
+
+func (u *Uffd) endRead() {
+	d := u.desc
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.readers--; d.readers == 0 && !d.nonblock {
+		unix.SetNonblock(int(u.fd), false)
+	}
+}
+
+// expectNonblock reports whether O_NONBLOCK is expected to be set on the
+// descriptor, including by reads in progress.
+func (u *Uffd) expectNonblock() bool {
+	u.desc.mu.Lock()
+	defer u.desc.mu.Unlock()
+	return u.desc.nonblock || u.desc.readers > 0
+}
+
+// control runs fn with the file descriptor, which is kept open until fn
+// returns.
+func (u *Uffd) control(fn func(fd uintptr) error) error {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.closed.Load() {
+		return ErrClosed
+	}
+	return fn(u.fd)
 }
 
-// FD returns the underlying file descriptor.
+// SetRawSyscalls makes the reads of non-blocking userfaultfds and the
+// UFFDIO_COPY, UFFDIO_ZEROPAGE and UFFDIO_WAKE ioctls use raw system calls,
+// which skip the scheduler bookkeeping that can dominate these
+// microsecond-scale calls. The goroutine keeps its P meanwhile, which can
+// delay other goroutines and the garbage collector if the kernel is slow,
+// for example when it has to reclaim memory to fill a page.
+func (u *Uffd) SetRawSyscalls(enable bool) {
+	u.raw.Store(enable)
+}
+
+// FD returns the underlying file descriptor. Prefer the methods of Uffd,
+// which cannot race with Close.
 func (u *Uffd) Fd() int {
 	return int(u.File.Fd())
 }
@@ -83,125 +284,297 @@ func (u *Uffd) Ioctls() uint64 {
 
 // Returns string representation.
 func (u *Uffd) String() string {
-	return fmt.Sprintf("uffd(fd=%d, features=%#x, ioctls=%#x)", u.Fd(), u.api.Features, u.api.Ioctls)
+	return fmt.Sprintf("uffd(fd=%d, features=%s, ioctls=%s)", u.Fd(), FeatureString(u.api.Features), IoctlsString(u.api.Ioctls))
 }
 
-// Returns true if ioctl is available.
+// Returns true if ioctl is available. The range ioctls such as
+// _UFFDIO_COPY are only known to be available once the kernel granted
+// them for a range registered with Register.
 func (u *Uffd) HasIoctl(ioctl int) bool {
-	return ioctl != -1 && u.api.Ioctls&(1<<ioctl) != 0
+	return ioctl != -1 && (u.api.Ioctls|u.ranges.Load())&(1<<ioctl) != 0
+}
+
+// RequireIoctls returns an error wrapping ErrMissingIoctl that lists the
+// ioctls, given as _UFFDIO_* bit numbers like for HasIoctl, that are not
+// available. See HasIoctl for the range ioctls.
+func (u *Uffd) RequireIoctls(ioctls ...int) error {
+	return requireIoctls(u.api.Ioctls|u.ranges.Load(), ioctls)
+}
+
+// checkIoctl returns ErrMissingIoctl if the kernel did not grant ioctl for
+// any of the ranges registered so far. Before the first registration the
+// kernel is left to decide.
+func (u *Uffd) checkIoctl(ioctl int) error {
+	if ranges := u.ranges.Load(); ranges != 0 && (ioctl == -1 || ranges&(1<<ioctl) == 0) {
+		return ErrMissingIoctl
+	}
+	return nil
 }
 
 // Continue resolves a minor page fault.
 func (u *Uffd) Continue(start uintptr, length int, mode int) error {
-	return Continue(u.File.Fd(), start, length, mode)
+	if err := u.checkIoctl(_UFFDIO_CONTINUE); err != nil {
+		return err
+	}
+	return u.control(func(fd uintptr) error {
+		return Continue(fd, start, length, mode)
+	})
 }
 
 // Copy resolves a page fault by copying from src to dst.
 func (u *Uffd) Copy(dst, src uintptr, length int, mode int) (int64, error) {
-	return Copy(u.File.Fd(), dst, src, length, mode)
+	var n int64
+	err := u.control(func(fd uintptr) (err error) {
+		n, err = copyWith(u.raw.Load(), fd, dst, src, length, mode)
+		return err
+	})
+	return n, err
 }
 
 // Move moves pages from src to dst.
 func (u *Uffd) Move(dst, src uintptr, length int, mode int) (int64, error) {
-	return Move(u.File.Fd(), dst, src, length, mode)
+	if err := u.checkIoctl(_UFFDIO_MOVE); err != nil {
+		return 0, err
+	}
+	var n int64
+	err := u.control(func(fd uintptr) (err error) {
+		n, err = Move(fd, dst, src, length, mode)
+		return err
+	})
+	return n, err
 }
 
 // Poison poisons pages in the given range.
 func (u *Uffd) Poison(start uintptr, length int, mode int) (int64, error) {
-	return Poison(u.File.Fd(), start, length, mode)
+	if err := u.checkIoctl(_UFFDIO_POISON); err != nil {
+		return 0, err
+	}
+	var n int64
+	err := u.control(func(fd uintptr) (err error) {
+		n, err = Poison(fd, start, length, mode)
+		return err
+	})
+	return n, err
 }
 
-// Register registers a memory range with the given mode.
+// Register registers a memory range with the given mode and records the
+// ioctls the kernel granted for it. If the kernel rejects the range with
+// EINVAL, the error includes the reason found by ValidateRange.
 func (u *Uffd) Register(start uintptr, length int, mode int) (*UffdioRegister, error) {
-	return Register(u.File.Fd(), start, length, mode)
+	var reg *UffdioRegister
+	err := u.control(func(fd uintptr) (err error) {
+		reg, err = Register(fd, start, length, mode)
+		return err
+	})
+	if errors.Is(err, unix.EINVAL) {
+		if verr := ValidateRange(start, length, mode); verr != nil {
+			return nil, fmt.Errorf("%w: %w", err, verr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.ranges.Or(reg.Ioctls)
+	return reg, nil
 }
 
 // Unregister unregisters a previously registered range.
 func (u *Uffd) Unregister(start uintptr, length int) error {
-	return Unregister(u.File.Fd(), start, length)
+	return u.control(func(fd uintptr) error {
+		return Unregister(fd, start, length)
+	})
 }
 
 // Wake wakes blocked page faults in the given range.
 func (u *Uffd) Wake(start uintptr, length int) error {
-	return Wake(u.File.Fd(), start, length)
+	return u.control(func(fd uintptr) error {
+		return wakeWith(u.raw.Load(), fd, start, length)
+	})
+}
+
+// WakeRanges wakes blocked page faults in several ranges at once, with one
+// UFFDIO_WAKE per run of adjacent or overlapping ranges. The ranges are
+// sorted in place.
+func (u *Uffd) WakeRanges(ranges []UffdioRange) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+	slices.SortFunc(ranges, func(a, b UffdioRange) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	return u.control(func(fd uintptr) error {
+		raw := u.raw.Load()
+		cur := ranges[0]
+		for _, r := range ranges[1:] {
+			if r.Start <= cur.Start+cur.Len {
+				cur.Len = max(cur.Len, r.Start+r.Len-cur.Start)
+				continue
+			}
+			if err := wakeWith(raw, fd, uintptr(cur.Start), int(cur.Len)); err != nil {
+				return err
+			}
+			cur = r
+		}
+		return wakeWith(raw, fd, uintptr(cur.Start), int(cur.Len))
+	})
 }
 
 // WriteProtect enables/disables write protection.
 func (u *Uffd) WriteProtect(start uintptr, length int, mode int) error {
-	return WriteProtect(u.File.Fd(), start, length, mode)
+	if err := u.checkIoctl(_UFFDIO_WRITEPROTECT); err != nil {
+		return err
+	}
+	return u.control(func(fd uintptr) error {
+		return WriteProtect(fd, start, length, mode)
+	})
 }
 
 // Zeropage zero-fills a memory range.
 func (u *Uffd) Zeropage(start uintptr, length int, mode int) (int64, error) {
-	return Zeropage(u.File.Fd(), start, length, mode)
+	var n int64
+	err := u.control(func(fd uintptr) (err error) {
+		n, err = zeropageWith(u.raw.Load(), fd, start, length, mode)
+		return err
+	})
+	return n, err
 }
 
 // ReadMsgTimeout reads one event message from the userfaultfd.
 //
 // timeout semantics:
-//   timeout == 0   : non-blocking poll/read; return immediately if no event
-//   timeout > 0    : wait up to timeout milliseconds for an event
-//   timeout < 0    : block indefinitely until an event arrives
 //
-// For file descriptors opened with O_NONBLOCK, read() returns EAGAIN when no
-// event is available. For blocking file descriptors, poll(2) always reports
-// POLLERR immediately (see userfaultfd(2)), so timeout values for
-// blocking descriptors do not affect behavior.
+//	timeout == 0   : non-blocking poll/read; return immediately if no event
+//	timeout > 0    : wait up to timeout milliseconds for an event
+//	timeout < 0    : block indefinitely until an event arrives
 //
-// On POLLERR, POLLHUP, or POLLNVAL, a *PollError is returned.
+// It behaves the same whether the userfaultfd is blocking or not: poll(2)
+// always reports POLLERR on blocking userfaultfds (see userfaultfd(2)), so
+// they are made non-blocking while read.
+//
+// On POLLERR, POLLHUP, or POLLNVAL, a *PollError is returned. Once the
+// userfaultfd is closed, including while waiting, ErrClosed is returned, and
+// once the deadline set by SetReadDeadline is past, os.ErrDeadlineExceeded.
 func (u *Uffd) ReadMsgTimeout(timeout int) (*UffdMsg, error) {
-	pfd := []unix.PollFd{{
-		Fd:     int32(u.Fd()),
-		Events: unix.POLLIN,
-	}}
+	msg := new(UffdMsg)
+	if _, err := u.ReadMsgsTimeout(unsafe.Slice(msg, 1), timeout); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ReadMsgsTimeout reads up to len(msgs) event messages with a single
+// read(2) and returns how many were read. It waits for the first one as
+// ReadMsgTimeout does. Serving loops reuse msgs to avoid allocating.
+// Messages are returned as the kernel wrote them, including those of event
+// types unknown to this package or with fields added by newer kernels,
+// which handlers can ignore: as they are consumed by the read, failing the
+// batch would leave the faults read with it unresolved. DecodeMsg checks
+// them strictly.
+func (u *Uffd) ReadMsgsTimeout(msgs []UffdMsg, timeout int) (int, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.closed.Load() {
+		return 0, ErrClosed
+	}
+	if err := u.beginRead(); err != nil {
+		return 0, err
+	}
+	defer u.endRead()
 
-	if err := retryOnEINTR(func() error {
-		_, err := unix.Poll(pfd, timeout)
+	var end time.Time
+	if timeout > 0 {
+		end = time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	}
+	var re int16
+	for {
+		// Watched first, so that a deadline set meanwhile wakes up the poll.
+		kick, err := u.watchDeadline()
 		if err != nil {
+			return 0, err
+		}
+		wait, err := u.readTimeout(timeout, end)
+		if err != nil {
+			u.unwatchDeadline(kick)
+			return 0, err
+		}
+		pfd := [3]unix.PollFd{{
+			Fd:     int32(u.fd),
+			Events: unix.POLLIN,
+		}, {
+			Fd:     int32(u.wake),
+			Events: unix.POLLIN,
+		}, {
+			Fd:     int32(kick.fd),
+			Events: unix.POLLIN,
+		}}
+		err = retryOnEINTR(func() error {
+			_, err := unix.Poll(pfd[:], wait)
 			return err
+		})
+		u.unwatchDeadline(kick)
+		switch {
+		case err != nil:
+			return 0, os.NewSyscallError("poll", err)
+		case pfd[1].Revents != 0:
+			return 0, ErrClosed
+		case pfd[2].Revents != 0:
+			// The deadline changed.
+			continue
+		case pfd[0].Revents == 0 && u.deadlinePassed():
+			return 0, os.ErrDeadlineExceeded
 		}
-		return nil
-	}); err != nil {
-		return nil, os.NewSyscallError("poll", err)
+		re = pfd[0].Revents
+		break
 	}
-	// From userfaultfd(2):
-	// If the O_NONBLOCK flag is not enabled, then poll(2) (always) indicates the file as having a POLLERR condition.
-	re := pfd[0].Revents
 	if re&(unix.POLLERR|unix.POLLHUP|unix.POLLNVAL) != 0 {
-		return nil, &PollError{Revents: re}
+		return 0, &PollError{Revents: re}
 	}
 
-	var msg UffdMsg
-	buf := (*[unsafe.Sizeof(msg)]byte)(unsafe.Pointer(&msg))[:]
+	const size = MsgSize
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(msgs))), len(msgs)*size)
 
-	if err := retryOnEINTR(func() error {
-		n, err := unix.Read(u.Fd(), buf)
-		if err != nil {
-			return err
-		}
-		if n != len(buf) {
-			return fmt.Errorf("truncated read: got %d, expected %d", n, len(buf))
+	var n int
+	if err := retryOnEINTR(func() (err error) {
+		if u.raw.Load() {
+			r, _, errno := unix.RawSyscall(unix.SYS_READ, u.fd, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+			if n = int(r); errno != 0 {
+				return errno
+			}
+			return nil
 		}
-		return nil
+		n, err = unix.Read(int(u.fd), buf)
+		return err
 	}); err != nil {
-		return nil, os.NewSyscallError("read", err)
+		return 0, os.NewSyscallError("read", err)
+	}
+	if n%size != 0 || n == 0 {
+		return 0, os.NewSyscallError("read", fmt.Errorf("truncated read: got %d, expected a multiple of %d", n, size))
 	}
 
-	return &msg, nil
+	n /= size
+	for i := range msgs[:n] {
+		u.events.count(msgs[i].Event)
+	}
+	return n, nil
 }
 
-// ReadMsg reads a single event message from the userfaultfd, blocking
-// according to the descriptor's file status flags.
-//
-// If O_NONBLOCK was specified when creating the userfaultfd, ReadMsg behaves
-// as a non-blocking read and returns a wrapped EAGAIN error if no event data
-// is available.
-//
-// If O_NONBLOCK was not specified, ReadMsg blocks indefinitely waiting for
-// the next available event and will not return until an event is delivered
-// or a terminal poll condition occurs (POLLERR, POLLHUP, or POLLNVAL).
+// ReadMsg reads a single event message from the userfaultfd, waiting for
+// one until the userfaultfd is closed, whether it is blocking or not. It
+// returns ErrClosed once closed, or a *PollError on a terminal poll
+// condition (POLLERR, POLLHUP or POLLNVAL).
 //
 // Internally, ReadMsg is equivalent to ReadMsgTimeout(-1).
 func (u *Uffd) ReadMsg() (*UffdMsg, error) {
 	return u.ReadMsgTimeout(-1)
 }
+
+// EventStats returns the number of events read so far by type.
+func (u *Uffd) EventStats() EventStats {
+	return EventStats{
+		Pagefault: u.events.pagefault.Load(),
+		Fork:      u.events.fork.Load(),
+		Remap:     u.events.remap.Load(),
+		Remove:    u.events.remove.Load(),
+		Unmap:     u.events.unmap.Load(),
+	}
+}
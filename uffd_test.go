@@ -1,12 +1,16 @@
+//go:build linux
+
 /* SPDX-License-Identifier: BSD-2-Clause */
 
 package userfaultfd
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"testing"
 	"time"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
@@ -49,6 +53,53 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewBestEffort(t *testing.T) {
+	const unknown = 1 << 63
+	wanted := uint64(UFFD_FEATURE_THREAD_ID | unknown)
+
+	_, err := New(flags|unix.O_CLOEXEC, wanted)
+	var fe *ErrFeatureUnavailable
+	if !errors.As(err, &fe) || fe.Feature != unknown {
+		t.Fatalf("New with an unknown feature returned %v", err)
+	}
+
+	uffd, granted, err := NewBestEffort(flags|unix.O_CLOEXEC, wanted)
+	if err != nil {
+		t.Fatalf("NewBestEffort failed: %v", err)
+	}
+	defer uffd.Close()
+	if want := uffd.Features() & UFFD_FEATURE_THREAD_ID; granted != want {
+		t.Errorf("granted %s, want %s", FeatureString(granted), FeatureString(want))
+	}
+}
+
+func TestCheckFdInfo(t *testing.T) {
+	uffd, _, err := NewBestEffort(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, UFFD_FEATURE_THREAD_ID)
+	if err != nil {
+		t.Fatalf("NewBestEffort failed: %v", err)
+	}
+	defer uffd.Close()
+
+	info, err := uffd.FdInfo()
+	if err != nil {
+		t.Fatalf("FdInfo failed: %v", err)
+	}
+	if info.Api != UFFD_API || info.Pending != 0 || info.Total != 0 {
+		t.Errorf("FdInfo = %+v", info)
+	}
+	if err := uffd.CheckFdInfo(); err != nil {
+		t.Errorf("CheckFdInfo failed: %v", err)
+	}
+
+	// Clearing O_NONBLOCK behind its back is noticed.
+	if err := unix.SetNonblock(uffd.Fd(), false); err != nil {
+		t.Fatalf("SetNonblock failed: %v", err)
+	}
+	if err := uffd.CheckFdInfo(); err == nil {
+		t.Errorf("CheckFdInfo did not notice O_NONBLOCK was cleared")
+	}
+}
+
 func TestReadMsgNoEvent(t *testing.T) {
 	uffd, err := New(flags|unix.O_NONBLOCK, 0)
 	if err != nil {
@@ -94,6 +145,78 @@ func TestReadMsgNonBlocking(t *testing.T) {
 	}
 }
 
+func TestSetNonblock(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	nonblock := func() bool {
+		fl, err := unix.FcntlInt(uintptr(uffd.Fd()), unix.F_GETFL, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fl&unix.O_NONBLOCK != 0
+	}
+
+	if err := uffd.SetNonblock(false); err != nil {
+		t.Fatalf("SetNonblock(false) failed: %v", err)
+	}
+	if uffd.Nonblock() || nonblock() {
+		t.Error("O_NONBLOCK still set")
+	}
+	if err := uffd.CheckFdInfo(); err != nil {
+		t.Errorf("CheckFdInfo failed: %v", err)
+	}
+
+	// A read in progress keeps the descriptor non-blocking until done.
+	done := make(chan error)
+	go func() {
+		_, err := uffd.ReadMsg()
+		done <- err
+	}()
+	waitFor(t, nonblock)
+	if err := uffd.SetNonblock(true); err != nil {
+		t.Fatalf("SetNonblock(true) failed: %v", err)
+	}
+	if err := uffd.SetNonblock(false); err != nil {
+		t.Fatalf("SetNonblock(false) failed: %v", err)
+	}
+	if !nonblock() {
+		t.Error("O_NONBLOCK cleared during a read")
+	}
+	if err := uffd.CheckFdInfo(); err != nil {
+		t.Errorf("CheckFdInfo during a read failed: %v", err)
+	}
+
+	// Dups share the flag, and the reads in progress on either.
+	dup, err := uffd.Dup()
+	if err != nil {
+		t.Fatalf("Dup failed: %v", err)
+	}
+	if dup.Nonblock() {
+		t.Error("dup of a blocking userfaultfd is non-blocking")
+	}
+	if _, err := dup.ReadMsgTimeout(0); !errors.Is(err, unix.EAGAIN) {
+		t.Errorf("ReadMsgTimeout(0) of the dup: %v, want EAGAIN", err)
+	}
+	if !nonblock() {
+		t.Error("O_NONBLOCK cleared by a read of the dup during a read")
+	}
+	dup.Close()
+
+	if err := uffd.SetNonblock(true); err != nil {
+		t.Fatalf("SetNonblock(true) failed: %v", err)
+	}
+	uffd.Close()
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Errorf("ReadMsg: %v, want %v", err, ErrClosed)
+	}
+	if err := uffd.SetNonblock(false); !errors.Is(err, ErrClosed) {
+		t.Errorf("SetNonblock after Close: %v, want %v", err, ErrClosed)
+	}
+}
+
 func TestHasIoctl(t *testing.T) {
 	uffd, err := New(flags, 0)
 	if err != nil {
@@ -158,10 +281,19 @@ func TestReadMsgTimeoutBlocking(t *testing.T) {
 	}
 	defer uffd.Close()
 
-	_, err = uffd.ReadMsgTimeout(-1)
-	var perr *PollError
-	if !errors.As(err, &perr) {
-		t.Fatalf("expected PollError, got %T: %v", err, err)
+	// Reads wait as on a non-blocking userfaultfd, and leave it blocking.
+	start := time.Now()
+	if _, err := uffd.ReadMsgTimeout(50); !errors.Is(err, unix.EAGAIN) {
+		t.Fatalf("ReadMsgTimeout(50): %v, want EAGAIN", err)
+	}
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("ReadMsgTimeout(50) returned after %v", elapsed)
+	}
+	if fl, _ := unix.FcntlInt(uintptr(uffd.Fd()), unix.F_GETFL, 0); fl&unix.O_NONBLOCK != 0 {
+		t.Error("O_NONBLOCK left set after reading")
+	}
+	if err := uffd.CheckFdInfo(); err != nil {
+		t.Errorf("CheckFdInfo failed: %v", err)
 	}
 }
 
@@ -223,9 +355,8 @@ func TestReadMsgTimeoutTable(t *testing.T) {
 			flags:   flags,
 			timeout: 0,
 			expectFn: func(t *testing.T, err error, elapsed time.Duration) {
-				var perr *PollError
-				if !errors.As(err, &perr) {
-					t.Fatalf("expected PollError, got %T: %v", err, err)
+				if !errors.Is(err, unix.EAGAIN) {
+					t.Fatalf("expected EAGAIN, got %v", err)
 				}
 				if elapsed > 10*time.Millisecond {
 					t.Fatalf("timeout=0 must return immediately; took %v", elapsed)
@@ -237,12 +368,11 @@ func TestReadMsgTimeoutTable(t *testing.T) {
 			flags:   flags,
 			timeout: 50,
 			expectFn: func(t *testing.T, err error, elapsed time.Duration) {
-				var perr *PollError
-				if !errors.As(err, &perr) {
-					t.Fatalf("expected PollError, got %T: %v", err, err)
+				if !errors.Is(err, unix.EAGAIN) {
+					t.Fatalf("expected EAGAIN, got %v", err)
 				}
-				if elapsed > 10*time.Millisecond {
-					t.Fatalf("blocking FD must not wait: %v", elapsed)
+				if elapsed < 45*time.Millisecond {
+					t.Fatalf("timeout did not wait long enough: %v", elapsed)
 				}
 			},
 		},
@@ -251,12 +381,11 @@ func TestReadMsgTimeoutTable(t *testing.T) {
 			flags:   flags,
 			timeout: -1,
 			expectFn: func(t *testing.T, err error, elapsed time.Duration) {
-				var perr *PollError
-				if !errors.As(err, &perr) {
-					t.Fatalf("expected PollError, got %T: %v", err, err)
+				if err != nil {
+					t.Fatalf("expected block, got err=%v", err)
 				}
-				if elapsed > 10*time.Millisecond {
-					t.Fatalf("must return immediately, took %v", elapsed)
+				if elapsed < 40*time.Millisecond {
+					t.Fatalf("should block indefinitely, returned after %v", elapsed)
 				}
 			},
 		},
@@ -298,3 +427,339 @@ func TestReadMsgTimeoutTable(t *testing.T) {
 		})
 	}
 }
+
+func TestHasIoctlRange(t *testing.T) {
+	uffd, err := New(flags, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	if uffd.HasIoctl(_UFFDIO_COPY) {
+		t.Fatalf("HasIoctl(_UFFDIO_COPY) = true before registration")
+	}
+
+	mem, err := unix.Mmap(-1, 0, unix.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+
+	start := uintptr(unsafe.Pointer(&mem[0]))
+	if _, err := uffd.Register(start, len(mem), UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	defer uffd.Unregister(start, len(mem))
+
+	if !uffd.HasIoctl(_UFFDIO_COPY) || !uffd.HasIoctl(_UFFDIO_ZEROPAGE) {
+		t.Fatalf("range ioctls not granted: %s", IoctlsString(uffd.ranges.Load()))
+	}
+	// Anonymous memory does not support minor faults.
+	if uffd.HasIoctl(_UFFDIO_CONTINUE) {
+		t.Fatalf("HasIoctl(_UFFDIO_CONTINUE) = true for anonymous memory")
+	}
+	if err := uffd.Continue(start, len(mem), 0); !errors.Is(err, ErrMissingIoctl) {
+		t.Fatalf("Continue returned %v, want ErrMissingIoctl", err)
+	}
+}
+
+func TestRequireIoctls(t *testing.T) {
+	uffd, err := New(flags, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	if err := uffd.RequireIoctls(_UFFDIO_API, _UFFDIO_REGISTER); err != nil {
+		t.Fatalf("RequireIoctls failed: %v", err)
+	}
+
+	reg := &UffdioRegister{Ioctls: 1<<_UFFDIO_WAKE | 1<<_UFFDIO_COPY}
+	if err := reg.Require(_UFFDIO_COPY); err != nil {
+		t.Fatalf("Require failed: %v", err)
+	}
+	err = reg.Require(_UFFDIO_COPY, _UFFDIO_ZEROPAGE, 0x07)
+	if !errors.Is(err, ErrMissingIoctl) {
+		t.Fatalf("Require returned %v, want ErrMissingIoctl", err)
+	}
+	if want := ErrMissingIoctl.Error() + ": ZEROPAGE|CONTINUE"; err.Error() != want {
+		t.Fatalf("Require error = %q, want %q", err, want)
+	}
+	if err := reg.Require(-1); !errors.Is(err, ErrMissingIoctl) {
+		t.Fatalf("Require of an unknown ioctl returned %v", err)
+	}
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Serve(uffd, HandlerFunc(func(*Uffd, *UffdMsg) error { return nil }))
+	}()
+
+	for range 2 {
+		if err := uffd.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	if err := uffd.Wake(0, os.Getpagesize()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Wake after Close: %v, want %v", err, ErrClosed)
+	}
+	if _, err := uffd.ReadMsgTimeout(0); !errors.Is(err, ErrClosed) {
+		t.Fatalf("ReadMsgTimeout after Close: %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestReadMsgUnblocksOnClose(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := uffd.ReadMsg()
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	uffd.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("ReadMsg: %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadMsg still blocked after Close")
+	}
+}
+
+func TestDup(t *testing.T) {
+	needProcs(t, 2)
+	pageSize := os.Getpagesize()
+	uffd, err := New(flags|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	mem, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+	addr := uintptr(unsafe.Pointer(&mem[0]))
+	if _, err := uffd.Register(addr, pageSize, UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	dup, err := uffd.Dup()
+	if err != nil {
+		t.Fatalf("Dup failed: %v", err)
+	}
+	defer dup.Close()
+	if dup.Fd() == uffd.Fd() {
+		t.Fatalf("Dup returned the same descriptor %d", dup.Fd())
+	}
+	if fdFlags, _ := unix.FcntlInt(uintptr(dup.Fd()), unix.F_GETFD, 0); fdFlags&unix.FD_CLOEXEC == 0 {
+		t.Errorf("FD_CLOEXEC not set")
+	}
+	if err := uffd.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := uffd.Dup(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Dup after Close: %v, want %v", err, ErrClosed)
+	}
+
+	// The duplicate serves the range registered on the closed original.
+	done := make(chan byte, 1)
+	go func() {
+		done <- mem[0]
+	}()
+	msg, err := dup.ReadMsgTimeout(1000)
+	if err != nil {
+		t.Fatalf("ReadMsgTimeout on the duplicate failed: %v", err)
+	}
+	if msg.Event != UFFD_EVENT_PAGEFAULT {
+		t.Fatalf("read event %s, want a page fault", EventString(msg.Event))
+	}
+	if _, err := dup.Zeropage(addr, pageSize, 0); err != nil {
+		t.Fatalf("Zeropage on the duplicate failed: %v", err)
+	}
+	if b := <-done; b != 0 {
+		t.Fatalf("read %d from the zero page", b)
+	}
+}
+
+// copyPage maps and registers a page, returning a function that resolves
+// a missing fault on it with UFFDIO_COPY, as if one had just been read.
+func copyPage(tb testing.TB, uffd *Uffd) func() error {
+	pageSize := os.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		tb.Fatalf("mmap failed: %v", err)
+	}
+	tb.Cleanup(func() { unix.Munmap(mem) })
+	dst := uintptr(unsafe.Pointer(&mem[0]))
+	if _, err := uffd.Register(dst, pageSize, UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		tb.Fatalf("Register failed: %v", err)
+	}
+	src := make([]byte, pageSize)
+	src[0] = 1
+
+	return func() error {
+		if err := unix.Madvise(mem, unix.MADV_DONTNEED); err != nil {
+			return err
+		}
+		if _, err := uffd.Copy(dst, uintptr(unsafe.Pointer(&src[0])), pageSize, 0); err != nil {
+			return err
+		}
+		if mem[0] != 1 {
+			return errors.New("page not copied")
+		}
+		return nil
+	}
+}
+
+func TestRawSyscalls(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	uffd.SetRawSyscalls(true)
+
+	if err := copyPage(t, uffd)(); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if _, err := uffd.ReadMsgTimeout(0); !errors.Is(err, unix.EAGAIN) {
+		t.Fatalf("ReadMsgTimeout: %v, want EAGAIN", err)
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	for _, raw := range []bool{false, true} {
+		name := "syscall"
+		if raw {
+			name = "raw"
+		}
+		b.Run(name, func(b *testing.B) {
+			uffd, err := New(flags|unix.O_NONBLOCK, 0)
+			if err != nil {
+				b.Fatalf("New failed: %v", err)
+			}
+			defer uffd.Close()
+			uffd.SetRawSyscalls(raw)
+
+			fill := copyPage(b, uffd)
+			for b.Loop() {
+				if err := fill(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWakeRanges(t *testing.T) {
+	uffd, err := New(flags|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+
+	pageSize := uint64(os.Getpagesize())
+	mem, err := unix.Mmap(-1, 0, int(4*pageSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+	base := uint64(uintptr(unsafe.Pointer(&mem[0])))
+	if _, err := uffd.Register(uintptr(base), len(mem), UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	ranges := []UffdioRange{
+		{Start: base + 3*pageSize, Len: pageSize},
+		{Start: base, Len: 2 * pageSize},
+		{Start: base + pageSize, Len: pageSize},
+	}
+	if err := uffd.WakeRanges(ranges); err != nil {
+		t.Fatalf("WakeRanges failed: %v", err)
+	}
+	if ranges[0].Start != base || ranges[2].Start != base+3*pageSize {
+		t.Errorf("ranges not sorted: %+v", ranges)
+	}
+	if err := uffd.WakeRanges(nil); err != nil {
+		t.Errorf("WakeRanges(nil) failed: %v", err)
+	}
+}
+
+// pipeUffd returns a Uffd reading the messages written to the returned
+// pipe, as if sent by the kernel.
+func pipeUffd(t *testing.T) (*Uffd, int) {
+	t.Helper()
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		t.Fatalf("pipe failed: %v", err)
+	}
+	t.Cleanup(func() { unix.Close(p[1]) })
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		t.Fatalf("eventfd failed: %v", err)
+	}
+	file := os.NewFile(uintptr(p[0]), "pipe")
+	u := &Uffd{File: file, fd: file.Fd(), wake: wake, desc: &description{nonblock: true}}
+	t.Cleanup(func() { u.Close() })
+	return u, p[1]
+}
+
+// Messages a newer kernel may send, of unknown types or with fields set in
+// bytes unused today, are returned with the rest of their batch.
+func TestReadMsgsNewerKernel(t *testing.T) {
+	u, w := pipeUffd(t)
+
+	batch := make([]UffdMsg, 3)
+	batch[0].Event = UFFD_EVENT_PAGEFAULT
+	batch[0].GetPagefault().Address = 0x1000
+	batch[1].Event = 0x20
+	batch[2].Event = UFFD_EVENT_PAGEFAULT
+	batch[2].GetPagefault().Address = 0x2000
+	msgBytes(&batch[2])[MsgSize-1] = 1
+	if _, err := unix.Write(w, unsafe.Slice((*byte)(unsafe.Pointer(&batch[0])), len(batch)*MsgSize)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	msgs := make([]UffdMsg, 4)
+	n, err := u.ReadMsgsTimeout(msgs, 0)
+	if err != nil || n != 3 {
+		t.Fatalf("ReadMsgsTimeout = %d, %v, want 3 messages", n, err)
+	}
+	if msgs[0] != batch[0] || msgs[1] != batch[1] || msgs[2] != batch[2] {
+		t.Error("messages differ from those written")
+	}
+}
+
+// Serve returns the errors of the handler wrapping ErrClosed, such as from
+// another userfaultfd, while its own is open.
+func TestServeHandlerClosed(t *testing.T) {
+	u, w := pipeUffd(t)
+	var msg UffdMsg
+	msg.Event = UFFD_EVENT_PAGEFAULT
+	if _, err := unix.Write(w, msgBytes(&msg)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	err := Serve(u, HandlerFunc(func(*Uffd, *UffdMsg) error {
+		return fmt.Errorf("mirror: %w", ErrClosed)
+	}))
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Serve = %v, want the error of the handler", err)
+	}
+}
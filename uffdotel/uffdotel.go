@@ -0,0 +1,65 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+// Package uffdotel traces the faults resolved by userfaultfd regions with
+// OpenTelemetry, keeping it out of the dependencies of the userfaultfd
+// package.
+package uffdotel
+
+import (
+	"context"
+	"log/slog"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer returns a userfaultfd.Tracer starting its spans with t, for
+// userfaultfd.WithTracer.
+func Tracer(t trace.Tracer) userfaultfd.Tracer {
+	return tracer{t}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t tracer) StartSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, userfaultfd.Span) {
+	var opts []trace.SpanStartOption
+	if len(attrs) > 0 {
+		kvs := make([]attribute.KeyValue, len(attrs))
+		for i, a := range attrs {
+			kvs[i] = keyValue(a)
+		}
+		opts = append(opts, trace.WithAttributes(kvs...))
+	}
+	ctx, s := t.t.Start(ctx, name, opts...)
+	return ctx, span{s}
+}
+
+// keyValue converts an attribute of a span.
+func keyValue(a slog.Attr) attribute.KeyValue {
+	switch v := a.Value.Resolve(); v.Kind() {
+	case slog.KindBool:
+		return attribute.Bool(a.Key, v.Bool())
+	case slog.KindInt64:
+		return attribute.Int64(a.Key, v.Int64())
+	case slog.KindFloat64:
+		return attribute.Float64(a.Key, v.Float64())
+	default:
+		return attribute.String(a.Key, v.String())
+	}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) End(err error) {
+	if err != nil {
+		s.s.RecordError(err)
+		s.s.SetStatus(codes.Error, err.Error())
+	}
+	s.s.End()
+}
@@ -0,0 +1,56 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package uffdotel
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingTracer records the attributes of the span started and the
+// status it ends with.
+type recordingTracer struct {
+	noop.Tracer
+	name   string
+	attrs  []attribute.KeyValue
+	status codes.Code
+	ended  bool
+}
+
+type recordingSpan struct {
+	noop.Span
+	t *recordingTracer
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	t.name = name
+	t.attrs = cfg.Attributes()
+	return ctx, recordingSpan{t: t}
+}
+
+func (s recordingSpan) SetStatus(code codes.Code, _ string) { s.t.status = code }
+func (s recordingSpan) End(...trace.SpanEndOption)          { s.t.ended = true }
+
+func TestTracer(t *testing.T) {
+	rt := &recordingTracer{}
+	_, span := Tracer(rt).StartSpan(context.Background(), "uffd.fault",
+		slog.String("uffd.region", "0x1000"), slog.Int64("uffd.offset", 4096))
+	span.End(errors.New("failed"))
+
+	want := []attribute.KeyValue{attribute.String("uffd.region", "0x1000"), attribute.Int64("uffd.offset", 4096)}
+	if rt.name != "uffd.fault" || !slices.Equal(rt.attrs, want) {
+		t.Errorf("started span %q with %v, want uffd.fault with %v", rt.name, rt.attrs, want)
+	}
+	if !rt.ended || rt.status != codes.Error {
+		t.Errorf("span ended %v with status %v, want %v", rt.ended, rt.status, codes.Error)
+	}
+}
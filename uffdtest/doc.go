@@ -0,0 +1,6 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+// Package uffdtest provides utilities for testing code that deals with
+// userfaultfd-backed memory and memory errors, and for generating fault
+// workloads against handlers.
+package uffdtest
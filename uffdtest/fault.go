@@ -0,0 +1,38 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package uffdtest
+
+import (
+	"testing"
+	"unsafe"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+)
+
+// CatchFault runs fn and reports the address of the memory fault it
+// caused, if any, see userfaultfd.CatchFault.
+func CatchFault(fn func()) (addr uintptr, faulted bool) {
+	return userfaultfd.CatchFault(fn)
+}
+
+// ExpectFault fails the test unless fn faults on an address within want.
+func ExpectFault(t testing.TB, want []byte, fn func()) {
+	t.Helper()
+	addr, faulted := CatchFault(fn)
+	if !faulted {
+		t.Errorf("expected memory fault")
+		return
+	}
+	start := uintptr(unsafe.Pointer(&want[0]))
+	if addr < start || addr >= start+uintptr(len(want)) {
+		t.Errorf("fault at %#x, want within [%#x, %#x)", addr, start, start+uintptr(len(want)))
+	}
+}
+
+// ExpectNoFault fails the test if fn causes a memory fault.
+func ExpectNoFault(t testing.TB, fn func()) {
+	t.Helper()
+	if addr, faulted := CatchFault(fn); faulted {
+		t.Errorf("unexpected memory fault at %#x", addr)
+	}
+}
@@ -0,0 +1,134 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package uffdtest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"unsafe"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+	"golang.org/x/sys/unix"
+)
+
+// Injector injects memory errors into a region with UFFDIO_POISON.
+// Accessing a poisoned page raises SIGBUS as a hardware memory error would.
+type Injector struct {
+	uffd     *userfaultfd.Uffd
+	mem      []byte
+	pageSize int
+
+	mu       sync.Mutex
+	poisoned map[int][]byte // saved contents by page index
+}
+
+// NewInjector populates mem, a private anonymous mapping, and registers it
+// on a new userfaultfd. Faults on pages that are neither populated nor
+// poisoned raise SIGBUS rather than blocking, since nothing serves them.
+func NewInjector(mem []byte) (*Injector, error) {
+	if err := unix.Madvise(mem, unix.MADV_POPULATE_WRITE); err != nil {
+		return nil, fmt.Errorf("populate: %w", err)
+	}
+
+	if !userfaultfd.HaveIoctlPoison {
+		return nil, userfaultfd.ErrMissingIoctl
+	}
+
+	flags := unix.O_CLOEXEC
+	if userfaultfd.HaveUserModeOnly {
+		flags |= userfaultfd.UFFD_USER_MODE_ONLY
+	}
+	u, err := userfaultfd.New(flags, userfaultfd.UFFD_FEATURE_SIGBUS)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := u.Register(uintptr(unsafe.Pointer(&mem[0])), len(mem), userfaultfd.UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		u.Close()
+		return nil, err
+	}
+	return &Injector{
+		uffd:     u,
+		mem:      mem,
+		pageSize: os.Getpagesize(),
+		poisoned: make(map[int][]byte),
+	}, nil
+}
+
+// Poison saves the contents of the given page and poisons it.
+func (i *Injector) Poison(page int) error {
+	p, err := i.page(page)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.poisoned[page]; ok {
+		return nil
+	}
+	saved := append([]byte(nil), p...)
+	if err := unix.Madvise(p, unix.MADV_DONTNEED); err != nil {
+		return err
+	}
+	if _, err := i.uffd.Poison(uintptr(unsafe.Pointer(&p[0])), len(p), 0); err != nil {
+		return err
+	}
+	i.poisoned[page] = saved
+	return nil
+}
+
+// Restore replaces the poisoned page with its contents before Poison.
+func (i *Injector) Restore(page int) error {
+	p, err := i.page(page)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	saved, ok := i.poisoned[page]
+	if !ok {
+		return errors.New("page not poisoned")
+	}
+	if err := unix.Madvise(p, unix.MADV_DONTNEED); err != nil {
+		return err
+	}
+	if _, err := i.uffd.Copy(uintptr(unsafe.Pointer(&p[0])), uintptr(unsafe.Pointer(&saved[0])), len(p), 0); err != nil {
+		return err
+	}
+	delete(i.poisoned, page)
+	return nil
+}
+
+// Close restores all poisoned pages and unregisters the region.
+func (i *Injector) Close() error {
+	i.mu.Lock()
+	pages := make([]int, 0, len(i.poisoned))
+	for page := range i.poisoned {
+		pages = append(pages, page)
+	}
+	i.mu.Unlock()
+
+	var errs []error
+	for _, page := range pages {
+		errs = append(errs, i.Restore(page))
+	}
+	errs = append(errs,
+		i.uffd.Unregister(uintptr(unsafe.Pointer(&i.mem[0])), len(i.mem)),
+		i.uffd.Close(),
+	)
+	return errors.Join(errs...)
+}
+
+func (i *Injector) page(page int) ([]byte, error) {
+	if page < 0 || (page+1)*i.pageSize > len(i.mem) {
+		return nil, fmt.Errorf("page %d out of range", page)
+	}
+	return i.mem[page*i.pageSize : (page+1)*i.pageSize], nil
+}
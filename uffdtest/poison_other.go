@@ -0,0 +1,16 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package uffdtest
+
+import userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+
+// Injector injects memory errors into a region.
+type Injector struct{}
+
+func NewInjector(mem []byte) (*Injector, error) { return nil, userfaultfd.ErrNotSupported }
+
+func (i *Injector) Poison(page int) error  { return userfaultfd.ErrNotSupported }
+func (i *Injector) Restore(page int) error { return userfaultfd.ErrNotSupported }
+func (i *Injector) Close() error           { return userfaultfd.ErrNotSupported }
@@ -0,0 +1,67 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package uffdtest
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestInjector(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, 3*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+	for i := range mem {
+		mem[i] = byte(i/pageSize + 1)
+	}
+
+	inj, err := NewInjector(mem)
+	if err != nil {
+		t.Skipf("NewInjector failed: %v", err)
+	}
+	defer func() {
+		if err := inj.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	}()
+
+	if err := inj.Poison(1); err != nil {
+		t.Fatalf("Poison failed: %v", err)
+	}
+	page := mem[pageSize : 2*pageSize]
+	ExpectFault(t, page, func() { mem[pageSize+10]++ })
+	ExpectNoFault(t, func() { mem[0]++; mem[2*pageSize]++ })
+
+	if err := inj.Restore(1); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	ExpectNoFault(t, func() {
+		for i, b := range page {
+			if b != 2 {
+				t.Fatalf("restored page differs at %d: %#x", i, b)
+			}
+		}
+	})
+
+	if err := inj.Restore(1); err == nil {
+		t.Fatalf("Restore of a healthy page succeeded")
+	}
+	if err := inj.Poison(3); err == nil {
+		t.Fatalf("Poison out of range succeeded")
+	}
+	if err := inj.Poison(2); err != nil {
+		t.Fatalf("Poison failed: %v", err)
+	}
+}
+
+func TestCatchFault(t *testing.T) {
+	if _, faulted := CatchFault(func() {}); faulted {
+		t.Fatalf("CatchFault reported a fault")
+	}
+}
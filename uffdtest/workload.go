@@ -0,0 +1,110 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package uffdtest
+
+import (
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+)
+
+// Pattern is the order in which a Workload touches pages.
+type Pattern int
+
+const (
+	// Sequential touches the pages in order, each worker starting where
+	// the previous one would end.
+	Sequential Pattern = iota
+	// Random touches pages chosen at random, possibly more than once.
+	Random
+)
+
+// sink keeps reads from being optimized away.
+var sink byte
+
+// Workload touches the pages of a mapping from several goroutines, to
+// exercise the handler serving its faults.
+type Workload struct {
+	Pattern Pattern
+	Workers int     // Goroutines touching pages, 1 if 0
+	Touches int     // Pages touched by each worker, all pages split among workers if 0
+	Writes  float64 // Fraction of touches that write, from 0 to 1
+	Seed    uint64  // Seed of the random choices
+}
+
+// WorkloadResult holds what a Workload did.
+type WorkloadResult struct {
+	Touches  int                   // Pages touched
+	Writes   int                   // Pages written
+	Duration time.Duration         // Time for all workers to finish
+	Latency  userfaultfd.Histogram // Time of each touch, including its fault if any
+}
+
+// Run touches the pages of mem as described by w, writing the index of the
+// page to its first byte on writes. The faults must be served by other
+// goroutines, so GOMAXPROCS is raised for the duration of Run to leave room
+// for them, see userfaultfd.Serve.
+func (w Workload) Run(mem []byte) WorkloadResult {
+	pageSize := os.Getpagesize()
+	pages := len(mem) / pageSize
+	workers := max(w.Workers, 1)
+	if pages == 0 {
+		return WorkloadResult{}
+	}
+
+	if prev := runtime.GOMAXPROCS(0); prev < workers+2 {
+		runtime.GOMAXPROCS(workers + 2)
+		defer runtime.GOMAXPROCS(prev)
+	}
+
+	var (
+		mu  sync.Mutex
+		res WorkloadResult
+		wg  sync.WaitGroup
+	)
+	start := time.Now()
+	for i := range workers {
+		wg.Go(func() {
+			rnd := rand.New(rand.NewPCG(w.Seed, uint64(i)))
+			touches := w.Touches
+			first := 0
+			if touches == 0 {
+				first = pages * i / workers
+				touches = pages*(i+1)/workers - first
+			}
+
+			var hist userfaultfd.Histogram
+			var writes int
+			var sum byte
+			for n := range touches {
+				page := (first + n) % pages
+				if w.Pattern == Random {
+					page = rnd.IntN(pages)
+				}
+				off := page * pageSize
+				t := time.Now()
+				if w.Writes > 0 && rnd.Float64() < w.Writes {
+					mem[off] = byte(page)
+					writes++
+				} else {
+					sum ^= mem[off]
+				}
+				hist.Record(time.Since(t))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			sink ^= sum
+			res.Touches += touches
+			res.Writes += writes
+			res.Latency.Merge(&hist)
+		})
+	}
+	wg.Wait()
+	res.Duration = time.Since(start)
+	return res
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package uffdtest
+
+import (
+	"os"
+	"testing"
+
+	userfaultfd "github.com/ricardobranco777/go-userfaultfd"
+	"golang.org/x/sys/unix"
+)
+
+func TestWorkload(t *testing.T) {
+	pageSize := os.Getpagesize()
+	flags := unix.O_CLOEXEC | unix.O_NONBLOCK
+	if userfaultfd.HaveUserModeOnly {
+		flags |= userfaultfd.UFFD_USER_MODE_ONLY
+	}
+
+	tests := []struct {
+		name    string
+		w       Workload
+		touches int
+	}{
+		{"sequential", Workload{Pattern: Sequential}, 16},
+		{"concurrent", Workload{Pattern: Sequential, Workers: 3}, 16},
+		{"random", Workload{Pattern: Random, Workers: 2, Touches: 20, Seed: 1}, 40},
+		{"write-heavy", Workload{Pattern: Random, Workers: 2, Touches: 20, Writes: 1}, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uffd, err := userfaultfd.New(flags, 0)
+			if err != nil {
+				t.Skip(err)
+			}
+			defer uffd.Close()
+			r, err := uffd.MapAndRegister(int64(16*pageSize), userfaultfd.UFFDIO_REGISTER_MODE_MISSING)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			done := make(chan error, 1)
+			go func() {
+				done <- r.Serve()
+			}()
+
+			res := tt.w.Run(r.Bytes())
+			if res.Touches != tt.touches || res.Latency.Count() != uint64(tt.touches) {
+				t.Errorf("%d touches, %d latencies, want %d", res.Touches, res.Latency.Count(), tt.touches)
+			}
+			if tt.w.Writes == 1 && res.Writes != res.Touches || tt.w.Writes == 0 && res.Writes != 0 {
+				t.Errorf("%d writes in %d touches", res.Writes, res.Touches)
+			}
+
+			uffd.Close()
+			if err := <-done; err != nil {
+				t.Errorf("Serve failed: %v", err)
+			}
+			if st := r.Stats(); st.Missing == 0 || tt.w.Pattern == Sequential && st.Missing != 16 {
+				t.Errorf("%d missing faults", st.Missing)
+			}
+		})
+	}
+}
@@ -1,3 +1,5 @@
+//go:build linux
+
 /* SPDX-License-Identifier: BSD-2-Clause */
 
 // Package userfaultfd provides a thin wrapper around Linux's userfaultfd(2) API.
@@ -5,6 +7,7 @@
 package userfaultfd
 
 import (
+	"errors"
 	"os"
 	"unsafe"
 
@@ -12,13 +15,49 @@ import (
 )
 
 func ioctl(fd uintptr, op uintptr, arg unsafe.Pointer) error {
-	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, op, uintptr(arg))
+	return ioctlRaw(false, fd, op, arg)
+}
+
+// ioctlRaw is ioctl, optionally without telling the scheduler, which saves
+// its bookkeeping but keeps the P for the duration of the call. Only for
+// ioctls that do not block.
+func ioctlRaw(raw bool, fd uintptr, op uintptr, arg unsafe.Pointer) error {
+	var errno unix.Errno
+	if raw {
+		_, _, errno = unix.RawSyscall(unix.SYS_IOCTL, fd, op, uintptr(arg))
+	} else {
+		_, _, errno = unix.Syscall(unix.SYS_IOCTL, fd, op, uintptr(arg))
+	}
 	if errno != 0 {
-		return os.NewSyscallError("ioctl", errno)
+		return ioctlErrno(errno)
 	}
 	return nil
 }
 
+// ioctlErrno returns the error of an ioctl failing with errno, matching
+// the sentinel error of its failure mode if any.
+func ioctlErrno(errno unix.Errno) error {
+	err := os.NewSyscallError("ioctl", errno)
+	switch errno {
+	case unix.EEXIST:
+		return &ioctlError{err: err, sentinel: ErrAlreadyMapped}
+	case unix.ENOENT:
+		return &ioctlError{err: err, sentinel: ErrNotRegistered}
+	case unix.ESRCH:
+		return &ioctlError{err: err, sentinel: ErrRangeGone}
+	}
+	return err
+}
+
+// alignError marks an EINVAL error as ErrBadAlignment when the range
+// starting at start, or its length, is not page aligned.
+func alignError(err error, start uintptr, length int) error {
+	if (start|uintptr(length))&uintptr(os.Getpagesize()-1) != 0 && errors.Is(err, unix.EINVAL) {
+		return &ioctlError{err: err, sentinel: ErrBadAlignment}
+	}
+	return err
+}
+
 // Open creates a new userfaultfd instance using the best available method.
 // It prefers the userfaultfd(2) syscall but falls back to /dev/userfaultfd
 // if the syscall is unavailable or returns ENOSYS/EPERM.
@@ -65,7 +104,7 @@ func Continue(fd uintptr, start uintptr, length int, mode int) error {
 	}
 	c := &UffdioContinue{Range: UffdioRange{Start: uint64(start), Len: uint64(length)}, Mode: uint64(mode)}
 	if err := ioctl(fd, UFFDIO_CONTINUE, unsafe.Pointer(c)); err != nil {
-		return err
+		return alignError(err, start, length)
 	}
 	return nil
 }
@@ -73,9 +112,13 @@ func Continue(fd uintptr, start uintptr, length int, mode int) error {
 // Copy resolves a page fault by copying content from src to dst.
 // Returns the number of bytes copied or an error.
 func Copy(fd uintptr, dst, src uintptr, length int, mode int) (int64, error) {
+	return copyWith(false, fd, dst, src, length, mode)
+}
+
+func copyWith(raw bool, fd uintptr, dst, src uintptr, length int, mode int) (int64, error) {
 	c := &UffdioCopy{Dst: uint64(dst), Src: uint64(src), Len: uint64(length), Mode: uint64(mode)}
-	if err := ioctl(fd, UFFDIO_COPY, unsafe.Pointer(c)); err != nil {
-		return 0, err
+	if err := ioctlRaw(raw, fd, UFFDIO_COPY, unsafe.Pointer(c)); err != nil {
+		return 0, alignError(err, dst|src, length)
 	}
 	return c.Copy, nil
 }
@@ -88,7 +131,7 @@ func Move(fd uintptr, dst, src uintptr, length int, mode int) (int64, error) {
 	}
 	m := &UffdioMove{Dst: uint64(dst), Src: uint64(src), Len: uint64(length), Mode: uint64(mode)}
 	if err := ioctl(fd, UFFDIO_MOVE, unsafe.Pointer(m)); err != nil {
-		return 0, err
+		return 0, alignError(err, dst|src, length)
 	}
 	return m.Move, nil
 }
@@ -102,7 +145,7 @@ func Poison(fd uintptr, start uintptr, length int, mode int) (int64, error) {
 	}
 	p := &UffdioPoison{Range: UffdioRange{Start: uint64(start), Len: uint64(length)}, Mode: uint64(mode)}
 	if err := ioctl(fd, UFFDIO_POISON, unsafe.Pointer(p)); err != nil {
-		return 0, err
+		return 0, alignError(err, start, length)
 	}
 	return p.Updated, nil
 }
@@ -112,7 +155,7 @@ func Poison(fd uintptr, start uintptr, length int, mode int) (int64, error) {
 func Register(fd uintptr, start uintptr, length int, mode int) (*UffdioRegister, error) {
 	reg := &UffdioRegister{Range: UffdioRange{Start: uint64(start), Len: uint64(length)}, Mode: uint64(mode)}
 	if err := ioctl(fd, UFFDIO_REGISTER, unsafe.Pointer(reg)); err != nil {
-		return nil, err
+		return nil, alignError(err, start, length)
 	}
 	return reg, nil
 }
@@ -121,16 +164,20 @@ func Register(fd uintptr, start uintptr, length int, mode int) (*UffdioRegister,
 func Unregister(fd uintptr, start uintptr, length int) error {
 	r := &UffdioRange{Start: uint64(start), Len: uint64(length)}
 	if err := ioctl(fd, UFFDIO_UNREGISTER, unsafe.Pointer(r)); err != nil {
-		return err
+		return alignError(err, start, length)
 	}
 	return nil
 }
 
 // Wake wakes up blocked page faults in the given range.
 func Wake(fd uintptr, start uintptr, length int) error {
+	return wakeWith(false, fd, start, length)
+}
+
+func wakeWith(raw bool, fd uintptr, start uintptr, length int) error {
 	r := &UffdioRange{Start: uint64(start), Len: uint64(length)}
-	if err := ioctl(fd, UFFDIO_WAKE, unsafe.Pointer(r)); err != nil {
-		return err
+	if err := ioctlRaw(raw, fd, UFFDIO_WAKE, unsafe.Pointer(r)); err != nil {
+		return alignError(err, start, length)
 	}
 	return nil
 }
@@ -142,7 +189,7 @@ func WriteProtect(fd uintptr, start uintptr, length int, mode int) error {
 	}
 	wp := &UffdioWriteprotect{Range: UffdioRange{Start: uint64(start), Len: uint64(length)}, Mode: uint64(mode)}
 	if err := ioctl(fd, UFFDIO_WRITEPROTECT, unsafe.Pointer(wp)); err != nil {
-		return err
+		return alignError(err, start, length)
 	}
 	return nil
 }
@@ -150,9 +197,13 @@ func WriteProtect(fd uintptr, start uintptr, length int, mode int) error {
 // Zeropage resolves a page fault by zero-filling the memory range.
 // Returns the length zeroed or an error.
 func Zeropage(fd uintptr, start uintptr, length int, mode int) (int64, error) {
+	return zeropageWith(false, fd, start, length, mode)
+}
+
+func zeropageWith(raw bool, fd uintptr, start uintptr, length int, mode int) (int64, error) {
 	z := &UffdioZeropage{Range: UffdioRange{Start: uint64(start), Len: uint64(length)}, Mode: uint64(mode)}
-	if err := ioctl(fd, UFFDIO_ZEROPAGE, unsafe.Pointer(z)); err != nil {
-		return 0, err
+	if err := ioctlRaw(raw, fd, UFFDIO_ZEROPAGE, unsafe.Pointer(z)); err != nil {
+		return 0, alignError(err, start, length)
 	}
 	return z.Zeropage, nil
 }
@@ -0,0 +1,533 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"context"
+	"io"
+	"iter"
+	"net"
+	"os"
+	"time"
+)
+
+// Stubs of the API for platforms without userfaultfd. Constructors and
+// operations return ErrNotSupported. FakeUffd works everywhere.
+
+func Open(flags int) (*os.File, error) {
+	return nil, ErrNotSupported
+}
+
+func ApiHandshake(fd uintptr, features uint64) (*UffdioApi, error) {
+	return nil, ErrNotSupported
+}
+
+func Continue(fd uintptr, start uintptr, length int, mode int) error {
+	return ErrNotSupported
+}
+
+func Copy(fd uintptr, dst, src uintptr, length int, mode int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func Move(fd uintptr, dst, src uintptr, length int, mode int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func Poison(fd uintptr, start uintptr, length int, mode int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func Register(fd uintptr, start uintptr, length int, mode int) (*UffdioRegister, error) {
+	return nil, ErrNotSupported
+}
+
+func Unregister(fd uintptr, start uintptr, length int) error {
+	return ErrNotSupported
+}
+
+func Wake(fd uintptr, start uintptr, length int) error {
+	return ErrNotSupported
+}
+
+func WriteProtect(fd uintptr, start uintptr, length int, mode int) error {
+	return ErrNotSupported
+}
+
+func Zeropage(fd uintptr, start uintptr, length int, mode int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func ProbeKernel() (KernelCaps, error) { return KernelCaps{}, ErrNotSupported }
+func LockHandlerMemory() error         { return ErrNotSupported }
+func UnlockHandlerMemory() error       { return ErrNotSupported }
+
+// Uffd wraps a userfaultfd file descriptor.
+type Uffd struct {
+	File *os.File
+}
+
+func New(flags int, features uint64) (*Uffd, error) {
+	return nil, ErrNotSupported
+}
+
+func ReadFdInfo(pid, fd int) (*FdInfo, error) {
+	return nil, ErrNotSupported
+}
+
+func NewBestEffort(flags int, wanted uint64) (*Uffd, uint64, error) {
+	return nil, 0, ErrNotSupported
+}
+
+func (u *Uffd) Close() error                                       { return ErrNotSupported }
+func (u *Uffd) FdInfo() (*FdInfo, error)                           { return nil, ErrNotSupported }
+func (u *Uffd) CheckFdInfo() error                                 { return ErrNotSupported }
+func (u *Uffd) Dup() (*Uffd, error)                                { return nil, ErrNotSupported }
+func (u *Uffd) SetNonblock(nonblock bool) error                    { return ErrNotSupported }
+func (u *Uffd) Nonblock() bool                                     { return false }
+func (u *Uffd) SetDeadline(t time.Time) error                      { return ErrNotSupported }
+func (u *Uffd) SetReadDeadline(t time.Time) error                  { return ErrNotSupported }
+func (u *Uffd) SetRawSyscalls(enable bool)                         {}
+func (u *Uffd) Fd() int                                            { return -1 }
+func (u *Uffd) Features() uint64                                   { return 0 }
+func (u *Uffd) Ioctls() uint64                                     { return 0 }
+func (u *Uffd) String() string                                     { return "uffd(unsupported)" }
+func (u *Uffd) HasIoctl(ioctl int) bool                            { return false }
+func (u *Uffd) RequireIoctls(ioctls ...int) error                  { return ErrNotSupported }
+func (u *Uffd) Continue(start uintptr, length int, mode int) error { return ErrNotSupported }
+func (u *Uffd) Unregister(start uintptr, length int) error         { return ErrNotSupported }
+func (u *Uffd) Wake(start uintptr, length int) error               { return ErrNotSupported }
+func (u *Uffd) WakeRanges(ranges []UffdioRange) error              { return ErrNotSupported }
+func (u *Uffd) ReadMsgTimeout(timeout int) (*UffdMsg, error)       { return nil, ErrNotSupported }
+func (u *Uffd) ReadMsg() (*UffdMsg, error)                         { return nil, ErrNotSupported }
+
+func (u *Uffd) ReadMsgsTimeout(msgs []UffdMsg, timeout int) (int, error) {
+	return 0, ErrNotSupported
+}
+func (u *Uffd) EventStats() EventStats { return EventStats{} }
+
+func (u *Uffd) Copy(dst, src uintptr, length int, mode int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (u *Uffd) Move(dst, src uintptr, length int, mode int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (u *Uffd) Poison(start uintptr, length int, mode int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (u *Uffd) Register(start uintptr, length int, mode int) (*UffdioRegister, error) {
+	return nil, ErrNotSupported
+}
+
+func (u *Uffd) WriteProtect(start uintptr, length int, mode int) error {
+	return ErrNotSupported
+}
+
+func (u *Uffd) Zeropage(start uintptr, length int, mode int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func Serve(u *Uffd, h Handler) error {
+	return ErrNotSupported
+}
+
+// Region is a memory range whose faults are resolved by the package.
+type Region struct{}
+
+// RegionOption configures a Region.
+type RegionOption func(*Region)
+
+func WithProvider(p PageProvider) RegionOption                        { return func(*Region) {} }
+func WithMaxResidentBytes(n int64) RegionOption                       { return func(*Region) {} }
+func WithEvictionPolicy(p EvictionPolicy) RegionOption                { return func(*Region) {} }
+func WithEvictionStore(s EvictionStore) RegionOption                  { return func(*Region) {} }
+func WithTracer(t Tracer) RegionOption                                { return func(*Region) {} }
+func WithHeatmap() RegionOption                                       { return func(*Region) {} }
+func WithDeferredWake() RegionOption                                  { return func(*Region) {} }
+func WithNUMAPlacement() RegionOption                                 { return func(*Region) {} }
+func WithMergeable() RegionOption                                     { return func(*Region) {} }
+func WithRateLimiter(l *RateLimiter, demand bool) RegionOption        { return func(*Region) {} }
+func WithWriteBack(w PageWriter, policy WriteBackPolicy) RegionOption { return func(*Region) {} }
+func WithPageHooks(h PageHooks) RegionOption                          { return func(*Region) {} }
+func WithRegionPolicy(p RegionPolicy) RegionOption                    { return func(*Region) {} }
+func WithSelfFaultDetection() RegionOption                            { return func(*Region) {} }
+func WithMoveStaging(slots int) RegionOption                          { return func(*Region) {} }
+func WithPageHashes() RegionOption                                    { return func(*Region) {} }
+
+func (m Mapping) Kind() MappingKind { return MappingSpecial }
+
+func ReadMaps() ([]Mapping, error) { return nil, ErrNotSupported }
+
+func ValidateRange(start uintptr, length int, mode int) error { return ErrNotSupported }
+
+func CreateHugetlbFile(dir string, size int64, pagesize int) (*os.File, error) {
+	return nil, ErrNotSupported
+}
+
+func HugePageSizes() ([]uintptr, error) { return nil, ErrNotSupported }
+
+func ThreadNode(tid int) (int, error)           { return 0, ErrNotSupported }
+func MovePages(addrs []uintptr, node int) error { return ErrNotSupported }
+
+func (u *Uffd) RegisterRegion(mem []byte, mode int, opts ...RegionOption) (*Region, error) {
+	return nil, ErrNotSupported
+}
+
+func (u *Uffd) MapAndRegister(size int64, mode int, opts ...RegionOption) (*Region, error) {
+	return nil, ErrNotSupported
+}
+
+func (u *Uffd) MapFileMinor(f *os.File, size int64, opts ...RegionOption) (r *Region, populate []byte, err error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (r *Region) Close() error                                          { return ErrNotSupported }
+func (r *Region) Contains(addr uintptr) bool                            { return false }
+func (r *Region) Provider() PageProvider                                { return nil }
+func (r *Region) Bytes() []byte                                         { return nil }
+func (r *Region) Base() uintptr                                         { return 0 }
+func (r *Region) ReadAt(p []byte, off int64) (int, error)               { return 0, ErrNotSupported }
+func (r *Region) Reader() *io.SectionReader                             { return io.NewSectionReader(r, 0, 0) }
+func (r *Region) Len() int                                              { return 0 }
+func (r *Region) Serve() error                                          { return ErrNotSupported }
+func (r *Region) HandleEvent(u *Uffd, msg *UffdMsg) error               { return ErrNotSupported }
+func (r *Region) DontNeed(off, length int) error                        { return ErrNotSupported }
+func (r *Region) PageOut(off, length int) error                         { return ErrNotSupported }
+func (r *Region) Free(off, length int) error                            { return ErrNotSupported }
+func (r *Region) Collapse(off, length int) error                        { return ErrNotSupported }
+func (r *Region) WillNeed(off, length int) error                        { return ErrNotSupported }
+func (r *Region) EndBatch(u *Uffd) error                                { return ErrNotSupported }
+func (r *Region) Stats() Stats                                          { return Stats{} }
+func (r *Region) ResetStats()                                           {}
+func (r *Region) Heatmap(buckets int) Heatmap                           { return Heatmap{} }
+func (r *Region) Filled() Bitmap                                        { return Bitmap{} }
+func (r *Region) Residency() (Bitmap, error)                            { return Bitmap{}, ErrNotSupported }
+func (r *Region) ResidentBytes() (resident, missing int64, err error)   { return 0, 0, ErrNotSupported }
+func (r *Region) Prefetch(ctx context.Context, off, length int64) error { return ErrNotSupported }
+func (r *Region) Dirty() *PageSet                                       { return new(PageSet) }
+func (r *Region) SetPageMeta(off int, v any)                            {}
+func (r *Region) PageMeta(off int) any                                  { return nil }
+func (r *Region) Overlay() *PageSet                                     { return new(PageSet) }
+func (r *Region) Flush() error                                          { return ErrNotSupported }
+func (r *Region) FlushRange(off, length int64) error                    { return ErrNotSupported }
+func (r *Region) Clone(opts ...RegionOption) (*Region, error)           { return nil, ErrNotSupported }
+func (r *Region) PageHash(off int) (uint64, bool)                       { return 0, false }
+func (r *Region) PageHashes() iter.Seq2[int, uint64]                    { return func(func(int, uint64) bool) {} }
+func (r *Region) GuardRange(off, length int) error                      { return ErrNotSupported }
+func (r *Region) UnguardRange(off, length int) error                    { return ErrNotSupported }
+func (r *Region) Guarded(off int) bool                                  { return false }
+
+// BufferPool pins ranges of a served Region in memory.
+type BufferPool struct{}
+
+func NewBufferPool(r *Region) *BufferPool                 { return &BufferPool{} }
+func (p *BufferPool) Pin(off, length int) ([]byte, error) { return nil, ErrNotSupported }
+func (p *BufferPool) Unpin(off, length int) error         { return ErrNotSupported }
+func (p *BufferPool) Stats() BufferPoolStats              { return BufferPoolStats{} }
+
+// Extent is a range of bytes of a region.
+type Extent struct {
+	Off, Len int
+}
+
+// Quarantine poisons the extents freed by an allocator over a Region.
+type Quarantine struct{}
+
+func NewQuarantine(r *Region, maxBytes int64) (*Quarantine, error) { return nil, ErrNotSupported }
+func (q *Quarantine) Free(extents ...Extent) ([]Extent, error)     { return nil, ErrNotSupported }
+func (q *Quarantine) Recycle(n int) ([]Extent, error)              { return nil, ErrNotSupported }
+func (q *Quarantine) Len() int                                     { return 0 }
+func (q *Quarantine) Bytes() int64                                 { return 0 }
+
+// RemoteRange is a range of the address space of another process.
+type RemoteRange struct {
+	Addr uintptr
+	Len  int
+}
+
+// ProcessMemory reads the memory of another process.
+type ProcessMemory struct{}
+
+func NewProcessMemory(pid int, ranges []RemoteRange) (*ProcessMemory, error) {
+	return nil, ErrNotSupported
+}
+
+func (m *ProcessMemory) Size() int64                             { return 0 }
+func (m *ProcessMemory) ReadAt(p []byte, off int64) (int, error) { return 0, ErrNotSupported }
+
+// Evictor reclaims cold pages of a Region.
+type Evictor struct{}
+
+func NewEvictor(r *Region, policy EvictionPolicy) *Evictor { return &Evictor{} }
+
+func (e *Evictor) Touch(addr uintptr)                              {}
+func (e *Evictor) Forget(start uintptr, length int)                {}
+func (e *Evictor) Resident() int                                   { return 0 }
+func (e *Evictor) Evict(n int) (int, error)                        { return 0, ErrNotSupported }
+func (e *Evictor) Refresh(ws *WorkingSet) error                    { return ErrNotSupported }
+func (e *Evictor) EvictOnPressure(m *PressureMonitor, n int) error { return ErrNotSupported }
+
+// WorkingSet estimates which pages of a region are accessed over time.
+type WorkingSet struct{}
+
+func NewWorkingSet(r *Region) (*WorkingSet, error) { return nil, ErrNotSupported }
+
+func (w *WorkingSet) Close() error            { return ErrNotSupported }
+func (w *WorkingSet) Reset() error            { return ErrNotSupported }
+func (w *WorkingSet) Sample() (Bitmap, error) { return Bitmap{}, ErrNotSupported }
+func (w *WorkingSet) Size() (int64, error)    { return 0, ErrNotSupported }
+
+// PressureMonitor waits for memory stall events using a PSI trigger.
+type PressureMonitor struct{}
+
+func NewPressureMonitor(path string, threshold, window time.Duration) (*PressureMonitor, error) {
+	return nil, ErrNotSupported
+}
+
+func (m *PressureMonitor) Close() error                   { return ErrNotSupported }
+func (m *PressureMonitor) Wait(timeout int) (bool, error) { return false, ErrNotSupported }
+
+// SigbusDispatcher resolves faults on regions in SIGBUS mode.
+type SigbusDispatcher struct{}
+
+func NewSigbusDispatcher() *SigbusDispatcher { return &SigbusDispatcher{} }
+
+func (d *SigbusDispatcher) Add(r *Region)      {}
+func (d *SigbusDispatcher) Remove(r *Region)   {}
+func (d *SigbusDispatcher) Do(fn func()) error { return ErrNotSupported }
+
+// Watchpoint invokes a callback on writes to a memory range.
+type Watchpoint struct{}
+
+func Watch(mem []byte, fn func(WriteEvent)) (*Watchpoint, error) { return nil, ErrNotSupported }
+
+func (w *Watchpoint) Close() error { return ErrNotSupported }
+
+// EventLoop dispatches the events of many userfaultfds from one goroutine.
+type EventLoop struct {
+	OnError func(u *Uffd, err error)
+}
+
+func NewEventLoop() (*EventLoop, error) { return nil, ErrNotSupported }
+
+func (l *EventLoop) Add(u *Uffd, h Handler) error { return ErrNotSupported }
+func (l *EventLoop) Remove(u *Uffd) error         { return ErrNotSupported }
+func (l *EventLoop) Len() int                     { return 0 }
+func (l *EventLoop) Run() error                   { return ErrNotSupported }
+func (l *EventLoop) Close() error                 { return ErrNotSupported }
+
+// Server resolves the page faults of a userfaultfd with a pool of workers.
+type Server struct{}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+func WithWorkers(n int) ServerOption          { return func(*Server) {} }
+func WithQueueSize(n int) ServerOption        { return func(*Server) {} }
+func WithRawSyscalls() ServerOption           { return func(*Server) {} }
+func WithWorkerCPUs(cpus ...int) ServerOption { return func(*Server) {} }
+func WithWorkerNice(nice int) ServerOption    { return func(*Server) {} }
+func WithWatchdog(threshold time.Duration, fn func(StuckFault) StuckAction) ServerOption {
+	return func(*Server) {}
+}
+
+func NewServer(u *Uffd, h Handler, opts ...ServerOption) *Server { return &Server{} }
+
+func (s *Server) Serve() error            { return ErrNotSupported }
+func (s *Server) Deduplicated() uint64    { return 0 }
+func (s *Server) Pending() []PendingFault { return nil }
+func (s *Server) Pause()                  {}
+func (s *Server) Resume()                 {}
+func (s *Server) Paused() bool            { return false }
+
+// ShardFunc assigns a page to one of the workers of a Server.
+type ShardFunc func(page uintptr) int
+
+// Sharder is implemented by handlers that choose the worker for each page.
+type Sharder interface {
+	Shard(page uintptr) int
+}
+
+func HashShard(page uintptr) int            { return 0 }
+func RangeShard(size uintptr) ShardFunc     { return HashShard }
+func WithSharding(f ShardFunc) ServerOption { return func(*Server) {} }
+
+// ServeMux dispatches the events of a userfaultfd to the handler of each range.
+type ServeMux struct{}
+
+func NewServeMux() *ServeMux { return &ServeMux{} }
+
+func (m *ServeMux) Handle(start uintptr, length int, h Handler) error { return ErrNotSupported }
+func (m *ServeMux) HandleRegion(r *Region) error                      { return ErrNotSupported }
+func (m *ServeMux) Remove(start uintptr) error                        { return ErrNotSupported }
+func (m *ServeMux) Handler(addr uintptr) Handler                      { return nil }
+func (m *ServeMux) HandleEvent(u *Uffd, msg *UffdMsg) error           { return ErrNotSupported }
+func (m *ServeMux) EndBatch(u *Uffd) error                            { return ErrNotSupported }
+func (m *ServeMux) Pending() []PendingFault                           { return nil }
+func (m *ServeMux) Pause()                                            {}
+func (m *ServeMux) Resume()                                           {}
+func (m *ServeMux) Paused() bool                                      { return false }
+func (m *ServeMux) SetSharding(f ShardFunc)                           {}
+func (m *ServeMux) Shard(page uintptr) int                            { return 0 }
+func (m *ServeMux) SetQuota(start uintptr, q Quota) error             { return ErrNotSupported }
+func (m *ServeMux) SetTenant(start uintptr, tenant string) error      { return ErrNotSupported }
+func (m *ServeMux) SetTenantQuota(tenant string, q Quota)             {}
+
+func (m *ServeMux) HandleFault(u *Uffd, msg *UffdMsg, done func(*UffdMsg)) (bool, error) {
+	return false, ErrNotSupported
+}
+
+// MappedSource is a read-only mapping of a file serving as a provider.
+type MappedSource struct{}
+
+func MapSource(f *os.File) (*MappedSource, error)               { return nil, ErrNotSupported }
+func (s *MappedSource) ReadAt(p []byte, off int64) (int, error) { return 0, ErrNotSupported }
+func (s *MappedSource) MappedPage(off int64, n int) []byte      { return nil }
+func (s *MappedSource) Len() int                                { return 0 }
+func (s *MappedSource) Close() error                            { return ErrNotSupported }
+
+// BlockDevice is a PageProvider reading from a block device with O_DIRECT.
+type BlockDevice struct{}
+
+func OpenBlockDevice(path string) (*BlockDevice, error)        { return nil, ErrNotSupported }
+func (d *BlockDevice) Size() int64                             { return 0 }
+func (d *BlockDevice) BlockSize() int                          { return 0 }
+func (d *BlockDevice) ReadAt(p []byte, off int64) (int, error) { return 0, ErrNotSupported }
+func (d *BlockDevice) Close() error                            { return ErrNotSupported }
+
+// LazyFile is a fixed-size file over demand-paged memory.
+type LazyFile struct{}
+
+func OpenLazyFile(name string, size int64, p PageProvider, w PageWriter, policy WriteBackPolicy, opts ...RegionOption) (*LazyFile, error) {
+	return nil, ErrNotSupported
+}
+
+func (f *LazyFile) Name() string                             { return "" }
+func (f *LazyFile) Region() *Region                          { return nil }
+func (f *LazyFile) ReadAt(p []byte, off int64) (int, error)  { return 0, ErrNotSupported }
+func (f *LazyFile) WriteAt(p []byte, off int64) (int, error) { return 0, ErrNotSupported }
+func (f *LazyFile) Sync() error                              { return ErrNotSupported }
+func (f *LazyFile) Stat() (os.FileInfo, error)               { return nil, ErrNotSupported }
+func (f *LazyFile) Close() error                             { return ErrNotSupported }
+
+// Segment is a range of a file laid out in a Composite.
+type Segment struct {
+	File   io.ReaderAt
+	Offset int64
+	Length int64
+}
+
+// Composite is a contiguous mapping made of segments of several files.
+type Composite struct{}
+
+func (u *Uffd) MapComposite(segs []Segment, opts ...RegionOption) (*Composite, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Composite) Bytes() []byte      { return nil }
+func (c *Composite) Slices() [][]byte   { return nil }
+func (c *Composite) Regions() []*Region { return nil }
+func (c *Composite) Mux() *ServeMux     { return nil }
+func (c *Composite) Serve() error       { return ErrNotSupported }
+func (c *Composite) Close() error       { return ErrNotSupported }
+
+func StreamCore(pid int, f *os.File, filter func(Mapping) bool) (*CoreFile, error) {
+	return nil, ErrNotSupported
+}
+
+func SendUffd(c *net.UnixConn, u *Uffd) error            { return ErrNotSupported }
+func ReceiveUffd(c *net.UnixConn) (*os.File, int, error) { return nil, 0, ErrNotSupported }
+
+// MirroredMapping is a mapping of a process mirrored by a ProcessMirror.
+type MirroredMapping struct {
+	Mapping
+	Region *Region
+}
+
+// ProcessMirror exposes the memory of another process to a debugger.
+type ProcessMirror struct{}
+
+func (u *Uffd) MirrorProcess(pid int, attach bool, filter func(Mapping) bool, opts ...RegionOption) (*ProcessMirror, error) {
+	return nil, ErrNotSupported
+}
+
+func (m *ProcessMirror) Mappings() []MirroredMapping               { return nil }
+func (m *ProcessMirror) Mux() *ServeMux                            { return nil }
+func (m *ProcessMirror) Serve() error                              { return ErrNotSupported }
+func (m *ProcessMirror) Bytes(addr uintptr, n int) ([]byte, error) { return nil, ErrNotSupported }
+func (m *ProcessMirror) ReadAt(p []byte, off int64) (int, error)   { return 0, ErrNotSupported }
+func (m *ProcessMirror) WriteAt(p []byte, off int64) (int, error)  { return 0, ErrNotSupported }
+func (m *ProcessMirror) Invalidate() error                         { return ErrNotSupported }
+func (m *ProcessMirror) Close() error                              { return ErrNotSupported }
+
+// CoreMapping is the memory of an ELF core file mapped by MapCore.
+type CoreMapping struct{}
+
+func (u *Uffd) MapCore(c *CoreFile, opts ...RegionOption) (*CoreMapping, error) {
+	return nil, ErrNotSupported
+}
+
+func (m *CoreMapping) At(vaddr uint64, n int) []byte { return nil }
+func (m *CoreMapping) Regions() []*Region            { return nil }
+func (m *CoreMapping) Mux() *ServeMux                { return nil }
+func (m *CoreMapping) Serve() error                  { return ErrNotSupported }
+func (m *CoreMapping) Close() error                  { return ErrNotSupported }
+
+// allocStaging allocates a staging buffer on the Go heap.
+func allocStaging(size int, huge bool) *[]byte {
+	buf := make([]byte, size)
+	return &buf
+}
+
+// GuestRegionMapping is a range of guest memory handed off by a VMM.
+type GuestRegionMapping struct {
+	BaseHostVirtAddr uint64 `json:"base_host_virt_addr"`
+	Size             uint64 `json:"size"`
+	Offset           uint64 `json:"offset"`
+	PageSize         uint64 `json:"page_size"`
+	PageSizeKiB      uint64 `json:"page_size_kib,omitempty"`
+}
+
+func SendGuestMemory(c *net.UnixConn, u *Uffd, mappings []GuestRegionMapping) error {
+	return ErrNotSupported
+}
+
+// VMBackend supplies the guest memory of the VMs connecting to a VMHandler.
+type VMBackend interface {
+	Memory(vm *VM) (PageProvider, error)
+}
+
+// VMBackendFunc adapts an ordinary function to a VMBackend.
+type VMBackendFunc func(vm *VM) (PageProvider, error)
+
+func (f VMBackendFunc) Memory(vm *VM) (PageProvider, error) { return f(vm) }
+
+// VMHandler serves the guest memory of VMs.
+type VMHandler struct{}
+
+func StartHandler(socketPath string, backend VMBackend) (*VMHandler, error) {
+	return nil, ErrNotSupported
+}
+
+func (h *VMHandler) Addr() net.Addr { return nil }
+func (h *VMHandler) VMs() []*VM     { return nil }
+func (h *VMHandler) VM(pid int) *VM { return nil }
+func (h *VMHandler) Close() error   { return ErrNotSupported }
+
+// VM is a VM connected to a VMHandler.
+type VM struct {
+	Pid       int
+	Mappings  []GuestRegionMapping
+	Connected time.Time
+}
+
+func (vm *VM) Stats() Stats           { return Stats{} }
+func (vm *VM) EventStats() EventStats { return EventStats{} }
+func (vm *VM) Done() <-chan struct{}  { return nil }
+func (vm *VM) Err() error             { return nil }
+func (vm *VM) Close() error           { return ErrNotSupported }
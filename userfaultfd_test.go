@@ -1,9 +1,12 @@
+//go:build linux
+
 /* SPDX-License-Identifier: BSD-2-Clause */
 
 package userfaultfd
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"testing"
@@ -16,10 +19,23 @@ import (
 var flags = 0
 
 func TestMain(m *testing.M) {
+	// Without userfaultfd, only the tests of FakeUffd can run.
+	fakeOnly := func(reason string) {
+		println("Skipping tests needing userfaultfd: " + reason)
+		flag.Parse()
+		// Skip all the others rather than overriding -test.run, which
+		// still selects among the tests of FakeUffd.
+		skip := `^(Test([^F]|F[^a]|Fa[^k]|Fak[^e])|Example|Benchmark|Fuzz)`
+		if s := flag.Lookup("test.skip").Value.String(); s != "" {
+			skip += "|" + s
+		}
+		flag.Set("test.skip", skip)
+		os.Exit(m.Run())
+	}
+
 	if os.Geteuid() != 0 && !UnprivilegedUserfaultfd {
 		if !HaveUserModeOnly {
-			println("Skipping all tests: UFFD_USER_MODE_ONLY not supported on this kernel")
-			os.Exit(1)
+			fakeOnly("UFFD_USER_MODE_ONLY not supported on this kernel")
 		}
 		flags |= UFFD_USER_MODE_ONLY
 	}
@@ -28,16 +44,13 @@ func TestMain(m *testing.M) {
 	fd, _, errno := unix.Syscall(unix.SYS_USERFAULTFD, uintptr(flags), 0, 0)
 	switch errno {
 	case unix.ENOSYS:
-		println("Skipping all tests: userfaultfd syscall not available on this kernel")
-		os.Exit(1)
+		fakeOnly("userfaultfd syscall not available on this kernel")
 	case unix.EPERM:
-		println("Skipping all tests: vm.unprivileged_userfaultfd probably unset")
-		os.Exit(1)
+		fakeOnly("vm.unprivileged_userfaultfd probably unset")
 	case 0:
 		break
 	default:
-		fmt.Printf("Skipping all tests: error: %v\n", errno)
-		os.Exit(1)
+		fakeOnly(fmt.Sprintf("error: %v", errno))
 	}
 
 	_ = unix.Close(int(fd))
@@ -6,8 +6,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-
-	"golang.org/x/sys/unix"
 )
 
 // UnprivilegedUserfaultfdAllowed returns true if
@@ -24,13 +22,43 @@ func UnprivilegedUserfaultfdAllowed() bool {
 	}
 }
 
-// retryOnEINTR repeatedly calls fn until it returns nil or an error other than EINTR.
-func retryOnEINTR(fn func() error) error {
-	for {
-		err := fn()
-		if err == unix.EINTR {
-			continue
-		}
-		return err
-	}
+// Page sizes for RoundUp and RoundDown.
+const (
+	PageSize4K     = 4 << 10
+	HugePageSize2M = 2 << 20
+	HugePageSize1G = 1 << 30
+)
+
+// RoundDown rounds addr down to a multiple of size, which must be a power
+// of two.
+func RoundDown(addr, size uintptr) uintptr {
+	return addr &^ (size - 1)
+}
+
+// RoundUp rounds addr up to a multiple of size, which must be a power of
+// two.
+func RoundUp(addr, size uintptr) uintptr {
+	return RoundDown(addr+size-1, size)
+}
+
+// RoundDownPage rounds addr down to a boundary of the system page size.
+func RoundDownPage(addr uintptr) uintptr {
+	return RoundDown(addr, uintptr(os.Getpagesize()))
+}
+
+// RoundUpPage rounds addr up to a boundary of the system page size.
+func RoundUpPage(addr uintptr) uintptr {
+	return RoundUp(addr, uintptr(os.Getpagesize()))
+}
+
+// PageAlignDown rounds addr down to a page boundary. It is the same as
+// RoundDownPage.
+func PageAlignDown(addr uintptr) uintptr {
+	return RoundDownPage(addr)
+}
+
+// PageAlignUp rounds addr up to a page boundary. It is the same as
+// RoundUpPage.
+func PageAlignUp(addr uintptr) uintptr {
+	return RoundUpPage(addr)
 }
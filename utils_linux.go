@@ -0,0 +1,50 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// retryOnEINTR repeatedly calls fn until it returns nil or an error other than EINTR.
+func retryOnEINTR(fn func() error) error {
+	for {
+		err := fn()
+		if err == unix.EINTR {
+			continue
+		}
+		return err
+	}
+}
+
+// HugePageSizes returns the hugepage sizes supported by the kernel, in
+// increasing order, as listed in /sys/kernel/mm/hugepages.
+func HugePageSizes() ([]uintptr, error) {
+	entries, err := os.ReadDir("/sys/kernel/mm/hugepages")
+	if err != nil {
+		return nil, err
+	}
+	var sizes []uintptr
+	for _, e := range entries {
+		kb, ok := strings.CutPrefix(e.Name(), "hugepages-")
+		if !ok {
+			continue
+		}
+		kb, ok = strings.CutSuffix(kb, "kB")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(kb, 10, 64)
+		if err != nil {
+			continue
+		}
+		sizes = append(sizes, uintptr(n)<<10)
+	}
+	slices.Sort(sizes)
+	return sizes, nil
+}
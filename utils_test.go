@@ -0,0 +1,56 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestRoundUp(t *testing.T) {
+	tests := []struct {
+		addr, size, down, up uintptr
+	}{
+		{0, PageSize4K, 0, 0},
+		{1, PageSize4K, 0, PageSize4K},
+		{PageSize4K, PageSize4K, PageSize4K, PageSize4K},
+		{HugePageSize2M + 1, HugePageSize2M, HugePageSize2M, 2 * HugePageSize2M},
+		{HugePageSize1G - 1, HugePageSize1G, 0, HugePageSize1G},
+	}
+	for _, tt := range tests {
+		if got := RoundDown(tt.addr, tt.size); got != tt.down {
+			t.Errorf("RoundDown(%#x, %#x) = %#x, want %#x", tt.addr, tt.size, got, tt.down)
+		}
+		if got := RoundUp(tt.addr, tt.size); got != tt.up {
+			t.Errorf("RoundUp(%#x, %#x) = %#x, want %#x", tt.addr, tt.size, got, tt.up)
+		}
+	}
+
+	pageSize := uintptr(os.Getpagesize())
+	if got := RoundUpPage(pageSize + 1); got != 2*pageSize {
+		t.Errorf("RoundUpPage(%#x) = %#x", pageSize+1, got)
+	}
+	if got := RoundDownPage(pageSize + 1); got != pageSize {
+		t.Errorf("RoundDownPage(%#x) = %#x", pageSize+1, got)
+	}
+}
+
+func TestHugePageSizes(t *testing.T) {
+	sizes, err := HugePageSizes()
+	if os.IsNotExist(err) {
+		t.Skip("no hugepage support")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.IsSorted(sizes) {
+		t.Errorf("sizes not sorted: %v", sizes)
+	}
+	for _, size := range sizes {
+		if size&(size-1) != 0 || size <= uintptr(os.Getpagesize()) {
+			t.Errorf("invalid hugepage size %#x", size)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxHandoffSize bounds the message describing the guest memory handed off
+// by a VMM.
+const maxHandoffSize = 64 << 10
+
+// handoffTimeout bounds the wait for the handoff of a VMM once connected.
+const handoffTimeout = 10 * time.Second
+
+// acceptRetryDelay is how long to wait before accepting connections again
+// after a failure, such as running out of descriptors.
+const acceptRetryDelay = 100 * time.Millisecond
+
+// GuestRegionMapping is a range of guest memory registered with the
+// userfaultfd handed off by a VMM, as described by Firecracker.
+type GuestRegionMapping struct {
+	BaseHostVirtAddr uint64 `json:"base_host_virt_addr"` // in the VMM
+	Size             uint64 `json:"size"`
+	Offset           uint64 `json:"offset"` // in the guest memory
+	PageSize         uint64 `json:"page_size"`
+	PageSizeKiB      uint64 `json:"page_size_kib,omitempty"` // sent by older Firecracker versions instead
+}
+
+// pageSize returns the page size of the mapping.
+func (m GuestRegionMapping) pageSize() int {
+	if m.PageSize == 0 {
+		return int(m.PageSizeKiB << 10)
+	}
+	return int(m.PageSize)
+}
+
+// SendGuestMemory hands off the userfaultfd u, with which the guest memory
+// described by mappings is registered, over c to a handler such as one
+// started by StartHandler, as Firecracker does.
+func SendGuestMemory(c *net.UnixConn, u *Uffd, mappings []GuestRegionMapping) error {
+	b, err := json.Marshal(mappings)
+	if err != nil {
+		return err
+	}
+	_, _, err = c.WriteMsgUnix(b, unix.UnixRights(u.Fd()), nil)
+	return err
+}
+
+// VMBackend supplies the guest memory of the VMs connecting to a VMHandler.
+type VMBackend interface {
+	// Memory returns the guest memory of vm, read at the offsets of its
+	// mappings, or an error to refuse it. If the memory implements
+	// io.Closer, it is closed once the VM disconnects. It may be called
+	// concurrently for VMMs connecting at the same time.
+	Memory(vm *VM) (PageProvider, error)
+}
+
+// VMBackendFunc adapts an ordinary function to a VMBackend.
+type VMBackendFunc func(vm *VM) (PageProvider, error)
+
+// Memory calls f(vm).
+func (f VMBackendFunc) Memory(vm *VM) (PageProvider, error) {
+	return f(vm)
+}
+
+// VMHandler is a page fault handler process serving the guest memory of
+// VMs, to be plugged into VMM orchestration such as firecracker-go-sdk:
+// each VMM connects to its socket and hands off its userfaultfd with the
+// mappings of its guest memory, whose faults are then resolved from the
+// VMBackend until it disconnects. A VMM connecting again, as when restoring
+// a snapshot, replaces its previous VM. It is safe for concurrent use.
+type VMHandler struct {
+	ln      *net.UnixListener
+	backend VMBackend
+
+	mu      sync.Mutex
+	vms     map[int]*VM                // by pid
+	pending map[*net.UnixConn]struct{} // connections awaiting their handoff
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// StartHandler listens on the Unix socket socketPath, replacing a stale
+// socket left by a previous handler, and serves the VMs connecting to it
+// from backend until Close.
+func StartHandler(socketPath string, backend VMBackend) (*VMHandler, error) {
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	h := &VMHandler{ln: ln, backend: backend, vms: make(map[int]*VM), pending: make(map[*net.UnixConn]struct{})}
+	h.wg.Add(1)
+	go h.accept()
+	return h, nil
+}
+
+// Addr returns the address of the socket.
+func (h *VMHandler) Addr() net.Addr {
+	return h.ln.Addr()
+}
+
+// VMs returns the VMs connected, by pid.
+func (h *VMHandler) VMs() []*VM {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	vms := make([]*VM, 0, len(h.vms))
+	for _, vm := range h.vms {
+		vms = append(vms, vm)
+	}
+	slices.SortFunc(vms, func(a, b *VM) int { return a.Pid - b.Pid })
+	return vms
+}
+
+// VM returns the VM of the VMM pid, or nil if it is not connected.
+func (h *VMHandler) VM(pid int) *VM {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.vms[pid]
+}
+
+// Close stops listening, disconnects the VMs and waits for them.
+func (h *VMHandler) Close() error {
+	h.mu.Lock()
+	h.closed = true
+	vms := h.vms
+	h.vms = nil
+	for c := range h.pending {
+		c.Close()
+	}
+	h.mu.Unlock()
+	err := h.ln.Close()
+	for _, vm := range vms {
+		vm.Close()
+	}
+	h.wg.Wait()
+	return err
+}
+
+func (h *VMHandler) accept() {
+	defer h.wg.Done()
+	for {
+		c, err := h.ln.AcceptUnix()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Such as running out of descriptors: try again later.
+			time.Sleep(acceptRetryDelay)
+			continue
+		}
+		// A VMM slow to hand off must not hold up the others.
+		h.mu.Lock()
+		if h.closed {
+			h.mu.Unlock()
+			c.Close()
+			continue
+		}
+		h.pending[c] = struct{}{}
+		h.wg.Add(1)
+		h.mu.Unlock()
+		go h.handoff(c)
+	}
+}
+
+// handoff receives the handoff of a VMM over c and serves its VM.
+func (h *VMHandler) handoff(c *net.UnixConn) {
+	defer h.wg.Done()
+	vm, err := h.connect(c)
+	h.mu.Lock()
+	delete(h.pending, c)
+	if err != nil || h.closed {
+		h.mu.Unlock()
+		if vm != nil {
+			vm.Close()
+		}
+		c.Close()
+		return
+	}
+	prev := h.vms[vm.Pid]
+	h.vms[vm.Pid] = vm
+	h.wg.Add(1)
+	h.mu.Unlock()
+	if prev != nil {
+		prev.Close()
+	}
+	go h.serve(vm)
+}
+
+// connect receives the handoff of a VMM.
+func (h *VMHandler) connect(c *net.UnixConn) (*VM, error) {
+	buf := make([]byte, maxHandoffSize)
+	if err := c.SetReadDeadline(time.Now().Add(handoffTimeout)); err != nil {
+		return nil, err
+	}
+	f, n, pid, err := receiveUffd(c, buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	var mappings []GuestRegionMapping
+	if err := json.Unmarshal(buf[:n], &mappings); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("invalid guest memory mappings: %w", err)
+	}
+	u, err := wrapUffd(f)
+	if err != nil {
+		return nil, err
+	}
+	vm := &VM{Pid: pid, Mappings: mappings, Connected: time.Now(), uffd: u, conn: c, done: make(chan struct{})}
+	if err := vm.check(); err != nil {
+		u.Close()
+		return nil, err
+	}
+	if vm.memory, err = h.backend.Memory(vm); err != nil {
+		u.Close()
+		return nil, err
+	}
+	return vm, nil
+}
+
+// serve resolves the faults of vm until it disconnects.
+func (h *VMHandler) serve(vm *VM) {
+	defer h.wg.Done()
+	go func() {
+		// The VMM holds the connection until it exits.
+		io.Copy(io.Discard, vm.conn)
+		vm.Close()
+	}()
+	err := Serve(vm.uffd, HandlerFunc(vm.handleEvent))
+	vm.Close()
+	if c, ok := vm.memory.(io.Closer); ok {
+		c.Close()
+	}
+	vm.err = err
+	close(vm.done)
+
+	h.mu.Lock()
+	if h.vms[vm.Pid] == vm {
+		delete(h.vms, vm.Pid)
+	}
+	h.mu.Unlock()
+}
+
+// wrapUffd wraps a userfaultfd received from another process.
+func wrapUffd(f *os.File) (*Uffd, error) {
+	info, err := ReadFdInfo(0, int(f.Fd()))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		f.Close()
+		return nil, os.NewSyscallError("eventfd", err)
+	}
+	return &Uffd{
+		File:    f,
+		api:     &UffdioApi{Api: info.Api, Features: info.Features, Ioctls: info.Ioctls},
+		enabled: info.Features,
+		flags:   info.Flags,
+		fd:      f.Fd(),
+		wake:    wake,
+		desc:    &description{nonblock: info.Flags&unix.O_NONBLOCK != 0},
+	}, nil
+}
+
+// VM is a VM connected to a VMHandler.
+type VM struct {
+	Pid       int                  // of the VMM
+	Mappings  []GuestRegionMapping // of its guest memory, by address
+	Connected time.Time
+
+	uffd   *Uffd
+	conn   *net.UnixConn
+	memory PageProvider
+	stats  regionStats
+	buf    []byte    // of the largest page, used by the serving goroutine
+	zeroed []PageSet // pages removed by the VMM, as by a balloon, by mapping
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// check sorts the mappings and checks they do not overlap.
+func (vm *VM) check() error {
+	slices.SortFunc(vm.Mappings, func(a, b GuestRegionMapping) int {
+		return cmp.Compare(a.BaseHostVirtAddr, b.BaseHostVirtAddr)
+	})
+	size := 0
+	for i, m := range vm.Mappings {
+		ps := m.pageSize()
+		if ps <= 0 || ps&(ps-1) != 0 || m.Size == 0 || (m.BaseHostVirtAddr|m.Size)%uint64(ps) != 0 {
+			return fmt.Errorf("invalid guest memory mapping %#x+%d with page size %d", m.BaseHostVirtAddr, m.Size, ps)
+		}
+		if i > 0 && vm.Mappings[i-1].BaseHostVirtAddr+vm.Mappings[i-1].Size > m.BaseHostVirtAddr {
+			return fmt.Errorf("guest memory mapping %#x overlaps another", m.BaseHostVirtAddr)
+		}
+		size = max(size, ps)
+	}
+	if size == 0 {
+		return errors.New("no guest memory mappings")
+	}
+	vm.buf = make([]byte, size)
+	vm.zeroed = make([]PageSet, len(vm.Mappings))
+	return nil
+}
+
+// Stats returns a snapshot of the counters of the faults of the VM.
+func (vm *VM) Stats() Stats {
+	vm.stats.mu.Lock()
+	defer vm.stats.mu.Unlock()
+	return vm.stats.Stats
+}
+
+// EventStats returns the number of events of the VM by type.
+func (vm *VM) EventStats() EventStats {
+	return vm.uffd.EventStats()
+}
+
+// Done returns a channel closed once the VM is disconnected.
+func (vm *VM) Done() <-chan struct{} {
+	return vm.done
+}
+
+// Err returns the error that disconnected the VM, once Done is closed, or
+// nil if the VMM disconnected.
+func (vm *VM) Err() error {
+	return vm.err
+}
+
+// Close disconnects the VM. Its faults are no longer resolved.
+func (vm *VM) Close() error {
+	var err error
+	vm.closeOnce.Do(func() {
+		err = errors.Join(vm.uffd.Close(), vm.conn.Close())
+	})
+	return err
+}
+
+// mapping returns the index of the mapping containing addr, or -1.
+func (vm *VM) mapping(addr uint64) int {
+	i, found := slices.BinarySearchFunc(vm.Mappings, addr, func(m GuestRegionMapping, addr uint64) int {
+		switch {
+		case addr < m.BaseHostVirtAddr:
+			return 1
+		case addr >= m.BaseHostVirtAddr+m.Size:
+			return -1
+		}
+		return 0
+	})
+	if !found {
+		return -1
+	}
+	return i
+}
+
+func (vm *VM) handleEvent(u *Uffd, msg *UffdMsg) error {
+	switch msg.Event {
+	case UFFD_EVENT_PAGEFAULT:
+		pf := msg.GetPagefault()
+		start := time.Now()
+		err := vm.resolve(pf.Address)
+		vm.stats.record(pf.Flags, start, time.Since(start), err)
+		return err
+	case UFFD_EVENT_REMOVE:
+		rm := msg.GetRemove()
+		for addr := rm.Start; addr < rm.End; {
+			i := vm.mapping(addr)
+			if i < 0 {
+				break
+			}
+			m := &vm.Mappings[i]
+			ps := uint64(m.pageSize())
+			end := min(rm.End, m.BaseHostVirtAddr+m.Size)
+			vm.zeroed[i].AddRange((addr-m.BaseHostVirtAddr)/ps, (end-m.BaseHostVirtAddr+ps-1)/ps)
+			addr = end
+		}
+	}
+	return nil
+}
+
+// resolve fills the page faulted at addr from the guest memory, or with
+// zeros if it was removed.
+func (vm *VM) resolve(addr uint64) error {
+	i := vm.mapping(addr)
+	if i < 0 {
+		return fmt.Errorf("page fault at %#x outside guest memory", addr)
+	}
+	m := &vm.Mappings[i]
+	ps := m.pageSize()
+	page := addr &^ uint64(ps-1)
+	buf := vm.buf[:ps]
+	zero := vm.zeroed[i].Contains((page - m.BaseHostVirtAddr) / uint64(ps))
+	if zero {
+		// UFFDIO_ZEROPAGE does not support hugetlbfs.
+		clear(buf)
+	} else {
+		n, err := vm.memory.ReadAt(buf, int64(m.Offset+page-m.BaseHostVirtAddr))
+		if err != nil && err != io.EOF {
+			vm.stats.add(&vm.stats.ProviderErrors, 1)
+			return err
+		}
+		clear(buf[n:])
+	}
+	_, err := vm.uffd.Copy(uintptr(page), uintptr(unsafe.Pointer(&buf[0])), ps, 0)
+	switch {
+	case err == nil && zero:
+		vm.stats.add(&vm.stats.Zeropages, 1)
+	case err == nil:
+		vm.stats.add(&vm.stats.BytesFilled, uint64(ps))
+	}
+	// Raced with the VMM populating the page: just wake the faulter.
+	if errors.Is(err, ErrAlreadyMapped) {
+		err = vm.uffd.Wake(uintptr(page), ps)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestVMHandler(t *testing.T) {
+	needProcs(t, 3)
+	pageSize := unix.Getpagesize()
+
+	var backends int
+	h, err := StartHandler(filepath.Join(t.TempDir(), "uffd.sock"), VMBackendFunc(func(vm *VM) (PageProvider, error) {
+		backends++
+		return patternProvider(8), nil
+	}))
+	if err != nil {
+		t.Fatalf("StartHandler failed: %v", err)
+	}
+	defer h.Close()
+
+	// The guest memory of the VMM, registered with its userfaultfd.
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	mem, err := unix.Mmap(-1, 0, 4*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(mem)
+	base := uintptr(unsafe.Pointer(&mem[0]))
+	if _, err := uffd.Register(base, len(mem), UFFDIO_REGISTER_MODE_MISSING); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	mappings := []GuestRegionMapping{{
+		BaseHostVirtAddr: uint64(base),
+		Size:             uint64(len(mem)),
+		Offset:           uint64(2 * pageSize),
+		PageSize:         uint64(pageSize),
+	}}
+
+	connect := func() *net.UnixConn {
+		t.Helper()
+		c, err := net.DialUnix("unix", nil, h.Addr().(*net.UnixAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SendGuestMemory(c, uffd, mappings); err != nil {
+			t.Fatalf("SendGuestMemory failed: %v", err)
+		}
+		return c
+	}
+	// A VMM yet to hand off does not hold up the others.
+	stalled, err := net.DialUnix("unix", nil, h.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	c := connect()
+	defer c.Close()
+	waitFor(t, func() bool { return h.VM(os.Getpid()) != nil })
+	vm := h.VM(os.Getpid())
+
+	for i := range 2 {
+		if got, want := mem[i*pageSize], byte(i+3); got != want {
+			t.Errorf("page %d: got %d, want %d", i, got, want)
+		}
+	}
+	// The faulters may be woken up before the faults are counted.
+	waitFor(t, func() bool { return vm.Stats().Missing == 2 })
+	if st := vm.Stats(); st.BytesFilled != uint64(2*pageSize) {
+		t.Errorf("filled %d bytes, want %d", st.BytesFilled, 2*pageSize)
+	}
+	if ev := vm.EventStats(); ev.Pagefault != 2 {
+		t.Errorf("%d page faults read, want 2", ev.Pagefault)
+	}
+
+	// A VMM connecting again replaces its VM.
+	c2 := connect()
+	defer c2.Close()
+	<-vm.Done()
+	if err := vm.Err(); err != nil {
+		t.Errorf("replaced VM failed: %v", err)
+	}
+	waitFor(t, func() bool { vm2 := h.VM(os.Getpid()); return vm2 != nil && vm2 != vm })
+	if got, want := mem[2*pageSize], byte(5); got != want {
+		t.Errorf("page 2 after reconnecting: got %d, want %d", got, want)
+	}
+	if vms := h.VMs(); len(vms) != 1 {
+		t.Errorf("%d VMs after reconnecting, want 1", len(vms))
+	}
+	waitFor(t, func() bool { return h.VM(os.Getpid()).Stats().Missing == 1 })
+	if backends != 2 {
+		t.Errorf("backend asked for %d VMs, want 2", backends)
+	}
+
+	// The VM disconnects with its VMM.
+	c2.Close()
+	waitFor(t, func() bool { return len(h.VMs()) == 0 })
+
+	// Handoffs that are not of guest memory are refused.
+	bad, err := net.DialUnix("unix", nil, h.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	if _, _, err := bad.WriteMsgUnix([]byte("{}"), unix.UnixRights(uffd.Fd()), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := bad.Read(make([]byte, 1)); n != 0 {
+		t.Errorf("read %d bytes from refused connection", n)
+	}
+	if vms := h.VMs(); len(vms) != 0 {
+		t.Errorf("%d VMs after a bad handoff", len(vms))
+	}
+
+	if err := h.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
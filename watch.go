@@ -0,0 +1,26 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// WriteEvent describes a write caught by a Watchpoint.
+type WriteEvent struct {
+	Addr uintptr // Faulting address, rounded down to the page without UFFD_FEATURE_EXACT_ADDRESS
+	Tid  int     // Thread that wrote, 0 if UFFD_FEATURE_THREAD_ID is not available
+	PC   uintptr // Instruction that wrote, 0 if it could not be determined
+}
+
+// Func returns the name and location of the function that wrote, or an
+// empty string if unknown.
+func (e WriteEvent) Func() string {
+	f := runtime.FuncForPC(e.PC)
+	if f == nil {
+		return ""
+	}
+	file, line := f.FileLine(e.PC)
+	return fmt.Sprintf("%s %s:%d", f.Name(), file, line)
+}
@@ -0,0 +1,166 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// How long a page stays writable after a reported write before the
+// watchpoint protects it again.
+const watchRearmInterval = 10 // milliseconds
+
+// Watchpoint invokes a callback on writes to a memory range, then lets the
+// write proceed. After a reported write the page stays writable for a few
+// milliseconds, so bursts of writes to the same page are reported once.
+type Watchpoint struct {
+	uffd     *Uffd
+	mem      []byte
+	fn       func(WriteEvent)
+	pageSize int
+	mu       sync.Mutex
+	writable []uintptr // pages to protect again
+	done     chan error
+}
+
+// Watch write-protects mem on a new userfaultfd and calls fn from a
+// dedicated goroutine for every write, until Close.
+func Watch(mem []byte, fn func(WriteEvent)) (*Watchpoint, error) {
+	const wanted = UFFD_FEATURE_PAGEFAULT_FLAG_WP | UFFD_FEATURE_THREAD_ID | UFFD_FEATURE_WP_UNPOPULATED | UFFD_FEATURE_EXACT_ADDRESS
+	u, granted, err := NewBestEffort(unix.O_CLOEXEC|unix.O_NONBLOCK|watchFlags(), wanted)
+	if err != nil {
+		return nil, err
+	}
+	if granted&UFFD_FEATURE_PAGEFAULT_FLAG_WP == 0 {
+		u.Close()
+		return nil, &ErrFeatureUnavailable{Feature: UFFD_FEATURE_PAGEFAULT_FLAG_WP}
+	}
+
+	w := &Watchpoint{
+		uffd:     u,
+		mem:      mem,
+		fn:       fn,
+		pageSize: os.Getpagesize(),
+		done:     make(chan error, 1),
+	}
+	if _, err := u.Register(w.base(), len(mem), UFFDIO_REGISTER_MODE_WP); err != nil {
+		u.Close()
+		return nil, err
+	}
+	if err := u.WriteProtect(w.base(), len(mem), UFFDIO_WRITEPROTECT_MODE_WP); err != nil {
+		u.Unregister(w.base(), len(mem))
+		u.Close()
+		return nil, err
+	}
+
+	go func() {
+		w.done <- w.serve()
+	}()
+	return w, nil
+}
+
+// Close removes the watchpoint, leaving the memory writable.
+func (w *Watchpoint) Close() error {
+	err := w.uffd.Unregister(w.base(), len(w.mem))
+	if cerr := w.uffd.Close(); err == nil {
+		err = cerr
+	}
+	if serr := <-w.done; err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (w *Watchpoint) base() uintptr {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(w.mem)))
+}
+
+func (w *Watchpoint) serve() error {
+	rearmed := time.Now()
+	for {
+		msg, err := w.uffd.ReadMsgTimeout(watchRearmInterval)
+		switch {
+		case err == nil, errors.Is(err, unix.EAGAIN):
+		case isClosed(err):
+			return nil
+		default:
+			return err
+		}
+		// Rearm on schedule even while writes keep arriving, or the pages
+		// written meanwhile would stay writable.
+		if time.Since(rearmed) >= watchRearmInterval*time.Millisecond {
+			if err := w.rearm(); err != nil {
+				return err
+			}
+			rearmed = time.Now()
+		}
+		if err != nil || msg.Event != UFFD_EVENT_PAGEFAULT {
+			continue
+		}
+
+		pf := msg.GetPagefault()
+		addr, page := pf.Addr()
+		ev := WriteEvent{Addr: addr, Tid: int(pf.Ptid)}
+		if ev.Tid != 0 {
+			ev.PC = threadPC(ev.Tid)
+		}
+		w.fn(ev)
+
+		w.mu.Lock()
+		w.writable = append(w.writable, page)
+		w.mu.Unlock()
+		if err := w.uffd.WriteProtect(page, w.pageSize, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// rearm protects again the pages made writable after a reported write.
+func (w *Watchpoint) rearm() error {
+	w.mu.Lock()
+	pages := w.writable
+	w.writable = nil
+	w.mu.Unlock()
+
+	for _, page := range pages {
+		if err := w.uffd.WriteProtect(page, w.pageSize, UFFDIO_WRITEPROTECT_MODE_WP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchFlags returns UFFD_USER_MODE_ONLY when faults in kernel mode can't
+// be handled by unprivileged users.
+func watchFlags() int {
+	if os.Geteuid() != 0 && !UnprivilegedUserfaultfd {
+		return UFFD_USER_MODE_ONLY
+	}
+	return 0
+}
+
+// threadPC returns the user-space instruction pointer of the blocked
+// thread tid, as reported by /proc/self/task/<tid>/syscall.
+func threadPC(tid int) uintptr {
+	data, err := os.ReadFile("/proc/self/task/" + strconv.Itoa(tid) + "/syscall")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0
+	}
+	pc, err := strconv.ParseUint(strings.TrimPrefix(fields[len(fields)-1], "0x"), 16, 64)
+	if err != nil {
+		return 0
+	}
+	return uintptr(pc)
+}
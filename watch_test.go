@@ -0,0 +1,116 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWatch(t *testing.T) {
+	needProcs(t, 2)
+
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, 2*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+	mem[0] = 1
+	mem[pageSize] = 1
+
+	events := make(chan WriteEvent, 16)
+	w, err := Watch(mem, func(ev WriteEvent) { events <- ev })
+	if err != nil {
+		t.Skipf("Watch failed: %v", err)
+	}
+
+	// Reads are not reported.
+	if mem[0] != 1 {
+		t.Fatalf("unexpected contents")
+	}
+
+	mem[pageSize+8] = 2
+	if mem[pageSize+8] != 2 {
+		t.Fatalf("write was lost")
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Addr&^uintptr(pageSize-1) != uintptr(unsafe.Pointer(&mem[0]))+uintptr(pageSize) {
+			t.Errorf("unexpected address %#x", ev.Addr)
+		}
+		t.Logf("write by tid %d at %s", ev.Tid, ev.Func())
+		if ev.Tid != 0 && ev.PC != 0 && !strings.Contains(ev.Func(), "TestWatch") {
+			t.Errorf("unexpected writer %q", ev.Func())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("write not reported")
+	}
+
+	// The page is protected again after the rearm interval.
+	time.Sleep(5 * watchRearmInterval * time.Millisecond)
+	mem[pageSize] = 3
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatalf("write after rearm not reported")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	mem[0] = 4
+	if len(events) != 0 {
+		t.Fatalf("write reported after Close")
+	}
+}
+
+func TestWatchBusy(t *testing.T) {
+	needProcs(t, 2)
+
+	const pages = 64
+	pageSize := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, pages*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	defer unix.Munmap(mem)
+	for i := 0; i < pages; i++ {
+		mem[i*pageSize] = 1
+	}
+
+	events := make(chan WriteEvent, 2*pages)
+	w, err := Watch(mem, func(ev WriteEvent) { events <- ev })
+	if err != nil {
+		t.Skipf("Watch failed: %v", err)
+	}
+	defer w.Close()
+
+	// Writes arriving faster than the rearm interval must not keep the
+	// first page writable.
+	base := uintptr(unsafe.Pointer(&mem[0]))
+	for i := 0; i < pages; i++ {
+		mem[i*pageSize] = 2
+		time.Sleep(watchRearmInterval * time.Millisecond / 4)
+	}
+	mem[0] = 3
+
+	deadline := time.After(time.Second)
+	for n := 0; n < 2; {
+		select {
+		case ev := <-events:
+			if ev.Addr&^uintptr(pageSize-1) == base {
+				n++
+			}
+		case <-deadline:
+			t.Fatalf("write to the first page reported %d times, want 2", n)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import "time"
+
+// PendingFault describes a fault being resolved, whose thread is blocked.
+type PendingFault struct {
+	Addr  uintptr       // Faulting address
+	Flags uint64        // UFFD_PAGEFAULT_FLAG_* flags of the fault
+	Tid   int           // Faulting thread, 0 if UFFD_FEATURE_THREAD_ID is not available
+	Age   time.Duration // Time since the fault was read
+}
+
+// StuckFault describes a fault left unresolved by the handler of a Server
+// for longer than the threshold of its watchdog.
+type StuckFault struct {
+	PendingFault
+	Handler Handler // Handler of the fault, the Region of its range with a ServeMux
+}
+
+// StuckAction is what the watchdog of a Server does with a stuck fault.
+type StuckAction int
+
+const (
+	StuckWait     StuckAction = iota // Keep waiting for the handler
+	StuckZeropage                    // Resolve a missing fault with a zero page
+	StuckPoison                      // Resolve a missing fault with UFFDIO_POISON, raising SIGBUS
+)
@@ -0,0 +1,86 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"time"
+)
+
+type watchdog struct {
+	threshold time.Duration
+	fn        func(StuckFault) StuckAction
+}
+
+// WithWatchdog calls fn once for every fault left unresolved for longer
+// than threshold, from a dedicated goroutine, and resolves it as fn
+// returns. A handler that later resolves the same page only wakes up the
+// faulting thread. Otherwise stuck faults leave their threads blocked
+// without notice.
+func WithWatchdog(threshold time.Duration, fn func(StuckFault) StuckAction) ServerOption {
+	return func(s *Server) {
+		s.watchdog = &watchdog{threshold: threshold, fn: fn}
+	}
+}
+
+// watch checks for stuck faults until done is closed.
+func (s *Server) watch(done <-chan struct{}) error {
+	t := time.NewTicker(max(s.watchdog.threshold/4, time.Millisecond))
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case now := <-t.C:
+			if err := s.checkStuck(now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) checkStuck(now time.Time) error {
+	var stuck []StuckFault
+	s.mu.Lock()
+	for k, f := range s.inflight {
+		if f.reported || now.Sub(f.read) < s.watchdog.threshold {
+			continue
+		}
+		f.reported = true
+		s.inflight[k] = f
+		stuck = append(stuck, StuckFault{PendingFault: f.pending(now)})
+	}
+	s.mu.Unlock()
+
+	for _, f := range stuck {
+		f.Handler = s.handler
+		if m, ok := s.handler.(interface{ Handler(uintptr) Handler }); ok {
+			if h := m.Handler(f.Addr); h != nil {
+				f.Handler = h
+			}
+		}
+		page := f.Addr &^ (s.pageSize - 1)
+		var err error
+		r, _ := f.Handler.(*Region)
+		switch s.watchdog.fn(f) {
+		case StuckZeropage:
+			if _, err = s.uffd.Zeropage(page, int(s.pageSize), 0); err == nil && r != nil {
+				r.filled.setAtomic(r.pageIndex(page))
+				r.hashPage(page, nil)
+				r.onFill(page)
+			}
+		case StuckPoison:
+			if _, err = s.uffd.Poison(page, int(s.pageSize), 0); err == nil && r != nil {
+				r.onPoison(page)
+			}
+		}
+		// The fault may have been resolved or its range gone meanwhile.
+		if err != nil && !errors.Is(err, ErrAlreadyMapped) && !errors.Is(err, ErrNotRegistered) &&
+			!errors.Is(err, ErrRangeGone) && !errors.Is(err, ErrClosed) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	pageIdleBitmap = "/sys/kernel/mm/page_idle/bitmap"
+
+	pagemapPresent   = 1 << 63
+	pagemapSoftDirty = 1 << 55
+	pagemapPFNMask   = 1<<55 - 1
+)
+
+// WorkingSet estimates which pages of a region are accessed over time.
+//
+// It uses idle page tracking when /sys/kernel/mm/page_idle/bitmap is
+// available, which requires CAP_SYS_ADMIN. Otherwise it falls back to
+// soft-dirty bits, with two limits: Sample then reports the pages written
+// rather than accessed, since reads leave them clear, and every Reset
+// clears them for the whole process, not only the region, disturbing any
+// other user of soft-dirty bits in the process.
+type WorkingSet struct {
+	r       *Region
+	pagemap *os.File
+	idle    *os.File // nil when using soft-dirty bits
+}
+
+// NewWorkingSet returns a WorkingSet for r. Call Reset to start sampling.
+func NewWorkingSet(r *Region) (*WorkingSet, error) {
+	pagemap, err := os.Open("/proc/self/pagemap")
+	if err != nil {
+		return nil, err
+	}
+	w := &WorkingSet{r: r, pagemap: pagemap}
+
+	if idle, err := os.OpenFile(pageIdleBitmap, os.O_RDWR, 0); err == nil {
+		w.idle = idle
+		return w, nil
+	}
+
+	if ok, err := softDirtySupported(pagemap); err != nil || !ok {
+		pagemap.Close()
+		if err == nil {
+			err = errors.New("neither idle page tracking nor soft-dirty bits are available")
+		}
+		return nil, err
+	}
+	return w, nil
+}
+
+// softDirtySupported checks whether a freshly faulted page is marked soft-dirty.
+func softDirtySupported(pagemap *os.File) (bool, error) {
+	pageSize := os.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return false, err
+	}
+	defer unix.Munmap(mem)
+	mem[0] = 1
+
+	var buf [8]byte
+	addr := uintptr(unsafe.Pointer(&mem[0]))
+	if _, err := pagemap.ReadAt(buf[:], int64(addr)/int64(pageSize)*8); err != nil {
+		return false, err
+	}
+	return binary.NativeEndian.Uint64(buf[:])&pagemapSoftDirty != 0, nil
+}
+
+// Close releases the files used for sampling.
+func (w *WorkingSet) Close() error {
+	if w.idle != nil {
+		w.idle.Close()
+	}
+	return w.pagemap.Close()
+}
+
+// Reset starts a new sampling interval.
+func (w *WorkingSet) Reset() error {
+	if w.idle == nil {
+		return os.WriteFile("/proc/self/clear_refs", []byte("4"), 0)
+	}
+
+	entries, err := w.entries()
+	if err != nil {
+		return err
+	}
+	// Only the bits set are marked idle, so the frames of other mappings
+	// sharing a word are left alone.
+	words := make(map[int64]uint64)
+	for _, e := range entries {
+		if e&pagemapPresent == 0 {
+			continue
+		}
+		pfn := int64(e & pagemapPFNMask)
+		words[pfn/64] |= 1 << (pfn % 64)
+	}
+	var buf [8]byte
+	for word, bits := range words {
+		binary.NativeEndian.PutUint64(buf[:], bits)
+		if _, err := w.idle.WriteAt(buf[:], word*8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sample returns the pages of the region accessed since the last Reset.
+func (w *WorkingSet) Sample() (Bitmap, error) {
+	entries, err := w.entries()
+	if err != nil {
+		return Bitmap{}, err
+	}
+
+	b := NewBitmap(len(entries))
+	var buf [8]byte
+	for i, e := range entries {
+		if e&pagemapPresent == 0 {
+			continue
+		}
+		if w.idle == nil {
+			if e&pagemapSoftDirty != 0 {
+				b.Set(i)
+			}
+			continue
+		}
+		pfn := int64(e & pagemapPFNMask)
+		if _, err := w.idle.ReadAt(buf[:], pfn/64*8); err != nil {
+			return Bitmap{}, err
+		}
+		if binary.NativeEndian.Uint64(buf[:])&(1<<(pfn%64)) == 0 {
+			b.Set(i)
+		}
+	}
+	return b, nil
+}
+
+// Size returns the number of bytes accessed since the last Reset.
+func (w *WorkingSet) Size() (int64, error) {
+	b, err := w.Sample()
+	if err != nil {
+		return 0, err
+	}
+	return int64(b.Count()) * int64(w.r.pageSize), nil
+}
+
+// entries reads the pagemap entries of the region.
+func (w *WorkingSet) entries() ([]uint64, error) {
+	n := (len(w.r.mem) + w.r.pageSize - 1) / w.r.pageSize
+	buf := make([]byte, n*8)
+	if _, err := w.pagemap.ReadAt(buf, int64(w.r.Base())/int64(w.r.pageSize)*8); err != nil {
+		return nil, err
+	}
+
+	entries := make([]uint64, n)
+	for i := range entries {
+		entries[i] = binary.NativeEndian.Uint64(buf[i*8:])
+	}
+	return entries, nil
+}
+
+// Refresh touches the pages accessed since the last Reset of ws, so the
+// eviction policy reflects real accesses rather than only faults, then
+// starts a new sampling interval.
+func (e *Evictor) Refresh(ws *WorkingSet) error {
+	b, err := ws.Sample()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < b.Len(); i++ {
+		if b.Test(i) {
+			e.Touch(e.r.Base() + uintptr(i*e.r.pageSize))
+		}
+	}
+	return ws.Reset()
+}
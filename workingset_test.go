@@ -0,0 +1,84 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWorkingSet(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 4)
+
+	for i := 0; i < 4; i++ {
+		mem[i*pageSize] = 1
+	}
+
+	ws, err := NewWorkingSet(r)
+	if err != nil {
+		t.Skipf("NewWorkingSet failed: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.Reset(); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	mem[2*pageSize] = 2
+
+	b, err := ws.Sample()
+	if err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+	for i, want := range []bool{false, false, true, false} {
+		if b.Test(i) != want {
+			t.Errorf("page %d accessed = %v, want %v", i, b.Test(i), want)
+		}
+	}
+
+	size, err := ws.Size()
+	if err != nil {
+		t.Fatalf("Size failed: %v", err)
+	}
+	if size != int64(pageSize) {
+		t.Errorf("Size() = %d, want %d", size, pageSize)
+	}
+}
+
+func TestEvictorRefresh(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r := serveRegion(t, 2, WithProvider(patternProvider(2)))
+	e := NewEvictor(r, nil)
+
+	mem[0] = 0xFF
+	mem[pageSize] = 0xFF
+
+	ws, err := NewWorkingSet(r)
+	if err != nil {
+		t.Skipf("NewWorkingSet failed: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.Reset(); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	// Page 0 was filled first, but is the only one accessed since.
+	mem[0] = 0xFE
+	if err := e.Refresh(ws); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if _, err := e.Evict(1); err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if mem[0] != 0xFE {
+		t.Fatalf("recently accessed page was evicted")
+	}
+	if mem[pageSize] != 2 {
+		t.Fatalf("idle page was not evicted")
+	}
+}
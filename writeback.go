@@ -0,0 +1,47 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"io"
+	"time"
+)
+
+// PageWriter receives the dirty pages of a write-back region. WritePages
+// writes p, one or more contiguous pages, at offset off of the region.
+type PageWriter interface {
+	WritePages(p []byte, off int64) error
+}
+
+// PageSyncer is a PageWriter whose writes are only durable, or visible,
+// once synced, such as a Journal. Flushes call Sync after writing back all
+// their pages.
+type PageSyncer interface {
+	PageWriter
+	Sync() error
+}
+
+// WriterAtPages adapts an io.WriterAt, such as the *os.File backing a
+// region, to a PageWriter.
+func WriterAtPages(w io.WriterAt) PageWriter {
+	return writerAtPages{w}
+}
+
+type writerAtPages struct {
+	w io.WriterAt
+}
+
+func (w writerAtPages) WritePages(p []byte, off int64) error {
+	_, err := w.w.WriteAt(p, off)
+	return err
+}
+
+// WriteBackPolicy sets when the dirty pages of a write-back region are
+// written back, besides explicit calls to Flush and FlushRange. The zero
+// value only writes back on demand.
+type WriteBackPolicy struct {
+	Interval      time.Duration // Flush every Interval, if not zero
+	MaxDirtyBytes int64         // Flush once more bytes are dirty, if not zero
+	MaxWriteBytes int           // Largest WritePages call, unlimited if zero
+	FlushOnClose  bool          // Flush when the region is closed
+}
@@ -0,0 +1,295 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// writeBack tracks the dirty pages of a region set up with WithWriteBack.
+type writeBack struct {
+	w      PageWriter
+	policy WriteBackPolicy
+
+	mu    sync.Mutex // orders dirtying pages with write-protecting them again
+	dirty PageSet
+
+	flushMu sync.Mutex // serializes flushes
+	err     error      // of the first failed background flush, under flushMu
+
+	kick chan struct{} // to flush on MaxDirtyBytes
+	stop chan struct{}
+	done chan struct{}
+}
+
+// pageRun is a run of contiguous pages, by index.
+type pageRun struct {
+	start, end uint64
+}
+
+// WithWriteBack writes the pages written to in the region back to w. The
+// region must be registered in missing and write-protect modes, on a
+// userfaultfd with UFFD_FEATURE_PAGEFAULT_FLAG_WP: pages are filled
+// write-protected, so that the first write to each marks it dirty. Flush
+// and FlushRange write back the dirty pages, besides the flushes of the
+// policy.
+//
+// Dirty pages removed by madvise(2) are dropped with their changes if the
+// region is told about it by UFFD_FEATURE_EVENT_REMOVE. Not compatible with
+// WithMaxResidentBytes, which would drop dirty pages too.
+func WithWriteBack(w PageWriter, policy WriteBackPolicy) RegionOption {
+	return func(r *Region) {
+		r.writeBack = &writeBack{w: w, policy: policy}
+	}
+}
+
+func (r *Region) checkWriteBack() error {
+	switch {
+	case r.mode&UFFDIO_REGISTER_MODE_MISSING == 0 || r.mode&UFFDIO_REGISTER_MODE_WP == 0:
+		return errors.New("write-back needs missing and write-protect modes")
+	case r.mode&UFFDIO_REGISTER_MODE_MINOR != 0:
+		return errors.New("write-back is not supported in minor mode")
+	case r.maxResident > 0:
+		return errors.New("write-back is not compatible with a resident limit")
+	}
+	return nil
+}
+
+// startWriteBack starts flushing in the background if the policy says so.
+func (r *Region) startWriteBack() {
+	wb := r.writeBack
+	if wb.policy.Interval <= 0 && wb.policy.MaxDirtyBytes <= 0 {
+		return
+	}
+	wb.kick = make(chan struct{}, 1)
+	wb.stop = make(chan struct{})
+	wb.done = make(chan struct{})
+	go r.flushLoop(wb)
+}
+
+func (r *Region) flushLoop(wb *writeBack) {
+	defer close(wb.done)
+	var tick <-chan time.Time
+	if wb.policy.Interval > 0 {
+		t := time.NewTicker(wb.policy.Interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-wb.stop:
+			return
+		case <-tick:
+		case <-wb.kick:
+		}
+		wb.flushMu.Lock()
+		if err := r.flush(0, int64(len(r.mem))); err != nil && wb.err == nil {
+			wb.err = err
+		}
+		wb.flushMu.Unlock()
+	}
+}
+
+// closeWriteBack stops the background flushes and flushes a last time if
+// the policy says so.
+func (r *Region) closeWriteBack() error {
+	wb := r.writeBack
+	if wb.stop != nil {
+		close(wb.stop)
+		<-wb.done
+	}
+	if wb.policy.FlushOnClose {
+		return r.Flush()
+	}
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	return wb.err
+}
+
+// unprotect resolves a write-protect fault, marking the page dirty first
+// with write-back. A flush may then write the page back and write-protect
+// it again before the faulting write lands, which faults once more and
+// dirties the page anew for the next flush: no write is lost, but the
+// page is not clean after such a flush.
+func (r *Region) unprotect(page uintptr) error {
+	mode := r.wakeMode(UFFDIO_WRITEPROTECT_MODE_DONTWAKE)
+	wb := r.writeBack
+	if wb == nil {
+		return r.uffd.WriteProtect(page, r.pageSize, mode)
+	}
+	wb.mu.Lock()
+	wb.dirty.Add(uint64(r.pageIndex(page)))
+	dirty := int64(wb.dirty.Len()) * int64(r.pageSize)
+	err := r.uffd.WriteProtect(page, r.pageSize, mode)
+	wb.mu.Unlock()
+
+	wb.dirtied(dirty)
+	return err
+}
+
+// dirtied kicks a flush once the dirty bytes are past MaxDirtyBytes.
+func (wb *writeBack) dirtied(dirty int64) {
+	if limit := wb.policy.MaxDirtyBytes; limit > 0 && dirty > limit {
+		select {
+		case wb.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// writeDirty copies p into the resident pages at off, which it marks dirty
+// and whose write protection it removes beforehand, with flushes held off
+// until done, so that the copy does not fault.
+func (r *Region) writeDirty(p []byte, off int64) (int, error) {
+	wb := r.writeBack
+	mem, err := r.pages(int(off), len(p))
+	if err != nil || len(mem) == 0 {
+		return 0, err
+	}
+	first := uint64(off) / uint64(r.pageSize)
+	start := r.Base() + uintptr(first)*uintptr(r.pageSize)
+
+	wb.mu.Lock()
+	wb.dirty.AddRange(first, first+uint64((len(mem)+r.pageSize-1)/r.pageSize))
+	dirty := int64(wb.dirty.Len()) * int64(r.pageSize)
+	if err := r.uffd.WriteProtect(start, len(mem), 0); err != nil {
+		wb.mu.Unlock()
+		return 0, err
+	}
+	n := copy(r.mem[off:], p)
+	wb.mu.Unlock()
+
+	wb.dirtied(dirty)
+	return n, nil
+}
+
+// discardDirty forgets the dirty pages of a range, which may extend beyond
+// the region.
+func (r *Region) discardDirty(start uintptr, length int) {
+	if wb := r.writeBack; wb != nil {
+		r.removePages(&wb.mu, &wb.dirty, start, length)
+	}
+}
+
+// Dirty returns the pages of the region written to since they were last
+// written back, by index. It is empty without WithWriteBack.
+func (r *Region) Dirty() *PageSet {
+	wb := r.writeBack
+	if wb == nil {
+		return new(PageSet)
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return wb.dirty.Clone()
+}
+
+// Flush writes back the dirty pages of a region set up with WithWriteBack.
+func (r *Region) Flush() error {
+	return r.FlushRange(0, int64(len(r.mem)))
+}
+
+// FlushRange writes back the dirty pages of the length bytes at off in the
+// region, with one WritePages call per run of contiguous pages, up to the
+// MaxWriteBytes of the policy. Pages are write-protected again before being
+// written back, so that writes meanwhile dirty them again rather than being
+// lost. The error of a failed background flush is returned by the next call.
+func (r *Region) FlushRange(off, length int64) error {
+	wb := r.writeBack
+	if wb == nil {
+		return errors.New("flush of a region without write-back")
+	}
+	if off < 0 || length < 0 || off+length > int64(len(r.mem)) {
+		return fmt.Errorf("invalid flush range %d+%d of region of %d bytes", off, length, len(r.mem))
+	}
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	err := wb.err
+	wb.err = nil
+	return errors.Join(err, r.flush(off, length))
+}
+
+// flush writes back the dirty pages of a range, with flushMu held.
+func (r *Region) flush(off, length int64) error {
+	wb := r.writeBack
+	first := uint64(off) / uint64(r.pageSize)
+	end := uint64(RoundUp(uintptr(off+length), uintptr(r.pageSize))) / uint64(r.pageSize)
+	runs, err := r.protectDirty(first, end)
+	for k, run := range runs {
+		if werr := r.writeRun(run); werr != nil {
+			// Still write-protected, the pages not written back are only
+			// dirty again if marked so.
+			wb.mu.Lock()
+			for _, run := range runs[k:] {
+				wb.dirty.AddRange(run.start, run.end)
+			}
+			wb.mu.Unlock()
+			return errors.Join(err, werr)
+		}
+	}
+	if s, ok := wb.w.(PageSyncer); ok && len(runs) > 0 {
+		err = errors.Join(err, s.Sync())
+	}
+	return err
+}
+
+// protectDirty write-protects the runs of dirty pages between first and end
+// excluded and marks them clean.
+func (r *Region) protectDirty(first, end uint64) ([]pageRun, error) {
+	wb := r.writeBack
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	var runs []pageRun
+	for i := range wb.dirty.All() {
+		if i < first {
+			continue
+		}
+		if i >= end {
+			break
+		}
+		if n := len(runs); n > 0 && runs[n-1].end == i {
+			runs[n-1].end++
+		} else {
+			runs = append(runs, pageRun{i, i + 1})
+		}
+	}
+	for k, run := range runs {
+		start := r.Base() + uintptr(run.start)*uintptr(r.pageSize)
+		if err := r.uffd.WriteProtect(start, int(run.end-run.start)*r.pageSize, UFFDIO_WRITEPROTECT_MODE_WP); err != nil {
+			return runs[:k], err
+		}
+		for i := run.start; i < run.end; i++ {
+			wb.dirty.Remove(i)
+		}
+	}
+	return runs, nil
+}
+
+// writeRun writes back a run of pages, in chunks of up to MaxWriteBytes.
+func (r *Region) writeRun(run pageRun) error {
+	wb := r.writeBack
+	off := int64(run.start) * int64(r.pageSize)
+	p := r.mem[off : int64(run.end)*int64(r.pageSize)]
+	chunk := len(p)
+	if n := wb.policy.MaxWriteBytes; n > 0 {
+		chunk = max(n/r.pageSize, 1) * r.pageSize
+	}
+	for len(p) > 0 {
+		n := min(chunk, len(p))
+		if err := wb.w.WritePages(p[:n], off); err != nil {
+			return err
+		}
+		for k := 0; k < n; k += r.pageSize {
+			r.hashPage(r.Base()+uintptr(off)+uintptr(k), p[k:k+r.pageSize])
+		}
+		r.onFlush(int(off), n)
+		p = p[n:]
+		off += int64(n)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+//go:build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+package userfaultfd
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// recordingWriter keeps the pages written back and the offsets of the
+// WritePages calls.
+type recordingWriter struct {
+	mu    sync.Mutex
+	data  []byte
+	calls []int64
+}
+
+func (w *recordingWriter) WritePages(p []byte, off int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	copy(w.data[off:], p)
+	w.calls = append(w.calls, off)
+	return nil
+}
+
+func (w *recordingWriter) take() []int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	calls := w.calls
+	w.calls = nil
+	return calls
+}
+
+// serveWriteProtected maps a region of the given number of pages filled
+// by patternProvider, registers it in missing and write-protect modes and
+// serves it until cleanup.
+func serveWriteProtected(t *testing.T, pages int, opts ...RegionOption) *Region {
+	t.Helper()
+	needProcs(t, 3) // as serveRegion
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, UFFD_FEATURE_PAGEFAULT_FLAG_WP)
+	if err != nil {
+		t.Skipf("write-protect faults not available: %v", err)
+	}
+	pageSize := unix.Getpagesize()
+	opts = append([]RegionOption{WithProvider(patternProvider(pages))}, opts...)
+	r, err := uffd.MapAndRegister(int64(pages*pageSize), UFFDIO_REGISTER_MODE_MISSING|UFFDIO_REGISTER_MODE_WP, opts...)
+	if err != nil {
+		uffd.Close()
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+	t.Cleanup(func() {
+		_ = r.Close()
+		_ = uffd.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+	})
+	return r
+}
+
+// serveWriteBack serves a region with write-back to a recordingWriter.
+func serveWriteBack(t *testing.T, pages int, policy WriteBackPolicy) ([]byte, *Region, *recordingWriter) {
+	t.Helper()
+	w := &recordingWriter{data: make([]byte, pages*unix.Getpagesize())}
+	r := serveWriteProtected(t, pages, WithWriteBack(w, policy))
+	return r.Bytes(), r, w
+}
+
+func TestRegionWriteBack(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r, w := serveWriteBack(t, 8, WriteBackPolicy{MaxWriteBytes: 2 * pageSize})
+
+	// Reads do not dirty pages.
+	if mem[7*pageSize] != 8 {
+		t.Fatalf("page 7 = %#x, want 8", mem[7*pageSize])
+	}
+	for _, i := range []int{0, 1, 2, 5} {
+		mem[i*pageSize] = 0xEE
+	}
+	if got := slices.Collect(r.Dirty().All()); !slices.Equal(got, []uint64{0, 1, 2, 5}) {
+		t.Fatalf("Dirty = %v", got)
+	}
+
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	// Pages 0-2 are coalesced, and split by MaxWriteBytes.
+	want := []int64{0, int64(2 * pageSize), int64(5 * pageSize)}
+	if got := w.take(); !slices.Equal(got, want) {
+		t.Errorf("WritePages offsets = %v, want %v", got, want)
+	}
+	if w.data[pageSize] != 0xEE || w.data[pageSize+1] != 2 || w.data[3*pageSize] != 0 {
+		t.Errorf("unexpected data written back")
+	}
+	if r.Dirty().Len() != 0 {
+		t.Errorf("pages still dirty after Flush")
+	}
+
+	// Written back pages are dirtied again by further writes.
+	mem[pageSize+1] = 0xDD
+	if err := r.FlushRange(0, int64(4*pageSize)); err != nil {
+		t.Fatalf("FlushRange failed: %v", err)
+	}
+	if got := w.take(); !slices.Equal(got, []int64{int64(pageSize)}) {
+		t.Errorf("WritePages offsets = %v, want [%d]", got, pageSize)
+	}
+	if w.data[pageSize+1] != 0xDD {
+		t.Errorf("second write not written back")
+	}
+}
+
+func TestRegionWriteBackPolicy(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	mem, r, w := serveWriteBack(t, 4, WriteBackPolicy{MaxDirtyBytes: int64(pageSize), FlushOnClose: true})
+
+	// The flush past MaxDirtyBytes may write page 1 back before the write
+	// lands, which then dirties it again, see unprotect.
+	mem[0] = 1
+	mem[pageSize] = 1
+	waitFor(t, func() bool { return len(w.take()) > 0 })
+	if r.Dirty().Len() > 1 {
+		t.Errorf("Dirty = %v after a flush", slices.Collect(r.Dirty().All()))
+	}
+
+	mem[3*pageSize] = 0xCC
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if w.data[pageSize] != 1 || w.data[3*pageSize] != 0xCC {
+		t.Errorf("pages not written back on Close")
+	}
+}
+
+func TestRegionWriteBackMode(t *testing.T) {
+	uffd, err := New(flags|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer uffd.Close()
+	if _, err := uffd.MapAndRegister(int64(unix.Getpagesize()), UFFDIO_REGISTER_MODE_MISSING, WithWriteBack(&recordingWriter{}, WriteBackPolicy{})); err == nil {
+		t.Error("write-back registered without write-protect mode")
+	}
+}
+
+func TestRegionWriteBackJournal(t *testing.T) {
+	log, file := openJournalFiles(t)
+	j, err := OpenJournal(log, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize := unix.Getpagesize()
+	uffd, err := New(flags|unix.O_NONBLOCK|unix.O_CLOEXEC, UFFD_FEATURE_PAGEFAULT_FLAG_WP)
+	if err != nil {
+		t.Skipf("write-protect faults not available: %v", err)
+	}
+	defer uffd.Close()
+	r, err := uffd.MapAndRegister(int64(2*pageSize), UFFDIO_REGISTER_MODE_MISSING|UFFDIO_REGISTER_MODE_WP, WithWriteBack(j, WriteBackPolicy{}))
+	if err != nil {
+		t.Fatalf("MapAndRegister failed: %v", err)
+	}
+	needProcs(t, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve()
+	}()
+
+	r.Bytes()[pageSize] = 0xAB
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := readFile(t, file); len(got) != 2*pageSize || got[pageSize] != 0xAB {
+		t.Errorf("page not written in place")
+	}
+	if got := readFile(t, log); len(got) != 0 {
+		t.Errorf("log not truncated: %d bytes", len(got))
+	}
+	r.Close()
+	uffd.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestRegionWriteBackDirtyTracker(t *testing.T) {
+	pageSize := unix.Getpagesize()
+	w := &recordingWriter{data: make([]byte, 3*pageSize)}
+	tr := NewDirtyTracker(w, false)
+	r := serveWriteProtected(t, 3, WithWriteBack(tr, WriteBackPolicy{}))
+
+	mem := r.Bytes()
+	for _, i := range []int{0, 2} {
+		mem[i*pageSize] = 0xAB
+		if err := r.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if len(w.take()) != 2 || tr.Epoch() != 2 {
+		t.Fatalf("epoch %d after two flushes", tr.Epoch())
+	}
+	seq, err := tr.Diff(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Collect(seq); len(got) != 1 || got[0].Off != int64(2*pageSize) || got[0].Len != pageSize {
+		t.Errorf("Diff(1, 2) = %v", got)
+	}
+}
@@ -0,0 +1,140 @@
+// Code generated by hack/mkconst from linux/userfaultfd.h; DO NOT EDIT.
+
+package userfaultfd
+
+// UFFDIO_API features
+const (
+	UFFD_FEATURE_PAGEFAULT_FLAG_WP  = 1 << 0
+	UFFD_FEATURE_EVENT_FORK         = 1 << 1
+	UFFD_FEATURE_EVENT_REMAP        = 1 << 2
+	UFFD_FEATURE_EVENT_REMOVE       = 1 << 3
+	UFFD_FEATURE_MISSING_HUGETLBFS  = 1 << 4
+	UFFD_FEATURE_MISSING_SHMEM      = 1 << 5
+	UFFD_FEATURE_EVENT_UNMAP        = 1 << 6
+	UFFD_FEATURE_SIGBUS             = 1 << 7
+	UFFD_FEATURE_THREAD_ID          = 1 << 8
+	UFFD_FEATURE_MINOR_HUGETLBFS    = 1 << 9
+	UFFD_FEATURE_MINOR_SHMEM        = 1 << 10
+	UFFD_FEATURE_EXACT_ADDRESS      = 1 << 11
+	UFFD_FEATURE_WP_HUGETLBFS_SHMEM = 1 << 12
+	UFFD_FEATURE_WP_UNPOPULATED     = 1 << 13
+	UFFD_FEATURE_POISON             = 1 << 14
+	UFFD_FEATURE_WP_ASYNC           = 1 << 15
+	UFFD_FEATURE_MOVE               = 1 << 16
+)
+
+var featureNames = []string{
+	"PAGEFAULT_FLAG_WP",
+	"EVENT_FORK",
+	"EVENT_REMAP",
+	"EVENT_REMOVE",
+	"MISSING_HUGETLBFS",
+	"MISSING_SHMEM",
+	"EVENT_UNMAP",
+	"SIGBUS",
+	"THREAD_ID",
+	"MINOR_HUGETLBFS",
+	"MINOR_SHMEM",
+	"EXACT_ADDRESS",
+	"WP_HUGETLBFS_SHMEM",
+	"WP_UNPOPULATED",
+	"POISON",
+	"WP_ASYNC",
+	"MOVE",
+}
+
+// userfaultfd events
+const (
+	UFFD_EVENT_PAGEFAULT = 0x12
+	UFFD_EVENT_FORK      = 0x13
+	UFFD_EVENT_REMAP     = 0x14
+	UFFD_EVENT_REMOVE    = 0x15
+	UFFD_EVENT_UNMAP     = 0x16
+)
+
+// UFFD_EVENT_PAGEFAULT flags
+const (
+	UFFD_PAGEFAULT_FLAG_WRITE = 1 << 0
+	UFFD_PAGEFAULT_FLAG_WP    = 1 << 1
+	UFFD_PAGEFAULT_FLAG_MINOR = 1 << 2
+)
+
+var pagefaultFlagNames = []string{
+	"WRITE",
+	"WP",
+	"MINOR",
+}
+
+// UFFDIO_CONTINUE(2) ioctl mode
+const (
+	UFFDIO_CONTINUE_MODE_DONTWAKE = 1 << 0
+	UFFDIO_CONTINUE_MODE_WP       = 1 << 1
+)
+
+// UFFDIO_COPY(2) ioctl mode
+const (
+	UFFDIO_COPY_MODE_DONTWAKE = 1 << 0
+	UFFDIO_COPY_MODE_WP       = 1 << 1
+)
+
+// UFFDIO_MOVE(2) ioctl mode
+const (
+	UFFDIO_MOVE_MODE_DONTWAKE        = 1 << 0
+	UFFDIO_MOVE_MODE_ALLOW_SRC_HOLES = 1 << 1
+)
+
+// UFFDIO_POISON(2) ioctl mode
+const (
+	UFFDIO_POISON_MODE_DONTWAKE = 1 << 0
+)
+
+// UFFDIO_REGISTER(2) ioctl mode
+const (
+	UFFDIO_REGISTER_MODE_MISSING = 1 << 0
+	UFFDIO_REGISTER_MODE_WP      = 1 << 1
+	UFFDIO_REGISTER_MODE_MINOR   = 1 << 2
+)
+
+var registerModeNames = []string{
+	"MISSING",
+	"WP",
+	"MINOR",
+}
+
+// UFFDIO_WRITEPROTECT(2) ioctl mode
+const (
+	UFFDIO_WRITEPROTECT_MODE_WP       = 1 << 0
+	UFFDIO_WRITEPROTECT_MODE_DONTWAKE = 1 << 1
+)
+
+// UFFDIO_ZEROPAGE(2) ioctl mode
+const (
+	UFFDIO_ZEROPAGE_MODE_DONTWAKE = 1 << 0
+)
+
+// Used to check available Ioctls
+const (
+	_UFFDIO_REGISTER     = 0x00
+	_UFFDIO_UNREGISTER   = 0x01
+	_UFFDIO_WAKE         = 0x02
+	_UFFDIO_COPY         = 0x03
+	_UFFDIO_ZEROPAGE     = 0x04
+	_UFFDIO_MOVE         = 0x05
+	_UFFDIO_WRITEPROTECT = 0x06
+	_UFFDIO_CONTINUE     = 0x07
+	_UFFDIO_POISON       = 0x08
+	_UFFDIO_API          = 0x3f
+)
+
+var ioctlNames = map[int]string{
+	0x00: "REGISTER",
+	0x01: "UNREGISTER",
+	0x02: "WAKE",
+	0x03: "COPY",
+	0x04: "ZEROPAGE",
+	0x05: "MOVE",
+	0x06: "WRITEPROTECT",
+	0x07: "CONTINUE",
+	0x08: "POISON",
+	0x3f: "API",
+}
@@ -0,0 +1,38 @@
+//go:build !linux || !cgo || userfaultfd_nocgo
+
+// Code generated by hack/mkconst from linux/userfaultfd.h; DO NOT EDIT.
+
+package userfaultfd
+
+const (
+	// Create a userfaultfd that can handle page faults only in user mode.
+	UFFD_USER_MODE_ONLY = 1
+)
+
+// Sizes of the kernel structures, the same on all architectures.
+const (
+	sizeofUffdioApi          = 24
+	sizeofUffdioRegister     = 32
+	sizeofUffdioRange        = 16
+	sizeofUffdioCopy         = 40
+	sizeofUffdioZeropage     = 32
+	sizeofUffdioMove         = 40
+	sizeofUffdioWriteprotect = 24
+	sizeofUffdioContinue     = 32
+	sizeofUffdioPoison       = 32
+)
+
+const (
+	UFFD_API            = 0xaa
+	USERFAULTFD_IOC_NEW = iocNone<<iocDirShift | 0xaa<<8 | 0x00
+	UFFDIO_API          = iocRead<<iocDirShift | iocWrite<<iocDirShift | sizeofUffdioApi<<iocSizeShift | 0xaa<<8 | _UFFDIO_API
+	UFFDIO_REGISTER     = iocRead<<iocDirShift | iocWrite<<iocDirShift | sizeofUffdioRegister<<iocSizeShift | 0xaa<<8 | _UFFDIO_REGISTER
+	UFFDIO_UNREGISTER   = iocRead<<iocDirShift | sizeofUffdioRange<<iocSizeShift | 0xaa<<8 | _UFFDIO_UNREGISTER
+	UFFDIO_WAKE         = iocRead<<iocDirShift | sizeofUffdioRange<<iocSizeShift | 0xaa<<8 | _UFFDIO_WAKE
+	UFFDIO_COPY         = iocRead<<iocDirShift | iocWrite<<iocDirShift | sizeofUffdioCopy<<iocSizeShift | 0xaa<<8 | _UFFDIO_COPY
+	UFFDIO_ZEROPAGE     = iocRead<<iocDirShift | iocWrite<<iocDirShift | sizeofUffdioZeropage<<iocSizeShift | 0xaa<<8 | _UFFDIO_ZEROPAGE
+	UFFDIO_MOVE         = iocRead<<iocDirShift | iocWrite<<iocDirShift | sizeofUffdioMove<<iocSizeShift | 0xaa<<8 | _UFFDIO_MOVE
+	UFFDIO_WRITEPROTECT = iocRead<<iocDirShift | iocWrite<<iocDirShift | sizeofUffdioWriteprotect<<iocSizeShift | 0xaa<<8 | _UFFDIO_WRITEPROTECT
+	UFFDIO_CONTINUE     = iocRead<<iocDirShift | iocWrite<<iocDirShift | sizeofUffdioContinue<<iocSizeShift | 0xaa<<8 | _UFFDIO_CONTINUE
+	UFFDIO_POISON       = iocRead<<iocDirShift | iocWrite<<iocDirShift | sizeofUffdioPoison<<iocSizeShift | 0xaa<<8 | _UFFDIO_POISON
+)